@@ -0,0 +1,240 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AlertStatus is the lifecycle state of an alert
+type AlertStatus string
+
+const (
+	// AlertPending means the condition holds but not yet for the rule's For duration
+	AlertPending  AlertStatus = "pending"
+	AlertFiring   AlertStatus = "firing"
+	AlertResolved AlertStatus = "resolved"
+)
+
+// ResolvedAlertRetention is how long a resolved alert stays queryable before the
+// engine that produced it forgets it, the same as Prometheus' rule manager
+const ResolvedAlertRetention = 15 * time.Minute
+
+// Alert is a firing or resolved alert produced by a rule. Labels identify the alert;
+// two alerts with the same labels are the same alert.
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      AlertStatus       `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt,omitzero"`
+	// LastSeen is the last time the alert condition was observed
+	LastSeen time.Time `json:"lastSeen"`
+	// Count is how many observations matched while the alert was active
+	Count int `json:"count"`
+}
+
+// AlertSink delivers alert notifications. Notify is called once when an alert
+// starts firing and once when it resolves.
+type AlertSink interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// alertFingerprint hashes the sorted labels so the same labels always give the same ID
+func alertFingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\xff%s\xff", key, labels[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// LogAlertSink writes every notification to a structured logger
+type LogAlertSink struct {
+	logger *slog.Logger
+}
+
+func NewLogAlertSink(logger *slog.Logger) *LogAlertSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &LogAlertSink{logger: logger}
+}
+
+func (s *LogAlertSink) Notify(ctx context.Context, alert Alert) error {
+	level := slog.LevelWarn
+	if alert.Status == AlertResolved {
+		level = slog.LevelInfo
+	}
+
+	attrs := []any{
+		"fingerprint", alert.Fingerprint,
+		"status", alert.Status,
+		"count", alert.Count,
+		"starts_at", alert.StartsAt,
+	}
+	keys := make([]string, 0, len(alert.Labels))
+	for key := range alert.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attrs = append(attrs, "label."+key, alert.Labels[key])
+	}
+
+	s.logger.Log(ctx, level, "alert "+strings.ToLower(string(alert.Status))+": "+alert.Labels["alertname"], attrs...)
+	return nil
+}
+
+// WebhookAlertSink posts every notification as JSON to an HTTP endpoint
+type WebhookAlertSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookAlertSink(url string, client *http.Client) *WebhookAlertSink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WebhookAlertSink{url: url, client: client}
+}
+
+func (s *WebhookAlertSink) Notify(ctx context.Context, alert Alert) error {
+	return s.post(ctx, alert)
+}
+
+func (s *WebhookAlertSink) post(ctx context.Context, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// AlertmanagerAlert is the alert format accepted by the Alertmanager v2 API
+// (POST /api/v2/alerts), so exported alerts can feed existing routing
+type AlertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt,omitzero"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// ToAlertmanager converts firing and resolved alerts; pending alerts are not
+// yet alerts as far as Alertmanager is concerned and are skipped
+func ToAlertmanager(alerts []Alert) []AlertmanagerAlert {
+	result := make([]AlertmanagerAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		if alert.Status == AlertPending {
+			continue
+		}
+		result = append(result, AlertmanagerAlert{
+			Labels:      alert.Labels,
+			Annotations: alert.Annotations,
+			StartsAt:    alert.StartsAt,
+			EndsAt:      alert.EndsAt,
+		})
+	}
+	return result
+}
+
+// AlertmanagerSink pushes every notification to an Alertmanager v2 alerts endpoint
+type AlertmanagerSink struct {
+	webhook *WebhookAlertSink
+}
+
+// NewAlertmanagerSink creates a sink for the Alertmanager at baseURL, e.g. http://alertmanager:9093
+func NewAlertmanagerSink(baseURL string, client *http.Client) *AlertmanagerSink {
+	return &AlertmanagerSink{
+		webhook: NewWebhookAlertSink(strings.TrimSuffix(baseURL, "/")+"/api/v2/alerts", client),
+	}
+}
+
+func (s *AlertmanagerSink) Notify(ctx context.Context, alert Alert) error {
+	return s.webhook.post(ctx, ToAlertmanager([]Alert{alert}))
+}
+
+// AlertSource is anything that can report its current alerts, such as an
+// EventRuleEngine or MetricAlertEngine
+type AlertSource interface {
+	Alerts() []Alert
+}
+
+// CollectAlerts merges the alerts of several sources, oldest first
+func CollectAlerts(sources ...AlertSource) []Alert {
+	var all []Alert
+	for _, source := range sources {
+		all = append(all, source.Alerts()...)
+	}
+	sortAlerts(all)
+	return all
+}
+
+// filterAlerts keeps alerts with the given status; an empty status keeps everything
+func filterAlerts(alerts []Alert, status AlertStatus) []Alert {
+	if status == "" {
+		return alerts
+	}
+	filtered := alerts[:0]
+	for _, alert := range alerts {
+		if alert.Status == status {
+			filtered = append(filtered, alert)
+		}
+	}
+	return filtered
+}
+
+// serveAlerts handles GET /api/v1/alerts, returning the alerts of every registered
+// source in Alertmanager format. ?status=firing or ?status=resolved filters them.
+func (s *MockHTTPServer) serveAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	status := AlertStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", AlertFiring, AlertResolved:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid status %q", status)})
+		return
+	}
+
+	s.mu.RLock()
+	sources := append([]AlertSource(nil), s.alertSources...)
+	s.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, ToAlertmanager(filterAlerts(CollectAlerts(sources...), status)))
+}
@@ -0,0 +1,473 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+var (
+	// ErrUnknownApproval is returned for approval IDs the gate has never held
+	ErrUnknownApproval = errors.New("unknown approval")
+	// ErrApprovalDecided is returned when approving or rejecting an intent that is no longer pending
+	ErrApprovalDecided = errors.New("approval already decided")
+)
+
+// ApprovalHistorySize is the number of decided approvals an ApprovalGate keeps
+const ApprovalHistorySize = 1000
+
+// ApprovalState is where a held intent is in the approval workflow
+type ApprovalState string
+
+const (
+	ApprovalPending    ApprovalState = "pending"
+	ApprovalApproved   ApprovalState = "approved"
+	ApprovalRejected   ApprovalState = "rejected"
+	ApprovalExpired    ApprovalState = "expired"
+	ApprovalSuperseded ApprovalState = "superseded"
+)
+
+func (s ApprovalState) valid() bool {
+	switch s {
+	case ApprovalPending, ApprovalApproved, ApprovalRejected, ApprovalExpired, ApprovalSuperseded:
+		return true
+	}
+	return false
+}
+
+// ApprovalRule holds intents in scope for a human decision. Without thresholds
+// every intent in scope is held; otherwise only those whose change exceeds one.
+type ApprovalRule struct {
+	Name  string      `json:"name"`
+	Scope PolicyScope `json:"scope"`
+	// MaxRatio holds intents that scale to more than MaxRatio times, or less than
+	// 1/MaxRatio of, the current replicas
+	MaxRatio float64 `json:"maxRatio,omitempty"`
+	// MaxChange holds intents that add or remove more than MaxChange replicas
+	MaxChange int32 `json:"maxChange,omitempty"`
+}
+
+// ApprovalConfig configures an ApprovalGate
+type ApprovalConfig struct {
+	Rules []ApprovalRule
+	// Timeout is how long an intent stays pending before it expires
+	Timeout time.Duration
+}
+
+// PendingApproval is an intent held by the gate and its decision
+type PendingApproval struct {
+	ID              string        `json:"id"`
+	TenantID        string        `json:"tenantId"`
+	ClusterID       string        `json:"clusterId"`
+	Namespace       string        `json:"namespace"`
+	Workload        string        `json:"workload"`
+	CurrentReplicas int32         `json:"currentReplicas"`
+	TargetReplicas  int32         `json:"targetReplicas"`
+	Reason          string        `json:"reason"`
+	Rule            string        `json:"rule"`
+	State           ApprovalState `json:"state"`
+	RequestedAt     time.Time     `json:"requestedAt"`
+	ExpiresAt       time.Time     `json:"expiresAt"`
+	DecidedAt       time.Time     `json:"decidedAt"`
+	Actor           string        `json:"actor,omitempty"`
+	Comment         string        `json:"comment,omitempty"`
+	intent          *agent.ScalingIntent
+	sequence        uint64
+}
+
+// Key returns the workload the intent targets
+func (p PendingApproval) Key() WorkloadKey {
+	return WorkloadKey{TenantID: p.TenantID, ClusterID: p.ClusterID, Namespace: p.Namespace, Workload: p.Workload}
+}
+
+// ApprovalGate sits between the intent producers and the agents. Intents matching
+// an approval rule are held until approved, rejected or expired; everything else
+// passes straight through. Current replicas come from the ingested metrics, and
+// intents for workloads that have not reported yet are held by any rule in scope.
+// Intents reach the agent in the order the gate received them: an approved intent
+// is dropped if a newer one for its workload arrived first.
+type ApprovalGate struct {
+	next           ScalingIntentSink
+	config         ApprovalConfig
+	approvals      map[string]*PendingApproval
+	order          []string
+	replicas       map[WorkloadKey]int32
+	latest         map[WorkloadKey]uint64
+	sequence       uint64
+	tenantResolver func(clusterID string) string
+	audit          AuditRecorder
+	now            func() time.Time
+	sendMu         sync.Mutex
+	mu             sync.RWMutex
+}
+
+func NewApprovalGate(next ScalingIntentSink, config ApprovalConfig) (*ApprovalGate, error) {
+	if config.Timeout == 0 {
+		config.Timeout = time.Hour
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("approval timeout must not be negative")
+	}
+	for _, rule := range config.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("approval rule name is required")
+		}
+		if rule.MaxRatio != 0 && rule.MaxRatio <= 1 {
+			return nil, fmt.Errorf("approval rule %q: max ratio must be greater than 1", rule.Name)
+		}
+		if rule.MaxChange < 0 {
+			return nil, fmt.Errorf("approval rule %q: max change must not be negative", rule.Name)
+		}
+	}
+	return &ApprovalGate{
+		next:      next,
+		config:    config,
+		approvals: make(map[string]*PendingApproval),
+		replicas:  make(map[WorkloadKey]int32),
+		latest:    make(map[WorkloadKey]uint64),
+		now:       time.Now,
+	}, nil
+}
+
+// SetClock replaces the time source, for tests
+func (g *ApprovalGate) SetClock(now func() time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.now = now
+}
+
+// SetTenantResolver sets the tenant whose approval rules apply to the intents of a cluster
+func (g *ApprovalGate) SetTenantResolver(resolver func(clusterID string) string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.tenantResolver = resolver
+}
+
+// SetAuditRecorder records every hold and decision
+func (g *ApprovalGate) SetAuditRecorder(recorder AuditRecorder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.audit = recorder
+}
+
+// Ingest records the current replicas of every workload in the report
+func (g *ApprovalGate) Ingest(tenantID string, report *agent.MetricsReport) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, workload := range report.WorkloadMetrics {
+		key := WorkloadKey{TenantID: tenantID, ClusterID: report.ClusterId, Namespace: workload.Namespace, Workload: workload.WorkloadName}
+		g.replicas[key] = workload.Replicas
+	}
+}
+
+// SendScalingIntent holds the intent if a rule requires approval and forwards it otherwise
+func (g *ApprovalGate) SendScalingIntent(clusterID string, intent *agent.ScalingIntent) {
+	g.mu.Lock()
+	tenantID := ""
+	if g.tenantResolver != nil {
+		tenantID = g.tenantResolver(clusterID)
+	}
+	key := WorkloadKey{TenantID: tenantID, ClusterID: clusterID, Namespace: intent.WorkloadNamespace, Workload: intent.WorkloadName}
+	current, known := g.replicas[key]
+	g.sequence++
+	sequence := g.sequence
+	g.latest[key] = sequence
+
+	// Any newer intent for the workload replaces a pending one, whether it is held
+	// itself or not, so that an outdated target can no longer be approved
+	now := g.now()
+	for _, id := range g.order {
+		if approval := g.approvals[id]; approval.State == ApprovalPending && approval.Key() == key {
+			approval.State = ApprovalSuperseded
+			approval.DecidedAt = now
+			approval.Comment = fmt.Sprintf("superseded by %s", intent.IntentId)
+			g.record(approval, "approval-gate", approval.Comment)
+		}
+	}
+
+	rule, held := g.match(key, current, known, intent.TargetReplicas)
+	if !held {
+		g.mu.Unlock()
+		g.forward(key, sequence, clusterID, intent)
+		return
+	}
+
+	approval := &PendingApproval{
+		ID:              intent.IntentId,
+		TenantID:        tenantID,
+		ClusterID:       clusterID,
+		Namespace:       intent.WorkloadNamespace,
+		Workload:        intent.WorkloadName,
+		CurrentReplicas: current,
+		TargetReplicas:  intent.TargetReplicas,
+		Reason:          intent.Reason,
+		Rule:            rule,
+		State:           ApprovalPending,
+		RequestedAt:     now,
+		ExpiresAt:       now.Add(g.config.Timeout),
+		intent:          intent,
+		sequence:        sequence,
+	}
+	g.approvals[intent.IntentId] = approval
+	g.record(approval, "approval-gate", "rule "+rule)
+	g.order = append(g.order, intent.IntentId)
+	g.prune()
+	g.mu.Unlock()
+}
+
+// forward sends an intent on unless a newer intent for its workload arrived
+// after it, and reports whether it was sent. Forwards are serialized, so an
+// intent checked here can no longer be overtaken by a newer one.
+func (g *ApprovalGate) forward(key WorkloadKey, sequence uint64, clusterID string, intent *agent.ScalingIntent) bool {
+	g.sendMu.Lock()
+	defer g.sendMu.Unlock()
+	g.mu.RLock()
+	newest := g.latest[key] == sequence
+	g.mu.RUnlock()
+	if !newest {
+		return false
+	}
+	g.next.SendScalingIntent(clusterID, intent)
+	return true
+}
+
+// prune must be called with g.mu held. It drops the oldest decided approvals
+// beyond ApprovalHistorySize; pending ones are kept until they are decided.
+func (g *ApprovalGate) prune() {
+	decided := 0
+	for _, id := range g.order {
+		if g.approvals[id].State != ApprovalPending {
+			decided++
+		}
+	}
+	if decided <= ApprovalHistorySize {
+		return
+	}
+	kept := g.order[:0]
+	for _, id := range g.order {
+		if decided > ApprovalHistorySize && g.approvals[id].State != ApprovalPending {
+			delete(g.approvals, id)
+			decided--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	g.order = kept
+}
+
+// match must be called with g.mu held
+func (g *ApprovalGate) match(key WorkloadKey, current int32, known bool, target int32) (string, bool) {
+	for _, rule := range g.config.Rules {
+		if !rule.Scope.matches(key) {
+			continue
+		}
+		if (rule.MaxRatio == 0 && rule.MaxChange == 0) || !known {
+			return rule.Name, true
+		}
+		change := target - current
+		if rule.MaxChange > 0 && (change > rule.MaxChange || -change > rule.MaxChange) {
+			return rule.Name, true
+		}
+		if rule.MaxRatio > 0 && (float64(target) > rule.MaxRatio*float64(current) || float64(target)*rule.MaxRatio < float64(current)) {
+			return rule.Name, true
+		}
+	}
+	return "", false
+}
+
+// Approve releases a pending intent to the agent. An intent overtaken by a newer
+// one for its workload before it could be sent is superseded instead.
+func (g *ApprovalGate) Approve(id, actor, comment string) (PendingApproval, error) {
+	approval, err := g.decide(id, ApprovalApproved, actor, comment)
+	if err != nil {
+		return PendingApproval{}, err
+	}
+	if g.forward(approval.Key(), approval.sequence, approval.ClusterID, approval.intent) {
+		return approval, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if current, exists := g.approvals[id]; exists {
+		current.State = ApprovalSuperseded
+		current.DecidedAt = g.now()
+		current.Comment = "superseded by a newer intent before it was sent"
+		g.record(current, "approval-gate", current.Comment)
+	}
+	return PendingApproval{}, fmt.Errorf("%w: %s was superseded before it was sent", ErrApprovalDecided, id)
+}
+
+// Reject drops a pending intent
+func (g *ApprovalGate) Reject(id, actor, comment string) (PendingApproval, error) {
+	return g.decide(id, ApprovalRejected, actor, comment)
+}
+
+func (g *ApprovalGate) decide(id string, state ApprovalState, actor, comment string) (PendingApproval, error) {
+	if actor == "" {
+		return PendingApproval{}, fmt.Errorf("actor is required")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	approval, exists := g.approvals[id]
+	if !exists {
+		return PendingApproval{}, fmt.Errorf("%w: %s", ErrUnknownApproval, id)
+	}
+	now := g.now()
+	g.expire(approval, now)
+	if approval.State != ApprovalPending {
+		return PendingApproval{}, fmt.Errorf("%w: %s is %s", ErrApprovalDecided, id, approval.State)
+	}
+	approval.State = state
+	approval.DecidedAt = now
+	approval.Actor = actor
+	approval.Comment = comment
+	g.record(approval, actor, comment)
+	return *approval, nil
+}
+
+// record must be called with g.mu held
+func (g *ApprovalGate) record(approval *PendingApproval, actor, detail string) {
+	if g.audit != nil {
+		g.audit.Record(approval.auditEntry(actor, detail))
+	}
+}
+
+// expire must be called with g.mu held
+func (g *ApprovalGate) expire(approval *PendingApproval, now time.Time) bool {
+	if approval.State != ApprovalPending || now.Before(approval.ExpiresAt) {
+		return false
+	}
+	approval.State = ApprovalExpired
+	approval.DecidedAt = approval.ExpiresAt
+	g.record(approval, "approval-gate", "no decision before "+approval.ExpiresAt.Format(time.RFC3339))
+	return true
+}
+
+// Tick expires pending intents past their timeout and returns how many expired
+func (g *ApprovalGate) Tick() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	expired := 0
+	for _, id := range g.order {
+		if g.expire(g.approvals[id], now) {
+			expired++
+		}
+	}
+	return expired
+}
+
+// Get returns a held intent by ID
+func (g *ApprovalGate) Get(id string) (PendingApproval, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	approval, exists := g.approvals[id]
+	if !exists {
+		return PendingApproval{}, false
+	}
+	g.expire(approval, g.now())
+	return *approval, true
+}
+
+// List returns the held intents of a tenant in the given states, newest first.
+// Empty arguments match everything.
+func (g *ApprovalGate) List(tenantID string, states ...ApprovalState) []PendingApproval {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	approvals := []PendingApproval{}
+	for _, id := range g.order {
+		approval := g.approvals[id]
+		g.expire(approval, now)
+		if tenantID != "" && approval.TenantID != tenantID {
+			continue
+		}
+		if len(states) > 0 && !containsApprovalState(states, approval.State) {
+			continue
+		}
+		approvals = append(approvals, *approval)
+	}
+	sort.SliceStable(approvals, func(i, j int) bool { return approvals[i].RequestedAt.After(approvals[j].RequestedAt) })
+	return approvals
+}
+
+func containsApprovalState(states []ApprovalState, state ApprovalState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// approvalDecision is the body of an approve or reject request
+type approvalDecision struct {
+	Actor   string `json:"actor"`
+	Comment string `json:"comment"`
+}
+
+// ServeHTTP serves the approval API when mounted on /api/v1/approvals and /api/v1/approvals/:
+//
+//	GET  /api/v1/approvals?tenant=tenant-1&state=pending
+//	GET  /api/v1/approvals/{id}
+//	POST /api/v1/approvals/{id}/approve   {"actor": "alice", "comment": "planned migration"}
+//	POST /api/v1/approvals/{id}/reject
+func (g *ApprovalGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/approvals"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		var states []ApprovalState
+		for _, state := range r.URL.Query()["state"] {
+			if !ApprovalState(state).valid() {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid state %q", state)})
+				return
+			}
+			states = append(states, ApprovalState(state))
+		}
+		writeJSON(w, http.StatusOK, g.List(r.URL.Query().Get("tenant"), states...))
+
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		approval, exists := g.Get(parts[0])
+		if !exists {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s: %s", ErrUnknownApproval, parts[0])})
+			return
+		}
+		writeJSON(w, http.StatusOK, approval)
+
+	case len(parts) == 2 && r.Method == http.MethodPost && (parts[1] == "approve" || parts[1] == "reject"):
+		var decision approvalDecision
+		if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid body: %v", err)})
+			return
+		}
+		decide := g.Approve
+		if parts[1] == "reject" {
+			decide = g.Reject
+		}
+		approval, err := decide(parts[0], decision.Actor, decision.Comment)
+		switch {
+		case errors.Is(err, ErrUnknownApproval):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case errors.Is(err, ErrApprovalDecided):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, approval)
+		}
+
+	case path == "" || len(parts) == 1 || len(parts) == 2 && (parts[1] == "approve" || parts[1] == "reject"):
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
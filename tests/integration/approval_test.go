@@ -0,0 +1,276 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+var productionApprovalRules = []ApprovalRule{
+	{Name: "postgres", Scope: PolicyScope{Workload: "postgres"}},
+	{Name: "production-2x", Scope: PolicyScope{Namespace: "production"}, MaxRatio: 2},
+}
+
+// approvalReport reports the current replicas of the workloads the approval tests scale
+func approvalReport() *agentv1.MetricsReport {
+	return &agentv1.MetricsReport{
+		ClusterId: "cluster-1",
+		WorkloadMetrics: []*agentv1.WorkloadMetric{
+			{Namespace: "production", WorkloadName: "api", WorkloadType: "deployment", Replicas: 2},
+			{Namespace: "production", WorkloadName: "postgres", WorkloadType: "statefulset", Replicas: 3},
+			{Namespace: "staging", WorkloadName: "api", WorkloadType: "deployment", Replicas: 2},
+		},
+	}
+}
+
+func approvalIntent(id, namespace, workload string, replicas int32) *agentv1.ScalingIntent {
+	return &agentv1.ScalingIntent{IntentId: id, WorkloadNamespace: namespace, WorkloadName: workload, WorkloadType: "deployment", TargetReplicas: replicas}
+}
+
+// TestApprovalGateHoldsLargeIntents tests which intents are held and how decisions release them
+func TestApprovalGateHoldsLargeIntents(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	var sent []string
+	gate, err := NewApprovalGate(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		sent = append(sent, intent.IntentId)
+	}), ApprovalConfig{Rules: productionApprovalRules, Timeout: 30 * time.Minute})
+	require.NoError(t, err)
+	gate.SetClock(clock.Now)
+	gate.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	gate.Ingest("tenant-1", approvalReport())
+
+	gate.SendScalingIntent("cluster-1", approvalIntent("double", "production", "api", 4))
+	gate.SendScalingIntent("cluster-1", approvalIntent("more-than-double", "production", "api", 5))
+	gate.SendScalingIntent("cluster-1", approvalIntent("staging", "staging", "api", 10))
+	gate.SendScalingIntent("cluster-1", approvalIntent("postgres", "production", "postgres", 4))
+	gate.SendScalingIntent("cluster-1", approvalIntent("unreported", "production", "search", 3))
+	require.Equal(t, []string{"double", "staging"}, sent)
+
+	pending := gate.List("tenant-1", ApprovalPending)
+	require.Len(t, pending, 3)
+	held, ok := gate.Get("more-than-double")
+	require.True(t, ok)
+	require.Equal(t, "production-2x", held.Rule)
+	require.Equal(t, int32(2), held.CurrentReplicas)
+	require.Equal(t, clock.Now().Add(30*time.Minute), held.ExpiresAt)
+	postgres, _ := gate.Get("postgres")
+	require.Equal(t, "postgres", postgres.Rule, "workload rules hold any change")
+	unreported, _ := gate.Get("unreported")
+	require.Equal(t, "production-2x", unreported.Rule, "unknown current replicas are held")
+
+	// Approval releases the intent with the decision recorded
+	_, err = gate.Approve("postgres", "", "")
+	require.Error(t, err, "an actor is required")
+	clock.Advance(5 * time.Minute)
+	approved, err := gate.Approve("postgres", "alice", "planned failover test")
+	require.NoError(t, err)
+	require.Equal(t, ApprovalApproved, approved.State)
+	require.Equal(t, "alice", approved.Actor)
+	require.Equal(t, "planned failover test", approved.Comment)
+	require.Equal(t, clock.Now(), approved.DecidedAt)
+	require.Equal(t, []string{"double", "staging", "postgres"}, sent)
+	_, err = gate.Approve("postgres", "alice", "")
+	require.ErrorIs(t, err, ErrApprovalDecided)
+	_, err = gate.Reject("missing", "alice", "")
+	require.ErrorIs(t, err, ErrUnknownApproval)
+
+	rejected, err := gate.Reject("unreported", "bob", "search is being decommissioned")
+	require.NoError(t, err)
+	require.Equal(t, ApprovalRejected, rejected.State)
+
+	// A newer intent supersedes the pending one of the same workload
+	gate.SendScalingIntent("cluster-1", approvalIntent("even-more", "production", "api", 8))
+	superseded, _ := gate.Get("more-than-double")
+	require.Equal(t, ApprovalSuperseded, superseded.State)
+	require.Equal(t, "superseded by even-more", superseded.Comment)
+
+	// Undecided intents expire and can no longer be approved
+	clock.Advance(30 * time.Minute)
+	require.Equal(t, 1, gate.Tick())
+	require.Zero(t, gate.Tick())
+	_, err = gate.Approve("even-more", "alice", "")
+	require.ErrorIs(t, err, ErrApprovalDecided)
+	require.Empty(t, gate.List("", ApprovalPending))
+	require.Empty(t, gate.List("tenant-2"))
+	require.Equal(t, []string{"double", "staging", "postgres"}, sent)
+
+	_, err = NewApprovalGate(nil, ApprovalConfig{Rules: []ApprovalRule{{Name: "half", MaxRatio: 0.5}}})
+	require.Error(t, err)
+}
+
+// TestApprovalSupersededByPassingIntent tests that an intent passing the gate replaces
+// a pending approval of the same workload
+func TestApprovalSupersededByPassingIntent(t *testing.T) {
+	var sent []string
+	gate, err := NewApprovalGate(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		sent = append(sent, intent.IntentId)
+	}), ApprovalConfig{Rules: productionApprovalRules})
+	require.NoError(t, err)
+	gate.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	gate.Ingest("tenant-1", approvalReport())
+
+	gate.SendScalingIntent("cluster-1", approvalIntent("spike", "production", "api", 10))
+	gate.SendScalingIntent("cluster-1", approvalIntent("settled", "production", "api", 3))
+	require.Equal(t, []string{"settled"}, sent)
+
+	_, err = gate.Approve("spike", "alice", "")
+	require.ErrorIs(t, err, ErrApprovalDecided)
+	superseded, _ := gate.Get("spike")
+	require.Equal(t, ApprovalSuperseded, superseded.State)
+	require.Equal(t, "superseded by settled", superseded.Comment)
+	require.Equal(t, []string{"settled"}, sent)
+}
+
+// TestApprovalNeverSentAfterNewerIntent tests that an approved intent overtaken by a newer
+// one before it is sent never reaches the agent, and that decided approvals are bounded
+func TestApprovalNeverSentAfterNewerIntent(t *testing.T) {
+	blocked, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var sent []string
+	gate, err := NewApprovalGate(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		if intent.IntentId == "blocking" {
+			close(blocked)
+			<-release
+		}
+		mu.Lock()
+		sent = append(sent, intent.IntentId)
+		mu.Unlock()
+	}), ApprovalConfig{Rules: productionApprovalRules})
+	require.NoError(t, err)
+	gate.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	gate.Ingest("tenant-1", approvalReport())
+	gate.SendScalingIntent("cluster-1", approvalIntent("spike", "production", "api", 10))
+
+	// Hold up the agent with an intent for another workload while the spike is
+	// approved and a newer intent for its workload arrives
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		gate.SendScalingIntent("cluster-1", approvalIntent("blocking", "staging", "api", 3))
+	}()
+	<-blocked
+	var approveErr error
+	go func() {
+		defer wg.Done()
+		_, approveErr = gate.Approve("spike", "alice", "")
+	}()
+	require.Eventually(t, func() bool {
+		approval, _ := gate.Get("spike")
+		return approval.State == ApprovalApproved
+	}, time.Second, time.Millisecond)
+	go func() {
+		defer wg.Done()
+		gate.SendScalingIntent("cluster-1", approvalIntent("settled", "production", "api", 3))
+	}()
+	require.Eventually(t, func() bool {
+		gate.mu.RLock()
+		defer gate.mu.RUnlock()
+		return gate.sequence == 3
+	}, time.Second, time.Millisecond, "the newer intent has reached the gate")
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, []string{"blocking", "settled"}, sent)
+	require.ErrorIs(t, approveErr, ErrApprovalDecided)
+	spike, _ := gate.Get("spike")
+	require.Equal(t, ApprovalSuperseded, spike.State)
+
+	// Only the newest decided approvals are kept
+	for i := 0; i <= ApprovalHistorySize; i++ {
+		gate.SendScalingIntent("cluster-1", approvalIntent(fmt.Sprintf("postgres-%d", i), "production", "postgres", 4))
+	}
+	approvals := gate.List("tenant-1")
+	require.Len(t, approvals, ApprovalHistorySize+1)
+	require.Equal(t, ApprovalPending, approvals[0].State)
+	_, kept := gate.Get("spike")
+	require.False(t, kept)
+}
+
+// TestApprovalAPI tests deciding held intents over HTTP, with approved intents reaching the tracker
+func TestApprovalAPI(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	tracker, handler := newTrackedHandler(clock, IntentTrackerConfig{})
+	tracker.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	gate, err := NewApprovalGate(tracker, ApprovalConfig{Rules: productionApprovalRules})
+	require.NoError(t, err)
+	gate.SetClock(clock.Now)
+	gate.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	gate.Ingest("tenant-1", approvalReport())
+
+	gate.SendScalingIntent("cluster-1", approvalIntent("postgres", "production", "postgres", 6))
+	gate.SendScalingIntent("cluster-1", approvalIntent("api", "production", "api", 6))
+	require.Empty(t, handler.GetReceivedIntents())
+	_, tracked := tracker.Get("postgres")
+	require.False(t, tracked, "held intents are not issued")
+
+	httpServer := NewMockHTTPServer(NewMockMetricsService())
+	httpServer.Handle("/api/v1/approvals", gate)
+	httpServer.Handle("/api/v1/approvals/", gate)
+	server := httptest.NewServer(httpServer.Handler())
+	defer server.Close()
+
+	request := func(method, path string, body interface{}) (int, []byte) {
+		var reader bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reader).Encode(body))
+		}
+		req, err := http.NewRequest(method, server.URL+path, &reader)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var data bytes.Buffer
+		data.ReadFrom(resp.Body)
+		return resp.StatusCode, data.Bytes()
+	}
+
+	status, body := request(http.MethodGet, "/api/v1/approvals?tenant=tenant-1&state=pending", nil)
+	require.Equal(t, http.StatusOK, status)
+	var pending []PendingApproval
+	require.NoError(t, json.Unmarshal(body, &pending))
+	require.Len(t, pending, 2)
+
+	status, body = request(http.MethodPost, "/api/v1/approvals/postgres/approve", map[string]string{"actor": "alice", "comment": "capacity for the migration"})
+	require.Equal(t, http.StatusOK, status)
+	var approved PendingApproval
+	require.NoError(t, json.Unmarshal(body, &approved))
+	require.Equal(t, ApprovalApproved, approved.State)
+	require.Equal(t, "alice", approved.Actor)
+
+	record, tracked := tracker.Get("postgres")
+	require.True(t, tracked)
+	require.Equal(t, IntentDelivered, record.State)
+	require.Len(t, handler.GetReceivedIntents(), 1)
+
+	status, _ = request(http.MethodPost, "/api/v1/approvals/postgres/approve", map[string]string{"actor": "alice"})
+	require.Equal(t, http.StatusConflict, status)
+	status, _ = request(http.MethodPost, "/api/v1/approvals/api/reject", map[string]string{})
+	require.Equal(t, http.StatusBadRequest, status, "an actor is required")
+	status, _ = request(http.MethodPost, "/api/v1/approvals/api/reject", map[string]string{"actor": "bob"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = request(http.MethodPost, "/api/v1/approvals/unknown/approve", map[string]string{"actor": "bob"})
+	require.Equal(t, http.StatusNotFound, status)
+
+	status, body = request(http.MethodGet, "/api/v1/approvals/api", nil)
+	require.Equal(t, http.StatusOK, status)
+	var rejected PendingApproval
+	require.NoError(t, json.Unmarshal(body, &rejected))
+	require.Equal(t, ApprovalRejected, rejected.State)
+	require.Equal(t, "bob", rejected.Actor)
+
+	status, _ = request(http.MethodGet, "/api/v1/approvals?state=maybe", nil)
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = request(http.MethodDelete, "/api/v1/approvals/api", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+	require.Len(t, handler.GetReceivedIntents(), 1)
+}
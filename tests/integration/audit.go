@@ -0,0 +1,438 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// DefaultAuditRetention is how long audit entries are kept for tenants without their own retention
+const DefaultAuditRetention = 90 * 24 * time.Hour
+
+// AuditEventType is the stage of a scaling decision an audit entry records
+type AuditEventType string
+
+const (
+	// AuditRecommendation is a computed recommendation that produced an intent
+	AuditRecommendation AuditEventType = "recommendation"
+	// AuditApproval is a change of the approval state of a held intent
+	AuditApproval AuditEventType = "approval"
+	// AuditConflict is an intent for a workload with its own autoscaler
+	AuditConflict AuditEventType = "conflict"
+	// AuditIntent is a change of the delivery or execution state of an intent
+	AuditIntent AuditEventType = "intent"
+	// AuditFreeze is a freeze being set or lifted, or an intent dropped by one
+	AuditFreeze AuditEventType = "freeze"
+)
+
+func (t AuditEventType) valid() bool {
+	switch t {
+	case AuditRecommendation, AuditApproval, AuditConflict, AuditIntent, AuditFreeze:
+		return true
+	}
+	return false
+}
+
+// MetricsSnapshot is the workload metrics a recommendation was computed from
+type MetricsSnapshot struct {
+	Replicas          int32              `json:"replicas"`
+	AvailableReplicas int32              `json:"availableReplicas"`
+	CPUPercentage     float64            `json:"cpuPercentage"`
+	MemoryPercentage  float64            `json:"memoryPercentage"`
+	CustomMetrics     map[string]float64 `json:"customMetrics,omitempty"`
+}
+
+func newMetricsSnapshot(workload *agent.WorkloadMetric) *MetricsSnapshot {
+	snapshot := &MetricsSnapshot{Replicas: workload.Replicas, AvailableReplicas: workload.AvailableReplicas}
+	if workload.Usage != nil {
+		snapshot.CPUPercentage = workload.Usage.CpuPercentage
+		snapshot.MemoryPercentage = workload.Usage.MemoryPercentage
+	}
+	if len(workload.CustomMetrics) > 0 {
+		snapshot.CustomMetrics = make(map[string]float64, len(workload.CustomMetrics))
+		for name, value := range workload.CustomMetrics {
+			snapshot.CustomMetrics[name] = value
+		}
+	}
+	return snapshot
+}
+
+// AuditedRecommendation is the recommendation before the scaling policies were applied
+type AuditedRecommendation struct {
+	Metric              string           `json:"metric"`
+	TargetType          MetricTargetType `json:"targetType"`
+	Usage               float64          `json:"usage"`
+	Target              float64          `json:"target"`
+	Predicted           bool             `json:"predicted,omitempty"`
+	RecommendedReplicas int32            `json:"recommendedReplicas"`
+}
+
+// AuditEntry is one record of the audit trail. Entries of the same intent share
+// its IntentID, so the trail of an intent reads from the recommendation through
+// approval to the result reported by the agent.
+type AuditEntry struct {
+	Sequence  uint64         `json:"sequence"`
+	Timestamp time.Time      `json:"timestamp"`
+	Type      AuditEventType `json:"type"`
+	TenantID  string         `json:"tenantId"`
+	ClusterID string         `json:"clusterId"`
+	Namespace string         `json:"namespace"`
+	Workload  string         `json:"workload"`
+	IntentID  string         `json:"intentId,omitempty"`
+	// Actor is who or what caused the entry: a component, an agent or a person
+	Actor          string                 `json:"actor"`
+	State          string                 `json:"state,omitempty"`
+	Metrics        *MetricsSnapshot       `json:"metrics,omitempty"`
+	Recommendation *AuditedRecommendation `json:"recommendation,omitempty"`
+	Limits         []PolicyLimit          `json:"limits,omitempty"`
+	TargetReplicas int32                  `json:"targetReplicas,omitempty"`
+	// ObservedReplicas are the replicas the agent reported with an execution result
+	ObservedReplicas int32  `json:"observedReplicas,omitempty"`
+	Detail           string `json:"detail,omitempty"`
+}
+
+// AuditRecorder receives audit entries from the components that make scaling decisions
+type AuditRecorder interface {
+	Record(entry AuditEntry)
+}
+
+// AuditQuery selects audit entries. Empty fields match everything.
+type AuditQuery struct {
+	TenantID  string
+	ClusterID string
+	Namespace string
+	Workload  string
+	IntentID  string
+	Types     []AuditEventType
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	if (q.TenantID != "" && entry.TenantID != q.TenantID) ||
+		(q.ClusterID != "" && entry.ClusterID != q.ClusterID) ||
+		(q.Namespace != "" && entry.Namespace != q.Namespace) ||
+		(q.Workload != "" && entry.Workload != q.Workload) ||
+		(q.IntentID != "" && entry.IntentID != q.IntentID) {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && entry.Timestamp.After(q.Until) {
+		return false
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if t == entry.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditLog is an append-only trail of scaling decisions. Entries are only ever
+// removed by Prune once they are older than the retention of their tenant.
+type AuditLog struct {
+	entries   []AuditEntry
+	sequence  uint64
+	retention map[string]time.Duration
+	fallback  time.Duration
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+// NewAuditLog creates a log keeping entries for retention unless a tenant has its own
+func NewAuditLog(retention time.Duration) *AuditLog {
+	if retention <= 0 {
+		retention = DefaultAuditRetention
+	}
+	return &AuditLog{
+		retention: make(map[string]time.Duration),
+		fallback:  retention,
+		now:       time.Now,
+	}
+}
+
+// SetClock replaces the time source, for tests
+func (l *AuditLog) SetClock(now func() time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.now = now
+}
+
+// SetTenantRetention sets how long the entries of a tenant are kept
+func (l *AuditLog) SetTenantRetention(tenantID string, retention time.Duration) error {
+	if retention <= 0 {
+		return fmt.Errorf("retention must be positive")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.retention[tenantID] = retention
+	return nil
+}
+
+// Record appends an entry, numbering it and stamping it with the current time if
+// it has none
+func (l *AuditLog) Record(entry AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sequence++
+	entry.Sequence = l.sequence
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = l.now()
+	}
+	entry.Limits = append([]PolicyLimit(nil), entry.Limits...)
+	l.entries = append(l.entries, entry)
+}
+
+// Prune removes the entries past the retention of their tenant and returns how many
+func (l *AuditLog) Prune() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	kept := l.entries[:0]
+	for _, entry := range l.entries {
+		retention, ok := l.retention[entry.TenantID]
+		if !ok {
+			retention = l.fallback
+		}
+		if now.Sub(entry.Timestamp) < retention {
+			kept = append(kept, entry)
+		}
+	}
+	pruned := len(l.entries) - len(kept)
+	l.entries = kept
+	return pruned
+}
+
+// Query returns the matching entries in the order they were recorded. A limit
+// keeps the most recent entries.
+func (l *AuditLog) Query(query AuditQuery) []AuditEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entries := []AuditEntry{}
+	for _, entry := range l.entries {
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[len(entries)-query.Limit:]
+	}
+	return entries
+}
+
+// ExportJSONL writes the matching entries as one JSON object per line
+func (l *AuditLog) ExportJSONL(w io.Writer, query AuditQuery) error {
+	encoder := json.NewEncoder(w)
+	for _, entry := range l.Query(query) {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the audit trail, e.g.
+// GET /api/v1/audit?tenant=tenant-1&workload=postgres&type=approval&since=24h&format=jsonl
+func (l *AuditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	params := r.URL.Query()
+	query := AuditQuery{
+		TenantID:  params.Get("tenant"),
+		ClusterID: params.Get("cluster"),
+		Namespace: params.Get("namespace"),
+		Workload:  params.Get("workload"),
+		IntentID:  params.Get("intent"),
+	}
+	for _, t := range params["type"] {
+		if !AuditEventType(t).valid() {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid type %q", t)})
+			return
+		}
+		query.Types = append(query.Types, AuditEventType(t))
+	}
+	l.mu.RLock()
+	now := l.now()
+	l.mu.RUnlock()
+	for name, field := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		ts, err := parseQueryTime(params.Get(name), now)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		*field = ts
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid limit %q", limit)})
+			return
+		}
+		query.Limit = n
+	}
+
+	switch params.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, l.Query(query))
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		l.ExportJSONL(w, query)
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid format %q", params.Get("format"))})
+	}
+}
+
+// auditEntry records a recommendation that produced intent, with the metrics of
+// the workload in report
+func (rec Recommendation) auditEntry(tenantID string, report *agent.MetricsReport, intent *agent.ScalingIntent) AuditEntry {
+	recommended := rec.DesiredReplicas
+	if len(rec.Limits) > 0 {
+		recommended = rec.Limits[0].From
+	}
+	entry := AuditEntry{
+		Timestamp: reportTime(report),
+		Type:      AuditRecommendation,
+		TenantID:  tenantID,
+		ClusterID: report.ClusterId,
+		Namespace: rec.Namespace,
+		Workload:  rec.Workload,
+		IntentID:  intent.IntentId,
+		Actor:     "recommender",
+		Recommendation: &AuditedRecommendation{
+			Metric:              rec.Metric,
+			TargetType:          rec.TargetType,
+			Usage:               rec.Usage,
+			Target:              rec.Target,
+			Predicted:           rec.Forecast != nil,
+			RecommendedReplicas: recommended,
+		},
+		Limits:         rec.Limits,
+		TargetReplicas: intent.TargetReplicas,
+		Detail:         intent.Reason,
+	}
+	for _, workload := range report.WorkloadMetrics {
+		if workload.Namespace == rec.Namespace && workload.WorkloadName == rec.Workload {
+			entry.Metrics = newMetricsSnapshot(workload)
+			break
+		}
+	}
+	return entry
+}
+
+// auditEntry records the intent entering its current state. States reported by
+// the agent are attributed to it.
+func (r *IntentRecord) auditEntry(detail string) AuditEntry {
+	actor := "intent-tracker"
+	switch r.State {
+	case IntentDelivered, IntentApplying, IntentSucceeded, IntentFailed:
+		actor = "agent/" + r.ClusterID
+	}
+	return AuditEntry{
+		Timestamp:        r.UpdatedAt,
+		Type:             AuditIntent,
+		TenantID:         r.TenantID,
+		ClusterID:        r.ClusterID,
+		Namespace:        r.Namespace,
+		Workload:         r.Workload,
+		IntentID:         r.IntentID,
+		Actor:            actor,
+		State:            string(r.State),
+		TargetReplicas:   r.TargetReplicas,
+		ObservedReplicas: r.ObservedReplicas,
+		Detail:           detail,
+	}
+}
+
+// auditEntry records an intent emitted when the window of the action started.
+// The schedule is the actor and the window the detail.
+func (a ScheduledAction) auditEntry(intent *agent.ScalingIntent, at time.Time) AuditEntry {
+	return AuditEntry{
+		Timestamp:      at,
+		Type:           AuditRecommendation,
+		TenantID:       a.Key.TenantID,
+		ClusterID:      a.Key.ClusterID,
+		Namespace:      a.Key.Namespace,
+		Workload:       a.Key.Workload,
+		IntentID:       intent.IntentId,
+		Actor:          "schedule/" + a.Schedule,
+		TargetReplicas: intent.TargetReplicas,
+		Detail:         fmt.Sprintf("%s from %s until %s", a, a.Start.Format(time.RFC3339), a.End.Format(time.RFC3339)),
+	}
+}
+
+// auditEntry records the approval entering its current state
+func (p *PendingApproval) auditEntry(actor, detail string) AuditEntry {
+	at := p.DecidedAt
+	if p.State == ApprovalPending {
+		at = p.RequestedAt
+	}
+	return AuditEntry{
+		Timestamp:      at,
+		Type:           AuditApproval,
+		TenantID:       p.TenantID,
+		ClusterID:      p.ClusterID,
+		Namespace:      p.Namespace,
+		Workload:       p.Workload,
+		IntentID:       p.ID,
+		Actor:          actor,
+		State:          string(p.State),
+		TargetReplicas: p.TargetReplicas,
+		Detail:         detail,
+	}
+}
+
+// auditEntry records a conflict and what the guard did about it
+func (c IntentConflict) auditEntry(targetReplicas int32) AuditEntry {
+	names := make([]string, len(c.Autoscalers))
+	for i, autoscaler := range c.Autoscalers {
+		names[i] = autoscaler.String()
+	}
+	detail := "conflicts with " + strings.Join(names, ", ")
+	if c.Override != "" {
+		detail += "; override: " + c.Override
+	}
+	return AuditEntry{
+		Timestamp:      c.Timestamp,
+		Type:           AuditConflict,
+		TenantID:       c.TenantID,
+		ClusterID:      c.ClusterID,
+		Namespace:      c.Namespace,
+		Workload:       c.Workload,
+		IntentID:       c.IntentID,
+		Actor:          "conflict-guard",
+		State:          string(c.Action),
+		TargetReplicas: targetReplicas,
+		Detail:         detail,
+	}
+}
+
+// auditEntry records a change of the freeze, or an intent it dropped, in its scope
+func (f ScalingFreeze) auditEntry(state, actor string, at time.Time) AuditEntry {
+	return AuditEntry{
+		Timestamp: at,
+		Type:      AuditFreeze,
+		TenantID:  f.Scope.TenantID,
+		ClusterID: f.Scope.ClusterID,
+		Namespace: f.Scope.Namespace,
+		Workload:  f.Scope.Workload,
+		Actor:     actor,
+		State:     state,
+		Detail:    f.String(),
+	}
+}
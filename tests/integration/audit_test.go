@@ -0,0 +1,268 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// TestAuditTrailOfIntent tests that every stage of a scaling decision is recorded and
+// outlives the intent handler
+func TestAuditTrailOfIntent(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	audit := NewAuditLog(0)
+	audit.SetClock(clock.Now)
+
+	tracker, handler := newTrackedHandler(clock, IntentTrackerConfig{})
+	tracker.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	tracker.SetAuditRecorder(audit)
+	gate, err := NewApprovalGate(tracker, ApprovalConfig{Rules: []ApprovalRule{{Name: "search", Scope: PolicyScope{Workload: "elasticsearch"}}}})
+	require.NoError(t, err)
+	gate.SetClock(clock.Now)
+	gate.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	gate.SetAuditRecorder(audit)
+
+	engine, err := NewScalingPolicyEngine(ScalingPolicy{Name: "logging", Scope: PolicyScope{Namespace: "logging"}, MaxReplicas: int32Ptr(4)})
+	require.NoError(t, err)
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 60, TargetMemoryPercentage: 80})
+	require.NoError(t, err)
+	recommender.SetPolicyEngine(engine)
+	recommender.SetAuditRecorder(audit)
+	recommender.AddIntentSink(gate)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	report.Timestamp = timestamppb.New(clock.Now())
+	gate.Ingest("tenant-1", report)
+	recommender.Ingest("tenant-1", report)
+
+	held := gate.List("tenant-1", ApprovalPending)
+	require.Len(t, held, 1)
+	intentID := held[0].ID
+	clock.Advance(10 * time.Minute)
+	_, err = gate.Approve(intentID, "alice", "log volume of the release")
+	require.NoError(t, err)
+	clock.Advance(time.Minute)
+	require.True(t, handler.StartIntent(intentID))
+	require.True(t, handler.CompleteIntent(intentID, nil))
+	handler.Clear()
+	require.Empty(t, handler.GetReceivedIntents())
+
+	trail := audit.Query(AuditQuery{IntentID: intentID})
+	var stages []string
+	for _, entry := range trail {
+		stages = append(stages, string(entry.Type)+"/"+entry.State+" by "+entry.Actor)
+	}
+	require.Equal(t, []string{
+		"recommendation/ by recommender",
+		"approval/pending by approval-gate",
+		"approval/approved by alice",
+		"intent/issued by intent-tracker",
+		"intent/delivered by agent/cluster-1",
+		"intent/applying by agent/cluster-1",
+		"intent/succeeded by agent/cluster-1",
+	}, stages)
+
+	recommendation := trail[0]
+	require.Equal(t, "tenant-1", recommendation.TenantID)
+	require.Equal(t, "logging", recommendation.Namespace)
+	require.Equal(t, clock.Now().Add(-11*time.Minute), recommendation.Timestamp)
+	require.Equal(t, int32(3), recommendation.Metrics.Replicas)
+	require.InDelta(t, 89.2, recommendation.Metrics.CPUPercentage, 0.001)
+	require.Equal(t, "cpu_percentage", recommendation.Recommendation.Metric)
+	require.Equal(t, int32(5), recommendation.Recommendation.RecommendedReplicas)
+	require.Len(t, recommendation.Limits, 1)
+	require.Equal(t, "logging", recommendation.Limits[0].Policy)
+	require.Equal(t, int32(4), recommendation.TargetReplicas)
+
+	require.Equal(t, "rule search", trail[1].Detail)
+	require.Equal(t, "log volume of the release", trail[2].Detail)
+	require.Equal(t, int32(4), trail[6].ObservedReplicas)
+	for i := 1; i < len(trail); i++ {
+		require.Greater(t, trail[i].Sequence, trail[i-1].Sequence)
+	}
+
+	// The other recommendations passed the gate straight to the tracker
+	require.Len(t, audit.Query(AuditQuery{Types: []AuditEventType{AuditRecommendation}}), 3)
+	require.Len(t, audit.Query(AuditQuery{Workload: "coredns", Types: []AuditEventType{AuditIntent}}), 2)
+	require.Empty(t, audit.Query(AuditQuery{TenantID: "tenant-2"}))
+	latest := audit.Query(AuditQuery{TenantID: "tenant-1", Limit: 2})
+	require.Equal(t, []string{"applying", "succeeded"}, []string{latest[0].State, latest[1].State})
+}
+
+// TestAuditTrailOfConflicts tests that refused and overridden intents are recorded
+func TestAuditTrailOfConflicts(t *testing.T) {
+	audit := NewAuditLog(0)
+	guard, err := NewConflictGuard(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {}), ConflictRefuse)
+	require.NoError(t, err)
+	guard.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	guard.SetAuditRecorder(audit)
+	guard.IngestAutoscalers("tenant-1", AutoscalerReport{ClusterID: "cluster-1", Autoscalers: []DiscoveredAutoscaler{{
+		Kind: AutoscalerKindHPA, Namespace: "production", Name: "api-hpa", TargetKind: "Deployment", TargetName: "api", MinReplicas: 2, MaxReplicas: 6,
+	}}})
+
+	guard.SendScalingIntent("cluster-1", approvalIntent("refused", "production", "api", 8))
+	guard.Override(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "api"}, "INC-1234")
+	guard.SendScalingIntent("cluster-1", approvalIntent("overridden", "production", "api", 8))
+
+	entries := audit.Query(AuditQuery{Types: []AuditEventType{AuditConflict}})
+	require.Len(t, entries, 2)
+	require.Equal(t, "refused", entries[0].State)
+	require.Equal(t, "conflict-guard", entries[0].Actor)
+	require.Equal(t, int32(8), entries[0].TargetReplicas)
+	require.Equal(t, "conflicts with HorizontalPodAutoscaler production/api-hpa", entries[0].Detail)
+	require.Equal(t, "overridden", entries[1].State)
+	require.Equal(t, "conflicts with HorizontalPodAutoscaler production/api-hpa; override: INC-1234", entries[1].Detail)
+}
+
+// TestAuditTrailOfSchedules tests that scheduled intents are attributed to their schedule
+// and followed through an agent without an intent tracker
+func TestAuditTrailOfSchedules(t *testing.T) {
+	// 08:01 in Berlin on a Monday, one minute into business hours
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 6, 1, 0, 0, time.UTC)}
+	audit := NewAuditLog(0)
+	audit.SetClock(clock.Now)
+
+	handler := NewMockScalingIntentHandler()
+	handler.SetAuditRecorder(audit, "tenant-1", "cluster-1")
+	scheduler, err := NewScalingScheduler(frontendSchedules()...)
+	require.NoError(t, err)
+	scheduler.SetClock(clock.Now)
+	scheduler.SetAuditRecorder(audit)
+	scheduler.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		handler.HandleScalingIntent(intent)
+	}))
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	report.Timestamp = timestamppb.New(clock.Now())
+	scheduler.Ingest("tenant-1", report)
+	require.Equal(t, 1, scheduler.Tick())
+	intentID := handler.GetReceivedIntents()[0].IntentId
+	require.True(t, handler.StartIntent(intentID))
+	require.True(t, handler.CompleteIntent(intentID, nil))
+
+	trail := audit.Query(AuditQuery{IntentID: intentID})
+	var stages []string
+	for _, entry := range trail {
+		stages = append(stages, string(entry.Type)+"/"+entry.State+" by "+entry.Actor)
+	}
+	require.Equal(t, []string{
+		"recommendation/ by schedule/business-hours",
+		"intent/delivered by agent/cluster-1",
+		"intent/applying by agent/cluster-1",
+		"intent/succeeded by agent/cluster-1",
+	}, stages)
+
+	scheduled := trail[0]
+	require.Equal(t, frontendKey, WorkloadKey{TenantID: scheduled.TenantID, ClusterID: scheduled.ClusterID, Namespace: scheduled.Namespace, Workload: scheduled.Workload})
+	require.Equal(t, "min 6 replicas from 2025-09-01T06:00:00Z until 2025-09-01T16:00:00Z", scheduled.Detail)
+	require.Equal(t, int32(3), scheduled.Metrics.Replicas)
+	require.Equal(t, int32(6), scheduled.TargetReplicas)
+	require.Equal(t, "tenant-1", trail[3].TenantID)
+	require.Equal(t, int32(6), trail[3].ObservedReplicas)
+}
+
+// TestAuditRetention tests that entries are pruned after the retention of their tenant
+func TestAuditRetention(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	audit := NewAuditLog(30 * 24 * time.Hour)
+	audit.SetClock(clock.Now)
+	require.NoError(t, audit.SetTenantRetention("regulated", 365*24*time.Hour))
+	require.Error(t, audit.SetTenantRetention("tenant-1", 0))
+
+	audit.Record(AuditEntry{Type: AuditIntent, TenantID: "tenant-1", IntentID: "old"})
+	audit.Record(AuditEntry{Type: AuditIntent, TenantID: "regulated", IntentID: "old-regulated"})
+	clock.Advance(20 * 24 * time.Hour)
+	audit.Record(AuditEntry{Type: AuditIntent, TenantID: "tenant-1", IntentID: "recent"})
+
+	require.Zero(t, audit.Prune())
+	clock.Advance(15 * 24 * time.Hour)
+	require.Equal(t, 1, audit.Prune())
+
+	var remaining []string
+	for _, entry := range audit.Query(AuditQuery{}) {
+		remaining = append(remaining, entry.IntentID)
+	}
+	require.Equal(t, []string{"old-regulated", "recent"}, remaining)
+
+	// Sequence numbers keep increasing after pruning
+	audit.Record(AuditEntry{Type: AuditIntent, TenantID: "tenant-1", IntentID: "newest"})
+	entries := audit.Query(AuditQuery{IntentID: "newest"})
+	require.Equal(t, uint64(4), entries[0].Sequence)
+}
+
+// TestAuditAPI tests querying and exporting the audit trail over HTTP
+func TestAuditAPI(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	audit := NewAuditLog(0)
+	audit.SetClock(clock.Now)
+	audit.Record(AuditEntry{Type: AuditRecommendation, TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "api", IntentID: "a"})
+	clock.Advance(time.Hour)
+	audit.Record(AuditEntry{Type: AuditApproval, TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "api", IntentID: "a", State: "pending"})
+	audit.Record(AuditEntry{Type: AuditIntent, TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "staging", Workload: "api", IntentID: "b", State: "issued"})
+	audit.Record(AuditEntry{Type: AuditIntent, TenantID: "tenant-2", ClusterID: "cluster-2", Namespace: "production", Workload: "api", IntentID: "c", State: "issued"})
+
+	httpServer := NewMockHTTPServer(NewMockMetricsService())
+	httpServer.Handle("/api/v1/audit", audit)
+	server := httptest.NewServer(httpServer.Handler())
+	defer server.Close()
+
+	get := func(query string) (*http.Response, []byte) {
+		resp, err := http.Get(server.URL + "/api/v1/audit" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var data bytes.Buffer
+		data.ReadFrom(resp.Body)
+		return resp, data.Bytes()
+	}
+
+	resp, body := get("?tenant=tenant-1&namespace=production")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var entries []AuditEntry
+	require.NoError(t, json.Unmarshal(body, &entries))
+	require.Len(t, entries, 2)
+
+	resp, body = get("?tenant=tenant-1&type=approval&type=intent&since=30m")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(body, &entries))
+	require.Len(t, entries, 2)
+	require.Equal(t, "a", entries[0].IntentID)
+	require.Equal(t, "b", entries[1].IntentID)
+
+	resp, body = get("?format=jsonl&limit=3")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	var sequences []uint64
+	for scanner.Scan() {
+		var entry AuditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		sequences = append(sequences, entry.Sequence)
+	}
+	require.Equal(t, []uint64{2, 3, 4}, sequences)
+
+	var exported strings.Builder
+	require.NoError(t, audit.ExportJSONL(&exported, AuditQuery{TenantID: "tenant-2"}))
+	require.Equal(t, 1, strings.Count(exported.String(), "\n"))
+	require.Contains(t, exported.String(), `"intentId":"c"`)
+
+	for _, query := range []string{"?type=scaling", "?since=yesterday", "?limit=0", "?format=csv"} {
+		resp, _ = get(query)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/api/v1/audit", nil)
+	require.NoError(t, err)
+	deleteResp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	deleteResp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, deleteResp.StatusCode)
+}
@@ -0,0 +1,360 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// Kinds of autoscalers that can fight over the replicas of a workload
+const (
+	AutoscalerKindHPA          = "HorizontalPodAutoscaler"
+	AutoscalerKindScaledObject = "ScaledObject"
+)
+
+// ConflictLogSize is the number of conflicts a ConflictGuard keeps
+const ConflictLogSize = 1000
+
+// ScaledObjectResource is the KEDA ScaledObject custom resource
+var ScaledObjectResource = schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledobjects"}
+
+// DiscoveredAutoscaler is an autoscaler object in the cluster and the workload it scales
+type DiscoveredAutoscaler struct {
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	TargetKind  string `json:"targetKind"`
+	TargetName  string `json:"targetName"`
+	MinReplicas int32  `json:"minReplicas,omitempty"`
+	MaxReplicas int32  `json:"maxReplicas,omitempty"`
+}
+
+func (a DiscoveredAutoscaler) String() string {
+	return fmt.Sprintf("%s %s/%s", a.Kind, a.Namespace, a.Name)
+}
+
+// Targets reports whether the autoscaler scales the given workload. An empty
+// workload type matches on the name alone.
+func (a DiscoveredAutoscaler) Targets(namespace, name, workloadType string) bool {
+	return a.Namespace == namespace && a.TargetName == name &&
+		(workloadType == "" || strings.EqualFold(a.TargetKind, workloadType))
+}
+
+// AutoscalerReport lists the autoscalers targeting the workloads of a
+// MetricsReport. The WorkloadMetric proto has no field for them yet, so it only
+// reaches the backend in Go, attached to IngestMetrics; ReportMetrics over gRPC
+// cannot carry it.
+type AutoscalerReport struct {
+	ClusterID   string                 `json:"clusterId"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Autoscalers []DiscoveredAutoscaler `json:"autoscalers"`
+}
+
+func (r AutoscalerReport) attachedCluster() string {
+	return r.ClusterID
+}
+
+// AutoscalerDiscovery finds the HPAs and, if installed, KEDA ScaledObjects of a
+// cluster on the agent side
+type AutoscalerDiscovery struct {
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+}
+
+// NewAutoscalerDiscovery creates a discovery. Without a dynamic client KEDA
+// ScaledObjects are not looked up.
+func NewAutoscalerDiscovery(client kubernetes.Interface, dynamicClient dynamic.Interface) *AutoscalerDiscovery {
+	return &AutoscalerDiscovery{client: client, dynamic: dynamicClient}
+}
+
+// Discover lists the autoscalers in namespace, or in all namespaces if it is empty
+func (d *AutoscalerDiscovery) Discover(ctx context.Context, namespace string) ([]DiscoveredAutoscaler, error) {
+	hpas, err := d.client.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list horizontal pod autoscalers: %w", err)
+	}
+	var autoscalers []DiscoveredAutoscaler
+	for _, hpa := range hpas.Items {
+		autoscaler := DiscoveredAutoscaler{
+			Kind:        AutoscalerKindHPA,
+			Namespace:   hpa.Namespace,
+			Name:        hpa.Name,
+			TargetKind:  hpa.Spec.ScaleTargetRef.Kind,
+			TargetName:  hpa.Spec.ScaleTargetRef.Name,
+			MaxReplicas: hpa.Spec.MaxReplicas,
+		}
+		if hpa.Spec.MinReplicas != nil {
+			autoscaler.MinReplicas = *hpa.Spec.MinReplicas
+		}
+		autoscalers = append(autoscalers, autoscaler)
+	}
+
+	if d.dynamic != nil {
+		scaledObjects, err := d.dynamic.Resource(ScaledObjectResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			// KEDA is not installed
+		case err != nil:
+			return nil, fmt.Errorf("failed to list scaled objects: %w", err)
+		default:
+			for _, item := range scaledObjects.Items {
+				autoscalers = append(autoscalers, scaledObjectAutoscaler(item))
+			}
+		}
+	}
+
+	sort.Slice(autoscalers, func(i, j int) bool {
+		if autoscalers[i].Namespace != autoscalers[j].Namespace {
+			return autoscalers[i].Namespace < autoscalers[j].Namespace
+		}
+		return autoscalers[i].Name < autoscalers[j].Name
+	})
+	return autoscalers, nil
+}
+
+func scaledObjectAutoscaler(item unstructured.Unstructured) DiscoveredAutoscaler {
+	autoscaler := DiscoveredAutoscaler{Kind: AutoscalerKindScaledObject, Namespace: item.GetNamespace(), Name: item.GetName()}
+	autoscaler.TargetName, _, _ = unstructured.NestedString(item.Object, "spec", "scaleTargetRef", "name")
+	autoscaler.TargetKind, _, _ = unstructured.NestedString(item.Object, "spec", "scaleTargetRef", "kind")
+	if autoscaler.TargetKind == "" {
+		// KEDA defaults the target to a Deployment
+		autoscaler.TargetKind = "Deployment"
+	}
+	if replicas, found, _ := unstructured.NestedInt64(item.Object, "spec", "minReplicaCount"); found {
+		autoscaler.MinReplicas = int32(replicas)
+	}
+	if replicas, found, _ := unstructured.NestedInt64(item.Object, "spec", "maxReplicaCount"); found {
+		autoscaler.MaxReplicas = int32(replicas)
+	}
+	return autoscaler
+}
+
+// Report discovers the autoscalers targeting the workloads of a metrics report
+func (d *AutoscalerDiscovery) Report(ctx context.Context, report *agent.MetricsReport) (AutoscalerReport, error) {
+	result := AutoscalerReport{ClusterID: report.ClusterId, Timestamp: reportTime(report), Autoscalers: []DiscoveredAutoscaler{}}
+	autoscalers, err := d.Discover(ctx, "")
+	if err != nil {
+		return result, err
+	}
+	for _, autoscaler := range autoscalers {
+		for _, workload := range report.WorkloadMetrics {
+			if autoscaler.Targets(workload.Namespace, workload.WorkloadName, workload.WorkloadType) {
+				result.Autoscalers = append(result.Autoscalers, autoscaler)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// ConflictPolicy is what the backend does with intents for workloads that already
+// have an autoscaler
+type ConflictPolicy string
+
+const (
+	// ConflictRefuse drops the intent
+	ConflictRefuse ConflictPolicy = "refuse"
+	// ConflictMark sends the intent with the conflict appended to its reason
+	ConflictMark ConflictPolicy = "mark"
+)
+
+// ConflictAction is what happened to a conflicting intent
+type ConflictAction string
+
+const (
+	ConflictRefused    ConflictAction = "refused"
+	ConflictMarked     ConflictAction = "marked"
+	ConflictOverridden ConflictAction = "overridden"
+)
+
+// IntentConflict records an intent that targeted a workload with an autoscaler
+type IntentConflict struct {
+	IntentID    string                 `json:"intentId"`
+	TenantID    string                 `json:"tenantId"`
+	ClusterID   string                 `json:"clusterId"`
+	Namespace   string                 `json:"namespace"`
+	Workload    string                 `json:"workload"`
+	Autoscalers []DiscoveredAutoscaler `json:"autoscalers"`
+	Action      ConflictAction         `json:"action"`
+	Override    string                 `json:"override,omitempty"`
+	Timestamp   time.Time              `json:"timestamp"`
+}
+
+type clusterKey struct {
+	tenantID  string
+	clusterID string
+}
+
+// ConflictGuard sits in front of the agents and checks every intent against the
+// autoscalers last reported for its cluster. Conflicting intents are refused or
+// marked according to the policy unless the workload has an override.
+type ConflictGuard struct {
+	next           ScalingIntentSink
+	policy         ConflictPolicy
+	autoscalers    map[clusterKey][]DiscoveredAutoscaler
+	overrides      map[WorkloadKey]string
+	conflicts      []IntentConflict
+	tenantResolver func(clusterID string) string
+	audit          AuditRecorder
+	now            func() time.Time
+	mu             sync.RWMutex
+}
+
+func NewConflictGuard(next ScalingIntentSink, policy ConflictPolicy) (*ConflictGuard, error) {
+	if policy == "" {
+		policy = ConflictRefuse
+	}
+	if policy != ConflictRefuse && policy != ConflictMark {
+		return nil, fmt.Errorf("unknown conflict policy %q", policy)
+	}
+	return &ConflictGuard{
+		next:        next,
+		policy:      policy,
+		autoscalers: make(map[clusterKey][]DiscoveredAutoscaler),
+		overrides:   make(map[WorkloadKey]string),
+		now:         time.Now,
+	}, nil
+}
+
+// SetClock replaces the time source, for tests
+func (g *ConflictGuard) SetClock(now func() time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.now = now
+}
+
+// SetTenantResolver sets the tenant whose autoscalers and overrides apply to the intents of a cluster
+func (g *ConflictGuard) SetTenantResolver(resolver func(clusterID string) string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.tenantResolver = resolver
+}
+
+// SetAuditRecorder records every conflict and the action taken
+func (g *ConflictGuard) SetAuditRecorder(recorder AuditRecorder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.audit = recorder
+}
+
+// IngestAutoscalers replaces the known autoscalers of the reporting cluster
+func (g *ConflictGuard) IngestAutoscalers(tenantID string, report AutoscalerReport) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.autoscalers[clusterKey{tenantID: tenantID, clusterID: report.ClusterID}] = append([]DiscoveredAutoscaler(nil), report.Autoscalers...)
+}
+
+// Override lets intents through for a workload despite its autoscalers
+func (g *ConflictGuard) Override(key WorkloadKey, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.overrides[key] = reason
+}
+
+// RemoveOverride removes an override and reports whether it existed
+func (g *ConflictGuard) RemoveOverride(key WorkloadKey) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, exists := g.overrides[key]
+	delete(g.overrides, key)
+	return exists
+}
+
+// Autoscalers returns the known autoscalers targeting a workload
+func (g *ConflictGuard) Autoscalers(key WorkloadKey, workloadType string) []DiscoveredAutoscaler {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.conflicting(key, workloadType)
+}
+
+// conflicting must be called with g.mu held
+func (g *ConflictGuard) conflicting(key WorkloadKey, workloadType string) []DiscoveredAutoscaler {
+	var conflicting []DiscoveredAutoscaler
+	for _, autoscaler := range g.autoscalers[clusterKey{tenantID: key.TenantID, clusterID: key.ClusterID}] {
+		if autoscaler.Targets(key.Namespace, key.Workload, workloadType) {
+			conflicting = append(conflicting, autoscaler)
+		}
+	}
+	return conflicting
+}
+
+// SendScalingIntent forwards the intent unless it conflicts and the policy refuses it
+func (g *ConflictGuard) SendScalingIntent(clusterID string, intent *agent.ScalingIntent) {
+	g.mu.Lock()
+	tenantID := ""
+	if g.tenantResolver != nil {
+		tenantID = g.tenantResolver(clusterID)
+	}
+	key := WorkloadKey{TenantID: tenantID, ClusterID: clusterID, Namespace: intent.WorkloadNamespace, Workload: intent.WorkloadName}
+	autoscalers := g.conflicting(key, intent.WorkloadType)
+	if len(autoscalers) == 0 {
+		g.mu.Unlock()
+		g.next.SendScalingIntent(clusterID, intent)
+		return
+	}
+
+	conflict := IntentConflict{
+		IntentID:    intent.IntentId,
+		TenantID:    tenantID,
+		ClusterID:   clusterID,
+		Namespace:   intent.WorkloadNamespace,
+		Workload:    intent.WorkloadName,
+		Autoscalers: autoscalers,
+		Timestamp:   g.now(),
+	}
+	override, overridden := g.overrides[key]
+	switch {
+	case overridden:
+		conflict.Action = ConflictOverridden
+		conflict.Override = override
+	case g.policy == ConflictMark:
+		conflict.Action = ConflictMarked
+		names := make([]string, len(autoscalers))
+		for i, autoscaler := range autoscalers {
+			names[i] = autoscaler.String()
+		}
+		intent = proto.Clone(intent).(*agent.ScalingIntent)
+		intent.Reason += "; conflicts with " + strings.Join(names, ", ")
+	default:
+		conflict.Action = ConflictRefused
+	}
+	g.conflicts = append(g.conflicts, conflict)
+	if len(g.conflicts) > ConflictLogSize {
+		g.conflicts = g.conflicts[len(g.conflicts)-ConflictLogSize:]
+	}
+	if g.audit != nil {
+		g.audit.Record(conflict.auditEntry(intent.TargetReplicas))
+	}
+	g.mu.Unlock()
+
+	if conflict.Action != ConflictRefused {
+		g.next.SendScalingIntent(clusterID, intent)
+	}
+}
+
+// Conflicts returns the recorded conflicts of a tenant, oldest first. An empty
+// tenant matches all.
+func (g *ConflictGuard) Conflicts(tenantID string) []IntentConflict {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	conflicts := []IntentConflict{}
+	for _, conflict := range g.conflicts {
+		if tenantID == "" || conflict.TenantID == tenantID {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,229 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+func testHPA(namespace, name, targetKind, targetName string, minReplicas, maxReplicas int32) *autoscalingv2.HorizontalPodAutoscaler {
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: targetKind, Name: targetName},
+			MinReplicas:    int32Ptr(minReplicas),
+			MaxReplicas:    maxReplicas,
+		},
+	}
+}
+
+func testScaledObject(namespace, name, targetName string, maxReplicas int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "keda.sh/v1alpha1",
+		"kind":       "ScaledObject",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"scaleTargetRef":  map[string]interface{}{"name": targetName},
+			"maxReplicaCount": maxReplicas,
+		},
+	}}
+}
+
+func newKEDAClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ScaledObjectResource: "ScaledObjectList"}, objects...)
+}
+
+// TestAutoscalerDiscovery tests the agent-side discovery of HPAs and KEDA ScaledObjects
+func TestAutoscalerDiscovery(t *testing.T) {
+	client := fake.NewClientset(
+		testHPA("logging", "elasticsearch", "StatefulSet", "elasticsearch", 3, 6),
+		testHPA("production", "frontend-hpa", "Deployment", "webapp-frontend", 2, 10),
+		testHPA("staging", "unreported", "Deployment", "api", 1, 3),
+	)
+	keda := newKEDAClient(testScaledObject("monitoring", "prometheus", "prometheus-server", 4))
+	discovery := NewAutoscalerDiscovery(client, keda)
+
+	autoscalers, err := discovery.Discover(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, autoscalers, 4)
+	require.Equal(t, DiscoveredAutoscaler{
+		Kind:        AutoscalerKindScaledObject,
+		Namespace:   "monitoring",
+		Name:        "prometheus",
+		TargetKind:  "Deployment",
+		TargetName:  "prometheus-server",
+		MaxReplicas: 4,
+	}, autoscalers[1])
+
+	autoscalers, err = discovery.Discover(context.Background(), "production")
+	require.NoError(t, err)
+	require.Equal(t, []DiscoveredAutoscaler{{
+		Kind:        AutoscalerKindHPA,
+		Namespace:   "production",
+		Name:        "frontend-hpa",
+		TargetKind:  "Deployment",
+		TargetName:  "webapp-frontend",
+		MinReplicas: 2,
+		MaxReplicas: 10,
+	}}, autoscalers)
+
+	// The report only carries autoscalers of workloads in the metrics report
+	report, err := discovery.Report(context.Background(), NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15))
+	require.NoError(t, err)
+	require.Equal(t, "cluster-1", report.ClusterID)
+	var names []string
+	for _, autoscaler := range report.Autoscalers {
+		names = append(names, autoscaler.String())
+	}
+	require.Equal(t, []string{
+		"HorizontalPodAutoscaler logging/elasticsearch",
+		"ScaledObject monitoring/prometheus",
+		"HorizontalPodAutoscaler production/frontend-hpa",
+	}, names)
+
+	// Clusters without KEDA only report their HPAs
+	missing := newKEDAClient()
+	missing.PrependReactor("list", "scaledobjects", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(ScaledObjectResource.GroupResource(), "")
+	})
+	autoscalers, err = NewAutoscalerDiscovery(client, missing).Discover(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, autoscalers, 3)
+	autoscalers, err = NewAutoscalerDiscovery(client, nil).Discover(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, autoscalers, 3)
+
+	failing := newKEDAClient()
+	failing.PrependReactor("list", "scaledobjects", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	_, err = NewAutoscalerDiscovery(client, failing).Discover(context.Background(), "")
+	require.ErrorContains(t, err, "failed to list scaled objects")
+}
+
+// TestConflictGuard tests refusing, marking and overriding intents for workloads with autoscalers
+func TestConflictGuard(t *testing.T) {
+	client := fake.NewClientset(
+		testHPA("logging", "elasticsearch", "StatefulSet", "elasticsearch", 3, 6),
+		testHPA("kube-system", "coredns", "StatefulSet", "coredns", 1, 2),
+	)
+	discovery := NewAutoscalerDiscovery(client, newKEDAClient(testScaledObject("monitoring", "prometheus", "prometheus-server", 4)))
+	metrics := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	autoscalers, err := discovery.Report(context.Background(), metrics)
+	require.NoError(t, err)
+	require.Len(t, autoscalers.Autoscalers, 2, "the coredns HPA targets a StatefulSet, not the coredns Deployment")
+
+	run := func(policy ConflictPolicy, configure func(*ConflictGuard)) (map[string]*agentv1.ScalingIntent, []IntentConflict) {
+		sent := make(map[string]*agentv1.ScalingIntent)
+		guard, err := NewConflictGuard(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+			sent[intent.WorkloadName] = intent
+		}), policy)
+		require.NoError(t, err)
+		guard.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+		guard.IngestAutoscalers("tenant-1", autoscalers)
+		if configure != nil {
+			configure(guard)
+		}
+
+		recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 60, TargetMemoryPercentage: 80})
+		require.NoError(t, err)
+		recommender.AddIntentSink(guard)
+		recommender.Ingest("tenant-1", metrics)
+		return sent, guard.Conflicts("tenant-1")
+	}
+
+	// Refused by default; only coredns is free of autoscalers
+	sent, conflicts := run("", nil)
+	require.Len(t, sent, 1)
+	require.Contains(t, sent, "coredns")
+	require.Len(t, conflicts, 2)
+	for _, conflict := range conflicts {
+		require.Equal(t, ConflictRefused, conflict.Action)
+	}
+	require.Equal(t, "prometheus-server", conflicts[0].Workload)
+	require.Equal(t, AutoscalerKindScaledObject, conflicts[0].Autoscalers[0].Kind)
+
+	// Marked intents go through with the conflict in their reason
+	sent, conflicts = run(ConflictMark, nil)
+	require.Len(t, sent, 3)
+	require.Equal(t, ConflictMarked, conflicts[1].Action)
+	require.Contains(t, sent["elasticsearch"].Reason, "; conflicts with HorizontalPodAutoscaler logging/elasticsearch")
+
+	// Overrides let a workload through despite its autoscaler
+	elasticsearch := WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "logging", Workload: "elasticsearch"}
+	sent, conflicts = run(ConflictRefuse, func(guard *ConflictGuard) {
+		guard.Override(elasticsearch, "HPA is being migrated, INC-1234")
+		require.Len(t, guard.Autoscalers(elasticsearch, "statefulset"), 1)
+		require.Empty(t, guard.Autoscalers(elasticsearch, "deployment"))
+	})
+	require.Len(t, sent, 2)
+	require.Equal(t, int32(5), sent["elasticsearch"].TargetReplicas)
+	require.NotContains(t, sent["elasticsearch"].Reason, "conflicts")
+	require.Equal(t, ConflictOverridden, conflicts[1].Action)
+	require.Equal(t, "HPA is being migrated, INC-1234", conflicts[1].Override)
+
+	// A fresh report replaces the autoscalers of the cluster
+	sent, conflicts = run(ConflictRefuse, func(guard *ConflictGuard) {
+		guard.IngestAutoscalers("tenant-1", AutoscalerReport{ClusterID: "cluster-1"})
+		require.False(t, guard.RemoveOverride(elasticsearch))
+	})
+	require.Len(t, sent, 3)
+	require.Empty(t, conflicts)
+
+	_, err = NewConflictGuard(nil, "ignore")
+	require.Error(t, err)
+}
+
+// TestConflictGuardThroughMetricsService tests that autoscalers attached to a stored
+// metrics report reach the guard before intents are recommended from the report
+func TestConflictGuardThroughMetricsService(t *testing.T) {
+	client := fake.NewClientset(testHPA("logging", "elasticsearch", "StatefulSet", "elasticsearch", 3, 6))
+	discovery := NewAutoscalerDiscovery(client, nil)
+
+	var sent []string
+	guard, err := NewConflictGuard(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		sent = append(sent, intent.WorkloadName)
+	}), ConflictRefuse)
+	require.NoError(t, err)
+	guard.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 60, TargetMemoryPercentage: 80})
+	require.NoError(t, err)
+	recommender.AddIntentSink(guard)
+
+	metricsService := NewMockMetricsService()
+	metricsService.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	metricsService.AddAutoscalerSink(guard)
+	metricsService.AddMetricsSink(recommender)
+
+	ctx := context.Background()
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	autoscalers, err := discovery.Report(ctx, report)
+	require.NoError(t, err)
+	_, err = metricsService.IngestMetrics(ctx, report, autoscalers)
+	require.NoError(t, err)
+
+	require.ElementsMatch(t, []string{"coredns", "prometheus-server"}, sent)
+	conflicts := guard.Conflicts("tenant-1")
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "elasticsearch", conflicts[0].Workload)
+	require.Equal(t, ConflictRefused, conflicts[0].Action)
+
+	// Attachments must describe the cluster of the report
+	_, err = metricsService.IngestMetrics(ctx, NewTestDataGenerator().GenerateMetricsReport("cluster-2", 15), autoscalers)
+	require.ErrorContains(t, err, "of cluster cluster-1 attached to a report of cluster cluster-2")
+	require.Len(t, metricsService.GetReceivedMetrics(), 1)
+}
@@ -0,0 +1,238 @@
+package integration
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// CardinalityLimits bounds the custom metrics accepted for a tenant. Zero limits are unlimited.
+type CardinalityLimits struct {
+	// MaxMetricNames bounds the distinct custom metric names across the tenant
+	MaxMetricNames int
+	// MaxSeries bounds the distinct (cluster, namespace, workload, metric) custom series
+	MaxSeries int
+	// Allow lists glob patterns (path.Match syntax); if set, only matching names are accepted
+	Allow []string
+	// Deny lists glob patterns of names that are always dropped
+	Deny []string
+}
+
+// CardinalityUsage reports how close a tenant is to its limits
+type CardinalityUsage struct {
+	TenantID       string
+	MetricNames    int
+	MaxMetricNames int
+	Series         int
+	MaxSeries      int
+	Dropped        int64
+}
+
+// CardinalityLimiter drops custom metrics that would push a tenant past its limits.
+// Series already accepted keep being accepted, so a limit only blocks new keys.
+type CardinalityLimiter struct {
+	defaults CardinalityLimits
+	limits   map[string]CardinalityLimits
+	names    map[string]map[string]struct{}
+	series   map[string]map[SeriesKey]struct{}
+	dropped  map[string]int64
+	mu       sync.Mutex
+}
+
+func NewCardinalityLimiter(defaults CardinalityLimits) (*CardinalityLimiter, error) {
+	if err := defaults.validate(); err != nil {
+		return nil, err
+	}
+
+	return &CardinalityLimiter{
+		defaults: defaults,
+		limits:   make(map[string]CardinalityLimits),
+		names:    make(map[string]map[string]struct{}),
+		series:   make(map[string]map[SeriesKey]struct{}),
+		dropped:  make(map[string]int64),
+	}, nil
+}
+
+// SetTenantLimits overrides the default limits for one tenant
+func (l *CardinalityLimiter) SetTenantLimits(tenantID string, limits CardinalityLimits) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits[tenantID] = limits
+	return nil
+}
+
+// Apply removes rejected custom metrics from the report in place and returns one
+// message per dropped key
+func (l *CardinalityLimiter) Apply(tenantID string, report *agent.MetricsReport) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var dropped []string
+
+	for _, workload := range report.WorkloadMetrics {
+		key := SeriesKey{
+			TenantID:  tenantID,
+			ClusterID: report.ClusterId,
+			Namespace: workload.Namespace,
+			Workload:  workload.WorkloadName,
+		}
+		for _, reason := range l.filter(key, workload.CustomMetrics) {
+			dropped = append(dropped, fmt.Sprintf("dropped custom metric for workload %s/%s in cluster %s: %s",
+				workload.Namespace, workload.WorkloadName, report.ClusterId, reason))
+		}
+	}
+
+	if cluster := report.ClusterMetrics; cluster != nil {
+		key := SeriesKey{TenantID: tenantID, ClusterID: report.ClusterId}
+		for _, reason := range l.filter(key, cluster.CustomMetrics) {
+			dropped = append(dropped, fmt.Sprintf("dropped cluster custom metric in cluster %s: %s", report.ClusterId, reason))
+		}
+	}
+
+	l.dropped[tenantID] += int64(len(dropped))
+	return dropped
+}
+
+// filter must be called with l.mu held. Names are visited in sorted order so the
+// same keys win whenever a limit is reached.
+func (l *CardinalityLimiter) filter(key SeriesKey, custom map[string]float64) []string {
+	if len(custom) == 0 {
+		return nil
+	}
+
+	limits := l.limitsFor(key.TenantID)
+	names := l.names[key.TenantID]
+	if names == nil {
+		names = make(map[string]struct{})
+		l.names[key.TenantID] = names
+	}
+	series := l.series[key.TenantID]
+	if series == nil {
+		series = make(map[SeriesKey]struct{})
+		l.series[key.TenantID] = series
+	}
+
+	sorted := make([]string, 0, len(custom))
+	for name := range custom {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var reasons []string
+	for _, name := range sorted {
+		key.Metric = name
+
+		reason := ""
+		_, knownName := names[name]
+		_, knownSeries := series[key]
+		switch {
+		case matchesAny(limits.Deny, name):
+			reason = fmt.Sprintf("%q is denied", name)
+		case len(limits.Allow) > 0 && !matchesAny(limits.Allow, name):
+			reason = fmt.Sprintf("%q is not allowed", name)
+		case knownSeries:
+		case !knownName && limits.MaxMetricNames > 0 && len(names) >= limits.MaxMetricNames:
+			reason = fmt.Sprintf("%q exceeds the tenant limit of %d metric names", name, limits.MaxMetricNames)
+		case limits.MaxSeries > 0 && len(series) >= limits.MaxSeries:
+			reason = fmt.Sprintf("%q exceeds the tenant limit of %d series", name, limits.MaxSeries)
+		}
+
+		if reason != "" {
+			delete(custom, name)
+			reasons = append(reasons, reason)
+			continue
+		}
+
+		names[name] = struct{}{}
+		series[key] = struct{}{}
+	}
+
+	return reasons
+}
+
+// Usage returns the current cardinality of every tenant seen so far
+func (l *CardinalityLimiter) Usage() []CardinalityUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tenants := make(map[string]struct{})
+	for tenantID := range l.names {
+		tenants[tenantID] = struct{}{}
+	}
+	for tenantID := range l.dropped {
+		tenants[tenantID] = struct{}{}
+	}
+
+	result := make([]CardinalityUsage, 0, len(tenants))
+	for tenantID := range tenants {
+		limits := l.limitsFor(tenantID)
+		result = append(result, CardinalityUsage{
+			TenantID:       tenantID,
+			MetricNames:    len(l.names[tenantID]),
+			MaxMetricNames: limits.MaxMetricNames,
+			Series:         len(l.series[tenantID]),
+			MaxSeries:      limits.MaxSeries,
+			Dropped:        l.dropped[tenantID],
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].TenantID < result[j].TenantID })
+	return result
+}
+
+// promFamilies reports usage against limits for the /metrics endpoint
+func (l *CardinalityLimiter) promFamilies() []*promFamily {
+	names := &promFamily{name: "hpa_cardinality_metric_names", help: "Distinct custom metric names accepted for the tenant.", kind: "gauge"}
+	namesLimit := &promFamily{name: "hpa_cardinality_metric_names_limit", help: "Limit on distinct custom metric names for the tenant; absent if unlimited.", kind: "gauge"}
+	series := &promFamily{name: "hpa_cardinality_series", help: "Distinct custom metric series accepted for the tenant.", kind: "gauge"}
+	seriesLimit := &promFamily{name: "hpa_cardinality_series_limit", help: "Limit on distinct custom metric series for the tenant; absent if unlimited.", kind: "gauge"}
+	dropped := &promFamily{name: "hpa_cardinality_dropped_total", help: "Custom metric keys dropped for the tenant.", kind: "counter"}
+
+	for _, usage := range l.Usage() {
+		labels := [][2]string{{"tenant", usage.TenantID}}
+		names.add(labels, float64(usage.MetricNames))
+		series.add(labels, float64(usage.Series))
+		dropped.add(labels, float64(usage.Dropped))
+		if usage.MaxMetricNames > 0 {
+			namesLimit.add(labels, float64(usage.MaxMetricNames))
+		}
+		if usage.MaxSeries > 0 {
+			seriesLimit.add(labels, float64(usage.MaxSeries))
+		}
+	}
+
+	return []*promFamily{names, namesLimit, series, seriesLimit, dropped}
+}
+
+// limitsFor must be called with l.mu held
+func (l *CardinalityLimiter) limitsFor(tenantID string) CardinalityLimits {
+	if limits, exists := l.limits[tenantID]; exists {
+		return limits
+	}
+	return l.defaults
+}
+
+func (c CardinalityLimits) validate() error {
+	for _, pattern := range append(append([]string(nil), c.Allow...), c.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid metric name pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
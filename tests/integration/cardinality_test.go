@@ -0,0 +1,114 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// TestCardinalityLimiterAllowDeny tests glob allow and deny lists
+func TestCardinalityLimiterAllowDeny(t *testing.T) {
+	limiter, err := NewCardinalityLimiter(CardinalityLimits{
+		Allow: []string{"*_total", "cpu_*", "cluster_*"},
+		Deny:  []string{"disk_io_*"},
+	})
+	require.NoError(t, err)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 1)
+	dropped := limiter.Apply("tenant-1", report)
+
+	custom := report.WorkloadMetrics[0].CustomMetrics
+	require.Contains(t, custom, "cpu_usage_cores")
+	require.Contains(t, custom, "network_rx_bytes_total")
+	require.NotContains(t, custom, "memory_usage_bytes")
+	require.NotContains(t, custom, "disk_io_read_bytes_total")
+	require.Len(t, report.ClusterMetrics.CustomMetrics, 8)
+
+	require.Len(t, dropped, 3)
+	require.Contains(t, dropped[0], `"disk_io_read_bytes_total" is denied`)
+	require.Contains(t, dropped[0], "kube-system/coredns")
+
+	_, err = NewCardinalityLimiter(CardinalityLimits{Deny: []string{"[unterminated"}})
+	require.Error(t, err)
+}
+
+// TestCardinalityLimitsReportedInIngestResponse tests per-tenant limits through ReportMetrics
+func TestCardinalityLimitsReportedInIngestResponse(t *testing.T) {
+	clusterService := NewMockClusterService()
+	metricsService := NewMockMetricsService()
+	server := NewMockGRPCServer(NewMockAuthService(), clusterService, metricsService)
+
+	limiter, err := NewCardinalityLimiter(CardinalityLimits{})
+	require.NoError(t, err)
+	require.NoError(t, limiter.SetTenantLimits("tenant-limited", CardinalityLimits{MaxMetricNames: 3, MaxSeries: 5}))
+	metricsService.SetCardinalityLimiter(limiter)
+
+	ctx := context.Background()
+	_, err = clusterService.RegisterCluster(ctx, &agentv1.RegisterClusterRequest{Name: "limited-cluster", TenantId: "tenant-limited"})
+	require.NoError(t, err)
+	_, err = clusterService.RegisterCluster(ctx, &agentv1.RegisterClusterRequest{Name: "open-cluster", TenantId: "tenant-open"})
+	require.NoError(t, err)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("limited-cluster", 2)
+	report.ClusterMetrics.CustomMetrics = nil
+	resp, err := server.ReportMetrics(ctx, &agentv1.MetricsReportRequest{
+		ClusterId: "limited-cluster",
+		Reports:   []*agentv1.MetricsReport{report},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.Accepted)
+	require.Equal(t, int32(1), resp.ProcessedCount)
+
+	// Three names fit on the first workload; the second workload adds two more series
+	// of those names before hitting the series limit
+	require.Len(t, report.WorkloadMetrics[0].CustomMetrics, 3)
+	require.Len(t, report.WorkloadMetrics[1].CustomMetrics, 2)
+	require.Len(t, resp.Errors, 14-5)
+	require.True(t, strings.Contains(strings.Join(resp.Errors, "\n"), "exceeds the tenant limit of 3 metric names"))
+	require.True(t, strings.Contains(strings.Join(resp.Errors, "\n"), "exceeds the tenant limit of 5 series"))
+
+	// Existing series keep flowing on later reports
+	again := NewTestDataGenerator().GenerateMetricsReport("limited-cluster", 2)
+	again.ClusterMetrics.CustomMetrics = nil
+	warnings, err := metricsService.IngestMetrics(ctx, again)
+	require.NoError(t, err)
+	require.Len(t, warnings, 9)
+	require.Len(t, again.WorkloadMetrics[0].CustomMetrics, 3)
+
+	resp, err = server.ReportMetrics(ctx, &agentv1.MetricsReportRequest{
+		ClusterId: "open-cluster",
+		Reports:   []*agentv1.MetricsReport{NewTestDataGenerator().GenerateMetricsReport("open-cluster", 15)},
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.Errors)
+
+	usage := limiter.Usage()
+	require.Len(t, usage, 2)
+	require.Equal(t, CardinalityUsage{TenantID: "tenant-limited", MetricNames: 3, MaxMetricNames: 3, Series: 5, MaxSeries: 5, Dropped: 18}, usage[0])
+	require.Equal(t, "tenant-open", usage[1].TenantID)
+	require.Equal(t, 15*7+8, usage[1].Series)
+}
+
+// TestCardinalityUsageExposedOnMetricsEndpoint tests the limiter gauges in the exposition
+func TestCardinalityUsageExposedOnMetricsEndpoint(t *testing.T) {
+	metricsService := NewMockMetricsService()
+	httpServer := NewMockHTTPServer(metricsService)
+
+	limiter, err := NewCardinalityLimiter(CardinalityLimits{MaxMetricNames: 4})
+	require.NoError(t, err)
+	metricsService.SetCardinalityLimiter(limiter)
+	httpServer.Exporter().AddCollector(limiter)
+
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), NewTestDataGenerator().GenerateMetricsReport("cluster-1", 1)))
+
+	var out strings.Builder
+	require.NoError(t, httpServer.Exporter().WriteExposition(&out))
+	require.Contains(t, out.String(), `hpa_cardinality_metric_names{tenant=""} 4`)
+	require.Contains(t, out.String(), `hpa_cardinality_metric_names_limit{tenant=""} 4`)
+	require.Contains(t, out.String(), `hpa_cardinality_dropped_total{tenant=""} 11`)
+	require.NotContains(t, out.String(), "hpa_cardinality_series_limit")
+}
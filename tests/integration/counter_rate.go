@@ -0,0 +1,220 @@
+package integration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// CounterSuffix marks a custom metric as a cumulative counter by naming convention
+const CounterSuffix = "_total"
+
+// RateSuffix is appended to the base name of a counter to name its derived rate
+const RateSuffix = "_per_second"
+
+// DefaultCounterBaselineAge is how long a CounterRateConverter keeps the last
+// sample of a counter that stopped reporting
+const DefaultCounterBaselineAge = time.Hour
+
+// CounterRateConverter derives per-second rates from cumulative custom metrics.
+// Counters are recognised by the _total suffix unless declared otherwise.
+// Baselines of counters that have not reported for longer than the max age are
+// dropped, so a counter that reports again after such a gap is primed anew.
+type CounterRateConverter struct {
+	declared  map[string]bool
+	last      map[SeriesKey]Sample
+	resets    int64
+	maxAge    time.Duration
+	newest    time.Time
+	nextSweep time.Time
+	mu        sync.Mutex
+}
+
+func NewCounterRateConverter() *CounterRateConverter {
+	return &CounterRateConverter{
+		declared: make(map[string]bool),
+		last:     make(map[SeriesKey]Sample),
+		maxAge:   DefaultCounterBaselineAge,
+	}
+}
+
+// SetMaxAge sets how long the baseline of a silent counter is kept, measured in
+// report time
+func (c *CounterRateConverter) SetMaxAge(maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxAge = maxAge
+	c.nextSweep = time.Time{}
+}
+
+// Baselines returns how many counters the converter holds a last sample for
+func (c *CounterRateConverter) Baselines() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.last)
+}
+
+// RemoveCluster drops the baselines of every counter of one cluster
+func (c *CounterRateConverter) RemoveCluster(clusterID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.last {
+		if key.ClusterID == clusterID {
+			delete(c.last, key)
+		}
+	}
+}
+
+// Reset drops all baselines
+func (c *CounterRateConverter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.last)
+}
+
+// DeclareCounter marks a metric as a counter regardless of its name
+func (c *CounterRateConverter) DeclareCounter(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.declared[name] = true
+}
+
+// DeclareGauge marks a metric as a gauge even if its name ends in _total
+func (c *CounterRateConverter) DeclareGauge(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.declared[name] = false
+}
+
+// IsCounter reports whether a metric is treated as a cumulative counter
+func (c *CounterRateConverter) IsCounter(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isCounter(name)
+}
+
+// Resets returns how many counter resets have been detected
+func (c *CounterRateConverter) Resets() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resets
+}
+
+// RateName returns the name of the rate series derived from a counter
+func RateName(counter string) string {
+	return strings.TrimSuffix(counter, CounterSuffix) + RateSuffix
+}
+
+// Convert adds a rate metric next to every counter of the report, computed against
+// the previous report of the same series. The raw counters are left in place. The
+// first sample of a series only primes the converter and produces no rate. A rate
+// whose name is already reported as a metric is skipped, and one message per
+// skipped rate is returned.
+func (c *CounterRateConverter) Convert(tenantID string, report *agent.MetricsReport) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ts := reportTime(report)
+	var skipped []string
+
+	for _, workload := range report.WorkloadMetrics {
+		key := SeriesKey{
+			TenantID:  tenantID,
+			ClusterID: report.ClusterId,
+			Namespace: workload.Namespace,
+			Workload:  workload.WorkloadName,
+		}
+		for _, name := range c.convert(key, ts, workload.CustomMetrics) {
+			skipped = append(skipped, fmt.Sprintf("skipped rate for workload %s/%s in cluster %s: %q is already reported",
+				workload.Namespace, workload.WorkloadName, report.ClusterId, name))
+		}
+	}
+
+	if cluster := report.ClusterMetrics; cluster != nil {
+		for _, name := range c.convert(SeriesKey{TenantID: tenantID, ClusterID: report.ClusterId}, ts, cluster.CustomMetrics) {
+			skipped = append(skipped, fmt.Sprintf("skipped cluster rate in cluster %s: %q is already reported", report.ClusterId, name))
+		}
+	}
+
+	c.sweep(ts)
+	return skipped
+}
+
+// sweep must be called with c.mu held. Once per max age of report time it drops
+// the baselines last seen more than the max age before the newest report, so
+// every baseline is gone within twice the max age of its last sample.
+func (c *CounterRateConverter) sweep(ts time.Time) {
+	if ts.After(c.newest) {
+		c.newest = ts
+	}
+	if c.maxAge <= 0 || c.newest.Before(c.nextSweep) {
+		return
+	}
+	cutoff := c.newest.Add(-c.maxAge)
+	for key, sample := range c.last {
+		if sample.Timestamp.Before(cutoff) {
+			delete(c.last, key)
+		}
+	}
+	c.nextSweep = c.newest.Add(c.maxAge)
+}
+
+// convert must be called with c.mu held. It returns the sorted names of the rates
+// that were not added because a reported metric already has that name.
+func (c *CounterRateConverter) convert(key SeriesKey, ts time.Time, custom map[string]float64) []string {
+	rates := make(map[string]float64)
+
+	for name, value := range custom {
+		if !c.isCounter(name) {
+			continue
+		}
+
+		key.Metric = name
+		previous, seen := c.last[key]
+		if seen && !ts.After(previous.Timestamp) {
+			// Duplicate or out-of-order report; keep the newer baseline
+			continue
+		}
+		if seen && c.maxAge > 0 && ts.Sub(previous.Timestamp) > c.maxAge {
+			// A baseline this old may not have been swept yet, but is stale all the same
+			seen = false
+		}
+		c.last[key] = Sample{Timestamp: ts, Value: value}
+		if !seen {
+			continue
+		}
+
+		increase := value - previous.Value
+		if increase < 0 {
+			// The counter restarted from zero, e.g. after a pod restart, so everything
+			// it has counted since is the increase
+			increase = value
+			c.resets++
+		}
+		rates[RateName(name)] = increase / ts.Sub(previous.Timestamp).Seconds()
+	}
+
+	var skipped []string
+	for name, rate := range rates {
+		if _, reported := custom[name]; reported {
+			skipped = append(skipped, name)
+			continue
+		}
+		custom[name] = rate
+	}
+	sort.Strings(skipped)
+	return skipped
+}
+
+// isCounter must be called with c.mu held
+func (c *CounterRateConverter) isCounter(name string) bool {
+	if counter, declared := c.declared[name]; declared {
+		return counter
+	}
+	return strings.HasSuffix(name, CounterSuffix)
+}
@@ -0,0 +1,171 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+func counterReport(ts time.Time, custom map[string]float64) *agentv1.MetricsReport {
+	return &agentv1.MetricsReport{
+		ClusterId: "cluster-1",
+		Timestamp: timestamppb.New(ts),
+		WorkloadMetrics: []*agentv1.WorkloadMetric{
+			{Namespace: "production", WorkloadName: "webapp-backend", WorkloadType: "deployment", CustomMetrics: custom},
+		},
+	}
+}
+
+// TestCounterRateConverterRatesAndResets tests rate computation across consecutive reports and resets
+func TestCounterRateConverterRatesAndResets(t *testing.T) {
+	converter := NewCounterRateConverter()
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	first := counterReport(base, map[string]float64{"network_rx_bytes_total": 1000, "restarts_total": 1, "queue_depth": 7})
+	converter.Convert("tenant-1", first)
+	require.NotContains(t, first.WorkloadMetrics[0].CustomMetrics, "network_rx_bytes_per_second")
+
+	second := counterReport(base.Add(10*time.Second), map[string]float64{"network_rx_bytes_total": 6000, "restarts_total": 1, "queue_depth": 9})
+	converter.Convert("tenant-1", second)
+	custom := second.WorkloadMetrics[0].CustomMetrics
+	require.Equal(t, 500.0, custom["network_rx_bytes_per_second"])
+	require.Equal(t, 0.0, custom["restarts_per_second"])
+	require.Equal(t, 6000.0, custom["network_rx_bytes_total"], "raw counter must be kept")
+	require.NotContains(t, custom, "queue_depth_per_second")
+
+	// After a pod restart the counter starts again from zero
+	third := counterReport(base.Add(20*time.Second), map[string]float64{"network_rx_bytes_total": 2000, "restarts_total": 2, "queue_depth": 9})
+	converter.Convert("tenant-1", third)
+	require.Equal(t, 200.0, third.WorkloadMetrics[0].CustomMetrics["network_rx_bytes_per_second"])
+	require.Equal(t, 0.1, third.WorkloadMetrics[0].CustomMetrics["restarts_per_second"])
+	require.Equal(t, int64(1), converter.Resets())
+
+	// A replayed report must not produce a rate or move the baseline
+	replay := counterReport(base.Add(20*time.Second), map[string]float64{"network_rx_bytes_total": 2000})
+	converter.Convert("tenant-1", replay)
+	require.NotContains(t, replay.WorkloadMetrics[0].CustomMetrics, "network_rx_bytes_per_second")
+
+	// Series are tracked per tenant
+	other := counterReport(base.Add(30*time.Second), map[string]float64{"network_rx_bytes_total": 5000})
+	converter.Convert("tenant-2", other)
+	require.NotContains(t, other.WorkloadMetrics[0].CustomMetrics, "network_rx_bytes_per_second")
+}
+
+// TestCounterRateConverterDeclaredMetadata tests declared counters and gauges overriding the suffix rule
+func TestCounterRateConverterDeclaredMetadata(t *testing.T) {
+	converter := NewCounterRateConverter()
+	converter.DeclareCounter("http_requests")
+	converter.DeclareGauge("connections_total")
+
+	require.True(t, converter.IsCounter("http_requests"))
+	require.True(t, converter.IsCounter("disk_io_read_bytes_total"))
+	require.False(t, converter.IsCounter("connections_total"))
+	require.Equal(t, "http_requests_per_second", RateName("http_requests"))
+
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	converter.Convert("tenant-1", counterReport(base, map[string]float64{"http_requests": 100, "connections_total": 40}))
+	report := counterReport(base.Add(5*time.Second), map[string]float64{"http_requests": 600, "connections_total": 20})
+	converter.Convert("tenant-1", report)
+
+	require.Equal(t, 100.0, report.WorkloadMetrics[0].CustomMetrics["http_requests_per_second"])
+	require.NotContains(t, report.WorkloadMetrics[0].CustomMetrics, "connections_per_second")
+}
+
+// TestCounterRateConverterDropsStaleBaselines tests that baselines of workloads that stopped
+// reporting are evicted by age and with their cluster
+func TestCounterRateConverterDropsStaleBaselines(t *testing.T) {
+	converter := NewCounterRateConverter()
+	converter.SetMaxAge(time.Hour)
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	// Short-lived workloads each leave a baseline behind
+	for i := 0; i < 10; i++ {
+		report := counterReport(base.Add(time.Duration(i)*10*time.Minute), map[string]float64{"jobs_processed_total": 5})
+		report.WorkloadMetrics[0].WorkloadName = fmt.Sprintf("batch-%d", i)
+		converter.Convert("tenant-1", report)
+	}
+	require.Equal(t, 10, converter.Baselines())
+
+	// Once they have been silent for longer than the max age they are dropped
+	converter.Convert("tenant-1", counterReport(base.Add(3*time.Hour), map[string]float64{"jobs_processed_total": 5}))
+	require.Equal(t, 1, converter.Baselines())
+
+	// A counter seen again after its baseline was dropped is primed anew
+	late := counterReport(base.Add(6*time.Hour), map[string]float64{"jobs_processed_total": 9})
+	converter.Convert("tenant-1", late)
+	require.NotContains(t, late.WorkloadMetrics[0].CustomMetrics, "jobs_processed_per_second")
+
+	// Clearing a cluster from the service drops its baselines too
+	metricsService := NewMockMetricsService()
+	metricsService.SetCounterRateConverter(converter)
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), counterReport(base.Add(7*time.Hour), map[string]float64{"jobs_processed_total": 12})))
+	require.Equal(t, 2, converter.Baselines(), "one per tenant")
+	metricsService.ClearCluster("cluster-1")
+	require.Zero(t, converter.Baselines())
+}
+
+// TestCounterRatesReachTimeSeriesStore tests that raw and rate series are both stored
+func TestCounterRatesReachTimeSeriesStore(t *testing.T) {
+	metricsService := NewMockMetricsService()
+	metricsService.SetCounterRateConverter(NewCounterRateConverter())
+	store := NewTimeSeriesStore(0)
+	metricsService.AddMetricsSink(store)
+
+	generator := NewTestDataGenerator()
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		report := generator.GenerateMetricsReport("cluster-1", 3)
+		report.Timestamp = timestamppb.New(base.Add(time.Duration(i) * 30 * time.Second))
+		for _, workload := range report.WorkloadMetrics {
+			workload.CustomMetrics["network_rx_bytes_total"] += float64(i) * 3000
+		}
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+	}
+
+	key := SeriesKey{ClusterID: "cluster-1", Namespace: "kube-system", Workload: "coredns", Metric: "network_rx_bytes_total"}
+	require.Len(t, store.Query(key, base, base.Add(time.Hour)), 3)
+
+	key.Metric = "network_rx_bytes_per_second"
+	rates := store.Query(key, base, base.Add(time.Hour))
+	require.Len(t, rates, 2)
+	require.Equal(t, 100.0, rates[0].Value)
+}
+
+// TestCounterRatesCountAgainstCardinalityLimits tests that derived rates are limited like
+// reported metrics and never overwrite a reported metric of the same name
+func TestCounterRatesCountAgainstCardinalityLimits(t *testing.T) {
+	metricsService := NewMockMetricsService()
+	metricsService.SetCounterRateConverter(NewCounterRateConverter())
+	limiter, err := NewCardinalityLimiter(CardinalityLimits{MaxMetricNames: 3})
+	require.NoError(t, err)
+	metricsService.SetCardinalityLimiter(limiter)
+
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	warnings, err := metricsService.IngestMetrics(ctx, counterReport(base, map[string]float64{"requests_total": 100, "errors_total": 1}))
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	report := counterReport(base.Add(10*time.Second), map[string]float64{
+		"requests_total": 600, "errors_total": 3, "requests_per_second": 42,
+	})
+	warnings, err = metricsService.IngestMetrics(ctx, report)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`skipped rate for workload production/webapp-backend in cluster cluster-1: "requests_per_second" is already reported`,
+		`dropped custom metric for workload production/webapp-backend in cluster cluster-1: "requests_per_second" exceeds the tenant limit of 3 metric names`,
+	}, warnings)
+
+	custom := report.WorkloadMetrics[0].CustomMetrics
+	require.Equal(t, 0.2, custom["errors_per_second"])
+	require.NotContains(t, custom, "requests_per_second")
+	usage := limiter.Usage()
+	require.Len(t, usage, 1)
+	require.Equal(t, 3, usage[0].MetricNames)
+}
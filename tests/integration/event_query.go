@@ -0,0 +1,329 @@
+package integration
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// DefaultEventQueryLimit is the page size used when EventQuery.Limit is zero
+const DefaultEventQueryLimit = 100
+
+// MaxEventQueryLimit caps the page size of a single query
+const MaxEventQueryLimit = 1000
+
+// ErrInvalidPageToken is returned for page tokens not produced by QueryEvents
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// EventQuery filters stored events. Empty fields match everything.
+type EventQuery struct {
+	TenantID        string
+	ClusterID       string
+	Namespace       string
+	Kind            string
+	Name            string
+	Type            string
+	Reason          string
+	SourceComponent string
+	// LabelSelector uses Kubernetes selector syntax, e.g. "app=webapp,tier!=cache"
+	LabelSelector string
+	// Since and Until bound the event timestamp; Since is inclusive, Until exclusive
+	Since time.Time
+	Until time.Time
+	Limit int
+	// PageToken continues a previous query from its NextPageToken
+	PageToken string
+}
+
+// EventRecord is a stored event with the cluster and tenant it was reported for
+type EventRecord struct {
+	TenantID   string
+	ClusterID  string
+	ReceivedAt time.Time
+	Event      *agent.KubernetesEvent
+
+	// cursor orders records newest first and survives later inserts
+	cursor eventCursor
+}
+
+// EventQueryResult is one page of events, newest first
+type EventQueryResult struct {
+	Events        []EventRecord
+	NextPageToken string
+}
+
+type eventCursor struct {
+	timestamp int64
+	seq       uint64
+	index     int
+}
+
+// before reports whether c sorts before other, i.e. is newer
+func (c eventCursor) before(other eventCursor) bool {
+	if c.timestamp != other.timestamp {
+		return c.timestamp > other.timestamp
+	}
+	if c.seq != other.seq {
+		return c.seq > other.seq
+	}
+	return c.index > other.index
+}
+
+func (c eventCursor) token() string {
+	raw := fmt.Sprintf("%d:%d:%d", c.timestamp, c.seq, c.index)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseEventCursor(token string) (eventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return eventCursor{}, ErrInvalidPageToken
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return eventCursor{}, ErrInvalidPageToken
+	}
+
+	var c eventCursor
+	if c.timestamp, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return eventCursor{}, ErrInvalidPageToken
+	}
+	if c.seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return eventCursor{}, ErrInvalidPageToken
+	}
+	if c.index, err = strconv.Atoi(parts[2]); err != nil {
+		return eventCursor{}, ErrInvalidPageToken
+	}
+	return c, nil
+}
+
+// QueryEvents returns stored events matching the query, newest first. Pages are
+// keyed on the last returned event, so events arriving between calls do not
+// shift or repeat later pages.
+func (m *MockMetricsService) QueryEvents(query EventQuery) (*EventQueryResult, error) {
+	selector := labels.Everything()
+	if query.LabelSelector != "" {
+		parsed, err := labels.Parse(query.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector: %w", err)
+		}
+		selector = parsed
+	}
+
+	var after *eventCursor
+	if query.PageToken != "" {
+		cursor, err := parseEventCursor(query.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		after = &cursor
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultEventQueryLimit
+	}
+	if limit > MaxEventQueryLimit {
+		limit = MaxEventQueryLimit
+	}
+
+	m.mu.RLock()
+	var matched []EventRecord
+	for _, stored := range m.receivedEvents {
+		if stored.evicted {
+			continue
+		}
+		if query.TenantID != "" && stored.tenantID != query.TenantID {
+			continue
+		}
+		if query.ClusterID != "" && stored.clusterID != query.ClusterID {
+			continue
+		}
+
+		for i, event := range stored.report.Events {
+			ts := stored.receivedAt
+			if event.Timestamp != nil {
+				ts = event.Timestamp.AsTime()
+			}
+			record := EventRecord{
+				TenantID:   stored.tenantID,
+				ClusterID:  stored.clusterID,
+				ReceivedAt: stored.receivedAt,
+				Event:      event,
+				cursor:     eventCursor{timestamp: ts.UnixNano(), seq: stored.seq, index: i},
+			}
+			if after != nil && !after.before(record.cursor) {
+				continue
+			}
+			if query.matches(event, ts, selector) {
+				matched = append(matched, record)
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].cursor.before(matched[j].cursor) })
+
+	result := &EventQueryResult{}
+	if len(matched) > limit {
+		matched = matched[:limit]
+		result.NextPageToken = matched[limit-1].cursor.token()
+	}
+	result.Events = matched
+	return result, nil
+}
+
+func (q EventQuery) matches(event *agent.KubernetesEvent, ts time.Time, selector labels.Selector) bool {
+	switch {
+	case q.Namespace != "" && event.Namespace != q.Namespace:
+		return false
+	case q.Kind != "" && event.Kind != q.Kind:
+		return false
+	case q.Name != "" && event.Name != q.Name:
+		return false
+	case q.Type != "" && event.Type != q.Type:
+		return false
+	case q.Reason != "" && event.Reason != q.Reason:
+		return false
+	case q.SourceComponent != "" && event.SourceComponent != q.SourceComponent:
+		return false
+	case !q.Since.IsZero() && ts.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !ts.Before(q.Until):
+		return false
+	}
+	return selector.Matches(labels.Set(event.Labels))
+}
+
+// eventJSON is the wire form of an EventRecord on the HTTP API
+type eventJSON struct {
+	TenantID        string            `json:"tenantId,omitempty"`
+	ClusterID       string            `json:"clusterId"`
+	Namespace       string            `json:"namespace"`
+	Kind            string            `json:"kind"`
+	Name            string            `json:"name"`
+	Type            string            `json:"type"`
+	Reason          string            `json:"reason"`
+	Message         string            `json:"message"`
+	SourceComponent string            `json:"sourceComponent,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Timestamp       *time.Time        `json:"timestamp,omitempty"`
+	ReceivedAt      time.Time         `json:"receivedAt"`
+}
+
+type eventQueryResponse struct {
+	Events        []eventJSON `json:"events"`
+	NextPageToken string      `json:"nextPageToken,omitempty"`
+}
+
+// serveEvents handles GET /api/v1/events. Filters map to EventQuery fields; since and
+// until accept RFC 3339 timestamps or a duration relative to now, such as since=1h.
+func (s *MockHTTPServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	query, err := s.parseEventQuery(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	result, err := s.metricsService.QueryEvents(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	response := eventQueryResponse{
+		Events:        make([]eventJSON, 0, len(result.Events)),
+		NextPageToken: result.NextPageToken,
+	}
+	for _, record := range result.Events {
+		event := record.Event
+		wire := eventJSON{
+			TenantID:        record.TenantID,
+			ClusterID:       record.ClusterID,
+			Namespace:       event.Namespace,
+			Kind:            event.Kind,
+			Name:            event.Name,
+			Type:            event.Type,
+			Reason:          event.Reason,
+			Message:         event.Message,
+			SourceComponent: event.SourceComponent,
+			Labels:          event.Labels,
+			ReceivedAt:      record.ReceivedAt,
+		}
+		if event.Timestamp != nil {
+			ts := event.Timestamp.AsTime()
+			wire.Timestamp = &ts
+		}
+		response.Events = append(response.Events, wire)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *MockHTTPServer) parseEventQuery(r *http.Request) (EventQuery, error) {
+	values := r.URL.Query()
+	query := EventQuery{
+		TenantID:        values.Get("tenant"),
+		ClusterID:       values.Get("cluster"),
+		Namespace:       values.Get("namespace"),
+		Kind:            values.Get("kind"),
+		Name:            values.Get("name"),
+		Type:            values.Get("type"),
+		Reason:          values.Get("reason"),
+		SourceComponent: values.Get("source"),
+		LabelSelector:   values.Get("labelSelector"),
+		PageToken:       values.Get("pageToken"),
+	}
+
+	now := s.metricsService.clock()
+	var err error
+	if query.Since, err = parseQueryTime(values.Get("since"), now); err != nil {
+		return EventQuery{}, fmt.Errorf("invalid since: %w", err)
+	}
+	if query.Until, err = parseQueryTime(values.Get("until"), now); err != nil {
+		return EventQuery{}, fmt.Errorf("invalid until: %w", err)
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 0 {
+			return EventQuery{}, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+
+	return query, nil
+}
+
+// parseQueryTime accepts an RFC 3339 timestamp or a duration before now
+func parseQueryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
+	ago, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 timestamp nor a duration", value)
+	}
+	return now.Add(-ago), nil
+}
+
+// clock returns the current time of the service clock
+func (m *MockMetricsService) clock() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.now()
+}
@@ -0,0 +1,150 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// newEventQueryFixture stores generated events for two tenants, one report per minute
+func newEventQueryFixture(t *testing.T) (*MockMetricsService, *fakeClock) {
+	clusterService := NewMockClusterService()
+	metricsService := NewMockMetricsService()
+	metricsService.SetTenantResolver(clusterService.TenantForCluster)
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	metricsService.SetClock(clock.Now)
+
+	ctx := context.Background()
+	_, err := clusterService.RegisterCluster(ctx, &agentv1.RegisterClusterRequest{Name: "prod-eu", TenantId: "tenant-a"})
+	require.NoError(t, err)
+	_, err = clusterService.RegisterCluster(ctx, &agentv1.RegisterClusterRequest{Name: "prod-us", TenantId: "tenant-b"})
+	require.NoError(t, err)
+
+	generator := NewTestDataGenerator()
+	for i := 0; i < 90; i++ {
+		for _, clusterID := range []string{"prod-eu", "prod-us"} {
+			report := generator.GenerateEventReport(clusterID, 15)
+			for _, event := range report.Events {
+				event.Timestamp = timestamppb.New(clock.Now())
+			}
+			require.NoError(t, metricsService.StoreEvents(ctx, report))
+		}
+		clock.Advance(time.Minute)
+	}
+
+	return metricsService, clock
+}
+
+// TestQueryEventsFilters tests the individual filters of EventQuery
+func TestQueryEventsFilters(t *testing.T) {
+	metricsService, clock := newEventQueryFixture(t)
+
+	// All Warning events for production in the last hour
+	result, err := metricsService.QueryEvents(EventQuery{
+		TenantID:  "tenant-a",
+		Namespace: "production",
+		Type:      "Warning",
+		Since:     clock.Now().Add(-time.Hour),
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 60)
+	require.Empty(t, result.NextPageToken)
+	for _, record := range result.Events {
+		require.Equal(t, "prod-eu", record.ClusterID)
+		require.Equal(t, "Failed", record.Event.Reason)
+	}
+	require.Equal(t, clock.Now().Add(-time.Minute), result.Events[0].Event.Timestamp.AsTime(), "newest first")
+
+	result, err = metricsService.QueryEvents(EventQuery{ClusterID: "prod-us", Kind: "Deployment", Reason: "ScalingReplicaSet", Limit: 1000})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 180)
+
+	result, err = metricsService.QueryEvents(EventQuery{Name: "redis-master-0", SourceComponent: "default-scheduler", Until: clock.Now().Add(-80 * time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 20)
+
+	result, err = metricsService.QueryEvents(EventQuery{ClusterID: "prod-eu", LabelSelector: "app in (coredns, redis),role!=master"})
+	require.NoError(t, err)
+	require.Len(t, result.Events, DefaultEventQueryLimit)
+	require.NotEmpty(t, result.NextPageToken)
+	require.Equal(t, "coredns", result.Events[0].Event.Labels["app"])
+
+	_, err = metricsService.QueryEvents(EventQuery{LabelSelector: "app in ("})
+	require.Error(t, err)
+	_, err = metricsService.QueryEvents(EventQuery{PageToken: "not-a-token"})
+	require.ErrorIs(t, err, ErrInvalidPageToken)
+}
+
+// TestQueryEventsPagination tests that pages are complete and stable under concurrent inserts
+func TestQueryEventsPagination(t *testing.T) {
+	metricsService, _ := newEventQueryFixture(t)
+
+	query := EventQuery{ClusterID: "prod-eu", Type: "Warning", Limit: 70}
+	first, err := metricsService.QueryEvents(query)
+	require.NoError(t, err)
+	require.Len(t, first.Events, 70)
+
+	// Newer events arriving between pages must not shift the next page
+	require.NoError(t, metricsService.StoreEvents(context.Background(), NewTestDataGenerator().GenerateEventReport("prod-eu", 15)))
+
+	seen := len(first.Events)
+	query.PageToken = first.NextPageToken
+	for query.PageToken != "" {
+		page, err := metricsService.QueryEvents(query)
+		require.NoError(t, err)
+		require.True(t, page.Events[0].cursor.timestamp <= first.Events[len(first.Events)-1].cursor.timestamp)
+		seen += len(page.Events)
+		query.PageToken = page.NextPageToken
+	}
+	require.Equal(t, 90*4, seen)
+}
+
+// TestEventsHTTPEndpoint tests GET /api/v1/events on the fake backend
+func TestEventsHTTPEndpoint(t *testing.T) {
+	metricsService, _ := newEventQueryFixture(t)
+	server := httptest.NewServer(NewMockHTTPServer(metricsService).Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/events?tenant=tenant-a&namespace=production&type=Warning&since=1h&limit=25")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var page struct {
+		Events []struct {
+			TenantID  string    `json:"tenantId"`
+			ClusterID string    `json:"clusterId"`
+			Reason    string    `json:"reason"`
+			Timestamp time.Time `json:"timestamp"`
+		} `json:"events"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Events, 25)
+	require.NotEmpty(t, page.NextPageToken)
+	require.Equal(t, "tenant-a", page.Events[0].TenantID)
+	require.Equal(t, "Failed", page.Events[0].Reason)
+
+	next, err := http.Get(server.URL + "/api/v1/events?tenant=tenant-a&namespace=production&type=Warning&since=1h&limit=50&pageToken=" + page.NextPageToken)
+	require.NoError(t, err)
+	defer next.Body.Close()
+	page.NextPageToken = ""
+	require.NoError(t, json.NewDecoder(next.Body).Decode(&page))
+	require.Len(t, page.Events, 35)
+	require.Empty(t, page.NextPageToken)
+
+	for _, query := range []string{"since=yesterday", "limit=-1", "labelSelector=app%20in%20(", "pageToken=%21"} {
+		bad, err := http.Get(server.URL + "/api/v1/events?" + query)
+		require.NoError(t, err)
+		bad.Body.Close()
+		require.Equal(t, http.StatusBadRequest, bad.StatusCode, query)
+	}
+}
@@ -0,0 +1,355 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// DefaultEventRuleWindow is the counting window of rules that do not set one
+const DefaultEventRuleWindow = 5 * time.Minute
+
+// EventRule fires an alert when events matching it occur often enough within a window.
+// Alerts are grouped per involved object, so every failing pod gets its own alert.
+// Empty match lists match everything.
+type EventRule struct {
+	Name     string
+	Severity string
+	Summary  string
+
+	Reasons    []string
+	Types      []string
+	Namespaces []string
+	// MessageContains matches a substring of the event message, e.g. "ImagePullBackOff"
+	MessageContains string
+	// LabelSelector uses Kubernetes selector syntax against the event labels
+	LabelSelector string
+
+	// Threshold is the number of matching events within Window needed to fire; defaults to 1
+	Threshold int
+	Window    time.Duration
+	// ResolveAfter is how long the rule must see no matching event before the alert
+	// resolves; defaults to Window
+	ResolveAfter time.Duration
+}
+
+type compiledEventRule struct {
+	EventRule
+	selector labels.Selector
+}
+
+// eventAlertGroup tracks one rule against one involved object
+type eventAlertGroup struct {
+	rule    *compiledEventRule
+	labels  map[string]string
+	matches []time.Time
+	alert   *Alert
+}
+
+// EventRuleEngine evaluates incoming events against rules and notifies sinks when
+// alerts start firing and when they resolve. A firing alert is not re-sent while
+// matching events keep arriving; they only bump its count.
+type EventRuleEngine struct {
+	rules            []*compiledEventRule
+	sinks            []AlertSink
+	groups           map[string]*eventAlertGroup
+	deliveryFailures int64
+	now              func() time.Time
+	mu               sync.Mutex
+}
+
+func NewEventRuleEngine(rules []EventRule, sinks ...AlertSink) (*EventRuleEngine, error) {
+	engine := &EventRuleEngine{
+		sinks:  sinks,
+		groups: make(map[string]*eventAlertGroup),
+		now:    time.Now,
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("event rule has no name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate event rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		selector, err := labels.Parse(rule.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("event rule %q: invalid label selector: %w", rule.Name, err)
+		}
+		if rule.Threshold <= 0 {
+			rule.Threshold = 1
+		}
+		if rule.Window <= 0 {
+			rule.Window = DefaultEventRuleWindow
+		}
+		if rule.ResolveAfter <= 0 {
+			rule.ResolveAfter = rule.Window
+		}
+		engine.rules = append(engine.rules, &compiledEventRule{EventRule: rule, selector: selector})
+	}
+
+	return engine, nil
+}
+
+// SetClock overrides the clock used to stamp undated events and to resolve alerts
+func (e *EventRuleEngine) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
+// AddSink registers another alert sink
+func (e *EventRuleEngine) AddSink(sink AlertSink) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sinks = append(e.sinks, sink)
+}
+
+// IngestEvents evaluates every event of the report against all rules
+func (e *EventRuleEngine) IngestEvents(tenantID string, report *agent.EventReport) {
+	e.mu.Lock()
+	var notifications []Alert
+	now := e.now()
+	for _, event := range report.Events {
+		ts := now
+		if event.Timestamp != nil {
+			ts = event.Timestamp.AsTime()
+		}
+		for _, rule := range e.rules {
+			if !rule.matches(event) {
+				continue
+			}
+			if alert := e.observe(rule, tenantID, report.ClusterId, event, ts); alert != nil {
+				notifications = append(notifications, *alert)
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	e.deliver(notifications)
+}
+
+// observe must be called with e.mu held. It returns the alert if it just started firing.
+func (e *EventRuleEngine) observe(rule *compiledEventRule, tenantID, clusterID string, event *agent.KubernetesEvent, ts time.Time) *Alert {
+	groupLabels := map[string]string{
+		"alertname": rule.Name,
+		"cluster":   clusterID,
+		"namespace": event.Namespace,
+		"kind":      event.Kind,
+		"name":      event.Name,
+	}
+	if rule.Severity != "" {
+		groupLabels["severity"] = rule.Severity
+	}
+	if tenantID != "" {
+		groupLabels["tenant"] = tenantID
+	}
+	fingerprint := alertFingerprint(groupLabels)
+
+	group, exists := e.groups[fingerprint]
+	if !exists {
+		group = &eventAlertGroup{rule: rule, labels: groupLabels}
+		e.groups[fingerprint] = group
+	}
+
+	group.matches = append(group.matches, ts)
+	sort.Slice(group.matches, func(i, j int) bool { return group.matches[i].Before(group.matches[j]) })
+	cutoff := group.matches[len(group.matches)-1].Add(-rule.Window)
+	for len(group.matches) > 0 && group.matches[0].Before(cutoff) {
+		group.matches = group.matches[1:]
+	}
+
+	if group.alert != nil && group.alert.Status == AlertFiring {
+		group.alert.Count++
+		if ts.After(group.alert.LastSeen) {
+			group.alert.LastSeen = ts
+		}
+		group.alert.Annotations["message"] = event.Message
+		return nil
+	}
+
+	if len(group.matches) < rule.Threshold {
+		return nil
+	}
+
+	group.alert = &Alert{
+		Fingerprint: fingerprint,
+		Status:      AlertFiring,
+		Labels:      groupLabels,
+		Annotations: map[string]string{
+			"summary": rule.summary(event),
+			"message": event.Message,
+			"reason":  event.Reason,
+		},
+		StartsAt: group.matches[0],
+		LastSeen: group.matches[len(group.matches)-1],
+		Count:    len(group.matches),
+	}
+	return copyAlert(group.alert)
+}
+
+// Evaluate resolves firing alerts whose rule has seen no matching event for
+// ResolveAfter. It is meant to be called periodically, like a rule evaluation tick.
+// Groups that are not firing drop matches older than the rule's Window, and are
+// forgotten once they have none left and any resolved alert is older than
+// ResolvedAlertRetention.
+func (e *EventRuleEngine) Evaluate() {
+	e.mu.Lock()
+	now := e.now()
+	var notifications []Alert
+	for fingerprint, group := range e.groups {
+		alert := group.alert
+		if alert == nil || alert.Status != AlertFiring {
+			e.pruneGroup(fingerprint, group, now)
+			continue
+		}
+		if now.Sub(alert.LastSeen) < group.rule.ResolveAfter {
+			continue
+		}
+		alert.Status = AlertResolved
+		alert.EndsAt = now
+		group.matches = nil
+		notifications = append(notifications, *copyAlert(alert))
+	}
+	e.mu.Unlock()
+
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].Fingerprint < notifications[j].Fingerprint })
+	e.deliver(notifications)
+}
+
+// pruneGroup must be called with e.mu held. It expires the matches of a group that
+// is not firing and deletes the group when nothing in it is still relevant.
+func (e *EventRuleEngine) pruneGroup(fingerprint string, group *eventAlertGroup, now time.Time) {
+	cutoff := now.Add(-group.rule.Window)
+	for len(group.matches) > 0 && group.matches[0].Before(cutoff) {
+		group.matches = group.matches[1:]
+	}
+	if len(group.matches) > 0 {
+		return
+	}
+	if group.alert != nil && now.Sub(group.alert.EndsAt) < ResolvedAlertRetention {
+		return
+	}
+	delete(e.groups, fingerprint)
+}
+
+// Alerts returns the latest state of every alert, oldest first
+func (e *EventRuleEngine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var result []Alert
+	for _, group := range e.groups {
+		if group.alert != nil {
+			result = append(result, *copyAlert(group.alert))
+		}
+	}
+	sortAlerts(result)
+	return result
+}
+
+// ActiveAlerts returns the currently firing alerts, oldest first
+func (e *EventRuleEngine) ActiveAlerts() []Alert {
+	var active []Alert
+	for _, alert := range e.Alerts() {
+		if alert.Status == AlertFiring {
+			active = append(active, alert)
+		}
+	}
+	return active
+}
+
+// DeliveryFailures returns how many sink notifications returned an error
+func (e *EventRuleEngine) DeliveryFailures() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deliveryFailures
+}
+
+// deliver sends notifications outside the engine lock so slow sinks do not block ingestion
+func (e *EventRuleEngine) deliver(notifications []Alert) {
+	if len(notifications) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	sinks := append([]AlertSink(nil), e.sinks...)
+	e.mu.Unlock()
+
+	var failures int64
+	for _, alert := range notifications {
+		for _, sink := range sinks {
+			if err := sink.Notify(context.Background(), alert); err != nil {
+				failures++
+			}
+		}
+	}
+
+	if failures > 0 {
+		e.mu.Lock()
+		e.deliveryFailures += failures
+		e.mu.Unlock()
+	}
+}
+
+func (r *compiledEventRule) matches(event *agent.KubernetesEvent) bool {
+	switch {
+	case len(r.Reasons) > 0 && !containsString(r.Reasons, event.Reason):
+		return false
+	case len(r.Types) > 0 && !containsString(r.Types, event.Type):
+		return false
+	case len(r.Namespaces) > 0 && !containsString(r.Namespaces, event.Namespace):
+		return false
+	case r.MessageContains != "" && !strings.Contains(event.Message, r.MessageContains):
+		return false
+	}
+	return r.selector.Matches(labels.Set(event.Labels))
+}
+
+func (r *compiledEventRule) summary(event *agent.KubernetesEvent) string {
+	if r.Summary != "" {
+		return r.Summary
+	}
+	return fmt.Sprintf("%s %s/%s: %s", event.Kind, event.Namespace, event.Name, event.Reason)
+}
+
+func copyAlert(alert *Alert) *Alert {
+	copied := *alert
+	copied.Labels = make(map[string]string, len(alert.Labels))
+	for key, value := range alert.Labels {
+		copied.Labels[key] = value
+	}
+	copied.Annotations = make(map[string]string, len(alert.Annotations))
+	for key, value := range alert.Annotations {
+		copied.Annotations[key] = value
+	}
+	return &copied
+}
+
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].StartsAt.Equal(alerts[j].StartsAt) {
+			return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+		}
+		return alerts[i].Fingerprint < alerts[j].Fingerprint
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// webhookReceiver records alerts posted to it and can be told to fail
+type webhookReceiver struct {
+	alerts []Alert
+	fail   bool
+	mu     sync.Mutex
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var alert Alert
+	if err := json.NewDecoder(req.Body).Decode(&alert); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.alerts = append(r.alerts, alert)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *webhookReceiver) received() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Alert(nil), r.alerts...)
+}
+
+func (r *webhookReceiver) setFailing(fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail = fail
+}
+
+// stampEvents gives every event of the report the same timestamp
+func stampEvents(report *agentv1.EventReport, ts time.Time) *agentv1.EventReport {
+	for _, event := range report.Events {
+		event.Timestamp = timestamppb.New(ts)
+	}
+	return report
+}
+
+// TestEventRulesFireAndResolve tests alerting on generator fixtures through a webhook sink
+func TestEventRulesFireAndResolve(t *testing.T) {
+	receiver := &webhookReceiver{}
+	webhook := httptest.NewServer(receiver)
+	defer webhook.Close()
+
+	engine, err := NewEventRuleEngine([]EventRule{
+		{Name: "ImagePullBackOff", Severity: "critical", Reasons: []string{"Failed"}, MessageContains: "ImagePullBackOff"},
+		{Name: "FailedMount", Severity: "warning", Reasons: []string{"FailedMount"}, Types: []string{"Warning"}},
+		{Name: "ReadinessProbeFlapping", Severity: "warning", Reasons: []string{"Unhealthy"}, LabelSelector: "app=prometheus-server", Threshold: 3, Window: 10 * time.Minute},
+	}, NewWebhookAlertSink(webhook.URL, nil))
+	require.NoError(t, err)
+
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	engine.SetClock(clock.Now)
+
+	metricsService := NewMockMetricsService()
+	metricsService.AddEventSink(engine)
+	ctx := context.Background()
+	generator := NewTestDataGenerator()
+
+	require.NoError(t, metricsService.StoreEvents(ctx, stampEvents(generator.GenerateEventReport("cluster-1", 15), clock.Now())))
+
+	alerts := receiver.received()
+	require.Len(t, alerts, 2, "the probe rule needs three failures before firing")
+	for _, alert := range alerts {
+		require.Equal(t, AlertFiring, alert.Status)
+		require.Equal(t, "cluster-1", alert.Labels["cluster"])
+	}
+	require.ElementsMatch(t, []string{"ImagePullBackOff", "FailedMount"}, []string{alerts[0].Labels["alertname"], alerts[1].Labels["alertname"]})
+
+	// Repeats are deduplicated into the firing alert
+	for i := 0; i < 2; i++ {
+		clock.Advance(time.Minute)
+		require.NoError(t, metricsService.StoreEvents(ctx, stampEvents(generator.GenerateEventReport("cluster-1", 15), clock.Now())))
+	}
+	alerts = receiver.received()
+	require.Len(t, alerts, 3)
+	require.Equal(t, "ReadinessProbeFlapping", alerts[2].Labels["alertname"])
+	require.Equal(t, "prometheus-server-6b8d5f4c7d-m9x2k", alerts[2].Labels["name"])
+	require.Equal(t, 3, alerts[2].Count)
+
+	active := engine.ActiveAlerts()
+	require.Len(t, active, 3)
+	require.Equal(t, 3, active[0].Count)
+
+	// FailedMount keeps recurring while the others go quiet
+	clock.Advance(4 * time.Minute)
+	mount := generator.GenerateEventReport("cluster-1", 10)
+	mount.Events = mount.Events[9:]
+	require.NoError(t, metricsService.StoreEvents(ctx, stampEvents(mount, clock.Now())))
+	clock.Advance(2 * time.Minute)
+	engine.Evaluate()
+
+	alerts = receiver.received()
+	require.Len(t, alerts, 4)
+	require.Equal(t, AlertResolved, alerts[3].Status)
+	require.Equal(t, "ImagePullBackOff", alerts[3].Labels["alertname"])
+	require.Equal(t, clock.Now(), alerts[3].EndsAt)
+
+	clock.Advance(10 * time.Minute)
+	engine.Evaluate()
+	require.Len(t, receiver.received(), 6)
+	require.Empty(t, engine.ActiveAlerts())
+	require.Len(t, engine.Alerts(), 3)
+
+	// A resolved alert fires again on the next matching event
+	require.NoError(t, metricsService.StoreEvents(ctx, stampEvents(generator.GenerateEventReport("cluster-1", 6), clock.Now())))
+	alerts = receiver.received()
+	require.Len(t, alerts, 7)
+	require.Equal(t, AlertFiring, alerts[6].Status)
+	require.Equal(t, clock.Now(), alerts[6].StartsAt)
+	require.Equal(t, alerts[0].Fingerprint, alerts[6].Fingerprint)
+	require.Zero(t, engine.DeliveryFailures())
+}
+
+// TestEventRuleSinks tests the log sink and delivery failure accounting
+func TestEventRuleSinks(t *testing.T) {
+	receiver := &webhookReceiver{fail: true}
+	webhook := httptest.NewServer(receiver)
+	defer webhook.Close()
+
+	var logs bytes.Buffer
+	engine, err := NewEventRuleEngine(
+		[]EventRule{{Name: "BackOff", Reasons: []string{"BackOff"}, Namespaces: []string{"production"}}},
+		NewLogAlertSink(slog.New(slog.NewJSONHandler(&logs, nil))),
+		NewWebhookAlertSink(webhook.URL, nil),
+	)
+	require.NoError(t, err)
+
+	engine.IngestEvents("tenant-1", NewTestDataGenerator().GenerateEventReport("cluster-1", 15))
+	require.Equal(t, int64(1), engine.DeliveryFailures())
+	require.Contains(t, logs.String(), `"msg":"alert firing: BackOff"`)
+	require.Contains(t, logs.String(), `"label.tenant":"tenant-1"`)
+	require.Contains(t, logs.String(), `"label.name":"webapp-frontend-deployment-5d4c7f9b8c-pq5rt"`)
+
+	_, err = NewEventRuleEngine([]EventRule{{Name: "bad", LabelSelector: "app in ("}})
+	require.Error(t, err)
+	_, err = NewEventRuleEngine([]EventRule{{Name: "dup"}, {Name: "dup"}})
+	require.Error(t, err)
+}
+
+// TestEventRulesForgetIdleGroups tests that Evaluate drops groups below threshold and
+// resolved alerts once they are no longer relevant
+func TestEventRulesForgetIdleGroups(t *testing.T) {
+	engine, err := NewEventRuleEngine([]EventRule{
+		{Name: "ImagePullBackOff", Reasons: []string{"Failed"}, MessageContains: "ImagePullBackOff"},
+		{Name: "ReadinessProbeFlapping", Reasons: []string{"Unhealthy"}, Threshold: 3, Window: 10 * time.Minute},
+	})
+	require.NoError(t, err)
+
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	engine.SetClock(clock.Now)
+	engine.IngestEvents("", stampEvents(NewTestDataGenerator().GenerateEventReport("cluster-1", 15), clock.Now()))
+	require.Len(t, engine.ActiveAlerts(), 1)
+	require.Len(t, engine.groups, 2)
+
+	// The probe group never reached its threshold and its match left the window
+	clock.Advance(11 * time.Minute)
+	engine.Evaluate()
+	require.Len(t, engine.groups, 1)
+	alerts := engine.Alerts()
+	require.Len(t, alerts, 1)
+	require.Equal(t, AlertResolved, alerts[0].Status)
+
+	// The resolved alert stays queryable for the retention, then is forgotten
+	clock.Advance(ResolvedAlertRetention - time.Second)
+	engine.Evaluate()
+	require.Len(t, engine.Alerts(), 1)
+	clock.Advance(time.Second)
+	engine.Evaluate()
+	require.Empty(t, engine.Alerts())
+	require.Empty(t, engine.groups)
+}
@@ -0,0 +1,183 @@
+package integration
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// EventKey identifies a series of repeated events, like the Kubernetes event series API
+type EventKey struct {
+	ClusterID string
+	Namespace string
+	Kind      string
+	Name      string
+	Reason    string
+	Type      string
+}
+
+// AggregatedEvent is one event series with its occurrence count and the latest message
+type AggregatedEvent struct {
+	EventKey
+	Count           int32
+	FirstSeen       time.Time
+	LastSeen        time.Time
+	Message         string
+	SourceComponent string
+	Labels          map[string]string
+	// updatedAt is when the series last changed on the backend clock, used for retention
+	updatedAt time.Time
+}
+
+// EventAggregator folds repeated events into one entry per EventKey
+type EventAggregator struct {
+	series map[EventKey]*AggregatedEvent
+	mu     sync.RWMutex
+}
+
+func NewEventAggregator() *EventAggregator {
+	return &EventAggregator{
+		series: make(map[EventKey]*AggregatedEvent),
+	}
+}
+
+// Record adds every event of the report. Events without a timestamp are stamped
+// with receivedAt.
+func (a *EventAggregator) Record(report *agent.EventReport, receivedAt time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, event := range report.Events {
+		a.record(report.ClusterId, event, receivedAt)
+	}
+}
+
+// record must be called with a.mu held
+func (a *EventAggregator) record(clusterID string, event *agent.KubernetesEvent, receivedAt time.Time) {
+	ts := receivedAt
+	if event.Timestamp != nil {
+		ts = event.Timestamp.AsTime()
+	}
+
+	key := EventKey{
+		ClusterID: clusterID,
+		Namespace: event.Namespace,
+		Kind:      event.Kind,
+		Name:      event.Name,
+		Reason:    event.Reason,
+		Type:      event.Type,
+	}
+
+	existing, found := a.series[key]
+	if !found {
+		a.series[key] = &AggregatedEvent{
+			EventKey:        key,
+			Count:           1,
+			FirstSeen:       ts,
+			LastSeen:        ts,
+			Message:         event.Message,
+			SourceComponent: event.SourceComponent,
+			Labels:          event.Labels,
+			updatedAt:       receivedAt,
+		}
+		return
+	}
+
+	existing.Count++
+	existing.updatedAt = receivedAt
+	if ts.Before(existing.FirstSeen) {
+		existing.FirstSeen = ts
+	}
+	// Late arrivals still count but must not overwrite a newer message
+	if !ts.Before(existing.LastSeen) {
+		existing.LastSeen = ts
+		existing.Message = event.Message
+		existing.SourceComponent = event.SourceComponent
+		existing.Labels = event.Labels
+	}
+}
+
+// Get returns the series for key
+func (a *EventAggregator) Get(key EventKey) (AggregatedEvent, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	event, found := a.series[key]
+	if !found {
+		return AggregatedEvent{}, false
+	}
+	return *event, true
+}
+
+// Events returns all series, most recently seen first
+func (a *EventAggregator) Events() []AggregatedEvent {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := make([]AggregatedEvent, 0, len(a.series))
+	for _, event := range a.series {
+		result = append(result, *event)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeen.Equal(result[j].LastSeen) {
+			return result[i].LastSeen.After(result[j].LastSeen)
+		}
+		return result[i].EventKey.less(result[j].EventKey)
+	})
+	return result
+}
+
+// Len returns the number of distinct series
+func (a *EventAggregator) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.series)
+}
+
+// Prune drops series that have not been updated since cutoff and returns how many were dropped
+func (a *EventAggregator) Prune(cutoff time.Time) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed := 0
+	for key, event := range a.series {
+		if event.updatedAt.Before(cutoff) {
+			delete(a.series, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// RemoveCluster drops all series of one cluster
+func (a *EventAggregator) RemoveCluster(clusterID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key := range a.series {
+		if key.ClusterID == clusterID {
+			delete(a.series, key)
+		}
+	}
+}
+
+// Reset drops all series
+func (a *EventAggregator) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clear(a.series)
+}
+
+func (k EventKey) less(other EventKey) bool {
+	a := [...]string{k.ClusterID, k.Namespace, k.Kind, k.Name, k.Reason, k.Type}
+	b := [...]string{other.ClusterID, other.Namespace, other.Kind, other.Name, other.Reason, other.Type}
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
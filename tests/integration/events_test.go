@@ -0,0 +1,136 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+func backOffEvent(ts time.Time, message string) *agentv1.KubernetesEvent {
+	return &agentv1.KubernetesEvent{
+		Namespace:       "production",
+		Name:            "webapp-frontend-deployment-5d4c7f9b8c-pq5rt",
+		Kind:            "Pod",
+		Type:            "Normal",
+		Reason:          "BackOff",
+		Message:         message,
+		Timestamp:       timestamppb.New(ts),
+		SourceComponent: "kubelet",
+	}
+}
+
+// TestEventAggregationCountsRepeats tests that repeated events fold into one series
+func TestEventAggregationCountsRepeats(t *testing.T) {
+	metricsService := NewMockMetricsService()
+	generator := NewTestDataGenerator()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, metricsService.StoreEvents(ctx, generator.GenerateEventReport("cluster-1", 30)))
+	}
+	require.NoError(t, metricsService.StoreEvents(ctx, generator.GenerateEventReport("cluster-2", 15)))
+
+	// Raw reports are still kept as received
+	require.Len(t, metricsService.GetReceivedEvents(), 5)
+
+	// The generator cycles through 15 distinct series per cluster
+	aggregated := metricsService.GetAggregatedEvents()
+	require.Len(t, aggregated, 30)
+
+	event, found := metricsService.EventAggregator().Get(EventKey{
+		ClusterID: "cluster-1",
+		Namespace: "monitoring",
+		Kind:      "Pod",
+		Name:      "prometheus-server-6b8d5f4c7d-m9x2k",
+		Reason:    "Unhealthy",
+		Type:      "Warning",
+	})
+	require.True(t, found)
+	require.Equal(t, int32(8), event.Count)
+	require.Equal(t, "Readiness probe failed: HTTP probe failed with statuscode: 503", event.Message)
+	require.False(t, event.LastSeen.Before(event.FirstSeen))
+
+	metricsService.ClearCluster("cluster-2")
+	require.Len(t, metricsService.GetAggregatedEvents(), 15)
+
+	metricsService.Clear()
+	require.Empty(t, metricsService.GetAggregatedEvents())
+}
+
+// TestEventAggregationTimestamps tests first-seen, last-seen and latest message handling
+func TestEventAggregationTimestamps(t *testing.T) {
+	aggregator := NewEventAggregator()
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	received := base.Add(time.Hour)
+
+	aggregator.Record(&agentv1.EventReport{
+		ClusterId: "cluster-1",
+		Events: []*agentv1.KubernetesEvent{
+			backOffEvent(base.Add(time.Minute), "Back-off restarting failed container (1)"),
+			backOffEvent(base.Add(3*time.Minute), "Back-off restarting failed container (3)"),
+		},
+	}, received)
+
+	// A late event extends first-seen but keeps the newer message
+	aggregator.Record(&agentv1.EventReport{
+		ClusterId: "cluster-1",
+		Events:    []*agentv1.KubernetesEvent{backOffEvent(base, "Back-off restarting failed container (0)")},
+	}, received)
+
+	// Events without a timestamp are stamped on arrival
+	undated := backOffEvent(base, "Back-off restarting failed container (latest)")
+	undated.Timestamp = nil
+	aggregator.Record(&agentv1.EventReport{ClusterId: "cluster-1", Events: []*agentv1.KubernetesEvent{undated}}, received)
+
+	events := aggregator.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, int32(4), events[0].Count)
+	require.Equal(t, base, events[0].FirstSeen)
+	require.Equal(t, received, events[0].LastSeen)
+	require.Equal(t, "Back-off restarting failed container (latest)", events[0].Message)
+
+	// A different type is a different series
+	warning := backOffEvent(base, "Back-off restarting failed container")
+	warning.Type = "Warning"
+	aggregator.Record(&agentv1.EventReport{ClusterId: "cluster-1", Events: []*agentv1.KubernetesEvent{warning}}, received)
+	require.Equal(t, 2, aggregator.Len())
+	require.Equal(t, "Normal", aggregator.Events()[0].Type, "most recently seen series comes first")
+
+	require.Equal(t, 2, aggregator.Prune(received.Add(time.Second)))
+	require.Zero(t, aggregator.Len())
+}
+
+// TestEventAggregationRetention tests that series age out with the retention policy
+func TestEventAggregationRetention(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	metricsService := NewMockMetricsService()
+	metricsService.SetClock(clock.Now)
+	metricsService.SetRetentionPolicy(RetentionPolicy{MaxAge: 10 * time.Minute})
+	ctx := context.Background()
+
+	require.NoError(t, metricsService.StoreEvents(ctx, &agentv1.EventReport{
+		ClusterId: "cluster-1",
+		Events:    []*agentv1.KubernetesEvent{backOffEvent(clock.Now(), "Back-off restarting failed container")},
+	}))
+	stale := NewTestDataGenerator().GenerateEventReport("cluster-1", 3)
+	require.NoError(t, metricsService.StoreEvents(ctx, stale))
+
+	clock.Advance(8 * time.Minute)
+	require.NoError(t, metricsService.StoreEvents(ctx, &agentv1.EventReport{
+		ClusterId: "cluster-1",
+		Events:    []*agentv1.KubernetesEvent{backOffEvent(clock.Now(), "Back-off restarting failed container")},
+	}))
+
+	// The recurring BackOff series survives; the one-off events age out
+	clock.Advance(5 * time.Minute)
+	metricsService.EnforceRetention()
+	events := metricsService.GetAggregatedEvents()
+	require.Len(t, events, 1)
+	require.Equal(t, "BackOff", events[0].Reason)
+	require.Equal(t, int32(2), events[0].Count)
+}
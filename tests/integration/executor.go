@@ -0,0 +1,448 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// ErrUnscalableWorkload is returned for intents targeting a workload type without a scale subresource
+var ErrUnscalableWorkload = errors.New("workload type is not scalable")
+
+// ExecutorConfig configures a ScalingExecutor
+type ExecutorConfig struct {
+	ClusterID string
+	// StepInterval is the pause between replica steps of a stepped rollout
+	StepInterval time.Duration
+	// ReadyPollInterval is how often the ready replicas of a step are checked before
+	// the next step; defaults to 5s
+	ReadyPollInterval time.Duration
+	// ReadyTimeout bounds the wait for the replicas of a step to become ready; defaults to 10m
+	ReadyTimeout time.Duration
+}
+
+// ExecutionResult is what an executor did for one intent
+type ExecutionResult struct {
+	IntentID     string
+	FromReplicas int32
+	ToReplicas   int32
+	// Steps are the replica counts written to the scale subresource, in order
+	Steps []int32
+}
+
+// ScalingExecutor applies ScalingIntents on the agent side by patching the scale
+// subresource of Deployments, StatefulSets and ReplicaSets. Scale-ups move at most
+// Strategy.MaxSurge replicas per step and scale-downs at most Strategy.MaxUnavailable,
+// and each step waits for the replicas of the previous one to be ready; a zero or
+// missing strategy scales in a single step. Intents for workloads under a
+// freeze relayed by the backend are refused, and a freeze stops a stepped rollout.
+// Vertical intents set the container resources of Deployment, StatefulSet and
+// DaemonSet pod templates.
+type ScalingExecutor struct {
+	client  kubernetes.Interface
+	config  ExecutorConfig
+	results ScalingIntentResultSink
+	sleep   func(ctx context.Context, d time.Duration) error
+	freezes []ScalingFreeze
+	now     func() time.Time
+	mu      sync.RWMutex
+}
+
+func NewScalingExecutor(client kubernetes.Interface, config ExecutorConfig) *ScalingExecutor {
+	if config.ReadyPollInterval == 0 {
+		config.ReadyPollInterval = 5 * time.Second
+	}
+	if config.ReadyTimeout == 0 {
+		config.ReadyTimeout = 10 * time.Minute
+	}
+	return &ScalingExecutor{
+		client: client,
+		config: config,
+		sleep:  sleepContext,
+		now:    time.Now,
+	}
+}
+
+// SetClock replaces the time source freezes are checked against, for tests
+func (e *ScalingExecutor) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
+// SendFreezes replaces the freezes of the cluster with those relayed by the backend
+func (e *ScalingExecutor) SendFreezes(clusterID string, freezes []ScalingFreeze) {
+	if e.config.ClusterID != "" && clusterID != e.config.ClusterID {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.freezes = append([]ScalingFreeze(nil), freezes...)
+}
+
+// frozen returns an error if a freeze is active for the workload. The freezes
+// were relayed for this cluster, so only their namespace and workload are matched.
+func (e *ScalingExecutor) frozen(namespace, workload string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	now := e.now()
+	for _, freeze := range e.freezes {
+		if freeze.Active(now) && (freeze.Scope.Namespace == "" || freeze.Scope.Namespace == namespace) &&
+			(freeze.Scope.Workload == "" || freeze.Scope.Workload == workload) {
+			return fmt.Errorf("%w: %s", ErrScalingFrozen, freeze)
+		}
+	}
+	return nil
+}
+
+// SetResultSink reports the progress and outcome of each executed intent to sink
+func (e *ScalingExecutor) SetResultSink(sink ScalingIntentResultSink) {
+	e.results = sink
+}
+
+// SetSleep replaces the wait between steps and readiness checks, for tests
+func (e *ScalingExecutor) SetSleep(sleep func(ctx context.Context, d time.Duration) error) {
+	e.sleep = sleep
+}
+
+// HandleScalingIntent executes the intent and reports the result
+func (e *ScalingExecutor) HandleScalingIntent(ctx context.Context, intent *agent.ScalingIntent) error {
+	_, err := e.Execute(ctx, intent)
+	return err
+}
+
+// Execute scales the target workload of the intent to TargetReplicas
+func (e *ScalingExecutor) Execute(ctx context.Context, intent *agent.ScalingIntent) (*ExecutionResult, error) {
+	result, observed, err := e.execute(ctx, intent)
+	if err != nil {
+		failed := ScalingIntentResult{IntentID: intent.IntentId, State: IntentFailed, Error: err.Error()}
+		if observed {
+			failed.ObservedReplicas = result.ToReplicas
+		}
+		e.report(failed)
+		return result, err
+	}
+	e.report(ScalingIntentResult{IntentID: intent.IntentId, State: IntentSucceeded, ObservedReplicas: result.ToReplicas})
+	return result, nil
+}
+
+// execute runs the rollout of the intent. observed is false when the replicas of
+// the workload could not be read, so that nothing is reported as observed.
+func (e *ScalingExecutor) execute(ctx context.Context, intent *agent.ScalingIntent) (result *ExecutionResult, observed bool, err error) {
+	result = &ExecutionResult{IntentID: intent.IntentId}
+	if intent.TargetReplicas < 0 {
+		return result, false, fmt.Errorf("invalid target replicas %d", intent.TargetReplicas)
+	}
+	scales, err := e.scales(intent.WorkloadType, intent.WorkloadNamespace)
+	if err != nil {
+		return result, false, err
+	}
+
+	scale, err := scales.GetScale(ctx, intent.WorkloadName)
+	if err != nil {
+		return result, false, fmt.Errorf("failed to get scale of %s %s/%s: %w", intent.WorkloadType, intent.WorkloadNamespace, intent.WorkloadName, err)
+	}
+	result.FromReplicas = scale.Spec.Replicas
+	result.ToReplicas = scale.Spec.Replicas
+	if scale.Spec.Replicas == intent.TargetReplicas {
+		return result, true, nil
+	}
+	if err := e.frozen(intent.WorkloadNamespace, intent.WorkloadName); err != nil {
+		return result, true, err
+	}
+	e.report(ScalingIntentResult{IntentID: intent.IntentId, State: IntentApplying, ObservedReplicas: scale.Spec.Replicas, ReadyReplicas: scale.Status.Replicas})
+
+	for _, replicas := range replicaSteps(scale.Spec.Replicas, intent.TargetReplicas, intent.Strategy) {
+		if len(result.Steps) > 0 {
+			if err := e.sleep(ctx, e.config.StepInterval); err != nil {
+				return result, true, err
+			}
+			if err := e.waitReady(ctx, scales, intent, result.ToReplicas); err != nil {
+				return result, true, err
+			}
+			if err := e.frozen(intent.WorkloadNamespace, intent.WorkloadName); err != nil {
+				return result, true, err
+			}
+		}
+		// Patching only the replicas needs no resourceVersion, so consecutive steps
+		// do not conflict with the controllers updating the workload in between
+		if err := scales.PatchReplicas(ctx, intent.WorkloadName, replicas); err != nil {
+			return result, true, fmt.Errorf("failed to scale %s %s/%s to %d replicas: %w", intent.WorkloadType, intent.WorkloadNamespace, intent.WorkloadName, replicas, err)
+		}
+		result.Steps = append(result.Steps, replicas)
+		result.ToReplicas = replicas
+	}
+	return result, true, nil
+}
+
+// waitReady polls the workload of the intent until at least replicas are ready,
+// so that a step never makes more replicas unavailable than the strategy allows
+func (e *ScalingExecutor) waitReady(ctx context.Context, scales scaleClient, intent *agent.ScalingIntent, replicas int32) error {
+	for waited := time.Duration(0); ; waited += e.config.ReadyPollInterval {
+		ready, err := scales.ReadyReplicas(ctx, intent.WorkloadName)
+		if err != nil {
+			return fmt.Errorf("failed to get ready replicas of %s %s/%s: %w", intent.WorkloadType, intent.WorkloadNamespace, intent.WorkloadName, err)
+		}
+		if ready >= replicas {
+			return nil
+		}
+		if waited >= e.config.ReadyTimeout {
+			return fmt.Errorf("timed out after %s waiting for %d ready replicas of %s %s/%s, %d ready",
+				e.config.ReadyTimeout, replicas, intent.WorkloadType, intent.WorkloadNamespace, intent.WorkloadName, ready)
+		}
+		if err := e.sleep(ctx, e.config.ReadyPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// scaleClient is the scale subresource of one workload kind, with the ready
+// replicas from the workload status
+type scaleClient interface {
+	GetScale(ctx context.Context, name string) (*autoscalingv1.Scale, error)
+	PatchReplicas(ctx context.Context, name string, replicas int32) error
+	ReadyReplicas(ctx context.Context, name string) (int32, error)
+}
+
+// scalableClient is the typed client of a workload kind with a scale subresource
+type scalableClient[T any] interface {
+	Get(ctx context.Context, name string, options metav1.GetOptions) (T, error)
+	GetScale(ctx context.Context, name string, options metav1.GetOptions) (*autoscalingv1.Scale, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (T, error)
+}
+
+// typedScaleClient implements scaleClient on a typed workload client
+type typedScaleClient[T any] struct {
+	client scalableClient[T]
+	ready  func(workload T) int32
+}
+
+func (c typedScaleClient[T]) GetScale(ctx context.Context, name string) (*autoscalingv1.Scale, error) {
+	return c.client.GetScale(ctx, name, metav1.GetOptions{})
+}
+
+func (c typedScaleClient[T]) PatchReplicas(ctx context.Context, name string, replicas int32) error {
+	patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas)
+	_, err := c.client.Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}, "scale")
+	return err
+}
+
+func (c typedScaleClient[T]) ReadyReplicas(ctx context.Context, name string) (int32, error) {
+	workload, err := c.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return c.ready(workload), nil
+}
+
+func (e *ScalingExecutor) scales(workloadType, namespace string) (scaleClient, error) {
+	switch strings.ToLower(workloadType) {
+	case "deployment":
+		return typedScaleClient[*appsv1.Deployment]{
+			client: e.client.AppsV1().Deployments(namespace),
+			ready:  func(deployment *appsv1.Deployment) int32 { return deployment.Status.ReadyReplicas },
+		}, nil
+	case "statefulset":
+		return typedScaleClient[*appsv1.StatefulSet]{
+			client: e.client.AppsV1().StatefulSets(namespace),
+			ready:  func(statefulSet *appsv1.StatefulSet) int32 { return statefulSet.Status.ReadyReplicas },
+		}, nil
+	case "replicaset":
+		return typedScaleClient[*appsv1.ReplicaSet]{
+			client: e.client.AppsV1().ReplicaSets(namespace),
+			ready:  func(replicaSet *appsv1.ReplicaSet) int32 { return replicaSet.Status.ReadyReplicas },
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnscalableWorkload, workloadType)
+	}
+}
+
+// ExecuteVertical sets the container resources of the pod template of the intent's
+// workload, which rolls out new pods
+func (e *ScalingExecutor) ExecuteVertical(ctx context.Context, intent VerticalScalingIntent) error {
+	err := e.executeVertical(ctx, intent)
+	if err != nil {
+		e.report(ScalingIntentResult{IntentID: intent.IntentID, State: IntentFailed, Error: err.Error()})
+		return err
+	}
+	e.report(ScalingIntentResult{IntentID: intent.IntentID, State: IntentSucceeded})
+	return nil
+}
+
+func (e *ScalingExecutor) executeVertical(ctx context.Context, intent VerticalScalingIntent) error {
+	if err := e.frozen(intent.Namespace, intent.WorkloadName); err != nil {
+		return err
+	}
+	template, update, err := e.podTemplate(ctx, intent.WorkloadType, intent.Namespace, intent.WorkloadName)
+	if err != nil {
+		return err
+	}
+	for _, resources := range intent.Containers {
+		container := findContainer(template.Spec.Containers, resources.Name)
+		if container == nil {
+			return fmt.Errorf("%s %s/%s has no container %q", intent.WorkloadType, intent.Namespace, intent.WorkloadName, resources.Name)
+		}
+		container.Resources.Requests = mergeResources(container.Resources.Requests, resources.Requests)
+		container.Resources.Limits = mergeResources(container.Resources.Limits, resources.Limits)
+	}
+	e.report(ScalingIntentResult{IntentID: intent.IntentID, State: IntentApplying})
+	if err := update(); err != nil {
+		return fmt.Errorf("failed to update resources of %s %s/%s: %w", intent.WorkloadType, intent.Namespace, intent.WorkloadName, err)
+	}
+	return nil
+}
+
+// ReportResources reads the container resources of the workloads in a metrics
+// report for the vertical recommender. Workloads without a pod template the
+// executor can update, or that no longer exist, are left out.
+func (e *ScalingExecutor) ReportResources(ctx context.Context, report *agent.MetricsReport) (ResourcesReport, error) {
+	resources := ResourcesReport{ClusterID: report.ClusterId, Timestamp: reportTime(report)}
+	for _, workload := range report.WorkloadMetrics {
+		template, _, err := e.podTemplate(ctx, workload.WorkloadType, workload.Namespace, workload.WorkloadName)
+		if errors.Is(err, ErrUnscalableWorkload) || apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return ResourcesReport{}, err
+		}
+		workloadResources := WorkloadResources{
+			Namespace:    workload.Namespace,
+			Workload:     workload.WorkloadName,
+			WorkloadType: workload.WorkloadType,
+			Replicas:     workload.Replicas,
+		}
+		for _, container := range template.Spec.Containers {
+			workloadResources.Containers = append(workloadResources.Containers, ContainerResources{
+				Name:     container.Name,
+				Requests: container.Resources.Requests,
+				Limits:   container.Resources.Limits,
+			})
+		}
+		resources.Workloads = append(resources.Workloads, workloadResources)
+	}
+	return resources, nil
+}
+
+// podTemplate returns the pod template of a workload and a function writing the
+// workload back with the changed template
+func (e *ScalingExecutor) podTemplate(ctx context.Context, workloadType, namespace, name string) (*corev1.PodTemplateSpec, func() error, error) {
+	switch strings.ToLower(workloadType) {
+	case "deployment":
+		client := e.client.AppsV1().Deployments(namespace)
+		deployment, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &deployment.Spec.Template, func() error {
+			_, err := client.Update(ctx, deployment, metav1.UpdateOptions{})
+			return err
+		}, nil
+	case "statefulset":
+		client := e.client.AppsV1().StatefulSets(namespace)
+		statefulSet, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &statefulSet.Spec.Template, func() error {
+			_, err := client.Update(ctx, statefulSet, metav1.UpdateOptions{})
+			return err
+		}, nil
+	case "daemonset":
+		client := e.client.AppsV1().DaemonSets(namespace)
+		daemonSet, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &daemonSet.Spec.Template, func() error {
+			_, err := client.Update(ctx, daemonSet, metav1.UpdateOptions{})
+			return err
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnscalableWorkload, workloadType)
+	}
+}
+
+func findContainer(containers []corev1.Container, name string) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// mergeResources sets the resources of changes on current, keeping the others
+func mergeResources(current, changes corev1.ResourceList) corev1.ResourceList {
+	if len(changes) == 0 {
+		return current
+	}
+	merged := current.DeepCopy()
+	if merged == nil {
+		merged = corev1.ResourceList{}
+	}
+	for name, quantity := range changes {
+		merged[name] = quantity
+	}
+	return merged
+}
+
+func (e *ScalingExecutor) report(result ScalingIntentResult) {
+	if e.results == nil {
+		return
+	}
+	result.ClusterID = e.config.ClusterID
+	e.results.ReportResult(result)
+}
+
+// replicaSteps returns the replica counts to step through from current to target
+func replicaSteps(current, target int32, strategy *agent.ScalingStrategy) []int32 {
+	step := int32(0)
+	if strategy != nil {
+		if target > current {
+			step = strategy.MaxSurge
+		} else {
+			step = strategy.MaxUnavailable
+		}
+	}
+	if step <= 0 {
+		return []int32{target}
+	}
+
+	var steps []int32
+	for replicas := current; replicas != target; {
+		if target > current {
+			replicas = min(replicas+step, target)
+		} else {
+			replicas = max(replicas-step, target)
+		}
+		steps = append(steps, replicas)
+	}
+	return steps
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
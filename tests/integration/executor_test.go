@@ -0,0 +1,326 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// newScaleClientset returns a fake clientset whose scale subresource reads and
+// writes the replicas of the stored Deployments and StatefulSets
+func newScaleClientset(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewClientset(objects...)
+	tracker := client.Tracker()
+
+	client.PrependReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		get := action.(k8stesting.GetAction)
+		obj, err := tracker.Get(action.GetResource(), get.GetNamespace(), get.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		scale := &autoscalingv1.Scale{ObjectMeta: metav1.ObjectMeta{Name: get.GetName(), Namespace: get.GetNamespace()}}
+		switch workload := obj.(type) {
+		case *appsv1.Deployment:
+			scale.Spec.Replicas = *workload.Spec.Replicas
+			scale.Status.Replicas = workload.Status.ReadyReplicas
+		case *appsv1.StatefulSet:
+			scale.Spec.Replicas = *workload.Spec.Replicas
+			scale.Status.Replicas = workload.Status.ReadyReplicas
+		default:
+			return true, nil, fmt.Errorf("no scale subresource for %T", obj)
+		}
+		return true, scale, nil
+	})
+
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		patch := action.(k8stesting.PatchAction)
+		var scale autoscalingv1.Scale
+		if err := json.Unmarshal(patch.GetPatch(), &scale); err != nil {
+			return true, nil, err
+		}
+		obj, err := tracker.Get(action.GetResource(), action.GetNamespace(), patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		// The new replicas become ready at once; tests delay readiness with a reactor
+		// on the workload itself
+		switch workload := obj.(type) {
+		case *appsv1.Deployment:
+			workload.Spec.Replicas = int32Ptr(scale.Spec.Replicas)
+			workload.Status.ReadyReplicas = scale.Spec.Replicas
+		case *appsv1.StatefulSet:
+			workload.Spec.Replicas = int32Ptr(scale.Spec.Replicas)
+			workload.Status.ReadyReplicas = scale.Spec.Replicas
+		}
+		return true, obj, tracker.Update(action.GetResource(), obj, action.GetNamespace())
+	})
+	return client
+}
+
+// scaleUpdates returns the replicas patched into the scale subresource, in order
+func scaleUpdates(client *fake.Clientset) []int32 {
+	var updates []int32
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" && action.GetSubresource() == "scale" {
+			var scale autoscalingv1.Scale
+			json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &scale)
+			updates = append(updates, scale.Spec.Replicas)
+		}
+	}
+	return updates
+}
+
+func testDeployment(namespace, name string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(replicas)},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: replicas},
+	}
+}
+
+// TestScalingExecutorSteppedRollout tests stepped scale-ups and scale-downs of Deployments and StatefulSets
+func TestScalingExecutorSteppedRollout(t *testing.T) {
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "elasticsearch", Namespace: "logging"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: int32Ptr(3)},
+	}
+	client := newScaleClientset(testDeployment("production", "webapp-frontend", 3), statefulSet)
+
+	executor := NewScalingExecutor(client, ExecutorConfig{ClusterID: "cluster-1", StepInterval: 30 * time.Second})
+	var waited []time.Duration
+	executor.SetSleep(func(ctx context.Context, d time.Duration) error {
+		waited = append(waited, d)
+		return nil
+	})
+
+	intent := &agentv1.ScalingIntent{
+		IntentId:          "scale-up",
+		WorkloadNamespace: "production",
+		WorkloadName:      "webapp-frontend",
+		WorkloadType:      "Deployment",
+		TargetReplicas:    8,
+		Strategy:          &agentv1.ScalingStrategy{Type: "RollingUpdate", MaxSurge: 2, MaxUnavailable: 1},
+	}
+	result, err := executor.Execute(context.Background(), intent)
+	require.NoError(t, err)
+	require.Equal(t, &ExecutionResult{IntentID: "scale-up", FromReplicas: 3, ToReplicas: 8, Steps: []int32{5, 7, 8}}, result)
+	require.Equal(t, []int32{5, 7, 8}, scaleUpdates(client))
+	require.Equal(t, []time.Duration{30 * time.Second, 30 * time.Second}, waited, "no wait before the first step")
+
+	deployment, err := client.AppsV1().Deployments("production").Get(context.Background(), "webapp-frontend", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(8), *deployment.Spec.Replicas)
+
+	// Scale-downs step by MaxUnavailable
+	client.ClearActions()
+	intent.IntentId, intent.TargetReplicas = "scale-down", 6
+	result, err = executor.Execute(context.Background(), intent)
+	require.NoError(t, err)
+	require.Equal(t, []int32{7, 6}, result.Steps)
+
+	// Without a strategy the StatefulSet scales in one step
+	client.ClearActions()
+	_, err = executor.Execute(context.Background(), &agentv1.ScalingIntent{
+		IntentId:          "statefulset",
+		WorkloadNamespace: "logging",
+		WorkloadName:      "elasticsearch",
+		WorkloadType:      "statefulset",
+		TargetReplicas:    5,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int32{5}, scaleUpdates(client))
+	statefulSet, err = client.AppsV1().StatefulSets("logging").Get(context.Background(), "elasticsearch", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(5), *statefulSet.Spec.Replicas)
+
+	// Workloads already at the target are left alone
+	client.ClearActions()
+	result, err = executor.Execute(context.Background(), &agentv1.ScalingIntent{
+		IntentId: "noop", WorkloadNamespace: "logging", WorkloadName: "elasticsearch", WorkloadType: "statefulset", TargetReplicas: 5,
+	})
+	require.NoError(t, err)
+	require.Empty(t, result.Steps)
+	require.Empty(t, scaleUpdates(client))
+}
+
+// TestScalingExecutorRejectsAndReports tests rejected intents, API failures and the reported results
+func TestScalingExecutorRejectsAndReports(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	client := newScaleClientset(testDeployment("production", "webapp-frontend", 2))
+	executor := NewScalingExecutor(client, ExecutorConfig{ClusterID: "cluster-1"})
+
+	var sent []*agentv1.ScalingIntent
+	tracker := NewIntentTracker(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		sent = append(sent, intent)
+	}), IntentTrackerConfig{})
+	tracker.SetClock(clock.Now)
+	executor.SetResultSink(tracker)
+
+	for _, workloadType := range []string{"daemonset", "job", "cronjob", ""} {
+		intent := &agentv1.ScalingIntent{
+			IntentId:          "reject-" + workloadType,
+			WorkloadNamespace: "kube-system",
+			WorkloadName:      "kube-proxy",
+			WorkloadType:      workloadType,
+			TargetReplicas:    3,
+		}
+		tracker.Issue("tenant-1", "cluster-1", intent)
+		_, err := executor.Execute(context.Background(), intent)
+		require.ErrorIs(t, err, ErrUnscalableWorkload, workloadType)
+
+		record, _ := tracker.Get(intent.IntentId)
+		require.Equal(t, IntentFailed, record.State)
+		require.Contains(t, record.Error, "workload type is not scalable")
+	}
+	for _, action := range client.Actions() {
+		require.NotEqual(t, "patch", action.GetVerb(), "rejected intents never reach the API")
+	}
+
+	_, err := executor.Execute(context.Background(), &agentv1.ScalingIntent{
+		IntentId: "missing", WorkloadNamespace: "production", WorkloadName: "webapp-backend", WorkloadType: "deployment", TargetReplicas: 3,
+	})
+	require.ErrorContains(t, err, "failed to get scale of deployment production/webapp-backend")
+
+	// A failed update in the middle of a rollout reports how far it got
+	updates := 0
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if updates++; updates == 2 {
+			return true, nil, errors.New("admission webhook denied the request")
+		}
+		return false, nil, nil
+	})
+	intent := &agentv1.ScalingIntent{
+		IntentId:          "partial",
+		WorkloadNamespace: "production",
+		WorkloadName:      "webapp-frontend",
+		WorkloadType:      "deployment",
+		TargetReplicas:    6,
+		Strategy:          &agentv1.ScalingStrategy{MaxSurge: 2},
+	}
+	tracker.Issue("tenant-1", "cluster-1", intent)
+	result, err := executor.Execute(context.Background(), intent)
+	require.ErrorContains(t, err, "failed to scale deployment production/webapp-frontend to 6 replicas: admission webhook denied the request")
+	require.Equal(t, []int32{4}, result.Steps)
+
+	record, _ := tracker.Get("partial")
+	require.Equal(t, IntentFailed, record.State)
+	require.Equal(t, int32(4), record.ObservedReplicas)
+	require.Equal(t, []IntentState{IntentIssued, IntentApplying, IntentFailed}, intentStates(record))
+
+	// A cancelled context stops the rollout between steps
+	ctx, cancel := context.WithCancel(context.Background())
+	executor.SetSleep(func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	})
+	intent.IntentId, intent.TargetReplicas = "cancelled", 2
+	intent.Strategy.MaxUnavailable = 1
+	tracker.Issue("tenant-1", "cluster-1", intent)
+	result, err = executor.Execute(ctx, intent)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []int32{3}, result.Steps)
+
+	// Successful intents report the observed replicas
+	executor.SetSleep(func(ctx context.Context, d time.Duration) error { return nil })
+	intent.IntentId = "succeeded"
+	tracker.Issue("tenant-1", "cluster-1", intent)
+	require.NoError(t, executor.HandleScalingIntent(context.Background(), intent))
+	record, _ = tracker.Get("succeeded")
+	require.Equal(t, IntentSucceeded, record.State)
+	require.Equal(t, int32(2), record.ObservedReplicas)
+	require.Len(t, sent, 7)
+}
+
+// TestScalingExecutorWaitsForReadyReplicas tests that each step waits for the replicas
+// of the previous one and gives up after the ready timeout
+func TestScalingExecutorWaitsForReadyReplicas(t *testing.T) {
+	client := newScaleClientset(testDeployment("production", "webapp-frontend", 2))
+	executor := NewScalingExecutor(client, ExecutorConfig{
+		ClusterID: "cluster-1", StepInterval: 30 * time.Second, ReadyPollInterval: 10 * time.Second, ReadyTimeout: time.Minute,
+	})
+	var waited []time.Duration
+	executor.SetSleep(func(ctx context.Context, d time.Duration) error {
+		waited = append(waited, d)
+		return nil
+	})
+
+	// The first two readiness checks see the new pods still starting
+	checks := 0
+	client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "" {
+			return false, nil, nil
+		}
+		obj, err := client.Tracker().Get(action.GetResource(), action.GetNamespace(), action.(k8stesting.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		deployment := obj.(*appsv1.Deployment).DeepCopy()
+		if checks++; checks <= 2 {
+			deployment.Status.ReadyReplicas -= 2
+		}
+		return true, deployment, nil
+	})
+
+	intent := &agentv1.ScalingIntent{
+		IntentId:          "scale-up",
+		WorkloadNamespace: "production",
+		WorkloadName:      "webapp-frontend",
+		WorkloadType:      "deployment",
+		TargetReplicas:    6,
+		Strategy:          &agentv1.ScalingStrategy{MaxSurge: 2},
+	}
+	result, err := executor.Execute(context.Background(), intent)
+	require.NoError(t, err)
+	require.Equal(t, []int32{4, 6}, result.Steps)
+	require.Equal(t, []time.Duration{30 * time.Second, 10 * time.Second, 10 * time.Second}, waited)
+
+	// Replicas are patched without a resourceVersion, so steps never conflict
+	for _, action := range client.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			require.Equal(t, "scale", patch.GetSubresource())
+			require.NotContains(t, string(patch.GetPatch()), "resourceVersion")
+		}
+	}
+
+	// Pods that never become ready stop the rollout after the timeout
+	var results []ScalingIntentResult
+	executor.SetResultSink(resultSinkFunc(func(result ScalingIntentResult) error {
+		results = append(results, result)
+		return nil
+	}))
+	waited, checks = nil, -100
+	intent.IntentId, intent.TargetReplicas = "stuck", 10
+	result, err = executor.Execute(context.Background(), intent)
+	require.ErrorContains(t, err, "timed out after 1m0s waiting for 8 ready replicas of deployment production/webapp-frontend, 6 ready")
+	require.Equal(t, []int32{8}, result.Steps)
+	require.Len(t, waited, 7)
+	require.Equal(t, IntentFailed, results[len(results)-1].State)
+	require.Equal(t, int32(8), results[len(results)-1].ObservedReplicas)
+
+	// Nothing is reported as observed when the replicas cannot be read
+	results = nil
+	_, err = executor.Execute(context.Background(), &agentv1.ScalingIntent{
+		IntentId: "missing", WorkloadNamespace: "production", WorkloadName: "webapp-backend", WorkloadType: "deployment", TargetReplicas: 3,
+	})
+	require.Error(t, err)
+	require.Equal(t, []ScalingIntentResult{{IntentID: "missing", ClusterID: "cluster-1", State: IntentFailed, Error: err.Error()}}, results)
+}
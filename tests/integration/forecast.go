@@ -0,0 +1,274 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrInsufficientHistory is returned when a series is too short to fit a seasonal model
+var ErrInsufficientHistory = errors.New("insufficient history for a seasonal forecast")
+
+// PredictiveMode selects how forecasts are combined with reactive recommendations
+type PredictiveMode string
+
+const (
+	// PredictiveOnly scales on the forecast alone once a model can be fitted
+	PredictiveOnly PredictiveMode = "predictive"
+	// PredictiveBlended takes the larger of the predictive and reactive recommendations
+	PredictiveBlended PredictiveMode = "blended"
+)
+
+// HoltWintersParams are the parameters of an additive Holt-Winters model
+type HoltWintersParams struct {
+	// Step is the interval the history is downsampled to; defaults to 5m
+	Step time.Duration
+	// Season is the length of one seasonal cycle; defaults to 24h
+	Season time.Duration
+	// Smoothing factors of the level, trend and seasonal components
+	Alpha float64
+	Beta  float64
+	Gamma float64
+	// Z scales the residual deviation into the confidence bounds; defaults to 1.96 (95%)
+	Z float64
+	// MinSeasons is the number of full seasons required to fit a model; defaults to 2
+	MinSeasons int
+}
+
+// ForecasterConfig configures the Holt-Winters forecaster. Zero values select the defaults.
+type ForecasterConfig struct {
+	HoltWintersParams
+	// Metric is the usage series to forecast; defaults to cpu_percentage
+	Metric string
+	// History is the training window; defaults to 7 days
+	History time.Duration
+	// Lead is how far ahead of now the forecast is made, e.g. the time pods take to
+	// become ready; defaults to 10m
+	Lead time.Duration
+	// UseUpperBound scales on the upper confidence bound instead of the point forecast
+	UseUpperBound bool
+}
+
+// Forecast is a predicted value with its confidence bounds
+type Forecast struct {
+	Timestamp time.Time
+	Value     float64
+	Lower     float64
+	Upper     float64
+}
+
+// HoltWinters is a fitted additive Holt-Winters model
+type HoltWinters struct {
+	alpha, beta, gamma float64
+	z                  float64
+	step               time.Duration
+	level              float64
+	trend              float64
+	seasonal           []float64
+	observed           int // Number of steps the model has been updated with
+	last               time.Time
+	rmse               float64
+}
+
+// FitHoltWinters fits an additive model to samples spaced one step apart.
+// Missing steps are filled with the model's own prediction.
+func FitHoltWinters(samples []Sample, params HoltWintersParams) (*HoltWinters, error) {
+	step, season := params.Step, params.Season
+	alpha, beta, gamma := params.Alpha, params.Beta, params.Gamma
+	if step <= 0 || season < step {
+		return nil, fmt.Errorf("season %s must be at least one step of %s", season, step)
+	}
+	m := int(season / step)
+	minSeasons := max(params.MinSeasons, 2)
+	if len(samples) == 0 {
+		return nil, ErrInsufficientHistory
+	}
+	start := samples[0].Timestamp
+	if steps := int(samples[len(samples)-1].Timestamp.Sub(start)/step) + 1; steps < m*minSeasons || len(samples) < m*minSeasons/2 {
+		return nil, ErrInsufficientHistory
+	}
+
+	// Place the samples on a regular grid so that gaps keep the seasonal phase
+	grid := make([]float64, int(samples[len(samples)-1].Timestamp.Sub(start)/step)+1)
+	present := make([]bool, len(grid))
+	for _, sample := range samples {
+		i := int(sample.Timestamp.Sub(start) / step)
+		grid[i], present[i] = sample.Value, true
+	}
+
+	// Initialize from the first two seasons
+	first, second := seasonMean(grid[:m], present[:m]), seasonMean(grid[m:2*m], present[m:2*m])
+	model := &HoltWinters{
+		alpha:    alpha,
+		beta:     beta,
+		gamma:    gamma,
+		z:        params.Z,
+		step:     step,
+		level:    first,
+		trend:    (second - first) / float64(m),
+		seasonal: make([]float64, m),
+	}
+	for i := 0; i < m; i++ {
+		if present[i] {
+			model.seasonal[i] = grid[i] - first
+		}
+	}
+
+	var sse float64
+	var errs int
+	for i, value := range grid {
+		slot := i % m
+		predicted := model.level + model.trend + model.seasonal[slot]
+		if !present[i] {
+			value = predicted
+		} else if i >= m {
+			sse += (value - predicted) * (value - predicted)
+			errs++
+		}
+
+		lastLevel := model.level
+		model.level = alpha*(value-model.seasonal[slot]) + (1-alpha)*(model.level+model.trend)
+		model.trend = beta*(model.level-lastLevel) + (1-beta)*model.trend
+		model.seasonal[slot] = gamma*(value-model.level) + (1-gamma)*model.seasonal[slot]
+	}
+	model.observed = len(grid)
+	model.last = start.Add(time.Duration(len(grid)-1) * step)
+	if errs > 0 {
+		model.rmse = math.Sqrt(sse / float64(errs))
+	}
+	return model, nil
+}
+
+func seasonMean(values []float64, present []bool) float64 {
+	var sum float64
+	var n int
+	for i, value := range values {
+		if present[i] {
+			sum += value
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// Forecast predicts the value at t, rounded to the nearest step after the last
+// observation. The bounds widen with the horizon like those of simple exponential smoothing.
+func (hw *HoltWinters) Forecast(t time.Time) Forecast {
+	h := int(math.Round(float64(t.Sub(hw.last)) / float64(hw.step)))
+	if h < 1 {
+		h = 1
+	}
+	slot := (hw.observed - 1 + h) % len(hw.seasonal)
+	value := hw.level + float64(h)*hw.trend + hw.seasonal[slot]
+	margin := hw.z * hw.rmse * math.Sqrt(1+float64(h-1)*hw.alpha*hw.alpha)
+	return Forecast{
+		Timestamp: hw.last.Add(time.Duration(h) * hw.step),
+		Value:     value,
+		Lower:     value - margin,
+		Upper:     value + margin,
+	}
+}
+
+// Forecaster fits Holt-Winters models on the history kept by a TimeSeriesStore.
+// Fitted models are cached per series and refitted at most once per Step, since the
+// downsampled history does not change in between.
+type Forecaster struct {
+	store  *TimeSeriesStore
+	config ForecasterConfig
+	models map[SeriesKey]fittedModel
+	mu     sync.Mutex
+}
+
+// fittedModel is the outcome of fitting a series, kept until the next step
+type fittedModel struct {
+	model    *HoltWinters
+	err      error
+	fittedAt time.Time
+}
+
+func NewForecaster(store *TimeSeriesStore, config ForecasterConfig) (*Forecaster, error) {
+	if config.Metric == "" {
+		config.Metric = MetricCPUPercentage
+	}
+	if config.Step == 0 {
+		config.Step = 5 * time.Minute
+	}
+	if config.Season == 0 {
+		config.Season = 24 * time.Hour
+	}
+	if config.History == 0 {
+		config.History = 7 * 24 * time.Hour
+	}
+	if config.Lead == 0 {
+		config.Lead = 10 * time.Minute
+	}
+	if config.Alpha == 0 {
+		config.Alpha = 0.3
+	}
+	if config.Beta == 0 {
+		config.Beta = 0.01
+	}
+	if config.Gamma == 0 {
+		config.Gamma = 0.3
+	}
+	if config.Z == 0 {
+		config.Z = 1.96
+	}
+	if config.MinSeasons == 0 {
+		config.MinSeasons = 2
+	}
+
+	for name, factor := range map[string]float64{"alpha": config.Alpha, "beta": config.Beta, "gamma": config.Gamma} {
+		if factor <= 0 || factor > 1 {
+			return nil, fmt.Errorf("%s must be in (0, 1], got %v", name, factor)
+		}
+	}
+	if config.Step < 0 || config.Season%config.Step != 0 {
+		return nil, fmt.Errorf("season %s must be a multiple of the step %s", config.Season, config.Step)
+	}
+	if config.MinSeasons < 2 {
+		return nil, fmt.Errorf("at least two seasons are required to fit a model")
+	}
+	if config.History < time.Duration(config.MinSeasons)*config.Season {
+		return nil, fmt.Errorf("history %s is shorter than %d seasons", config.History, config.MinSeasons)
+	}
+
+	return &Forecaster{store: store, config: config, models: make(map[SeriesKey]fittedModel)}, nil
+}
+
+// Predict forecasts the value of the series Lead after now, from a model fitted on
+// its history before now. The metric of key is replaced by the configured metric.
+func (f *Forecaster) Predict(key SeriesKey, now time.Time) (Forecast, error) {
+	key.Metric = f.config.Metric
+	fitted := f.fit(key, now)
+	if fitted.err != nil {
+		return Forecast{}, fitted.err
+	}
+	return fitted.model.Forecast(now.Add(f.config.Lead)), nil
+}
+
+// fit returns the cached model of the series, refitting it if it is a step old.
+// Failures are cached too, so a series without enough history is not queried on
+// every report either.
+func (f *Forecaster) fit(key SeriesKey, now time.Time) fittedModel {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cached, ok := f.models[key]; ok && !now.Before(cached.fittedAt) && now.Sub(cached.fittedAt) < f.config.Step {
+		return cached
+	}
+
+	fitted := fittedModel{fittedAt: now}
+	history, err := f.store.QueryRange(key, now.Add(-f.config.History), now, f.config.Step, AggregationAvg)
+	if err == nil {
+		fitted.model, err = FitHoltWinters(history, f.config.HoltWintersParams)
+	}
+	fitted.err = err
+	f.models[key] = fitted
+	return fitted
+}
@@ -0,0 +1,183 @@
+package integration
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// diurnalCPU is a synthetic daily load with a ramp from 06:00 to a peak of 65% at noon
+func diurnalCPU(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	load := 35.0
+	if hour >= 6 && hour <= 18 {
+		load += 30 * math.Sin(math.Pi*(hour-6)/12)
+	}
+	return load
+}
+
+// diurnalSamples returns 5m samples of diurnalCPU with a little deterministic noise
+func diurnalSamples(start, end time.Time) []Sample {
+	var samples []Sample
+	for i, ts := 0, start; !ts.After(end); i, ts = i+1, ts.Add(5*time.Minute) {
+		samples = append(samples, Sample{Timestamp: ts, Value: diurnalCPU(ts) + math.Sin(float64(i)*1.7)})
+	}
+	return samples
+}
+
+// TestHoltWintersDiurnalForecast tests the seasonal forecast and its confidence bounds on a synthetic series
+func TestHoltWintersDiurnalForecast(t *testing.T) {
+	day := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+	params := HoltWintersParams{Step: 5 * time.Minute, Season: 24 * time.Hour, Alpha: 0.3, Beta: 0.01, Gamma: 0.3, Z: 1.96, MinSeasons: 2}
+
+	_, err := FitHoltWinters(diurnalSamples(day, day.Add(30*time.Hour)), params)
+	require.ErrorIs(t, err, ErrInsufficientHistory)
+
+	model, err := FitHoltWinters(diurnalSamples(day, day.Add(55*time.Hour)), params)
+	require.NoError(t, err)
+
+	// From 07:00 on the third day, predict the ramp two hours ahead
+	ahead := model.Forecast(day.Add(57 * time.Hour))
+	require.Equal(t, day.Add(57*time.Hour), ahead.Timestamp)
+	truth := diurnalCPU(ahead.Timestamp)
+	require.InDelta(t, truth, ahead.Value, 3)
+	require.Less(t, ahead.Lower, truth)
+	require.Greater(t, ahead.Upper, truth)
+	require.Greater(t, ahead.Value, diurnalCPU(day.Add(55*time.Hour))+10, "the forecast anticipates the ramp")
+
+	next := model.Forecast(day.Add(55*time.Hour + 5*time.Minute))
+	require.Less(t, next.Upper-next.Lower, ahead.Upper-ahead.Lower, "bounds widen with the horizon")
+
+	// Gaps in the history keep the seasonal phase
+	samples := diurnalSamples(day, day.Add(55*time.Hour))
+	gappy := append(append([]Sample(nil), samples[:400]...), samples[430:]...)
+	model, err = FitHoltWinters(gappy, params)
+	require.NoError(t, err)
+	require.InDelta(t, truth, model.Forecast(day.Add(57*time.Hour)).Value, 3)
+
+	_, err = NewForecaster(NewTimeSeriesStore(0), ForecasterConfig{History: 24 * time.Hour})
+	require.Error(t, err)
+	_, err = NewForecaster(NewTimeSeriesStore(0), ForecasterConfig{HoltWintersParams: HoltWintersParams{Alpha: 1.5}})
+	require.Error(t, err)
+}
+
+// TestForecasterRefitsOncePerStep tests that a fitted model is reused until the next step
+func TestForecasterRefitsOncePerStep(t *testing.T) {
+	day := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+	key := SeriesKey{ClusterID: "cluster-1", Namespace: "production", Workload: "webapp-frontend", Metric: MetricCPUPercentage}
+	store := NewTimeSeriesStore(0)
+	forecaster, err := NewForecaster(store, ForecasterConfig{})
+	require.NoError(t, err)
+
+	// Without enough history no model can be fitted
+	now := day.Add(24 * time.Hour)
+	for _, sample := range diurnalSamples(day, now) {
+		store.Append(key, sample)
+	}
+	_, err = forecaster.Predict(key, now)
+	require.ErrorIs(t, err, ErrInsufficientHistory)
+
+	now = day.Add(55 * time.Hour)
+	for _, sample := range diurnalSamples(day.Add(24*time.Hour+5*time.Minute), now) {
+		store.Append(key, sample)
+	}
+	fitted, err := forecaster.Predict(key, now)
+	require.NoError(t, err)
+
+	// A sample within the step does not refit the model
+	store.Append(key, Sample{Timestamp: now.Add(time.Minute), Value: 100})
+	cached, err := forecaster.Predict(key, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, fitted, cached)
+
+	store.Append(key, Sample{Timestamp: now.Add(5 * time.Minute), Value: 100})
+	refitted, err := forecaster.Predict(key, now.Add(5*time.Minute))
+	require.NoError(t, err)
+	require.Greater(t, refitted.Value, fitted.Value+5)
+}
+
+// TestPredictiveScalingAheadOfMorningRamp tests predictive and blended intents from stored CPU history
+func TestPredictiveScalingAheadOfMorningRamp(t *testing.T) {
+	day := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+	key := SeriesKey{ClusterID: "cluster-1", Namespace: "production", Workload: "webapp-frontend", Metric: MetricCPUPercentage}
+
+	run := func(mode PredictiveMode, now time.Time) []*agentv1.ScalingIntent {
+		store := NewTimeSeriesStore(0)
+		for _, sample := range diurnalSamples(day, now.Add(-5*time.Minute)) {
+			store.Append(key, sample)
+		}
+
+		forecaster, err := NewForecaster(store, ForecasterConfig{Lead: 2 * time.Hour})
+		require.NoError(t, err)
+		recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 50})
+		require.NoError(t, err)
+		require.NoError(t, recommender.SetForecaster(forecaster, mode))
+
+		handler := NewMockScalingIntentHandler()
+		recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+			handler.HandleScalingIntent(intent)
+		}))
+
+		metricsService := NewMockMetricsService()
+		metricsService.AddMetricsSink(store)
+		metricsService.AddMetricsSink(recommender)
+
+		report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(now)
+		frontend := report.WorkloadMetrics[5]
+		frontend.Replicas, frontend.AvailableReplicas = 4, 4
+		frontend.Usage.CpuPercentage = diurnalCPU(now)
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+
+		var intents []*agentv1.ScalingIntent
+		for _, intent := range handler.GetReceivedIntents() {
+			if intent.WorkloadName == "webapp-frontend" {
+				intents = append(intents, intent)
+			}
+		}
+		return intents
+	}
+
+	// At 07:00 the observed 42.8% keeps 4 replicas, but the ramp to 56% at 09:00 needs 5
+	morning := day.Add(55 * time.Hour)
+	intents := run(PredictiveBlended, morning)
+	require.Len(t, intents, 1)
+	require.Equal(t, int32(5), intents[0].TargetReplicas)
+	require.Contains(t, intents[0].Reason, "cpu_percentage forecast at")
+	require.Contains(t, intents[0].Reason, "in 2h0m0s")
+
+	// At 16:00 the load is on target and falls to 35% by 18:00. Only the
+	// predictive mode scales down ahead of it; blended keeps the reactive 4.
+	afternoon := day.Add(64 * time.Hour)
+	intents = run(PredictiveOnly, afternoon)
+	require.Len(t, intents, 1)
+	require.Equal(t, int32(3), intents[0].TargetReplicas)
+	require.Empty(t, run(PredictiveBlended, afternoon))
+
+	// Workloads without history fall back to reactive recommendations
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetMemoryPercentage: 80})
+	require.NoError(t, err)
+	forecaster, err := NewForecaster(NewTimeSeriesStore(0), ForecasterConfig{})
+	require.NoError(t, err)
+	require.Error(t, recommender.SetForecaster(forecaster, PredictiveBlended), "cpu has no utilization target")
+	forecaster, err = NewForecaster(NewTimeSeriesStore(0), ForecasterConfig{Metric: MetricMemoryPercentage})
+	require.NoError(t, err)
+	require.Error(t, recommender.SetForecaster(forecaster, "sometimes"))
+	require.NoError(t, recommender.SetForecaster(forecaster, PredictiveOnly))
+
+	var emitted []*agentv1.ScalingIntent
+	recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		emitted = append(emitted, intent)
+	}))
+	recommender.Ingest("", NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15))
+	require.Len(t, emitted, 3)
+	for _, intent := range emitted {
+		require.NotContains(t, intent.Reason, "forecast")
+	}
+}
@@ -0,0 +1,414 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+var (
+	// ErrUnknownFreeze is returned for freeze IDs the guard does not know
+	ErrUnknownFreeze = errors.New("unknown freeze")
+	// ErrFreezeEnded is returned when lifting a freeze that was lifted or has ended
+	ErrFreezeEnded = errors.New("freeze already ended")
+	// ErrScalingFrozen is returned by the executor for intents of frozen workloads
+	ErrScalingFrozen = errors.New("scaling is frozen")
+)
+
+// ScalingFreeze stops all automated scaling in its scope. A freeze without End
+// lasts until it is lifted; one with End is a window that also ends by itself.
+type ScalingFreeze struct {
+	ID        string      `json:"id"`
+	Scope     PolicyScope `json:"scope"`
+	Reason    string      `json:"reason"`
+	Actor     string      `json:"actor"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Start     time.Time   `json:"start"`
+	End       time.Time   `json:"end"`
+	LiftedAt  time.Time   `json:"liftedAt"`
+	LiftedBy  string      `json:"liftedBy,omitempty"`
+}
+
+// Active reports whether the freeze applies at now
+func (f ScalingFreeze) Active(now time.Time) bool {
+	return !now.Before(f.Start) && !f.ended(now)
+}
+
+// ended reports whether the freeze was lifted or its window is over at now
+func (f ScalingFreeze) ended(now time.Time) bool {
+	return !f.LiftedAt.IsZero() || (!f.End.IsZero() && !now.Before(f.End))
+}
+
+func (f ScalingFreeze) String() string {
+	s := f.ID
+	if !f.End.IsZero() {
+		s += " until " + f.End.Format(time.RFC3339)
+	}
+	if f.Reason != "" {
+		s += ": " + f.Reason
+	}
+	return s
+}
+
+// FreezeSink relays the freezes of a cluster to its agent. Every call carries all
+// freezes of the cluster that have not ended yet, including windows yet to start.
+type FreezeSink interface {
+	SendFreezes(clusterID string, freezes []ScalingFreeze)
+}
+
+// FreezeGuard sits in front of the agents and drops every intent for a workload
+// under an active freeze. Freezes are relayed to the agents of the clusters the
+// guard has seen reports from, so they also refuse intents sent before a freeze.
+type FreezeGuard struct {
+	next           ScalingIntentSink
+	freezes        map[string]*ScalingFreeze
+	order          []string
+	clusters       map[clusterKey]bool
+	sinks          []FreezeSink
+	sequence       int
+	tenantResolver func(clusterID string) string
+	audit          AuditRecorder
+	now            func() time.Time
+	mu             sync.RWMutex
+}
+
+func NewFreezeGuard(next ScalingIntentSink) *FreezeGuard {
+	return &FreezeGuard{
+		next:     next,
+		freezes:  make(map[string]*ScalingFreeze),
+		clusters: make(map[clusterKey]bool),
+		now:      time.Now,
+	}
+}
+
+// SetClock replaces the time source, for tests
+func (g *FreezeGuard) SetClock(now func() time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.now = now
+}
+
+// SetTenantResolver sets the tenant whose freezes apply to the intents of a cluster
+func (g *FreezeGuard) SetTenantResolver(resolver func(clusterID string) string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.tenantResolver = resolver
+}
+
+// SetAuditRecorder records every freeze, lift and dropped intent
+func (g *FreezeGuard) SetAuditRecorder(recorder AuditRecorder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.audit = recorder
+}
+
+// AddFreezeSink relays freeze changes to the agents through sink
+func (g *FreezeGuard) AddFreezeSink(sink FreezeSink) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sinks = append(g.sinks, sink)
+}
+
+// Ingest registers the reporting cluster and relays its freezes with every
+// report, so agents that connect or restart during a freeze learn about it.
+// Every relay carries the whole list, so repeating it changes nothing.
+func (g *FreezeGuard) Ingest(tenantID string, report *agent.MetricsReport) {
+	key := clusterKey{tenantID: tenantID, clusterID: report.ClusterId}
+	g.mu.Lock()
+	g.clusters[key] = true
+	updates := g.updates(func(k clusterKey) bool { return k == key })
+	g.mu.Unlock()
+	g.relay(updates)
+}
+
+// Freeze starts a freeze. A zero Start freezes immediately.
+func (g *FreezeGuard) Freeze(freeze ScalingFreeze) (ScalingFreeze, error) {
+	if freeze.Actor == "" {
+		return ScalingFreeze{}, fmt.Errorf("actor is required")
+	}
+	if freeze.Reason == "" {
+		return ScalingFreeze{}, fmt.Errorf("reason is required")
+	}
+
+	g.mu.Lock()
+	now := g.now()
+	if freeze.Start.IsZero() {
+		freeze.Start = now
+	}
+	if !freeze.End.IsZero() && !freeze.End.After(freeze.Start) {
+		g.mu.Unlock()
+		return ScalingFreeze{}, fmt.Errorf("freeze end %s is not after its start %s", freeze.End.Format(time.RFC3339), freeze.Start.Format(time.RFC3339))
+	}
+	if !freeze.End.IsZero() && !freeze.End.After(now) {
+		g.mu.Unlock()
+		return ScalingFreeze{}, fmt.Errorf("freeze end %s is in the past", freeze.End.Format(time.RFC3339))
+	}
+	g.sequence++
+	freeze.ID = fmt.Sprintf("freeze-%d", g.sequence)
+	freeze.CreatedAt = now
+	freeze.LiftedAt, freeze.LiftedBy = time.Time{}, ""
+	g.freezes[freeze.ID] = &freeze
+	g.order = append(g.order, freeze.ID)
+	if g.audit != nil {
+		g.audit.Record(freeze.auditEntry("frozen", freeze.Actor, now))
+	}
+	updates := g.updates(func(k clusterKey) bool { return freeze.covers(k) })
+	g.mu.Unlock()
+
+	g.relay(updates)
+	return freeze, nil
+}
+
+// Lift ends a freeze before its window ends
+func (g *FreezeGuard) Lift(id, actor string) (ScalingFreeze, error) {
+	if actor == "" {
+		return ScalingFreeze{}, fmt.Errorf("actor is required")
+	}
+	g.mu.Lock()
+	freeze, exists := g.freezes[id]
+	if !exists {
+		g.mu.Unlock()
+		return ScalingFreeze{}, fmt.Errorf("%w: %s", ErrUnknownFreeze, id)
+	}
+	now := g.now()
+	if freeze.ended(now) {
+		g.mu.Unlock()
+		return ScalingFreeze{}, fmt.Errorf("%w: %s", ErrFreezeEnded, id)
+	}
+	freeze.LiftedAt = now
+	freeze.LiftedBy = actor
+	if g.audit != nil {
+		g.audit.Record(freeze.auditEntry("lifted", actor, now))
+	}
+	lifted := *freeze
+	updates := g.updates(func(k clusterKey) bool { return lifted.covers(k) })
+	g.mu.Unlock()
+
+	g.relay(updates)
+	return lifted, nil
+}
+
+// Get returns a freeze by ID
+func (g *FreezeGuard) Get(id string) (ScalingFreeze, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	freeze, exists := g.freezes[id]
+	if !exists {
+		return ScalingFreeze{}, false
+	}
+	return *freeze, true
+}
+
+// Active returns the freezes in effect for a tenant, including those of all
+// tenants, oldest first. An empty tenant matches all.
+func (g *FreezeGuard) Active(tenantID string) []ScalingFreeze {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	now := g.now()
+	freezes := []ScalingFreeze{}
+	for _, id := range g.order {
+		freeze := g.freezes[id]
+		if freeze.Active(now) && (tenantID == "" || freeze.Scope.TenantID == "" || freeze.Scope.TenantID == tenantID) {
+			freezes = append(freezes, *freeze)
+		}
+	}
+	return freezes
+}
+
+// Frozen returns the active freeze covering a workload, if any
+func (g *FreezeGuard) Frozen(key WorkloadKey) (ScalingFreeze, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.frozen(key, g.now())
+}
+
+// frozen must be called with g.mu held
+func (g *FreezeGuard) frozen(key WorkloadKey, now time.Time) (ScalingFreeze, bool) {
+	for _, id := range g.order {
+		if freeze := g.freezes[id]; freeze.Active(now) && freeze.Scope.matches(key) {
+			return *freeze, true
+		}
+	}
+	return ScalingFreeze{}, false
+}
+
+// SendScalingIntent forwards the intent unless its workload is frozen
+func (g *FreezeGuard) SendScalingIntent(clusterID string, intent *agent.ScalingIntent) {
+	g.mu.Lock()
+	tenantID := ""
+	if g.tenantResolver != nil {
+		tenantID = g.tenantResolver(clusterID)
+	}
+	key := WorkloadKey{TenantID: tenantID, ClusterID: clusterID, Namespace: intent.WorkloadNamespace, Workload: intent.WorkloadName}
+	now := g.now()
+	freeze, frozen := g.frozen(key, now)
+	if frozen && g.audit != nil {
+		entry := freeze.auditEntry("blocked", "freeze-guard", now)
+		entry.TenantID, entry.ClusterID, entry.Namespace, entry.Workload = tenantID, clusterID, intent.WorkloadNamespace, intent.WorkloadName
+		entry.IntentID = intent.IntentId
+		entry.TargetReplicas = intent.TargetReplicas
+		g.audit.Record(entry)
+	}
+	g.mu.Unlock()
+
+	if !frozen {
+		g.next.SendScalingIntent(clusterID, intent)
+	}
+}
+
+// covers reports whether the freeze can apply to workloads of the cluster
+func (f ScalingFreeze) covers(key clusterKey) bool {
+	return (f.Scope.TenantID == "" || f.Scope.TenantID == key.tenantID) &&
+		(f.Scope.ClusterID == "" || f.Scope.ClusterID == key.clusterID)
+}
+
+// updates must be called with g.mu held. It returns the freezes that have not
+// ended for every known cluster selected by include.
+func (g *FreezeGuard) updates(include func(clusterKey) bool) map[clusterKey][]ScalingFreeze {
+	now := g.now()
+	updates := make(map[clusterKey][]ScalingFreeze)
+	for key := range g.clusters {
+		if !include(key) {
+			continue
+		}
+		freezes := []ScalingFreeze{}
+		for _, id := range g.order {
+			if freeze := g.freezes[id]; !freeze.ended(now) && freeze.covers(key) {
+				freezes = append(freezes, *freeze)
+			}
+		}
+		updates[key] = freezes
+	}
+	return updates
+}
+
+// relay sends the updates without holding the lock, in a stable cluster order
+func (g *FreezeGuard) relay(updates map[clusterKey][]ScalingFreeze) {
+	g.mu.RLock()
+	sinks := append([]FreezeSink(nil), g.sinks...)
+	g.mu.RUnlock()
+
+	keys := make([]clusterKey, 0, len(updates))
+	for key := range updates {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].tenantID != keys[j].tenantID {
+			return keys[i].tenantID < keys[j].tenantID
+		}
+		return keys[i].clusterID < keys[j].clusterID
+	})
+	for _, key := range keys {
+		for _, sink := range sinks {
+			sink.SendFreezes(key.clusterID, updates[key])
+		}
+	}
+}
+
+// freezeRequest is the body of a freeze request. Without start the freeze begins
+// immediately; duration is an alternative to end.
+type freezeRequest struct {
+	TenantID  string    `json:"tenant"`
+	ClusterID string    `json:"cluster"`
+	Namespace string    `json:"namespace"`
+	Workload  string    `json:"workload"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Duration  string    `json:"duration"`
+}
+
+// freezeLift is the body of a lift request
+type freezeLift struct {
+	Actor string `json:"actor"`
+}
+
+// ServeHTTP serves the freeze API when mounted on /api/v1/freezes and /api/v1/freezes/:
+//
+//	GET  /api/v1/freezes?tenant=tenant-1
+//	POST /api/v1/freezes                 {"tenant": "tenant-1", "namespace": "production", "reason": "INC-1234", "actor": "alice", "duration": "2h"}
+//	GET  /api/v1/freezes/{id}
+//	POST /api/v1/freezes/{id}/lift       {"actor": "alice"}
+func (g *FreezeGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/freezes"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, g.Active(r.URL.Query().Get("tenant")))
+
+	case path == "" && r.Method == http.MethodPost:
+		var request freezeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid body: %v", err)})
+			return
+		}
+		freeze := ScalingFreeze{
+			Scope:  PolicyScope{TenantID: request.TenantID, ClusterID: request.ClusterID, Namespace: request.Namespace, Workload: request.Workload},
+			Reason: request.Reason,
+			Actor:  request.Actor,
+			Start:  request.Start,
+			End:    request.End,
+		}
+		if request.Duration != "" {
+			duration, err := time.ParseDuration(request.Duration)
+			if err != nil || duration <= 0 || !request.End.IsZero() {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid duration %q", request.Duration)})
+				return
+			}
+			start := freeze.Start
+			if start.IsZero() {
+				g.mu.RLock()
+				start = g.now()
+				g.mu.RUnlock()
+				freeze.Start = start
+			}
+			freeze.End = start.Add(duration)
+		}
+		created, err := g.Freeze(freeze)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		freeze, exists := g.Get(parts[0])
+		if !exists {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s: %s", ErrUnknownFreeze, parts[0])})
+			return
+		}
+		writeJSON(w, http.StatusOK, freeze)
+
+	case len(parts) == 2 && r.Method == http.MethodPost && parts[1] == "lift":
+		var lift freezeLift
+		if err := json.NewDecoder(r.Body).Decode(&lift); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid body: %v", err)})
+			return
+		}
+		lifted, err := g.Lift(parts[0], lift.Actor)
+		switch {
+		case errors.Is(err, ErrUnknownFreeze):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case errors.Is(err, ErrFreezeEnded):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, lifted)
+		}
+
+	case path == "" || len(parts) == 1 || len(parts) == 2 && parts[1] == "lift":
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
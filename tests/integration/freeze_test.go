@@ -0,0 +1,308 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// TestFreezeGuard tests dropping intents under immediate and windowed freezes at every scope level
+func TestFreezeGuard(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	var sent []string
+	guard := NewFreezeGuard(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		sent = append(sent, intent.IntentId)
+	}))
+	guard.SetClock(clock.Now)
+	guard.SetTenantResolver(func(clusterID string) string {
+		if clusterID == "cluster-2" {
+			return "tenant-2"
+		}
+		return "tenant-1"
+	})
+	audit := NewAuditLog(0)
+	guard.SetAuditRecorder(audit)
+
+	send := func(id, clusterID, namespace, workload string) {
+		guard.SendScalingIntent(clusterID, approvalIntent(id, namespace, workload, 3))
+	}
+
+	// An immediate namespace freeze lasts until it is lifted
+	incident, err := guard.Freeze(ScalingFreeze{Scope: PolicyScope{TenantID: "tenant-1", Namespace: "production"}, Reason: "INC-1234", Actor: "alice"})
+	require.NoError(t, err)
+	require.Equal(t, clock.Now(), incident.Start)
+	send("frozen", "cluster-1", "production", "api")
+	send("other-namespace", "cluster-1", "staging", "api")
+	send("other-tenant", "cluster-2", "production", "api")
+	require.Equal(t, []string{"other-namespace", "other-tenant"}, sent)
+
+	frozen, ok := guard.Frozen(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "postgres"})
+	require.True(t, ok)
+	require.Equal(t, incident.ID, frozen.ID)
+
+	// A release window on one workload only applies between its start and end
+	release, err := guard.Freeze(ScalingFreeze{
+		Scope:  PolicyScope{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "staging", Workload: "api"},
+		Reason: "release 2.4",
+		Actor:  "bob",
+		Start:  clock.Now().Add(time.Hour),
+		End:    clock.Now().Add(3 * time.Hour),
+	})
+	require.NoError(t, err)
+	require.Len(t, guard.Active("tenant-1"), 1)
+	send("before-window", "cluster-1", "staging", "api")
+	clock.Advance(2 * time.Hour)
+	send("in-window", "cluster-1", "staging", "api")
+	send("other-workload", "cluster-1", "staging", "worker")
+	require.Len(t, guard.Active("tenant-1"), 2)
+	require.Len(t, guard.Active(""), 2)
+	require.Empty(t, guard.Active("tenant-2"))
+	clock.Advance(time.Hour)
+	send("after-window", "cluster-1", "staging", "api")
+	require.Equal(t, []string{"other-namespace", "other-tenant", "before-window", "other-workload", "after-window"}, sent)
+
+	_, err = guard.Lift(release.ID, "bob")
+	require.ErrorIs(t, err, ErrFreezeEnded, "the window already ended")
+	lifted, err := guard.Lift(incident.ID, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "alice", lifted.LiftedBy)
+	require.Equal(t, clock.Now(), lifted.LiftedAt)
+	send("lifted", "cluster-1", "production", "api")
+	require.Equal(t, "lifted", sent[len(sent)-1])
+	_, err = guard.Lift("freeze-99", "alice")
+	require.ErrorIs(t, err, ErrUnknownFreeze)
+
+	// A freeze without a tenant stops scaling everywhere
+	everything, err := guard.Freeze(ScalingFreeze{Reason: "control plane upgrade", Actor: "carol"})
+	require.NoError(t, err)
+	require.Len(t, guard.Active("tenant-2"), 1)
+	send("global-1", "cluster-1", "kube-system", "coredns")
+	send("global-2", "cluster-2", "production", "api")
+	require.Equal(t, "lifted", sent[len(sent)-1])
+	_, err = guard.Lift(everything.ID, "carol")
+	require.NoError(t, err)
+
+	// Dropped intents are audited with the freeze that dropped them
+	blocked := audit.Query(AuditQuery{Types: []AuditEventType{AuditFreeze}, IntentID: "in-window"})
+	require.Len(t, blocked, 1)
+	require.Equal(t, "blocked", blocked[0].State)
+	require.Equal(t, "api", blocked[0].Workload)
+	require.Contains(t, blocked[0].Detail, "release 2.4")
+	require.Len(t, audit.Query(AuditQuery{Types: []AuditEventType{AuditFreeze}}), 9)
+
+	for _, invalid := range []ScalingFreeze{
+		{Reason: "no actor"},
+		{Actor: "alice"},
+		{Actor: "alice", Reason: "backwards", Start: clock.Now(), End: clock.Now().Add(-time.Minute)},
+		{Actor: "alice", Reason: "past", Start: clock.Now().Add(-2 * time.Hour), End: clock.Now().Add(-time.Hour)},
+	} {
+		_, err := guard.Freeze(invalid)
+		require.Error(t, err, invalid.Reason)
+	}
+}
+
+// TestFreezeRelayedToAgent tests that the executor refuses stale intents and stops rollouts under a relayed freeze
+func TestFreezeRelayedToAgent(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	client := newScaleClientset(testDeployment("production", "webapp-frontend", 3))
+	executor := NewScalingExecutor(client, ExecutorConfig{ClusterID: "cluster-1"})
+	executor.SetClock(clock.Now)
+
+	guard := NewFreezeGuard(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {}))
+	guard.SetClock(clock.Now)
+	guard.AddFreezeSink(executor)
+
+	// Freezes set before the agent reports are relayed with its first report
+	incident, err := guard.Freeze(ScalingFreeze{Scope: PolicyScope{TenantID: "tenant-1", Namespace: "production"}, Reason: "INC-1234", Actor: "alice"})
+	require.NoError(t, err)
+	guard.Ingest("tenant-1", NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15))
+
+	// An intent sent before the freeze is refused by the agent
+	stale := &agentv1.ScalingIntent{
+		IntentId:          "stale",
+		WorkloadNamespace: "production",
+		WorkloadName:      "webapp-frontend",
+		WorkloadType:      "deployment",
+		TargetReplicas:    6,
+		Strategy:          &agentv1.ScalingStrategy{MaxSurge: 1},
+	}
+	_, err = executor.Execute(context.Background(), stale)
+	require.ErrorIs(t, err, ErrScalingFrozen)
+	require.ErrorContains(t, err, "INC-1234")
+	require.Empty(t, scaleUpdates(client))
+
+	// Freezes of other clusters and tenants are not relayed to this agent
+	_, err = guard.Freeze(ScalingFreeze{Scope: PolicyScope{TenantID: "tenant-1", ClusterID: "cluster-2"}, Reason: "cluster-2 only", Actor: "alice"})
+	require.NoError(t, err)
+	_, err = guard.Freeze(ScalingFreeze{Scope: PolicyScope{TenantID: "tenant-2"}, Reason: "tenant-2 only", Actor: "alice"})
+	require.NoError(t, err)
+	_, err = guard.Lift(incident.ID, "alice")
+	require.NoError(t, err)
+
+	// A freeze set during a stepped rollout stops the remaining steps
+	executor.SetSleep(func(ctx context.Context, d time.Duration) error {
+		if len(scaleUpdates(client)) == 2 {
+			_, err := guard.Freeze(ScalingFreeze{Scope: PolicyScope{Workload: "webapp-frontend"}, Reason: "rollback in progress", Actor: "bob"})
+			require.NoError(t, err)
+		}
+		return nil
+	})
+	result, err := executor.Execute(context.Background(), stale)
+	require.ErrorIs(t, err, ErrScalingFrozen)
+	require.Equal(t, []int32{4, 5}, result.Steps)
+	require.Equal(t, int32(5), result.ToReplicas)
+
+	// A freeze window is enforced by the agent clock once relayed
+	for _, freeze := range guard.Active("tenant-1") {
+		_, err := guard.Lift(freeze.ID, "bob")
+		require.NoError(t, err)
+	}
+	_, err = guard.Freeze(ScalingFreeze{Reason: "maintenance", Actor: "bob", Start: clock.Now().Add(time.Hour), End: clock.Now().Add(2 * time.Hour)})
+	require.NoError(t, err)
+	clock.Advance(90 * time.Minute)
+	_, err = executor.Execute(context.Background(), stale)
+	require.ErrorIs(t, err, ErrScalingFrozen)
+	clock.Advance(time.Hour)
+	result, err = executor.Execute(context.Background(), stale)
+	require.NoError(t, err)
+	require.Equal(t, int32(6), result.ToReplicas)
+}
+
+type freezeSinkFunc func(clusterID string, freezes []ScalingFreeze)
+
+func (f freezeSinkFunc) SendFreezes(clusterID string, freezes []ScalingFreeze) {
+	f(clusterID, freezes)
+}
+
+// TestFreezeRelayedAfterAgentRestart tests that every report relays the freezes again, so a restarted agent learns about them
+func TestFreezeRelayedAfterAgentRestart(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	client := newScaleClientset(testDeployment("production", "webapp-frontend", 3))
+	executor := NewScalingExecutor(client, ExecutorConfig{ClusterID: "cluster-1"})
+	executor.SetClock(clock.Now)
+
+	guard := NewFreezeGuard(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {}))
+	guard.SetClock(clock.Now)
+	guard.AddFreezeSink(freezeSinkFunc(func(clusterID string, freezes []ScalingFreeze) {
+		executor.SendFreezes(clusterID, freezes)
+	}))
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	guard.Ingest("tenant-1", report)
+	_, err := guard.Freeze(ScalingFreeze{Scope: PolicyScope{TenantID: "tenant-1"}, Reason: "INC-1234", Actor: "alice"})
+	require.NoError(t, err)
+
+	intent := &agentv1.ScalingIntent{IntentId: "scale-up", WorkloadNamespace: "production", WorkloadName: "webapp-frontend", WorkloadType: "deployment", TargetReplicas: 4}
+	_, err = executor.Execute(context.Background(), intent)
+	require.ErrorIs(t, err, ErrScalingFrozen)
+
+	// The restarted agent has lost the freeze until the cluster reports again
+	executor = NewScalingExecutor(client, ExecutorConfig{ClusterID: "cluster-1"})
+	executor.SetClock(clock.Now)
+	guard.Ingest("tenant-1", report)
+	_, err = executor.Execute(context.Background(), intent)
+	require.ErrorIs(t, err, ErrScalingFrozen)
+	require.Empty(t, scaleUpdates(client))
+
+	// Repeated relays replace the freezes rather than adding to them
+	guard.Ingest("tenant-1", report)
+	for _, freeze := range guard.Active("tenant-1") {
+		_, err := guard.Lift(freeze.ID, "alice")
+		require.NoError(t, err)
+	}
+	result, err := executor.Execute(context.Background(), intent)
+	require.NoError(t, err)
+	require.Equal(t, int32(4), result.ToReplicas)
+}
+
+// TestFreezeAPI tests setting, listing and lifting freezes over HTTP
+func TestFreezeAPI(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	guard := NewFreezeGuard(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {}))
+	guard.SetClock(clock.Now)
+
+	httpServer := NewMockHTTPServer(NewMockMetricsService())
+	httpServer.Handle("/api/v1/freezes", guard)
+	httpServer.Handle("/api/v1/freezes/", guard)
+	server := httptest.NewServer(httpServer.Handler())
+	defer server.Close()
+
+	request := func(method, path string, body interface{}) (int, []byte) {
+		var reader bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reader).Encode(body))
+		}
+		req, err := http.NewRequest(method, server.URL+path, &reader)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var data bytes.Buffer
+		data.ReadFrom(resp.Body)
+		return resp.StatusCode, data.Bytes()
+	}
+
+	status, body := request(http.MethodPost, "/api/v1/freezes", map[string]string{
+		"tenant": "tenant-1", "namespace": "production", "reason": "release freeze", "actor": "alice", "duration": "2h",
+	})
+	require.Equal(t, http.StatusCreated, status)
+	var created ScalingFreeze
+	require.NoError(t, json.Unmarshal(body, &created))
+	require.Equal(t, PolicyScope{TenantID: "tenant-1", Namespace: "production"}, created.Scope)
+	require.Equal(t, clock.Now().Add(2*time.Hour), created.End)
+
+	status, body = request(http.MethodPost, "/api/v1/freezes", map[string]string{
+		"tenant": "tenant-2", "reason": "INC-42", "actor": "bob", "start": clock.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	require.Equal(t, http.StatusCreated, status)
+	var scheduled ScalingFreeze
+	require.NoError(t, json.Unmarshal(body, &scheduled))
+
+	status, body = request(http.MethodGet, "/api/v1/freezes?tenant=tenant-1", nil)
+	require.Equal(t, http.StatusOK, status)
+	var active []ScalingFreeze
+	require.NoError(t, json.Unmarshal(body, &active))
+	require.Len(t, active, 1)
+	require.Equal(t, created.ID, active[0].ID)
+	status, body = request(http.MethodGet, "/api/v1/freezes?tenant=tenant-2", nil)
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal(body, &active))
+	require.Empty(t, active, "scheduled freezes are not active yet")
+
+	status, _ = request(http.MethodPost, "/api/v1/freezes/"+created.ID+"/lift", map[string]string{})
+	require.Equal(t, http.StatusBadRequest, status, "an actor is required")
+	status, body = request(http.MethodPost, "/api/v1/freezes/"+created.ID+"/lift", map[string]string{"actor": "alice"})
+	require.Equal(t, http.StatusOK, status)
+	var lifted ScalingFreeze
+	require.NoError(t, json.Unmarshal(body, &lifted))
+	require.Equal(t, "alice", lifted.LiftedBy)
+	status, _ = request(http.MethodPost, "/api/v1/freezes/"+created.ID+"/lift", map[string]string{"actor": "alice"})
+	require.Equal(t, http.StatusConflict, status)
+	status, _ = request(http.MethodPost, "/api/v1/freezes/freeze-99/lift", map[string]string{"actor": "alice"})
+	require.Equal(t, http.StatusNotFound, status)
+
+	status, body = request(http.MethodGet, "/api/v1/freezes/"+scheduled.ID, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal(body, &scheduled))
+	require.Equal(t, "INC-42", scheduled.Reason)
+	status, _ = request(http.MethodGet, "/api/v1/freezes/freeze-99", nil)
+	require.Equal(t, http.StatusNotFound, status)
+
+	for _, invalid := range []map[string]string{
+		{"reason": "no actor"},
+		{"actor": "alice", "reason": "bad duration", "duration": "forever"},
+		{"actor": "alice", "reason": "both", "duration": "1h", "end": clock.Now().Add(time.Hour).Format(time.RFC3339)},
+	} {
+		status, _ = request(http.MethodPost, "/api/v1/freezes", invalid)
+		require.Equal(t, http.StatusBadRequest, status, invalid["reason"])
+	}
+	status, _ = request(http.MethodDelete, "/api/v1/freezes/"+scheduled.ID, nil)
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+}
@@ -0,0 +1,64 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// MockHTTPServer serves the HTTP side of the fake backend
+type MockHTTPServer struct {
+	mux            *http.ServeMux
+	metricsService *MockMetricsService
+	exporter       *MetricsExporter
+	alertSources   []AlertSource
+	mu             sync.RWMutex
+}
+
+// NewMockHTTPServer creates the HTTP handlers and subscribes them to the metrics service
+func NewMockHTTPServer(metrics *MockMetricsService) *MockHTTPServer {
+	s := &MockHTTPServer{
+		mux:            http.NewServeMux(),
+		metricsService: metrics,
+		exporter:       NewMetricsExporter(),
+	}
+
+	metrics.AddMetricsSink(s.exporter)
+
+	s.mux.Handle("/metrics", s.exporter)
+	s.mux.HandleFunc("/api/v1/events", s.serveEvents)
+	s.mux.HandleFunc("/api/v1/alerts", s.serveAlerts)
+	s.mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	return s
+}
+
+// Handler returns the root handler, suitable for httptest.NewServer
+func (s *MockHTTPServer) Handler() http.Handler {
+	return s.mux
+}
+
+// Exporter returns the exporter behind /metrics so other components can add collectors
+func (s *MockHTTPServer) Exporter() *MetricsExporter {
+	return s.exporter
+}
+
+// AddAlertSource makes the alerts of source available on /api/v1/alerts
+func (s *MockHTTPServer) AddAlertSource(source AlertSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alertSources = append(s.alertSources, source)
+}
+
+// Handle registers an additional handler on the server
+func (s *MockHTTPServer) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
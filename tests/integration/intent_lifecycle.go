@@ -0,0 +1,469 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+var (
+	// ErrUnknownIntent is returned for results of intents the tracker never issued
+	ErrUnknownIntent = errors.New("unknown scaling intent")
+	// ErrInvalidTransition is returned for results that do not follow the intent state machine
+	ErrInvalidTransition = errors.New("invalid scaling intent transition")
+)
+
+// IntentState is the lifecycle state of a ScalingIntent
+type IntentState string
+
+const (
+	IntentIssued     IntentState = "issued"
+	IntentDelivered  IntentState = "delivered"
+	IntentApplying   IntentState = "applying"
+	IntentSucceeded  IntentState = "succeeded"
+	IntentFailed     IntentState = "failed"
+	IntentSuperseded IntentState = "superseded"
+	IntentExpired    IntentState = "expired"
+)
+
+// intentTransitions lists the states each non-terminal state can move to
+var intentTransitions = map[IntentState][]IntentState{
+	IntentIssued:    {IntentDelivered, IntentApplying, IntentSucceeded, IntentFailed, IntentSuperseded, IntentExpired},
+	IntentDelivered: {IntentApplying, IntentSucceeded, IntentFailed, IntentSuperseded, IntentExpired},
+	IntentApplying:  {IntentSucceeded, IntentFailed, IntentSuperseded, IntentExpired},
+}
+
+// Terminal reports whether no further transitions are possible
+func (s IntentState) Terminal() bool {
+	switch s {
+	case IntentSucceeded, IntentFailed, IntentSuperseded, IntentExpired:
+		return true
+	}
+	return false
+}
+
+func (s IntentState) valid() bool {
+	_, nonTerminal := intentTransitions[s]
+	return nonTerminal || s.Terminal()
+}
+
+func (s IntentState) canMoveTo(next IntentState) bool {
+	for _, allowed := range intentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ScalingIntentResult is what an agent would report back about an intent: an
+// acknowledgement on delivery, progress while applying, and the final outcome with
+// the replicas it observed. The shared agent proto has no result message yet, so
+// there is no RPC carrying it; until one is added, results only reach a tracker
+// through Go callers such as MockScalingIntentHandler and ScalingExecutor.
+type ScalingIntentResult struct {
+	IntentID         string
+	ClusterID        string
+	State            IntentState // delivered, applying, succeeded or failed
+	ObservedReplicas int32
+	ReadyReplicas    int32
+	Error            string
+	Timestamp        time.Time
+}
+
+// ScalingIntentResultSink receives the results of the intents it issued
+type ScalingIntentResultSink interface {
+	ReportResult(result ScalingIntentResult) error
+}
+
+// IntentTransition is one step in the history of an intent
+type IntentTransition struct {
+	From   IntentState `json:"from,omitempty"`
+	To     IntentState `json:"to"`
+	At     time.Time   `json:"at"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// IntentRecord is the server-side view of an issued intent
+type IntentRecord struct {
+	IntentID         string             `json:"intentId"`
+	TenantID         string             `json:"tenantId,omitempty"`
+	ClusterID        string             `json:"clusterId"`
+	Namespace        string             `json:"namespace"`
+	Workload         string             `json:"workload"`
+	WorkloadType     string             `json:"workloadType,omitempty"`
+	TargetReplicas   int32              `json:"targetReplicas"`
+	Reason           string             `json:"reason,omitempty"`
+	State            IntentState        `json:"state"`
+	Attempts         int                `json:"attempts"`
+	IssuedAt         time.Time          `json:"issuedAt"`
+	UpdatedAt        time.Time          `json:"updatedAt"`
+	ObservedReplicas int32              `json:"observedReplicas,omitempty"`
+	ReadyReplicas    int32              `json:"readyReplicas,omitempty"`
+	Error            string             `json:"error,omitempty"`
+	SupersededBy     string             `json:"supersededBy,omitempty"`
+	Transitions      []IntentTransition `json:"transitions"`
+
+	intent     *agent.ScalingIntent
+	lastSentAt time.Time
+}
+
+// Key returns the workload the intent targets
+func (r IntentRecord) Key() WorkloadKey {
+	return WorkloadKey{TenantID: r.TenantID, ClusterID: r.ClusterID, Namespace: r.Namespace, Workload: r.Workload}
+}
+
+func copyIntentRecord(record *IntentRecord) IntentRecord {
+	result := *record
+	result.Transitions = append([]IntentTransition(nil), record.Transitions...)
+	result.intent = nil
+	return result
+}
+
+// IntentTrackerConfig sets the server-side timeouts. Zero values select the defaults.
+type IntentTrackerConfig struct {
+	// DeliveryTimeout is how long an issued intent waits for the agent's
+	// acknowledgement before it is resent; defaults to 30s
+	DeliveryTimeout time.Duration
+	// MaxAttempts is how often an intent is sent before it expires; defaults to 3
+	MaxAttempts int
+	// ApplyTimeout is how long a delivered intent may take to finish; defaults to 10m
+	ApplyTimeout time.Duration
+	// HistorySize is the number of intents kept per workload; defaults to 100
+	HistorySize int
+}
+
+// IntentQuery filters the intent history. Empty fields match anything.
+type IntentQuery struct {
+	TenantID  string
+	ClusterID string
+	Namespace string
+	Workload  string
+	States    []IntentState
+	Since     time.Time
+}
+
+// IntentTracker drives ScalingIntents through their lifecycle. It sends intents to
+// the agents, applies the results they report, resends unacknowledged intents,
+// expires stalled ones and keeps a per-workload history. It implements
+// ScalingIntentSink for the recommender and ScalingIntentResultSink for agents.
+type IntentTracker struct {
+	config         IntentTrackerConfig
+	sender         ScalingIntentSink
+	records        map[string]*IntentRecord
+	byWorkload     map[WorkloadKey][]string // Intent IDs, oldest first
+	tenantResolver func(clusterID string) string
+	audit          AuditRecorder
+	now            func() time.Time
+	mu             sync.RWMutex
+}
+
+// NewIntentTracker creates a tracker that delivers intents through sender
+func NewIntentTracker(sender ScalingIntentSink, config IntentTrackerConfig) *IntentTracker {
+	if config.DeliveryTimeout == 0 {
+		config.DeliveryTimeout = 30 * time.Second
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 3
+	}
+	if config.ApplyTimeout == 0 {
+		config.ApplyTimeout = 10 * time.Minute
+	}
+	if config.HistorySize == 0 {
+		config.HistorySize = 100
+	}
+
+	return &IntentTracker{
+		config:     config,
+		sender:     sender,
+		records:    make(map[string]*IntentRecord),
+		byWorkload: make(map[WorkloadKey][]string),
+		now:        time.Now,
+	}
+}
+
+// SetClock overrides the clock used for timestamps and timeouts
+func (t *IntentTracker) SetClock(now func() time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now = now
+}
+
+// SetTenantResolver maps the clusters of intents received through SendScalingIntent to tenants
+func (t *IntentTracker) SetTenantResolver(resolver func(clusterID string) string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tenantResolver = resolver
+}
+
+// SetAuditRecorder records every state change of the tracked intents
+func (t *IntentTracker) SetAuditRecorder(recorder AuditRecorder) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.audit = recorder
+}
+
+// SendScalingIntent issues an intent for the tenant of the cluster
+func (t *IntentTracker) SendScalingIntent(clusterID string, intent *agent.ScalingIntent) {
+	t.mu.RLock()
+	resolver := t.tenantResolver
+	t.mu.RUnlock()
+
+	tenantID := ""
+	if resolver != nil {
+		tenantID = resolver(clusterID)
+	}
+	t.Issue(tenantID, clusterID, intent)
+}
+
+// Issue records and sends an intent. Earlier intents for the same workload that
+// have not finished yet are superseded.
+func (t *IntentTracker) Issue(tenantID, clusterID string, intent *agent.ScalingIntent) IntentRecord {
+	t.mu.Lock()
+	now := t.now()
+	record := &IntentRecord{
+		IntentID:       intent.IntentId,
+		TenantID:       tenantID,
+		ClusterID:      clusterID,
+		Namespace:      intent.WorkloadNamespace,
+		Workload:       intent.WorkloadName,
+		WorkloadType:   intent.WorkloadType,
+		TargetReplicas: intent.TargetReplicas,
+		Reason:         intent.Reason,
+		State:          IntentIssued,
+		Attempts:       1,
+		IssuedAt:       now,
+		UpdatedAt:      now,
+		Transitions:    []IntentTransition{{To: IntentIssued, At: now}},
+		intent:         proto.Clone(intent).(*agent.ScalingIntent),
+		lastSentAt:     now,
+	}
+
+	key := record.Key()
+	for _, id := range t.byWorkload[key] {
+		if previous := t.records[id]; !previous.State.Terminal() {
+			t.transition(previous, IntentSuperseded, now, "superseded by "+record.IntentID)
+			previous.SupersededBy = record.IntentID
+		}
+	}
+
+	t.records[record.IntentID] = record
+	if t.audit != nil {
+		t.audit.Record(record.auditEntry(""))
+	}
+	ids := append(t.byWorkload[key], record.IntentID)
+	if len(ids) > t.config.HistorySize {
+		for _, id := range ids[:len(ids)-t.config.HistorySize] {
+			delete(t.records, id)
+		}
+		ids = ids[len(ids)-t.config.HistorySize:]
+	}
+	t.byWorkload[key] = ids
+
+	result := copyIntentRecord(record)
+	sender := t.sender
+	t.mu.Unlock()
+
+	// The agent may acknowledge synchronously, so send without holding the lock
+	if sender != nil {
+		sender.SendScalingIntent(clusterID, intent)
+	}
+	return result
+}
+
+// transition must be called with t.mu held
+func (t *IntentTracker) transition(record *IntentRecord, to IntentState, at time.Time, detail string) {
+	record.Transitions = append(record.Transitions, IntentTransition{From: record.State, To: to, At: at, Detail: detail})
+	record.State = to
+	record.UpdatedAt = at
+	if t.audit != nil {
+		t.audit.Record(record.auditEntry(detail))
+	}
+}
+
+// ReportResult applies a result of an intent to its record
+func (t *IntentTracker) ReportResult(result ScalingIntentResult) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record, ok := t.records[result.IntentID]
+	if !ok || (result.ClusterID != "" && result.ClusterID != record.ClusterID) {
+		return fmt.Errorf("%w: %s", ErrUnknownIntent, result.IntentID)
+	}
+	switch result.State {
+	case IntentDelivered, IntentApplying, IntentSucceeded, IntentFailed:
+	default:
+		return fmt.Errorf("%w: agents cannot report %q", ErrInvalidTransition, result.State)
+	}
+	if !record.State.canMoveTo(result.State) {
+		return fmt.Errorf("%w: %s -> %s for intent %s", ErrInvalidTransition, record.State, result.State, result.IntentID)
+	}
+
+	at := result.Timestamp
+	if at.IsZero() {
+		at = t.now()
+	}
+	if result.ObservedReplicas > 0 {
+		record.ObservedReplicas = result.ObservedReplicas
+	}
+	if result.ReadyReplicas > 0 {
+		record.ReadyReplicas = result.ReadyReplicas
+	}
+	if result.Error != "" {
+		record.Error = result.Error
+	}
+	t.transition(record, result.State, at, result.Error)
+	return nil
+}
+
+// Tick enforces the timeouts: unacknowledged intents are resent until MaxAttempts
+// and then expire, and delivered intents expire after ApplyTimeout. It returns the
+// number of intents resent and expired.
+func (t *IntentTracker) Tick() (resent, expired int) {
+	type delivery struct {
+		clusterID string
+		intent    *agent.ScalingIntent
+	}
+
+	t.mu.Lock()
+	now := t.now()
+	var deliveries []delivery
+	for _, record := range t.records {
+		switch record.State {
+		case IntentIssued:
+			if now.Sub(record.lastSentAt) < t.config.DeliveryTimeout {
+				continue
+			}
+			if record.Attempts >= t.config.MaxAttempts {
+				t.transition(record, IntentExpired, now, fmt.Sprintf("not acknowledged after %d attempts", record.Attempts))
+				expired++
+				continue
+			}
+			record.Attempts++
+			record.lastSentAt = now
+			deliveries = append(deliveries, delivery{clusterID: record.ClusterID, intent: record.intent})
+		case IntentDelivered, IntentApplying:
+			if now.Sub(record.UpdatedAt) >= t.config.ApplyTimeout {
+				t.transition(record, IntentExpired, now, fmt.Sprintf("no result within %s", t.config.ApplyTimeout))
+				expired++
+			}
+		}
+	}
+	sender := t.sender
+	t.mu.Unlock()
+
+	if sender != nil {
+		for _, d := range deliveries {
+			sender.SendScalingIntent(d.clusterID, d.intent)
+		}
+	}
+	return len(deliveries), expired
+}
+
+// Get returns the record of an intent
+func (t *IntentTracker) Get(intentID string) (IntentRecord, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	record, ok := t.records[intentID]
+	if !ok {
+		return IntentRecord{}, false
+	}
+	return copyIntentRecord(record), true
+}
+
+// History returns the intents of one workload, newest first
+func (t *IntentTracker) History(key WorkloadKey) []IntentRecord {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := t.byWorkload[key]
+	result := make([]IntentRecord, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		result = append(result, copyIntentRecord(t.records[ids[i]]))
+	}
+	return result
+}
+
+// Query returns the intents matching the query, newest first
+func (t *IntentTracker) Query(query IntentQuery) []IntentRecord {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var result []IntentRecord
+	for _, record := range t.records {
+		if (query.TenantID != "" && record.TenantID != query.TenantID) ||
+			(query.ClusterID != "" && record.ClusterID != query.ClusterID) ||
+			(query.Namespace != "" && record.Namespace != query.Namespace) ||
+			(query.Workload != "" && record.Workload != query.Workload) ||
+			record.IssuedAt.Before(query.Since) {
+			continue
+		}
+		if len(query.States) > 0 && !containsIntentState(query.States, record.State) {
+			continue
+		}
+		result = append(result, copyIntentRecord(record))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].IssuedAt.Equal(result[j].IssuedAt) {
+			return result[i].IssuedAt.After(result[j].IssuedAt)
+		}
+		return result[i].IntentID > result[j].IntentID
+	})
+	return result
+}
+
+func containsIntentState(states []IntentState, state IntentState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP serves the intent history as JSON, filtered by the tenant, cluster,
+// namespace, workload, state (repeatable) and since query parameters. Mount it with
+// MockHTTPServer.Handle("/api/v1/intents", tracker).
+func (t *IntentTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	params := r.URL.Query()
+	query := IntentQuery{
+		TenantID:  params.Get("tenant"),
+		ClusterID: params.Get("cluster"),
+		Namespace: params.Get("namespace"),
+		Workload:  params.Get("workload"),
+	}
+	for _, state := range params["state"] {
+		if !IntentState(state).valid() {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid state %q", state)})
+			return
+		}
+		query.States = append(query.States, IntentState(state))
+	}
+	if since := params.Get("since"); since != "" {
+		t.mu.RLock()
+		now := t.now()
+		t.mu.RUnlock()
+		ts, err := parseQueryTime(since, now)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		query.Since = ts
+	}
+
+	writeJSON(w, http.StatusOK, t.Query(query))
+}
@@ -0,0 +1,195 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// newTrackedHandler wires a tracker to an agent-side handler that acknowledges and reports results
+func newTrackedHandler(clock *fakeClock, config IntentTrackerConfig) (*IntentTracker, *MockScalingIntentHandler) {
+	handler := NewMockScalingIntentHandler()
+	tracker := NewIntentTracker(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		handler.HandleScalingIntent(intent)
+	}), config)
+	tracker.SetClock(clock.Now)
+	handler.SetResultSink(tracker)
+	return tracker, handler
+}
+
+func intentStates(record IntentRecord) []IntentState {
+	var states []IntentState
+	for _, transition := range record.Transitions {
+		states = append(states, transition.To)
+	}
+	return states
+}
+
+// TestIntentLifecycleSucceeded tests the happy path from issue to the reported outcome
+func TestIntentLifecycleSucceeded(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	tracker, handler := newTrackedHandler(clock, IntentTrackerConfig{})
+
+	intent := NewTestDataGenerator().GenerateScalingIntent("cluster-1")
+	issued := tracker.Issue("tenant-1", "cluster-1", intent)
+	require.Equal(t, IntentIssued, issued.State)
+
+	record, ok := tracker.Get(intent.IntentId)
+	require.True(t, ok)
+	require.Equal(t, IntentDelivered, record.State, "the agent acknowledges on receipt")
+
+	clock.Advance(time.Second)
+	require.True(t, handler.StartIntent(intent.IntentId))
+	clock.Advance(20 * time.Second)
+	require.True(t, handler.CompleteIntent(intent.IntentId, nil))
+
+	record, _ = tracker.Get(intent.IntentId)
+	require.Equal(t, IntentSucceeded, record.State)
+	require.Equal(t, int32(5), record.ObservedReplicas)
+	require.Equal(t, []IntentState{IntentIssued, IntentDelivered, IntentApplying, IntentSucceeded}, intentStates(record))
+	require.Equal(t, clock.Now(), record.UpdatedAt)
+	require.Equal(t, 1, record.Attempts)
+
+	err := tracker.ReportResult(ScalingIntentResult{IntentID: intent.IntentId, State: IntentApplying})
+	require.ErrorIs(t, err, ErrInvalidTransition)
+	err = tracker.ReportResult(ScalingIntentResult{IntentID: "unknown", State: IntentSucceeded})
+	require.ErrorIs(t, err, ErrUnknownIntent)
+	err = tracker.ReportResult(ScalingIntentResult{IntentID: intent.IntentId, ClusterID: "cluster-2", State: IntentFailed})
+	require.ErrorIs(t, err, ErrUnknownIntent, "results from another cluster are rejected")
+
+	// Failures carry the agent's error
+	failing := NewTestDataGenerator().GenerateScalingIntent("cluster-1")
+	failing.IntentId = "scaling-intent-test-002"
+	tracker.Issue("tenant-1", "cluster-1", failing)
+	require.True(t, handler.CompleteIntent(failing.IntentId, errors.New("deployment not found")))
+	record, _ = tracker.Get(failing.IntentId)
+	require.Equal(t, IntentFailed, record.State)
+	require.Equal(t, "deployment not found", record.Error)
+}
+
+// TestIntentSupersededAndExpired tests superseding, resends and timeouts
+func TestIntentSupersededAndExpired(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	var sent []string
+	tracker := NewIntentTracker(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		sent = append(sent, intent.IntentId)
+	}), IntentTrackerConfig{DeliveryTimeout: 30 * time.Second, MaxAttempts: 3, ApplyTimeout: 5 * time.Minute})
+	tracker.SetClock(clock.Now)
+
+	newIntent := func(id, workload string, replicas int32) *agentv1.ScalingIntent {
+		return &agentv1.ScalingIntent{IntentId: id, WorkloadNamespace: "production", WorkloadName: workload, WorkloadType: "deployment", TargetReplicas: replicas}
+	}
+
+	tracker.Issue("tenant-1", "cluster-1", newIntent("first", "webapp-frontend", 4))
+	clock.Advance(time.Second)
+	tracker.Issue("tenant-1", "cluster-1", newIntent("second", "webapp-frontend", 6))
+
+	first, _ := tracker.Get("first")
+	require.Equal(t, IntentSuperseded, first.State)
+	require.Equal(t, "second", first.SupersededBy)
+	require.ErrorIs(t, tracker.ReportResult(ScalingIntentResult{IntentID: "first", State: IntentSucceeded}), ErrInvalidTransition)
+
+	// Unacknowledged intents are resent until MaxAttempts, then expire
+	clock.Advance(10 * time.Second)
+	resent, expired := tracker.Tick()
+	require.Zero(t, resent)
+	require.Zero(t, expired)
+	for i := 0; i < 2; i++ {
+		clock.Advance(30 * time.Second)
+		resent, _ = tracker.Tick()
+		require.Equal(t, 1, resent)
+	}
+	clock.Advance(30 * time.Second)
+	_, expired = tracker.Tick()
+	require.Equal(t, 1, expired)
+	require.Equal(t, []string{"first", "second", "second", "second"}, sent)
+
+	second, _ := tracker.Get("second")
+	require.Equal(t, IntentExpired, second.State)
+	require.Equal(t, 3, second.Attempts)
+	require.Equal(t, "not acknowledged after 3 attempts", second.Transitions[len(second.Transitions)-1].Detail)
+
+	// A delivered intent without a result expires after the apply timeout
+	tracker.Issue("tenant-1", "cluster-1", newIntent("third", "webapp-backend", 3))
+	require.NoError(t, tracker.ReportResult(ScalingIntentResult{IntentID: "third", State: IntentDelivered}))
+	clock.Advance(4 * time.Minute)
+	require.NoError(t, tracker.ReportResult(ScalingIntentResult{IntentID: "third", State: IntentApplying, ObservedReplicas: 2}))
+	clock.Advance(4 * time.Minute)
+	_, expired = tracker.Tick()
+	require.Zero(t, expired, "progress restarts the apply timeout")
+	clock.Advance(time.Minute)
+	_, expired = tracker.Tick()
+	require.Equal(t, 1, expired)
+
+	third, _ := tracker.Get("third")
+	require.Equal(t, IntentExpired, third.State)
+	require.Equal(t, int32(2), third.ObservedReplicas)
+}
+
+// TestIntentHistoryQueryAPI tests the per-workload history and the HTTP query endpoint
+func TestIntentHistoryQueryAPI(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	tracker, handler := newTrackedHandler(clock, IntentTrackerConfig{HistorySize: 2})
+	tracker.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 60, TargetMemoryPercentage: 80})
+	require.NoError(t, err)
+	recommender.AddIntentSink(tracker)
+	metricsService := NewMockMetricsService()
+	metricsService.AddMetricsSink(recommender)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)))
+		clock.Advance(time.Minute)
+	}
+
+	key := WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "logging", Workload: "elasticsearch"}
+	history := tracker.History(key)
+	require.Len(t, history, 2, "history is capped per workload")
+	require.Equal(t, IntentDelivered, history[0].State)
+	require.Equal(t, IntentSuperseded, history[1].State)
+	require.Equal(t, history[0].IntentID, history[1].SupersededBy)
+	require.True(t, handler.CompleteIntent(history[0].IntentID, nil))
+
+	httpServer := NewMockHTTPServer(metricsService)
+	httpServer.Handle("/api/v1/intents", tracker)
+	server := httptest.NewServer(httpServer.Handler())
+	defer server.Close()
+
+	get := func(query string) (int, []IntentRecord) {
+		resp, err := http.Get(server.URL + "/api/v1/intents" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var records []IntentRecord
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&records))
+		}
+		return resp.StatusCode, records
+	}
+
+	status, records := get("?tenant=tenant-1&state=delivered")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, records, 2, "the latest coredns and prometheus-server intents")
+
+	status, records = get("?workload=elasticsearch&state=succeeded&state=superseded")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, records, 2)
+	require.Equal(t, IntentSucceeded, records[0].State)
+	require.Equal(t, int32(5), records[0].ObservedReplicas)
+	require.Equal(t, "cpu_percentage at 89.2% across 3 available replicas is above the 60.0% target: 3 -> 5 replicas", records[0].Reason)
+
+	status, records = get("?namespace=logging&since=1m")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, records, 1)
+
+	status, _ = get("?state=lost")
+	require.Equal(t, http.StatusBadRequest, status)
+}
@@ -0,0 +1,491 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// DefaultMetricSeriesStaleAfter is how long a series may go without a sample before
+// Evaluate treats it as gone
+const DefaultMetricSeriesStaleAfter = 5 * time.Minute
+
+// ThresholdOp compares a sample against a threshold
+type ThresholdOp string
+
+const (
+	OpGreaterThan    ThresholdOp = ">"
+	OpGreaterOrEqual ThresholdOp = ">="
+	OpLessThan       ThresholdOp = "<"
+	OpLessOrEqual    ThresholdOp = "<="
+)
+
+// MetricSelector picks the workload series a metric rule applies to. Empty lists match everything.
+type MetricSelector struct {
+	Metric     string
+	Namespaces []string
+	Workloads  []string
+}
+
+// ThresholdRule fires when a series crosses a static threshold for at least For,
+// e.g. memory_percentage > 90 for 5m
+type ThresholdRule struct {
+	Name     string
+	Severity string
+	Summary  string
+	MetricSelector
+	Op    ThresholdOp
+	Value float64
+	For   time.Duration
+}
+
+// AnomalyRule fires when a sample deviates from the series' exponentially weighted
+// moving average by more than Threshold standard deviations
+type AnomalyRule struct {
+	Name     string
+	Severity string
+	Summary  string
+	MetricSelector
+	// Alpha is the EWMA smoothing factor in (0, 1]; defaults to 0.3
+	Alpha float64
+	// Threshold is the z-score above which a sample is anomalous; defaults to 3
+	Threshold float64
+	// MinSamples is the warm-up before the baseline is trusted; defaults to 10
+	MinSamples int
+}
+
+// ewmaStats is the running baseline of one series for an anomaly rule
+type ewmaStats struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// metricAlertState tracks one rule against one series
+type metricAlertState struct {
+	alert        *Alert
+	pendingSince time.Time
+	lastSample   time.Time
+	ewma         ewmaStats
+}
+
+// observe records a sample time, keeping the newest when reports arrive out of order
+func (s *metricAlertState) observe(ts time.Time) {
+	if ts.After(s.lastSample) {
+		s.lastSample = ts
+	}
+}
+
+// MetricAlertEngine evaluates threshold and anomaly rules against every ingested
+// workload series. It implements MetricsSink.
+type MetricAlertEngine struct {
+	thresholds       []ThresholdRule
+	anomalies        []AnomalyRule
+	sinks            []AlertSink
+	states           map[string]*metricAlertState
+	deliveryFailures int64
+	staleAfter       time.Duration
+	now              func() time.Time
+	mu               sync.Mutex
+}
+
+func NewMetricAlertEngine(thresholds []ThresholdRule, anomalies []AnomalyRule, sinks ...AlertSink) (*MetricAlertEngine, error) {
+	engine := &MetricAlertEngine{
+		sinks:      sinks,
+		states:     make(map[string]*metricAlertState),
+		staleAfter: DefaultMetricSeriesStaleAfter,
+		now:        time.Now,
+	}
+
+	names := make(map[string]bool)
+	checkName := func(name, metric string) error {
+		if name == "" {
+			return fmt.Errorf("metric rule has no name")
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate metric rule %q", name)
+		}
+		if metric == "" {
+			return fmt.Errorf("metric rule %q has no metric", name)
+		}
+		names[name] = true
+		return nil
+	}
+
+	for _, rule := range thresholds {
+		if err := checkName(rule.Name, rule.Metric); err != nil {
+			return nil, err
+		}
+		switch rule.Op {
+		case OpGreaterThan, OpGreaterOrEqual, OpLessThan, OpLessOrEqual:
+		default:
+			return nil, fmt.Errorf("metric rule %q: unsupported operator %q", rule.Name, rule.Op)
+		}
+		engine.thresholds = append(engine.thresholds, rule)
+	}
+
+	for _, rule := range anomalies {
+		if err := checkName(rule.Name, rule.Metric); err != nil {
+			return nil, err
+		}
+		if rule.Alpha == 0 {
+			rule.Alpha = 0.3
+		}
+		if rule.Alpha < 0 || rule.Alpha > 1 {
+			return nil, fmt.Errorf("metric rule %q: alpha must be in (0, 1]", rule.Name)
+		}
+		if rule.Threshold <= 0 {
+			rule.Threshold = 3
+		}
+		if rule.MinSamples <= 0 {
+			rule.MinSamples = 10
+		}
+		engine.anomalies = append(engine.anomalies, rule)
+	}
+
+	return engine, nil
+}
+
+// SetClock replaces the clock Evaluate compares sample times against, for tests
+func (e *MetricAlertEngine) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
+// SetStaleAfter sets how long a series may go without a sample before Evaluate
+// resolves its alerts and forgets its state
+func (e *MetricAlertEngine) SetStaleAfter(staleAfter time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.staleAfter = staleAfter
+}
+
+// AddSink registers another alert sink
+func (e *MetricAlertEngine) AddSink(sink AlertSink) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sinks = append(e.sinks, sink)
+}
+
+// Ingest evaluates every rule against the workload series of the report
+func (e *MetricAlertEngine) Ingest(tenantID string, report *agent.MetricsReport) {
+	ts := reportTime(report)
+
+	e.mu.Lock()
+	var notifications []Alert
+	for _, workload := range report.WorkloadMetrics {
+		key := SeriesKey{
+			TenantID:  tenantID,
+			ClusterID: report.ClusterId,
+			Namespace: workload.Namespace,
+			Workload:  workload.WorkloadName,
+		}
+		values := workloadValues(workload)
+
+		for i := range e.thresholds {
+			rule := &e.thresholds[i]
+			if value, ok := rule.selects(key, values); ok {
+				key.Metric = rule.Metric
+				if alert := e.evaluateThreshold(rule, key, ts, value); alert != nil {
+					notifications = append(notifications, *alert)
+				}
+			}
+		}
+		for i := range e.anomalies {
+			rule := &e.anomalies[i]
+			if value, ok := rule.selects(key, values); ok {
+				key.Metric = rule.Metric
+				if alert := e.evaluateAnomaly(rule, key, ts, value); alert != nil {
+					notifications = append(notifications, *alert)
+				}
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	e.deliver(notifications)
+}
+
+// Evaluate resolves the firing alerts of series that have not reported for the
+// stale bound and forgets their state, along with pending alerts that can no longer
+// fire and resolved alerts older than ResolvedAlertRetention. The EWMA baseline of a
+// series that is still reporting is kept. It is meant to be called periodically,
+// like a rule evaluation tick.
+func (e *MetricAlertEngine) Evaluate() {
+	e.mu.Lock()
+	now := e.now()
+	var notifications []Alert
+	for fingerprint, state := range e.states {
+		stale := now.Sub(state.lastSample) >= e.staleAfter
+		alert := state.alert
+		if stale && alert != nil && alert.Status == AlertFiring {
+			alert.Status = AlertResolved
+			alert.EndsAt = now
+			alert.Annotations["reason"] = "series stopped reporting"
+			notifications = append(notifications, *copyAlert(alert))
+			continue
+		}
+		if alert != nil && alert.Status == AlertResolved && now.Sub(alert.EndsAt) >= ResolvedAlertRetention {
+			state.alert = nil
+		}
+		if state.alert == nil && state.ewma.samples > 0 && !stale {
+			continue
+		}
+		if state.alert == nil || (stale && state.alert.Status == AlertPending) {
+			delete(e.states, fingerprint)
+		}
+	}
+	e.mu.Unlock()
+
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].Fingerprint < notifications[j].Fingerprint })
+	e.deliver(notifications)
+}
+
+// deliver sends notifications outside the engine lock so slow sinks do not block ingestion
+func (e *MetricAlertEngine) deliver(notifications []Alert) {
+	if len(notifications) == 0 {
+		return
+	}
+	e.mu.Lock()
+	sinks := append([]AlertSink(nil), e.sinks...)
+	e.mu.Unlock()
+
+	var failures int64
+	for _, alert := range notifications {
+		for _, sink := range sinks {
+			if err := sink.Notify(context.Background(), alert); err != nil {
+				failures++
+			}
+		}
+	}
+	if failures > 0 {
+		e.mu.Lock()
+		e.deliveryFailures += failures
+		e.mu.Unlock()
+	}
+}
+
+// evaluateThreshold must be called with e.mu held. It returns an alert to deliver
+// when the alert starts firing or resolves.
+func (e *MetricAlertEngine) evaluateThreshold(rule *ThresholdRule, key SeriesKey, ts time.Time, value float64) *Alert {
+	labels := metricAlertLabels(rule.Name, rule.Severity, key)
+	fingerprint := alertFingerprint(labels)
+	state := e.states[fingerprint]
+
+	if !rule.Op.holds(value, rule.Value) {
+		if state == nil || state.alert == nil {
+			return nil
+		}
+		state.observe(ts)
+		switch state.alert.Status {
+		case AlertPending:
+			// Never fired, so there is nothing to resolve
+			delete(e.states, fingerprint)
+		case AlertFiring:
+			state.alert.Status = AlertResolved
+			state.alert.EndsAt = ts
+			state.alert.Annotations["value"] = formatAlertValue(value)
+			return copyAlert(state.alert)
+		}
+		return nil
+	}
+
+	if state == nil {
+		state = &metricAlertState{}
+		e.states[fingerprint] = state
+	}
+	state.observe(ts)
+	if state.alert == nil || state.alert.Status == AlertResolved {
+		summary := rule.Summary
+		if summary == "" {
+			summary = fmt.Sprintf("%s of %s/%s %s %s", rule.Metric, key.Namespace, key.Workload, rule.Op, formatAlertValue(rule.Value))
+		}
+		state.pendingSince = ts
+		state.alert = &Alert{
+			Fingerprint: fingerprint,
+			Status:      AlertPending,
+			Labels:      labels,
+			Annotations: map[string]string{"summary": summary},
+			StartsAt:    ts,
+		}
+	}
+
+	alert := state.alert
+	alert.Count++
+	alert.LastSeen = ts
+	alert.Annotations["value"] = formatAlertValue(value)
+
+	// Like Prometheus, a firing alert keeps the time it first became pending as StartsAt
+	if alert.Status == AlertPending && ts.Sub(state.pendingSince) >= rule.For {
+		alert.Status = AlertFiring
+		return copyAlert(alert)
+	}
+	return nil
+}
+
+// evaluateAnomaly must be called with e.mu held. The sample is scored against the
+// baseline before being folded into it, so one outlier cannot hide itself.
+func (e *MetricAlertEngine) evaluateAnomaly(rule *AnomalyRule, key SeriesKey, ts time.Time, value float64) *Alert {
+	labels := metricAlertLabels(rule.Name, rule.Severity, key)
+	fingerprint := alertFingerprint(labels)
+	state := e.states[fingerprint]
+	if state == nil {
+		state = &metricAlertState{ewma: ewmaStats{mean: value}}
+		e.states[fingerprint] = state
+	}
+	state.observe(ts)
+
+	stats := &state.ewma
+	deviation := value - stats.mean
+	zscore := 0.0
+	if stddev := math.Sqrt(stats.variance); stddev > 1e-9 {
+		zscore = math.Abs(deviation) / stddev
+	} else if math.Abs(deviation) > 1e-9 {
+		zscore = math.Inf(1)
+	}
+	anomalous := stats.samples >= rule.MinSamples && zscore > rule.Threshold
+	expected := stats.mean
+
+	increment := rule.Alpha * deviation
+	stats.mean += increment
+	stats.variance = (1 - rule.Alpha) * (stats.variance + deviation*increment)
+	stats.samples++
+
+	alert := state.alert
+	switch {
+	case anomalous && (alert == nil || alert.Status == AlertResolved):
+		summary := rule.Summary
+		if summary == "" {
+			summary = fmt.Sprintf("%s of %s/%s deviates from its baseline", rule.Metric, key.Namespace, key.Workload)
+		}
+		state.alert = &Alert{
+			Fingerprint: fingerprint,
+			Status:      AlertFiring,
+			Labels:      labels,
+			Annotations: map[string]string{"summary": summary},
+			StartsAt:    ts,
+		}
+		alert = state.alert
+	case anomalous:
+	case alert != nil && alert.Status == AlertFiring:
+		alert.Status = AlertResolved
+		alert.EndsAt = ts
+		alert.Annotations["value"] = formatAlertValue(value)
+		alert.Annotations["expected"] = formatAlertValue(expected)
+		alert.Annotations["zscore"] = formatAlertValue(zscore)
+		return copyAlert(alert)
+	default:
+		return nil
+	}
+
+	alert.Count++
+	alert.LastSeen = ts
+	alert.Annotations["value"] = formatAlertValue(value)
+	alert.Annotations["expected"] = formatAlertValue(expected)
+	alert.Annotations["zscore"] = formatAlertValue(zscore)
+	if alert.Count == 1 {
+		return copyAlert(alert)
+	}
+	return nil
+}
+
+// Alerts returns the latest state of every pending, firing or resolved alert, oldest first
+func (e *MetricAlertEngine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var result []Alert
+	for _, state := range e.states {
+		if state.alert != nil {
+			result = append(result, *copyAlert(state.alert))
+		}
+	}
+	sortAlerts(result)
+	return result
+}
+
+// ActiveAlerts returns the currently firing alerts, oldest first
+func (e *MetricAlertEngine) ActiveAlerts() []Alert {
+	var active []Alert
+	for _, alert := range e.Alerts() {
+		if alert.Status == AlertFiring {
+			active = append(active, alert)
+		}
+	}
+	return active
+}
+
+// DeliveryFailures returns how many sink notifications returned an error
+func (e *MetricAlertEngine) DeliveryFailures() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deliveryFailures
+}
+
+// workloadValues flattens the usage and custom metrics of a workload by metric name
+func workloadValues(workload *agent.WorkloadMetric) map[string]float64 {
+	values := make(map[string]float64, len(workload.CustomMetrics)+4)
+	if usage := workload.Usage; usage != nil {
+		values[MetricCPUPercentage] = usage.CpuPercentage
+		values[MetricMemoryPercentage] = usage.MemoryPercentage
+		values[MetricStoragePercentage] = usage.StoragePercentage
+		values[MetricPodsRunning] = float64(usage.PodsRunning)
+	}
+	for name, value := range workload.CustomMetrics {
+		values[name] = value
+	}
+	return values
+}
+
+func (s MetricSelector) selects(key SeriesKey, values map[string]float64) (float64, bool) {
+	if len(s.Namespaces) > 0 && !containsString(s.Namespaces, key.Namespace) {
+		return 0, false
+	}
+	if len(s.Workloads) > 0 && !containsString(s.Workloads, key.Workload) {
+		return 0, false
+	}
+	value, ok := values[s.Metric]
+	return value, ok
+}
+
+func (op ThresholdOp) holds(value, threshold float64) bool {
+	switch op {
+	case OpGreaterThan:
+		return value > threshold
+	case OpGreaterOrEqual:
+		return value >= threshold
+	case OpLessThan:
+		return value < threshold
+	case OpLessOrEqual:
+		return value <= threshold
+	}
+	return false
+}
+
+func metricAlertLabels(rule, severity string, key SeriesKey) map[string]string {
+	labels := map[string]string{
+		"alertname": rule,
+		"cluster":   key.ClusterID,
+		"namespace": key.Namespace,
+		"workload":  key.Workload,
+		"metric":    key.Metric,
+	}
+	if severity != "" {
+		labels["severity"] = severity
+	}
+	if key.TenantID != "" {
+		labels["tenant"] = key.TenantID
+	}
+	return labels
+}
+
+func formatAlertValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', 6, 64)
+}
@@ -0,0 +1,244 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestThresholdAlertPendingFiringResolved tests the For duration and resolve semantics
+func TestThresholdAlertPendingFiringResolved(t *testing.T) {
+	receiver := &webhookReceiver{}
+	webhook := httptest.NewServer(receiver)
+	defer webhook.Close()
+
+	engine, err := NewMetricAlertEngine([]ThresholdRule{{
+		Name:           "WorkloadMemoryHigh",
+		Severity:       "warning",
+		MetricSelector: MetricSelector{Metric: MetricMemoryPercentage, Namespaces: []string{"logging"}},
+		Op:             OpGreaterThan,
+		Value:          90,
+		For:            5 * time.Minute,
+	}}, nil, NewWebhookAlertSink(webhook.URL, nil))
+	require.NoError(t, err)
+
+	metricsService := NewMockMetricsService()
+	metricsService.AddMetricsSink(engine)
+	generator := NewTestDataGenerator()
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i <= 5; i++ {
+		report := generator.GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(base.Add(time.Duration(i) * time.Minute))
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+
+		alerts := engine.Alerts()
+		require.Len(t, alerts, 1, "only elasticsearch is above 90% memory in the logging namespace")
+		require.Equal(t, "elasticsearch", alerts[0].Labels["workload"])
+		if i < 5 {
+			require.Equal(t, AlertPending, alerts[0].Status)
+			require.Empty(t, receiver.received())
+		}
+	}
+
+	firing := receiver.received()
+	require.Len(t, firing, 1)
+	require.Equal(t, AlertFiring, firing[0].Status)
+	require.Equal(t, base, firing[0].StartsAt)
+	require.Equal(t, "91.7", firing[0].Annotations["value"])
+	require.Equal(t, MetricMemoryPercentage, firing[0].Labels["metric"])
+
+	report := generator.GenerateMetricsReport("cluster-1", 15)
+	report.Timestamp = timestamppb.New(base.Add(6 * time.Minute))
+	report.WorkloadMetrics[10].Usage.MemoryPercentage = 70
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+
+	notifications := receiver.received()
+	require.Len(t, notifications, 2)
+	require.Equal(t, AlertResolved, notifications[1].Status)
+	require.Equal(t, base.Add(6*time.Minute), notifications[1].EndsAt)
+	require.Empty(t, engine.ActiveAlerts())
+
+	// A condition that clears while pending never notifies
+	for i, memory := range []float64{95, 60} {
+		report := generator.GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(base.Add(time.Duration(7+i) * time.Minute))
+		report.WorkloadMetrics[10].Usage.MemoryPercentage = memory
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+	}
+	require.Len(t, receiver.received(), 2)
+	require.Empty(t, engine.Alerts())
+}
+
+// TestAnomalyAlertOnCPUSpike tests EWMA z-score detection on a single workload series
+func TestAnomalyAlertOnCPUSpike(t *testing.T) {
+	receiver := &webhookReceiver{}
+	webhook := httptest.NewServer(receiver)
+	defer webhook.Close()
+
+	engine, err := NewMetricAlertEngine(nil, []AnomalyRule{{
+		Name:           "CPUAnomaly",
+		MetricSelector: MetricSelector{Metric: MetricCPUPercentage, Workloads: []string{"webapp-backend"}},
+	}}, NewWebhookAlertSink(webhook.URL, nil))
+	require.NoError(t, err)
+
+	generator := NewTestDataGenerator()
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	ingest := func(minute int, cpu float64) {
+		report := generator.GenerateMetricsReport("cluster-1", 7)
+		report.Timestamp = timestamppb.New(base.Add(time.Duration(minute) * time.Minute))
+		report.WorkloadMetrics[6].Usage.CpuPercentage = cpu
+		engine.Ingest("tenant-1", report)
+	}
+
+	// Normal jitter around the fixture value, including during warm-up
+	for i := 0; i < 20; i++ {
+		ingest(i, 58.9+float64(i%2*2-1))
+	}
+	require.Empty(t, receiver.received())
+
+	ingest(20, 95)
+	alerts := receiver.received()
+	require.Len(t, alerts, 1)
+	require.Equal(t, AlertFiring, alerts[0].Status)
+	require.Equal(t, "webapp-backend", alerts[0].Labels["workload"])
+	require.Equal(t, "tenant-1", alerts[0].Labels["tenant"])
+	require.Equal(t, "95", alerts[0].Annotations["value"])
+
+	ingest(21, 59)
+	alerts = receiver.received()
+	require.Len(t, alerts, 2)
+	require.Equal(t, AlertResolved, alerts[1].Status)
+
+	_, err = NewMetricAlertEngine(nil, []AnomalyRule{{Name: "bad", MetricSelector: MetricSelector{Metric: MetricCPUPercentage}, Alpha: 2}})
+	require.Error(t, err)
+	_, err = NewMetricAlertEngine([]ThresholdRule{{Name: "bad", MetricSelector: MetricSelector{Metric: MetricCPUPercentage}, Op: "=="}}, nil)
+	require.Error(t, err)
+}
+
+// TestMetricAlertsResolveStaleSeries tests that Evaluate resolves the alert of a series
+// that stopped reporting and forgets state that is no longer relevant
+func TestMetricAlertsResolveStaleSeries(t *testing.T) {
+	receiver := &webhookReceiver{}
+	webhook := httptest.NewServer(receiver)
+	defer webhook.Close()
+
+	engine, err := NewMetricAlertEngine([]ThresholdRule{{
+		Name:           "WorkloadMemoryHigh",
+		MetricSelector: MetricSelector{Metric: MetricMemoryPercentage, Namespaces: []string{"logging"}},
+		Op:             OpGreaterThan,
+		Value:          90,
+	}}, []AnomalyRule{{
+		Name:           "CPUAnomaly",
+		MetricSelector: MetricSelector{Metric: MetricCPUPercentage, Workloads: []string{"webapp-backend"}},
+	}}, NewWebhookAlertSink(webhook.URL, nil))
+	require.NoError(t, err)
+
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: base}
+	engine.SetClock(clock.Now)
+	generator := NewTestDataGenerator()
+	ingest := func(minute int, withElasticsearch bool) {
+		report := generator.GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(base.Add(time.Duration(minute) * time.Minute))
+		if !withElasticsearch {
+			workloads := report.WorkloadMetrics[:0]
+			for _, workload := range report.WorkloadMetrics {
+				if workload.WorkloadName != "elasticsearch" {
+					workloads = append(workloads, workload)
+				}
+			}
+			report.WorkloadMetrics = workloads
+		}
+		engine.Ingest("", report)
+	}
+
+	for minute := 0; minute <= 2; minute++ {
+		ingest(minute, true)
+	}
+	require.Len(t, engine.ActiveAlerts(), 1)
+	require.Len(t, engine.states, 2)
+
+	// Elasticsearch stops reporting while the rest of the cluster carries on
+	for minute := 3; minute <= 7; minute++ {
+		ingest(minute, false)
+		clock.now = base.Add(time.Duration(minute) * time.Minute)
+		engine.Evaluate()
+	}
+	notifications := receiver.received()
+	require.Len(t, notifications, 2)
+	require.Equal(t, AlertResolved, notifications[1].Status)
+	require.Equal(t, base.Add(7*time.Minute), notifications[1].EndsAt)
+	require.Equal(t, "series stopped reporting", notifications[1].Annotations["reason"])
+	require.Empty(t, engine.ActiveAlerts())
+	require.Len(t, engine.Alerts(), 1)
+
+	// The resolved alert expires, but the baseline of a reporting series is kept
+	ingest(22, false)
+	clock.now = base.Add(22 * time.Minute)
+	engine.Evaluate()
+	require.Empty(t, engine.Alerts())
+	require.Len(t, engine.states, 1)
+
+	clock.Advance(DefaultMetricSeriesStaleAfter)
+	engine.Evaluate()
+	require.Empty(t, engine.states)
+	require.Len(t, receiver.received(), 2)
+}
+
+// TestAlertsExportedInAlertmanagerFormat tests the HTTP alert endpoint and the Alertmanager sink
+func TestAlertsExportedInAlertmanagerFormat(t *testing.T) {
+	var pushed [][]AlertmanagerAlert
+	alertmanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v2/alerts", r.URL.Path)
+		var batch []AlertmanagerAlert
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		pushed = append(pushed, batch)
+	}))
+	defer alertmanager.Close()
+
+	engine, err := NewMetricAlertEngine([]ThresholdRule{
+		{Name: "WorkloadMemoryHigh", MetricSelector: MetricSelector{Metric: MetricMemoryPercentage}, Op: OpGreaterThan, Value: 90},
+		{Name: "WorkloadCPUHigh", MetricSelector: MetricSelector{Metric: MetricCPUPercentage}, Op: OpGreaterOrEqual, Value: 80, For: time.Hour},
+	}, nil, NewAlertmanagerSink(alertmanager.URL+"/", nil))
+	require.NoError(t, err)
+
+	metricsService := NewMockMetricsService()
+	httpServer := NewMockHTTPServer(metricsService)
+	httpServer.AddAlertSource(engine)
+	metricsService.AddMetricsSink(engine)
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)))
+
+	require.Len(t, pushed, 1)
+	require.Len(t, pushed[0], 1)
+	require.Equal(t, "elasticsearch", pushed[0][0].Labels["workload"])
+	require.True(t, pushed[0][0].EndsAt.IsZero())
+
+	server := httptest.NewServer(httpServer.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/alerts?status=firing")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var raw []map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&raw))
+	require.Len(t, raw, 1, "pending alerts are not exported")
+	require.Equal(t, "WorkloadMemoryHigh", raw[0]["labels"].(map[string]interface{})["alertname"])
+	require.Contains(t, raw[0], "startsAt")
+	require.NotContains(t, raw[0], "endsAt")
+
+	require.Len(t, engine.Alerts(), 2, "the pending CPU alert is still queryable from Go")
+
+	bad, err := http.Get(server.URL + "/api/v1/alerts?status=pending")
+	require.NoError(t, err)
+	bad.Body.Close()
+	require.Equal(t, http.StatusBadRequest, bad.StatusCode)
+}
@@ -0,0 +1,260 @@
+package integration
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// PrometheusContentType is the content type of the Prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsExporter re-exports the latest agent-reported values in Prometheus text format.
+// Each cluster's newest report replaces its previous one, so workloads that stop
+// being reported disappear from the exposition. Clusters are kept per tenant, so
+// tenants reporting the same cluster ID do not replace each other's series.
+type MetricsExporter struct {
+	latest     map[clusterLabels]*exportedCluster
+	reports    map[clusterLabels]float64
+	collectors []PromCollector
+	mu         sync.RWMutex
+}
+
+// PromCollector contributes additional metric families to a MetricsExporter
+type PromCollector interface {
+	promFamilies() []*promFamily
+}
+
+type clusterLabels struct {
+	tenantID  string
+	clusterID string
+}
+
+type exportedCluster struct {
+	tenantID string
+	report   *agent.MetricsReport
+}
+
+// promSeries is one exposition line of a metric family
+type promSeries struct {
+	labels [][2]string
+	value  float64
+}
+
+// promFamily is a metric family with its help text and type
+type promFamily struct {
+	name   string
+	help   string
+	kind   string
+	series []promSeries
+}
+
+// promMetric is a single named value converted from a metrics report
+type promMetric struct {
+	name   string
+	labels [][2]string
+	value  float64
+}
+
+// exportedFamilies lists every family derived from agent reports, in exposition order
+var exportedFamilies = []promFamily{
+	{name: "hpa_agent_metrics_reports_total", help: "Metrics reports received from agents.", kind: "counter"},
+	{name: "hpa_cluster_cpu_percentage", help: "Overall CPU usage of the cluster.", kind: "gauge"},
+	{name: "hpa_cluster_memory_percentage", help: "Overall memory usage of the cluster.", kind: "gauge"},
+	{name: "hpa_cluster_storage_percentage", help: "Overall storage usage of the cluster.", kind: "gauge"},
+	{name: "hpa_cluster_nodes", help: "Total nodes in the cluster.", kind: "gauge"},
+	{name: "hpa_cluster_ready_nodes", help: "Ready nodes in the cluster.", kind: "gauge"},
+	{name: "hpa_cluster_pods", help: "Total pods in the cluster.", kind: "gauge"},
+	{name: "hpa_cluster_running_pods", help: "Running pods in the cluster.", kind: "gauge"},
+	{name: "hpa_cluster_custom_metric", help: "Custom metric of the cluster, named by the metric label.", kind: "gauge"},
+	{name: "hpa_cluster_last_report_timestamp_seconds", help: "Unix time of the latest metrics report from the cluster.", kind: "gauge"},
+	{name: "hpa_workload_cpu_percentage", help: "CPU usage of the workload as reported by the agent.", kind: "gauge"},
+	{name: "hpa_workload_memory_percentage", help: "Memory usage of the workload as reported by the agent.", kind: "gauge"},
+	{name: "hpa_workload_storage_percentage", help: "Storage usage of the workload as reported by the agent.", kind: "gauge"},
+	{name: "hpa_workload_pods_running", help: "Running pods of the workload.", kind: "gauge"},
+	{name: "hpa_workload_replicas", help: "Desired replicas of the workload.", kind: "gauge"},
+	{name: "hpa_workload_available_replicas", help: "Available replicas of the workload.", kind: "gauge"},
+	{name: "hpa_workload_custom_metric", help: "Custom metric of the workload, named by the metric label.", kind: "gauge"},
+}
+
+func NewMetricsExporter() *MetricsExporter {
+	return &MetricsExporter{
+		latest:  make(map[clusterLabels]*exportedCluster),
+		reports: make(map[clusterLabels]float64),
+	}
+}
+
+// Ingest records a report as the latest state of its cluster
+func (e *MetricsExporter) Ingest(tenantID string, report *agent.MetricsReport) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	labels := clusterLabels{tenantID: tenantID, clusterID: report.ClusterId}
+	e.latest[labels] = &exportedCluster{tenantID: tenantID, report: report}
+	e.reports[labels]++
+}
+
+// AddCollector includes the families of another component in every scrape
+func (e *MetricsExporter) AddCollector(collector PromCollector) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.collectors = append(e.collectors, collector)
+}
+
+// ServeHTTP writes the exposition for a Prometheus scrape
+func (e *MetricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", PrometheusContentType)
+	if err := e.WriteExposition(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteExposition writes all metric families in Prometheus text format
+func (e *MetricsExporter) WriteExposition(w io.Writer) error {
+	families := e.families()
+
+	e.mu.RLock()
+	collectors := append([]PromCollector(nil), e.collectors...)
+	e.mu.RUnlock()
+	for _, collector := range collectors {
+		families = append(families, collector.promFamilies()...)
+	}
+
+	for _, family := range families {
+		if len(family.series) == 0 {
+			continue
+		}
+		if err := writePromFamily(w, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *MetricsExporter) families() []*promFamily {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	families := make([]*promFamily, len(exportedFamilies))
+	byName := make(map[string]*promFamily, len(exportedFamilies))
+	for i := range exportedFamilies {
+		family := exportedFamilies[i]
+		families[i] = &family
+		byName[family.name] = &family
+	}
+
+	for _, cluster := range e.latest {
+		for _, metric := range reportSeries(cluster.tenantID, cluster.report) {
+			byName[metric.name].add(metric.labels, metric.value)
+		}
+	}
+
+	for labels, count := range e.reports {
+		byName["hpa_agent_metrics_reports_total"].add([][2]string{{"tenant", labels.tenantID}, {"cluster", labels.clusterID}}, count)
+	}
+
+	return families
+}
+
+func (f *promFamily) add(labels [][2]string, value float64) {
+	f.series = append(f.series, promSeries{labels: labels, value: value})
+}
+
+// reportSeries converts a report into the gauges of the workload and cluster families
+func reportSeries(tenantID string, report *agent.MetricsReport) []promMetric {
+	var metrics []promMetric
+	add := func(name string, labels [][2]string, value float64) {
+		metrics = append(metrics, promMetric{name: name, labels: labels, value: value})
+	}
+
+	base := [][2]string{{"tenant", tenantID}, {"cluster", report.ClusterId}}
+
+	for _, workload := range report.WorkloadMetrics {
+		labels := append(append([][2]string(nil), base...),
+			[2]string{"namespace", workload.Namespace},
+			[2]string{"workload", workload.WorkloadName},
+			[2]string{"workload_type", workload.WorkloadType},
+		)
+
+		if usage := workload.Usage; usage != nil {
+			add("hpa_workload_cpu_percentage", labels, usage.CpuPercentage)
+			add("hpa_workload_memory_percentage", labels, usage.MemoryPercentage)
+			add("hpa_workload_storage_percentage", labels, usage.StoragePercentage)
+			add("hpa_workload_pods_running", labels, float64(usage.PodsRunning))
+		}
+		add("hpa_workload_replicas", labels, float64(workload.Replicas))
+		add("hpa_workload_available_replicas", labels, float64(workload.AvailableReplicas))
+
+		for name, value := range workload.CustomMetrics {
+			add("hpa_workload_custom_metric", append(append([][2]string(nil), labels...), [2]string{"metric", name}), value)
+		}
+	}
+
+	if cluster := report.ClusterMetrics; cluster != nil {
+		if usage := cluster.OverallUsage; usage != nil {
+			add("hpa_cluster_cpu_percentage", base, usage.CpuPercentage)
+			add("hpa_cluster_memory_percentage", base, usage.MemoryPercentage)
+			add("hpa_cluster_storage_percentage", base, usage.StoragePercentage)
+		}
+		add("hpa_cluster_nodes", base, float64(cluster.TotalNodes))
+		add("hpa_cluster_ready_nodes", base, float64(cluster.ReadyNodes))
+		add("hpa_cluster_pods", base, float64(cluster.TotalPods))
+		add("hpa_cluster_running_pods", base, float64(cluster.RunningPods))
+
+		for name, value := range cluster.CustomMetrics {
+			add("hpa_cluster_custom_metric", append(append([][2]string(nil), base...), [2]string{"metric", name}), value)
+		}
+	}
+
+	if report.Timestamp != nil {
+		add("hpa_cluster_last_report_timestamp_seconds", base, float64(report.Timestamp.AsTime().UnixMilli())/1000)
+	}
+
+	return metrics
+}
+
+func writePromFamily(w io.Writer, family *promFamily) error {
+	lines := make([]string, len(family.series))
+	for i, series := range family.series {
+		lines[i] = family.name + formatPromLabels(series.labels) + " " + formatPromValue(series.value)
+	}
+	// Stable output makes scrapes diffable and tests deterministic
+	sort.Strings(lines)
+
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s\n",
+		family.name, family.help, family.name, family.kind, strings.Join(lines, "\n"))
+	return err
+}
+
+func formatPromLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = label[0] + `="` + escapePromLabelValue(label[1]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapePromLabelValue(value string) string {
+	return promLabelEscaper.Replace(value)
+}
+
+func formatPromValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
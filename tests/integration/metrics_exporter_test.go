@@ -0,0 +1,87 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// TestMetricsEndpointExposesAgentMetrics tests the /metrics endpoint of the fake backend
+func TestMetricsEndpointExposesAgentMetrics(t *testing.T) {
+	clusterService := NewMockClusterService()
+	metricsService := NewMockMetricsService()
+	NewMockGRPCServer(NewMockAuthService(), clusterService, metricsService)
+
+	httpServer := httptest.NewServer(NewMockHTTPServer(metricsService).Handler())
+	defer httpServer.Close()
+
+	ctx := context.Background()
+	_, err := clusterService.RegisterCluster(ctx, &agentv1.RegisterClusterRequest{Name: "cluster-1", TenantId: "tenant-1"})
+	require.NoError(t, err)
+
+	generator := NewTestDataGenerator()
+	require.NoError(t, metricsService.StoreMetrics(ctx, generator.GenerateMetricsReport("cluster-1", 15)))
+	require.NoError(t, metricsService.StoreMetrics(ctx, generator.GenerateMetricsReport("cluster-1", 15)))
+
+	resp, err := http.Get(httpServer.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, PrometheusContentType, resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	exposition := string(body)
+
+	require.Contains(t, exposition, "# TYPE hpa_workload_cpu_percentage gauge\n")
+	require.Contains(t, exposition, `hpa_workload_cpu_percentage{tenant="tenant-1",cluster="cluster-1",namespace="production",workload="webapp-backend",workload_type="deployment"} 58.9`)
+	require.Contains(t, exposition, `hpa_workload_custom_metric{tenant="tenant-1",cluster="cluster-1",namespace="kube-system",workload="coredns",workload_type="deployment",metric="restarts_total"} 0`)
+	require.Contains(t, exposition, `hpa_cluster_nodes{tenant="tenant-1",cluster="cluster-1"} 4`)
+	require.Contains(t, exposition, "# TYPE hpa_agent_metrics_reports_total counter\n")
+	require.Contains(t, exposition, `hpa_agent_metrics_reports_total{tenant="tenant-1",cluster="cluster-1"} 2`)
+
+	// Only the latest report is exported, so each workload appears once per family
+	require.Equal(t, 1, strings.Count(exposition, `hpa_workload_replicas{tenant="tenant-1",cluster="cluster-1",namespace="logging",workload="elasticsearch"`))
+}
+
+// TestMetricsExporterEscapesLabelValues tests escaping of label values in the exposition
+func TestMetricsExporterEscapesLabelValues(t *testing.T) {
+	exporter := NewMetricsExporter()
+	exporter.Ingest(`tenant "quoted"`, &agentv1.MetricsReport{
+		ClusterId: "cluster\\1",
+		WorkloadMetrics: []*agentv1.WorkloadMetric{
+			{Namespace: "default", WorkloadName: "app", WorkloadType: "deployment", Replicas: 2},
+		},
+	})
+
+	var out strings.Builder
+	require.NoError(t, exporter.WriteExposition(&out))
+	require.Contains(t, out.String(), `hpa_workload_replicas{tenant="tenant \"quoted\"",cluster="cluster\\1",namespace="default",workload="app",workload_type="deployment"} 2`)
+	require.NotContains(t, out.String(), "hpa_workload_cpu_percentage")
+}
+
+// TestMetricsExporterSeparatesTenants tests that tenants reporting the same cluster ID keep their own series
+func TestMetricsExporterSeparatesTenants(t *testing.T) {
+	exporter := NewMetricsExporter()
+	for tenantID, replicas := range map[string]int32{"tenant-1": 2, "tenant-2": 5} {
+		exporter.Ingest(tenantID, &agentv1.MetricsReport{
+			ClusterId: "cluster-1",
+			WorkloadMetrics: []*agentv1.WorkloadMetric{
+				{Namespace: "default", WorkloadName: "app", WorkloadType: "deployment", Replicas: replicas},
+			},
+		})
+	}
+
+	var out strings.Builder
+	require.NoError(t, exporter.WriteExposition(&out))
+	require.Contains(t, out.String(), `hpa_workload_replicas{tenant="tenant-1",cluster="cluster-1",namespace="default",workload="app",workload_type="deployment"} 2`)
+	require.Contains(t, out.String(), `hpa_workload_replicas{tenant="tenant-2",cluster="cluster-1",namespace="default",workload="app",workload_type="deployment"} 5`)
+}
@@ -2,6 +2,7 @@ package integration
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -61,6 +62,7 @@ func (m *MockAuthService) SetValidToken(token, tenantID, clusterID string) {
 type MockClusterService struct {
 	mock.Mock
 	clusters map[string]*ClusterInfo
+	notifier *Notifier
 	mu       sync.RWMutex
 }
 
@@ -92,6 +94,15 @@ func (m *MockClusterService) RegisterCluster(ctx context.Context, req *agent.Reg
 	
 	m.clusters[req.Name] = cluster
 	
+	if m.notifier != nil {
+		m.notifier.Notify(Notification{
+			Type:      NotificationClusterRegistered,
+			TenantID:  cluster.TenantID,
+			ClusterID: cluster.ID,
+			Data:      ClusterNotification{ClusterID: cluster.ID, Status: cluster.Status, Labels: cluster.Metadata},
+		})
+	}
+	
 	// Default response (no mock framework dependency)
 	return &agent.RegisterClusterResponse{
 		ClusterId:    req.Name,
@@ -106,13 +117,30 @@ func (m *MockClusterService) UpdateClusterStatus(clusterID, status string) error
 	defer m.mu.Unlock()
 	
 	if cluster, exists := m.clusters[clusterID]; exists {
+		previous := cluster.Status
 		cluster.Status = status
 		cluster.LastSeen = time.Now()
+		
+		if m.notifier != nil && previous != status {
+			m.notifier.Notify(Notification{
+				Type:      NotificationClusterStatusChanged,
+				TenantID:  cluster.TenantID,
+				ClusterID: clusterID,
+				Data:      ClusterNotification{ClusterID: clusterID, Status: status, PreviousStatus: previous},
+			})
+		}
 	}
 	
 	return nil
 }
 
+// SetNotifier makes cluster registrations and status changes send notifications
+func (m *MockClusterService) SetNotifier(notifier *Notifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifier = notifier
+}
+
 func (m *MockClusterService) GetCluster(clusterID string) (*ClusterInfo, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -121,35 +149,165 @@ func (m *MockClusterService) GetCluster(clusterID string) (*ClusterInfo, bool) {
 	return cluster, exists
 }
 
+// TenantForCluster returns the tenant a cluster was registered under, or "" if unknown
+func (m *MockClusterService) TenantForCluster(clusterID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	if cluster, exists := m.clusters[clusterID]; exists {
+		return cluster.TenantID
+	}
+	return ""
+}
+
+// MetricsSink receives every metrics report stored by MockMetricsService
+type MetricsSink interface {
+	Ingest(tenantID string, report *agent.MetricsReport)
+}
+
+// ReportAttachment is a Go-form agent message sent alongside a metrics report for
+// data the shared proto does not carry yet: an AutoscalerReport or a ResourcesReport
+type ReportAttachment interface {
+	attachedCluster() string
+}
+
+// AutoscalerSink receives the autoscalers attached to stored metrics reports,
+// such as a ConflictGuard
+type AutoscalerSink interface {
+	IngestAutoscalers(tenantID string, report AutoscalerReport)
+}
+
+// ResourcesSink receives the container resources attached to stored metrics
+// reports, such as a VerticalRecommender
+type ResourcesSink interface {
+	IngestResources(tenantID string, report ResourcesReport)
+}
+
+// EventSink receives every event report stored by MockMetricsService
+type EventSink interface {
+	IngestEvents(tenantID string, report *agent.EventReport)
+}
+
 // MockMetricsService handles metrics storage and processing for integration tests
 type MockMetricsService struct {
 	mock.Mock
-	receivedMetrics []*agent.MetricsReport
-	receivedEvents  []*agent.EventReport
+	receivedMetrics []*storedMetricsReport
+	receivedEvents  []*storedEventReport
+	// Per-cluster arrival order and the count of reports evicted by the cluster
+	// cap that are still in receivedMetrics and receivedEvents
+	clusterMetrics  map[string][]*storedMetricsReport
+	clusterEvents   map[string][]*storedEventReport
+	evictedMetrics  int
+	evictedEvents   int
+	eventSeq        uint64
+	events          *EventAggregator
+	sinks           []MetricsSink
+	eventSinks      []EventSink
+	autoscalerSinks []AutoscalerSink
+	resourcesSinks  []ResourcesSink
+	tenantResolver  func(clusterID string) string
+	limiter         *CardinalityLimiter
+	rateConverter   *CounterRateConverter
+	retention       RetentionPolicy
+	retentionStats  RetentionStats
+	now             func() time.Time
 	mu              sync.RWMutex
 }
 
 func NewMockMetricsService() *MockMetricsService {
 	return &MockMetricsService{
-		receivedMetrics: make([]*agent.MetricsReport, 0),
-		receivedEvents:  make([]*agent.EventReport, 0),
+		receivedMetrics: make([]*storedMetricsReport, 0),
+		receivedEvents:  make([]*storedEventReport, 0),
+		clusterMetrics:  make(map[string][]*storedMetricsReport),
+		clusterEvents:   make(map[string][]*storedEventReport),
+		events:          NewEventAggregator(),
+		now:             time.Now,
 	}
 }
 
 func (m *MockMetricsService) StoreMetrics(ctx context.Context, report *agent.MetricsReport) error {
+	_, err := m.IngestMetrics(ctx, report)
+	return err
+}
+
+// IngestMetrics stores a report like StoreMetrics and also returns a warning for every
+// custom metric dropped by the cardinality limiter. Attachments sent alongside the
+// report are passed to their sinks before the metrics sinks see the report, so that
+// intents recommended from it already account for the attached autoscalers.
+func (m *MockMetricsService) IngestMetrics(ctx context.Context, report *agent.MetricsReport, attachments ...ReportAttachment) ([]string, error) {
+	for _, attachment := range attachments {
+		if cluster := attachment.attachedCluster(); cluster != report.ClusterId {
+			return nil, fmt.Errorf("%T of cluster %s attached to a report of cluster %s", attachment, cluster, report.ClusterId)
+		}
+	}
+	
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	
-	m.receivedMetrics = append(m.receivedMetrics, report)
+	tenantID := m.tenantFor(report.ClusterId)
 	
-	return nil
+	var warnings []string
+	// Rates are derived before limiting so the series they add count against the
+	// tenant limits like any reported metric
+	if m.rateConverter != nil {
+		warnings = m.rateConverter.Convert(tenantID, report)
+	}
+	if m.limiter != nil {
+		warnings = append(warnings, m.limiter.Apply(tenantID, report)...)
+	}
+	
+	m.retainMetrics(&storedMetricsReport{
+		storedReport: m.newStoredReport(report.ClusterId, report),
+		report:       report,
+	})
+	m.enforceRetention()
+	
+	sinks := append([]MetricsSink(nil), m.sinks...)
+	autoscalerSinks := append([]AutoscalerSink(nil), m.autoscalerSinks...)
+	resourcesSinks := append([]ResourcesSink(nil), m.resourcesSinks...)
+	m.mu.Unlock()
+	
+	for _, attachment := range attachments {
+		switch attachment := attachment.(type) {
+		case AutoscalerReport:
+			for _, sink := range autoscalerSinks {
+				sink.IngestAutoscalers(tenantID, attachment)
+			}
+		case ResourcesReport:
+			for _, sink := range resourcesSinks {
+				sink.IngestResources(tenantID, attachment)
+			}
+		}
+	}
+	
+	// Sinks such as alert engines and recommenders may read back from the service,
+	// so they run without holding the lock
+	for _, sink := range sinks {
+		sink.Ingest(tenantID, report)
+	}
+	
+	return warnings, nil
 }
 
 func (m *MockMetricsService) StoreEvents(ctx context.Context, report *agent.EventReport) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	
-	m.receivedEvents = append(m.receivedEvents, report)
+	m.eventSeq++
+	m.retainEvents(&storedEventReport{
+		storedReport: m.newStoredReport(report.ClusterId, report),
+		seq:          m.eventSeq,
+		report:       report,
+	})
+	m.events.Record(report, m.now())
+	m.enforceRetention()
+	
+	tenantID := m.tenantFor(report.ClusterId)
+	sinks := append([]EventSink(nil), m.eventSinks...)
+	m.mu.Unlock()
+	
+	// Event sinks may notify external systems, so they run without holding the lock
+	for _, sink := range sinks {
+		sink.IngestEvents(tenantID, report)
+	}
 	
 	return nil
 }
@@ -159,8 +317,12 @@ func (m *MockMetricsService) GetReceivedMetrics() []*agent.MetricsReport {
 	defer m.mu.RUnlock()
 	
 	// Return copy to avoid race conditions
-	result := make([]*agent.MetricsReport, len(m.receivedMetrics))
-	copy(result, m.receivedMetrics)
+	result := make([]*agent.MetricsReport, 0, len(m.receivedMetrics)-m.evictedMetrics)
+	for _, stored := range m.receivedMetrics {
+		if !stored.evicted {
+			result = append(result, stored.report)
+		}
+	}
 	return result
 }
 
@@ -169,17 +331,100 @@ func (m *MockMetricsService) GetReceivedEvents() []*agent.EventReport {
 	defer m.mu.RUnlock()
 	
 	// Return copy to avoid race conditions
-	result := make([]*agent.EventReport, len(m.receivedEvents))
-	copy(result, m.receivedEvents)
+	result := make([]*agent.EventReport, 0, len(m.receivedEvents)-m.evictedEvents)
+	for _, stored := range m.receivedEvents {
+		if !stored.evicted {
+			result = append(result, stored.report)
+		}
+	}
 	return result
 }
 
+// GetAggregatedEvents returns stored events folded into one entry per series, most recent first
+func (m *MockMetricsService) GetAggregatedEvents() []AggregatedEvent {
+	return m.events.Events()
+}
+
+// EventAggregator returns the aggregated event store
+func (m *MockMetricsService) EventAggregator() *EventAggregator {
+	return m.events
+}
+
+// AddMetricsSink makes every stored metrics report also feed the given sink,
+// such as a TimeSeriesStore or RollupEngine
+func (m *MockMetricsService) AddMetricsSink(sink MetricsSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sinks = append(m.sinks, sink)
+}
+
+// AddAutoscalerSink makes the autoscalers attached to stored metrics reports feed
+// the given sink
+func (m *MockMetricsService) AddAutoscalerSink(sink AutoscalerSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.autoscalerSinks = append(m.autoscalerSinks, sink)
+}
+
+// AddResourcesSink makes the container resources attached to stored metrics
+// reports feed the given sink
+func (m *MockMetricsService) AddResourcesSink(sink ResourcesSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resourcesSinks = append(m.resourcesSinks, sink)
+}
+
+// AddEventSink makes every stored event report also feed the given sink,
+// such as an EventRuleEngine
+func (m *MockMetricsService) AddEventSink(sink EventSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.eventSinks = append(m.eventSinks, sink)
+}
+
+// SetCardinalityLimiter makes every stored report pass through the limiter first
+func (m *MockMetricsService) SetCardinalityLimiter(limiter *CardinalityLimiter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limiter = limiter
+}
+
+// SetCounterRateConverter makes every stored report carry per-second rates of its counters
+func (m *MockMetricsService) SetCounterRateConverter(converter *CounterRateConverter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rateConverter = converter
+}
+
+// SetTenantResolver sets how the tenant of an incoming report is derived from its cluster ID
+func (m *MockMetricsService) SetTenantResolver(resolver func(clusterID string) string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tenantResolver = resolver
+}
+
+// tenantFor must be called with m.mu held
+func (m *MockMetricsService) tenantFor(clusterID string) string {
+	if m.tenantResolver == nil {
+		return ""
+	}
+	return m.tenantResolver(clusterID)
+}
+
 func (m *MockMetricsService) Clear() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
 	m.receivedMetrics = m.receivedMetrics[:0]
 	m.receivedEvents = m.receivedEvents[:0]
+	clear(m.clusterMetrics)
+	clear(m.clusterEvents)
+	m.evictedMetrics, m.evictedEvents = 0, 0
+	m.events.Reset()
+	if m.rateConverter != nil {
+		m.rateConverter.Reset()
+	}
+	m.retentionStats.RetainedBytes = 0
 }
 
 // MockMetricsCollector provides test metrics for the integration tests
@@ -272,6 +517,11 @@ type MockScalingIntentHandler struct {
 	receivedIntents []*agent.ScalingIntent
 	mu              sync.RWMutex
 	handler         func(*agent.ScalingIntent)
+	notifier        *Notifier
+	tenantID        string
+	clusterID       string
+	results         ScalingIntentResultSink
+	audit           AuditRecorder
 }
 
 func NewMockScalingIntentHandler() *MockScalingIntentHandler {
@@ -285,12 +535,140 @@ func (m *MockScalingIntentHandler) HandleScalingIntent(intent *agent.ScalingInte
 	defer m.mu.Unlock()
 	
 	m.receivedIntents = append(m.receivedIntents, intent)
+	m.notifyIntent(NotificationScalingIntentIssued, intent, nil)
+	m.reportResult(intent, ScalingIntentResult{IntentID: intent.IntentId, State: IntentDelivered})
 	
 	if m.handler != nil {
 		m.handler(intent)
 	}
 }
 
+// SetNotifier makes issued and completed intents send notifications for the given cluster
+func (m *MockScalingIntentHandler) SetNotifier(notifier *Notifier, tenantID, clusterID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifier = notifier
+	m.tenantID = tenantID
+	m.clusterID = clusterID
+}
+
+// SetResultSink makes the handler acknowledge received intents and report their
+// progress and outcome, like the agent does over its stream
+func (m *MockScalingIntentHandler) SetResultSink(sink ScalingIntentResultSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results = sink
+}
+
+// SetAuditRecorder records the delivery, progress and outcome of received intents
+// for the given cluster when no result sink is set. With a result sink, the
+// IntentTracker behind it records them instead, so they are not recorded twice.
+func (m *MockScalingIntentHandler) SetAuditRecorder(recorder AuditRecorder, tenantID, clusterID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = recorder
+	m.tenantID = tenantID
+	m.clusterID = clusterID
+}
+
+// StartIntent reports that a received intent is being applied
+func (m *MockScalingIntentHandler) StartIntent(intentID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	intent := m.findIntent(intentID)
+	if intent == nil {
+		return false
+	}
+	m.reportResult(intent, ScalingIntentResult{IntentID: intentID, State: IntentApplying})
+	return true
+}
+
+// CompleteIntent reports the outcome of a received intent; a nil err means it succeeded
+// and the workload reached the target replicas
+func (m *MockScalingIntentHandler) CompleteIntent(intentID string, err error) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	intent := m.findIntent(intentID)
+	if intent == nil {
+		return false
+	}
+	if err != nil {
+		m.notifyIntent(NotificationScalingIntentFailed, intent, err)
+		m.reportResult(intent, ScalingIntentResult{IntentID: intentID, State: IntentFailed, Error: err.Error()})
+	} else {
+		m.notifyIntent(NotificationScalingIntentCompleted, intent, nil)
+		m.reportResult(intent, ScalingIntentResult{
+			IntentID:         intentID,
+			State:            IntentSucceeded,
+			ObservedReplicas: intent.TargetReplicas,
+			ReadyReplicas:    intent.TargetReplicas,
+		})
+	}
+	return true
+}
+
+// findIntent must be called with m.mu held
+func (m *MockScalingIntentHandler) findIntent(intentID string) *agent.ScalingIntent {
+	for _, intent := range m.receivedIntents {
+		if intent.IntentId == intentID {
+			return intent
+		}
+	}
+	return nil
+}
+
+// reportResult must be called with m.mu held
+func (m *MockScalingIntentHandler) reportResult(intent *agent.ScalingIntent, result ScalingIntentResult) {
+	result.ClusterID = m.clusterID
+	if m.results != nil {
+		m.results.ReportResult(result)
+		return
+	}
+	if m.audit != nil {
+		m.audit.Record(AuditEntry{
+			Type:             AuditIntent,
+			TenantID:         m.tenantID,
+			ClusterID:        m.clusterID,
+			Namespace:        intent.WorkloadNamespace,
+			Workload:         intent.WorkloadName,
+			IntentID:         intent.IntentId,
+			Actor:            "agent/" + m.clusterID,
+			State:            string(result.State),
+			TargetReplicas:   intent.TargetReplicas,
+			ObservedReplicas: result.ObservedReplicas,
+			Detail:           result.Error,
+		})
+	}
+}
+
+// notifyIntent must be called with m.mu held
+func (m *MockScalingIntentHandler) notifyIntent(notificationType NotificationType, intent *agent.ScalingIntent, err error) {
+	if m.notifier == nil {
+		return
+	}
+	
+	data := ScalingIntentNotification{
+		IntentID:       intent.IntentId,
+		Namespace:      intent.WorkloadNamespace,
+		Workload:       intent.WorkloadName,
+		WorkloadType:   intent.WorkloadType,
+		TargetReplicas: intent.TargetReplicas,
+		Reason:         intent.Reason,
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	
+	m.notifier.Notify(Notification{
+		Type:      notificationType,
+		TenantID:  m.tenantID,
+		ClusterID: m.clusterID,
+		Data:      data,
+	})
+}
+
 func (m *MockScalingIntentHandler) SetHandler(handler func(*agent.ScalingIntent)) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -629,6 +1007,9 @@ type MockGRPCServer struct {
 }
 
 func NewMockGRPCServer(auth *MockAuthService, cluster *MockClusterService, metrics *MockMetricsService) *MockGRPCServer {
+	// Reports only carry a cluster ID; resolve tenants through the registered clusters
+	metrics.SetTenantResolver(cluster.TenantForCluster)
+	
 	return &MockGRPCServer{
 		authService:    auth,
 		clusterService: cluster,
@@ -649,8 +1030,9 @@ func (s *MockGRPCServer) Heartbeat(ctx context.Context, req *agent.HeartbeatRequ
 }
 
 func (s *MockGRPCServer) ReportMetrics(ctx context.Context, req *agent.MetricsReportRequest) (*agent.MetricsReportResponse, error) {
+	var warnings []string
 	for _, report := range req.Reports {
-		err := s.metricsService.StoreMetrics(ctx, report)
+		dropped, err := s.metricsService.IngestMetrics(ctx, report)
 		if err != nil {
 			return &agent.MetricsReportResponse{
 				Accepted:       false,
@@ -658,12 +1040,14 @@ func (s *MockGRPCServer) ReportMetrics(ctx context.Context, req *agent.MetricsRe
 				Errors:         []string{err.Error()},
 			}, nil
 		}
+		warnings = append(warnings, dropped...)
 	}
 	
+	// Reports with dropped custom metrics are still accepted; the drops are reported as errors
 	return &agent.MetricsReportResponse{
 		Accepted:       true,
 		ProcessedCount: int32(len(req.Reports)),
-		Errors:         nil,
+		Errors:         warnings,
 	}, nil
 }
 
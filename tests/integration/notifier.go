@@ -0,0 +1,569 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NotificationType names what a notification is about
+type NotificationType string
+
+const (
+	NotificationClusterRegistered      NotificationType = "cluster.registered"
+	NotificationClusterStatusChanged   NotificationType = "cluster.status_changed"
+	NotificationScalingIntentIssued    NotificationType = "scaling_intent.issued"
+	NotificationScalingIntentCompleted NotificationType = "scaling_intent.completed"
+	NotificationScalingIntentFailed    NotificationType = "scaling_intent.failed"
+	NotificationAlertFiring            NotificationType = "alert.firing"
+	NotificationAlertResolved          NotificationType = "alert.resolved"
+)
+
+// Headers set on every webhook delivery
+const (
+	HeaderNotificationID   = "X-HPA-Notification-Id"
+	HeaderNotificationType = "X-HPA-Notification-Type"
+	HeaderTimestamp        = "X-HPA-Timestamp"
+	// HeaderSignature carries "sha256=" followed by the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the endpoint secret
+	HeaderSignature = "X-HPA-Signature"
+)
+
+var (
+	errNotifierClosed    = errors.New("notifier is closed")
+	errDeliveryQueueFull = errors.New("delivery queue is full")
+)
+
+// Notification is the JSON payload posted to webhook endpoints
+type Notification struct {
+	ID        string           `json:"id"`
+	Type      NotificationType `json:"type"`
+	TenantID  string           `json:"tenantId"`
+	ClusterID string           `json:"clusterId,omitempty"`
+	Timestamp time.Time        `json:"timestamp"`
+	Data      interface{}      `json:"data,omitempty"`
+}
+
+// WebhookEndpoint is an HTTP endpoint registered by a tenant
+type WebhookEndpoint struct {
+	ID     string
+	URL    string
+	Secret string
+	// Types limits the notifications sent to the endpoint; empty means all
+	Types []NotificationType
+}
+
+// NotifierConfig configures webhook delivery
+type NotifierConfig struct {
+	Workers     int           // Concurrent deliveries
+	QueueSize   int           // Max deliveries waiting; further deliveries go to the dead-letter queue
+	MaxAttempts int           // Attempts per delivery, including the first
+	MinBackoff  time.Duration // Wait before the first retry, doubled on every further retry
+	MaxBackoff  time.Duration
+	Timeout     time.Duration // Per-request timeout
+	LogSize     int           // Max delivery attempts kept in the delivery log
+	Client      *http.Client
+}
+
+// DeliveryAttempt is one entry of the delivery log
+type DeliveryAttempt struct {
+	NotificationID string
+	Type           NotificationType
+	TenantID       string
+	EndpointID     string
+	Attempt        int
+	StatusCode     int
+	Error          string
+	Delivered      bool
+	Duration       time.Duration
+	Timestamp      time.Time
+}
+
+// DeadLetter is a delivery that could not be completed
+type DeadLetter struct {
+	Notification Notification
+	TenantID     string
+	EndpointID   string
+	Attempts     int
+	LastError    string
+	FailedAt     time.Time
+}
+
+// NotifierStats counts delivery outcomes
+type NotifierStats struct {
+	Delivered    int64
+	Retries      int64
+	DeadLettered int64
+}
+
+type delivery struct {
+	notification Notification
+	body         []byte
+	endpoint     WebhookEndpoint
+}
+
+// Notifier delivers HMAC-signed JSON notifications to per-tenant webhook endpoints.
+// Delivery is asynchronous so callers such as MockClusterService never block on HTTP.
+type Notifier struct {
+	config    NotifierConfig
+	endpoints map[string][]WebhookEndpoint
+	log       []DeliveryAttempt
+	dead      []DeadLetter
+	queue     chan delivery
+	done      chan struct{}
+	workers   sync.WaitGroup
+	pending   sync.WaitGroup
+	once      sync.Once
+	closed    bool
+	now       func() time.Time
+
+	delivered    atomic.Int64
+	retries      atomic.Int64
+	deadLettered atomic.Int64
+
+	mu sync.RWMutex
+}
+
+// NewNotifier fills in config defaults and starts the delivery workers
+func NewNotifier(config NotifierConfig) *Notifier {
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 500 * time.Millisecond
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 30 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.LogSize <= 0 {
+		config.LogSize = 1000
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{}
+	}
+
+	n := &Notifier{
+		config:    config,
+		endpoints: make(map[string][]WebhookEndpoint),
+		queue:     make(chan delivery, config.QueueSize),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+
+	n.workers.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go n.run()
+	}
+
+	return n
+}
+
+// AddEndpoint registers a webhook endpoint for a tenant, replacing one with the same ID
+func (n *Notifier) AddEndpoint(tenantID string, endpoint WebhookEndpoint) error {
+	u, err := url.Parse(endpoint.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", endpoint.URL)
+	}
+	if endpoint.ID == "" {
+		return fmt.Errorf("webhook endpoint has no ID")
+	}
+	if endpoint.Secret == "" {
+		return fmt.Errorf("webhook endpoint %q has no signing secret", endpoint.ID)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	endpoints := n.endpoints[tenantID]
+	for i := range endpoints {
+		if endpoints[i].ID == endpoint.ID {
+			endpoints[i] = endpoint
+			return nil
+		}
+	}
+	n.endpoints[tenantID] = append(endpoints, endpoint)
+	return nil
+}
+
+// RemoveEndpoint unregisters a tenant's webhook endpoint
+func (n *Notifier) RemoveEndpoint(tenantID, endpointID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	endpoints := n.endpoints[tenantID]
+	for i := range endpoints {
+		if endpoints[i].ID == endpointID {
+			n.endpoints[tenantID] = append(endpoints[:i], endpoints[i+1:]...)
+			return
+		}
+	}
+}
+
+// Notify queues a notification for every endpoint of its tenant subscribed to its type.
+// ID and Timestamp are filled in when empty. It returns the notification ID.
+func (n *Notifier) Notify(notification Notification) string {
+	if notification.ID == "" {
+		notification.ID = newNotificationID()
+	}
+	if notification.Timestamp.IsZero() {
+		notification.Timestamp = n.now().UTC()
+	}
+
+	n.mu.RLock()
+	var targets []WebhookEndpoint
+	for _, endpoint := range n.endpoints[notification.TenantID] {
+		if len(endpoint.Types) == 0 || containsNotificationType(endpoint.Types, notification.Type) {
+			targets = append(targets, endpoint)
+		}
+	}
+	n.mu.RUnlock()
+
+	if len(targets) == 0 {
+		return notification.ID
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		for _, endpoint := range targets {
+			n.deadLetter(delivery{notification: notification, endpoint: endpoint}, 0, fmt.Sprintf("failed to encode notification: %v", err))
+		}
+		return notification.ID
+	}
+
+	for _, endpoint := range targets {
+		d := delivery{notification: notification, body: body, endpoint: endpoint}
+		if err := n.enqueue(d); err != nil {
+			n.deadLetter(d, 0, err.Error())
+		}
+	}
+
+	return notification.ID
+}
+
+// Flush waits until every queued delivery has been delivered or dead-lettered
+func (n *Notifier) Flush() {
+	n.pending.Wait()
+}
+
+// Close flushes queued deliveries and stops the workers. Later notifications are dead-lettered.
+func (n *Notifier) Close() error {
+	n.once.Do(func() {
+		n.mu.Lock()
+		n.closed = true
+		n.mu.Unlock()
+		close(n.done)
+	})
+	n.workers.Wait()
+	return nil
+}
+
+// DeliveryLog returns the most recent delivery attempts, oldest first
+func (n *Notifier) DeliveryLog() []DeliveryAttempt {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]DeliveryAttempt(nil), n.log...)
+}
+
+// DeadLetters returns the deliveries that exhausted their attempts or were rejected
+func (n *Notifier) DeadLetters() []DeadLetter {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]DeadLetter(nil), n.dead...)
+}
+
+// RedeliverDeadLetters requeues every dead letter to its endpoint, if the endpoint is
+// still registered, and returns how many were requeued. Letters that cannot be
+// requeued stay in the dead-letter queue.
+func (n *Notifier) RedeliverDeadLetters() int {
+	n.mu.Lock()
+	dead := n.dead
+	n.dead = nil
+	n.mu.Unlock()
+
+	requeued := 0
+	var kept []DeadLetter
+	for _, letter := range dead {
+		endpoint, found := n.endpoint(letter.TenantID, letter.EndpointID)
+		if !found {
+			kept = append(kept, letter)
+			continue
+		}
+		body, err := json.Marshal(letter.Notification)
+		if err != nil {
+			kept = append(kept, letter)
+			continue
+		}
+		if err := n.enqueue(delivery{notification: letter.Notification, body: body, endpoint: endpoint}); err != nil {
+			kept = append(kept, letter)
+			continue
+		}
+		requeued++
+	}
+
+	if len(kept) > 0 {
+		n.mu.Lock()
+		n.dead = append(kept, n.dead...)
+		n.mu.Unlock()
+	}
+	return requeued
+}
+
+// Stats returns a snapshot of the delivery counters
+func (n *Notifier) Stats() NotifierStats {
+	return NotifierStats{
+		Delivered:    n.delivered.Load(),
+		Retries:      n.retries.Load(),
+		DeadLettered: n.deadLettered.Load(),
+	}
+}
+
+// AlertSink adapts the notifier to an AlertSink, routing alerts by their tenant label
+func (n *Notifier) AlertSink() AlertSink {
+	return notifierAlertSink{notifier: n}
+}
+
+type notifierAlertSink struct {
+	notifier *Notifier
+}
+
+func (s notifierAlertSink) Notify(ctx context.Context, alert Alert) error {
+	notificationType := NotificationAlertFiring
+	if alert.Status == AlertResolved {
+		notificationType = NotificationAlertResolved
+	}
+	s.notifier.Notify(Notification{
+		Type:      notificationType,
+		TenantID:  alert.Labels["tenant"],
+		ClusterID: alert.Labels["cluster"],
+		Data:      alert,
+	})
+	return nil
+}
+
+// enqueue hands a delivery to the workers without blocking
+func (n *Notifier) enqueue(d delivery) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.closed {
+		return errNotifierClosed
+	}
+
+	n.pending.Add(1)
+	select {
+	case n.queue <- d:
+		return nil
+	default:
+		n.pending.Done()
+		return errDeliveryQueueFull
+	}
+}
+
+func (n *Notifier) run() {
+	defer n.workers.Done()
+
+	for {
+		select {
+		case d := <-n.queue:
+			n.deliver(d)
+		case <-n.done:
+			// Drain whatever was queued before Close
+			for {
+				select {
+				case d := <-n.queue:
+					n.deliver(d)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (n *Notifier) deliver(d delivery) {
+	defer n.pending.Done()
+
+	backoff := n.config.MinBackoff
+	for attempt := 1; ; attempt++ {
+		started := n.now()
+		status, err := n.post(d)
+		n.record(DeliveryAttempt{
+			NotificationID: d.notification.ID,
+			Type:           d.notification.Type,
+			TenantID:       d.notification.TenantID,
+			EndpointID:     d.endpoint.ID,
+			Attempt:        attempt,
+			StatusCode:     status,
+			Error:          errorString(err),
+			Delivered:      err == nil,
+			Duration:       n.now().Sub(started),
+			Timestamp:      started,
+		})
+
+		if err == nil {
+			n.delivered.Add(1)
+			return
+		}
+
+		if _, retryable := err.(*retryableError); !retryable || attempt >= n.config.MaxAttempts {
+			n.deadLetter(d, attempt, err.Error())
+			return
+		}
+
+		n.retries.Add(1)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > n.config.MaxBackoff {
+			backoff = n.config.MaxBackoff
+		}
+	}
+}
+
+func (n *Notifier) post(d delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hpa-integration-tests")
+	req.Header.Set(HeaderNotificationID, d.notification.ID)
+	req.Header.Set(HeaderNotificationType, string(d.notification.Type))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, SignPayload(d.endpoint.Secret, timestamp, d.body))
+
+	resp, err := n.config.Client.Do(req)
+	if err != nil {
+		return 0, &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, nil
+	}
+
+	err = fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return resp.StatusCode, &retryableError{err: err}
+	}
+	return resp.StatusCode, err
+}
+
+func (n *Notifier) record(attempt DeliveryAttempt) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.log = append(n.log, attempt)
+	if over := len(n.log) - n.config.LogSize; over > 0 {
+		n.log = append(n.log[:0:0], n.log[over:]...)
+	}
+}
+
+func (n *Notifier) deadLetter(d delivery, attempts int, reason string) {
+	n.deadLettered.Add(1)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dead = append(n.dead, DeadLetter{
+		Notification: d.notification,
+		TenantID:     d.notification.TenantID,
+		EndpointID:   d.endpoint.ID,
+		Attempts:     attempts,
+		LastError:    reason,
+		FailedAt:     n.now(),
+	})
+}
+
+func (n *Notifier) endpoint(tenantID, endpointID string) (WebhookEndpoint, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for _, endpoint := range n.endpoints[tenantID] {
+		if endpoint.ID == endpointID {
+			return endpoint, true
+		}
+	}
+	return WebhookEndpoint{}, false
+}
+
+// SignPayload returns the HeaderSignature value for a body sent at timestamp
+func SignPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a HeaderSignature value in constant time
+func VerifySignature(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignPayload(secret, timestamp, body)), []byte(signature))
+}
+
+func newNotificationID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func containsNotificationType(types []NotificationType, t NotificationType) bool {
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// ClusterNotification is the data of cluster lifecycle notifications
+type ClusterNotification struct {
+	ClusterID      string            `json:"clusterId"`
+	Status         string            `json:"status"`
+	PreviousStatus string            `json:"previousStatus,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// ScalingIntentNotification is the data of scaling intent notifications
+type ScalingIntentNotification struct {
+	IntentID       string `json:"intentId"`
+	Namespace      string `json:"namespace"`
+	Workload       string `json:"workload"`
+	WorkloadType   string `json:"workloadType"`
+	TargetReplicas int32  `json:"targetReplicas"`
+	Reason         string `json:"reason,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
@@ -0,0 +1,241 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// signedReceiver verifies webhook signatures and answers with scripted status codes
+type signedReceiver struct {
+	secret        string
+	statuses      []int // Returned in order; 200 once exhausted
+	notifications []Notification
+	badSignatures int
+	mu            sync.Mutex
+}
+
+func (r *signedReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !VerifySignature(r.secret, req.Header.Get(HeaderTimestamp), body, req.Header.Get(HeaderSignature)) {
+		r.badSignatures++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	var notification Notification
+	if err := json.Unmarshal(body, &notification); err != nil || notification.ID != req.Header.Get(HeaderNotificationID) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.notifications = append(r.notifications, notification)
+}
+
+func (r *signedReceiver) received() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Notification(nil), r.notifications...)
+}
+
+func (r *signedReceiver) types() []NotificationType {
+	var types []NotificationType
+	for _, notification := range r.received() {
+		types = append(types, notification.Type)
+	}
+	return types
+}
+
+// TestNotifierClusterAndScalingHooks tests notifications from the cluster service and scaling path
+func TestNotifierClusterAndScalingHooks(t *testing.T) {
+	all := &signedReceiver{secret: "tenant-a-secret"}
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+	clustersOnly := &signedReceiver{secret: "tenant-a-ops"}
+	clustersServer := httptest.NewServer(clustersOnly)
+	defer clustersServer.Close()
+	other := &signedReceiver{secret: "tenant-b-secret"}
+	otherServer := httptest.NewServer(other)
+	defer otherServer.Close()
+
+	notifier := NewNotifier(NotifierConfig{Workers: 1})
+	defer notifier.Close()
+	require.NoError(t, notifier.AddEndpoint("tenant-a", WebhookEndpoint{ID: "all", URL: allServer.URL, Secret: "tenant-a-secret"}))
+	require.NoError(t, notifier.AddEndpoint("tenant-a", WebhookEndpoint{
+		ID:     "ops",
+		URL:    clustersServer.URL,
+		Secret: "tenant-a-ops",
+		Types:  []NotificationType{NotificationClusterRegistered, NotificationClusterStatusChanged},
+	}))
+	require.NoError(t, notifier.AddEndpoint("tenant-b", WebhookEndpoint{ID: "all", URL: otherServer.URL, Secret: "tenant-b-secret"}))
+	require.Error(t, notifier.AddEndpoint("tenant-a", WebhookEndpoint{ID: "nosecret", URL: allServer.URL}))
+	require.Error(t, notifier.AddEndpoint("tenant-a", WebhookEndpoint{ID: "badurl", URL: "ftp://example", Secret: "s"}))
+
+	clusterService := NewMockClusterService()
+	clusterService.SetNotifier(notifier)
+	_, err := clusterService.RegisterCluster(context.Background(), &agentv1.RegisterClusterRequest{
+		Name:     "prod-eu",
+		TenantId: "tenant-a",
+		Labels:   map[string]string{"region": "eu-west-1"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, clusterService.UpdateClusterStatus("prod-eu", "active"), "unchanged status is not notified")
+	require.NoError(t, clusterService.UpdateClusterStatus("prod-eu", "disconnected"))
+
+	intents := NewMockScalingIntentHandler()
+	intents.SetNotifier(notifier, "tenant-a", "prod-eu")
+	intent := NewTestDataGenerator().GenerateScalingIntent("prod-eu")
+	intents.HandleScalingIntent(intent)
+	require.True(t, intents.CompleteIntent(intent.IntentId, nil))
+	require.True(t, intents.CompleteIntent(intent.IntentId, errors.New("deployment not found")))
+	require.False(t, intents.CompleteIntent("unknown", nil))
+
+	notifier.Flush()
+
+	require.Equal(t, []NotificationType{
+		NotificationClusterRegistered,
+		NotificationClusterStatusChanged,
+		NotificationScalingIntentIssued,
+		NotificationScalingIntentCompleted,
+		NotificationScalingIntentFailed,
+	}, all.types())
+	require.Equal(t, []NotificationType{NotificationClusterRegistered, NotificationClusterStatusChanged}, clustersOnly.types())
+	require.Empty(t, other.received())
+	require.Zero(t, all.badSignatures)
+
+	notifications := all.received()
+	require.Equal(t, "tenant-a", notifications[0].TenantID)
+	require.Equal(t, "prod-eu", notifications[0].ClusterID)
+	require.Equal(t, map[string]interface{}{"clusterId": "prod-eu", "status": "active", "labels": map[string]interface{}{"region": "eu-west-1"}}, notifications[0].Data)
+	require.Equal(t, "active", notifications[1].Data.(map[string]interface{})["previousStatus"])
+	require.Equal(t, "deployment not found", notifications[4].Data.(map[string]interface{})["error"])
+	require.Equal(t, float64(5), notifications[2].Data.(map[string]interface{})["targetReplicas"])
+
+	require.Equal(t, NotifierStats{Delivered: 7}, notifier.Stats())
+	require.Len(t, notifier.DeliveryLog(), 7)
+	require.Empty(t, notifier.DeadLetters())
+}
+
+// TestNotifierRetriesAndDeadLetters tests backoff retries, the dead-letter queue and redelivery
+func TestNotifierRetriesAndDeadLetters(t *testing.T) {
+	flaky := &signedReceiver{secret: "s", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	rejecting := &signedReceiver{secret: "s", statuses: []int{http.StatusBadRequest}}
+	rejectingServer := httptest.NewServer(rejecting)
+	defer rejectingServer.Close()
+	down := &signedReceiver{secret: "s", statuses: []int{500, 500, 500}}
+	downServer := httptest.NewServer(down)
+	defer downServer.Close()
+
+	notifier := NewNotifier(NotifierConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond})
+	defer notifier.Close()
+	require.NoError(t, notifier.AddEndpoint("flaky", WebhookEndpoint{ID: "flaky", URL: flakyServer.URL, Secret: "s"}))
+	require.NoError(t, notifier.AddEndpoint("rejecting", WebhookEndpoint{ID: "rejecting", URL: rejectingServer.URL, Secret: "s"}))
+	require.NoError(t, notifier.AddEndpoint("down", WebhookEndpoint{ID: "down", URL: downServer.URL, Secret: "s"}))
+	// A wrong secret is rejected by the receiver like any other client error
+	require.NoError(t, notifier.AddEndpoint("down", WebhookEndpoint{ID: "wrong-secret", URL: downServer.URL, Secret: "other"}))
+
+	for _, tenantID := range []string{"flaky", "rejecting", "down"} {
+		notifier.Notify(Notification{Type: NotificationClusterRegistered, TenantID: tenantID})
+	}
+	notifier.Flush()
+
+	require.Len(t, flaky.received(), 1)
+	require.Empty(t, rejecting.received())
+	require.Empty(t, down.received())
+	require.Equal(t, 1, down.badSignatures)
+
+	stats := notifier.Stats()
+	require.Equal(t, int64(1), stats.Delivered)
+	require.Equal(t, int64(4), stats.Retries)
+	require.Equal(t, int64(3), stats.DeadLettered)
+
+	dead := notifier.DeadLetters()
+	require.Len(t, dead, 3)
+	byEndpoint := make(map[string]DeadLetter)
+	for _, letter := range dead {
+		byEndpoint[letter.EndpointID] = letter
+	}
+	require.Equal(t, 1, byEndpoint["rejecting"].Attempts)
+	require.Equal(t, "webhook returned HTTP 400", byEndpoint["rejecting"].LastError)
+	require.Equal(t, 3, byEndpoint["down"].Attempts)
+	require.Equal(t, 1, byEndpoint["wrong-secret"].Attempts)
+
+	var flakyAttempts []int
+	for _, attempt := range notifier.DeliveryLog() {
+		if attempt.EndpointID == "flaky" {
+			flakyAttempts = append(flakyAttempts, attempt.StatusCode)
+		}
+	}
+	require.Equal(t, []int{503, 429, 200}, flakyAttempts)
+
+	// Once the endpoints recover, dead letters can be redelivered with the same ID
+	notifier.RemoveEndpoint("down", "wrong-secret")
+	require.Equal(t, 2, notifier.RedeliverDeadLetters())
+	notifier.Flush()
+	require.Len(t, rejecting.received(), 1)
+	require.Len(t, down.received(), 1)
+	require.Equal(t, byEndpoint["down"].Notification.ID, down.received()[0].ID)
+
+	// Letters of removed endpoints stay dead until the endpoint is back
+	kept := notifier.DeadLetters()
+	require.Len(t, kept, 1)
+	require.Equal(t, "wrong-secret", kept[0].EndpointID)
+	require.Zero(t, notifier.RedeliverDeadLetters())
+	require.Len(t, notifier.DeadLetters(), 1)
+	require.NoError(t, notifier.AddEndpoint("down", WebhookEndpoint{ID: "wrong-secret", URL: downServer.URL, Secret: "s"}))
+	require.Equal(t, 1, notifier.RedeliverDeadLetters())
+	notifier.Flush()
+	require.Len(t, down.received(), 2)
+	require.Empty(t, notifier.DeadLetters())
+
+	require.NoError(t, notifier.Close())
+	notifier.Notify(Notification{Type: NotificationClusterRegistered, TenantID: "flaky"})
+	require.Len(t, notifier.DeadLetters(), 1)
+	require.Equal(t, "notifier is closed", notifier.DeadLetters()[0].LastError)
+}
+
+// TestNotifierAlertSink tests alert delivery through the notifier
+func TestNotifierAlertSink(t *testing.T) {
+	receiver := &signedReceiver{secret: "s"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	notifier := NewNotifier(NotifierConfig{})
+	defer notifier.Close()
+	require.NoError(t, notifier.AddEndpoint("tenant-1", WebhookEndpoint{ID: "alerts", URL: server.URL, Secret: "s", Types: []NotificationType{NotificationAlertFiring}}))
+
+	engine, err := NewEventRuleEngine([]EventRule{{Name: "FailedMount", Reasons: []string{"FailedMount"}}}, notifier.AlertSink())
+	require.NoError(t, err)
+	engine.IngestEvents("tenant-1", NewTestDataGenerator().GenerateEventReport("cluster-1", 15))
+	notifier.Flush()
+
+	notifications := receiver.received()
+	require.Len(t, notifications, 1)
+	require.Equal(t, NotificationAlertFiring, notifications[0].Type)
+	require.Equal(t, "cluster-1", notifications[0].ClusterID)
+	require.Equal(t, "FailedMount", notifications[0].Data.(map[string]interface{})["labels"].(map[string]interface{})["alertname"])
+}
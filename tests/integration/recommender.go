@@ -0,0 +1,561 @@
+package integration
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// DefaultScalingTolerance matches the default of the Kubernetes HPA controller
+const DefaultScalingTolerance = 0.1
+
+// scalableWorkloadTypes are the workload types with a scale subresource
+var scalableWorkloadTypes = map[string]bool{
+	"deployment":  true,
+	"statefulset": true,
+	"replicaset":  true,
+}
+
+// MetricTargetType is how a metric value is compared to its target, like autoscaling/v2 MetricTarget
+type MetricTargetType string
+
+const (
+	// TargetUtilization compares a percentage averaged over the available replicas
+	TargetUtilization MetricTargetType = "Utilization"
+	// TargetAverageValue divides the workload total by the replicas before comparing
+	TargetAverageValue MetricTargetType = "AverageValue"
+	// TargetValue compares the workload total directly
+	TargetValue MetricTargetType = "Value"
+)
+
+// MetricTarget is the target of one metric, either a usage field such as
+// cpu_percentage or any key of WorkloadMetric.CustomMetrics
+type MetricTarget struct {
+	Metric string
+	Type   MetricTargetType
+	Value  float64
+}
+
+func (t MetricTarget) validate() error {
+	if t.Metric == "" {
+		return fmt.Errorf("metric target name is required")
+	}
+	switch t.Type {
+	case TargetUtilization, TargetAverageValue, TargetValue:
+	default:
+		return fmt.Errorf("metric %s: unknown target type %q", t.Metric, t.Type)
+	}
+	if t.Value <= 0 {
+		return fmt.Errorf("metric %s: target value must be positive", t.Metric)
+	}
+	return nil
+}
+
+// RecommenderConfig sets the targets of the replica recommender. The CPU and memory
+// fields are shorthands for Utilization targets; a zero value disables them.
+type RecommenderConfig struct {
+	TargetCPUPercentage    float64
+	TargetMemoryPercentage float64
+	// Metrics are additional targets; like the HPA, the largest recommendation wins
+	Metrics []MetricTarget
+	// Tolerance is the relative deviation from a target that is ignored; defaults to 0.1
+	Tolerance float64
+	// Strategy is copied into every emitted intent
+	Strategy *agent.ScalingStrategy
+}
+
+// Recommendation is the desired replica count of one workload with the metric that drove it
+type Recommendation struct {
+	ClusterID         string
+	Namespace         string
+	Workload          string
+	WorkloadType      string
+	CurrentReplicas   int32
+	AvailableReplicas int32
+	DesiredReplicas   int32
+	Metric            string
+	TargetType        MetricTargetType
+	Usage             float64
+	Target            float64
+	Reason            string
+	// Window is the rollup window Usage is averaged over, zero for the reported value
+	Window time.Duration
+	// Forecast is set when a predicted rather than the observed value drove the recommendation
+	Forecast *Forecast
+	// Limits are the scaling policy constraints that changed DesiredReplicas
+	Limits []PolicyLimit
+}
+
+// ScalingIntentSink receives the intents emitted for a cluster
+type ScalingIntentSink interface {
+	SendScalingIntent(clusterID string, intent *agent.ScalingIntent)
+}
+
+// ScalingIntentSinkFunc adapts a function to a ScalingIntentSink
+type ScalingIntentSinkFunc func(clusterID string, intent *agent.ScalingIntent)
+
+func (f ScalingIntentSinkFunc) SendScalingIntent(clusterID string, intent *agent.ScalingIntent) {
+	f(clusterID, intent)
+}
+
+// ReplicaRecommender applies the HPA formula desired = ceil(current * usage / target)
+// to every metric target of an ingested workload, the CPU and memory targets as well
+// as custom metric targets, and keeps the largest result. With rollups, usage is
+// averaged over a sliding window rather than taken from the last report. With a
+// Forecaster, the forecasted usage replaces or is blended with the reported one. A
+// ScalingIntent is emitted whenever the desired replica count differs from the
+// current one. It implements MetricsSink.
+type ReplicaRecommender struct {
+	config    RecommenderConfig
+	targets   []MetricTarget
+	sinks     []ScalingIntentSink
+	policies  *ScalingPolicyEngine
+	scheduler *ScalingScheduler
+	audit     AuditRecorder
+	// forecaster and mode enable predictive scaling
+	forecaster *Forecaster
+	mode       PredictiveMode
+	// rollups and window average the usage of every target metric
+	rollups *RollupEngine
+	window  time.Duration
+	mu      sync.RWMutex
+}
+
+func NewReplicaRecommender(config RecommenderConfig) (*ReplicaRecommender, error) {
+	if config.TargetCPUPercentage < 0 || config.TargetMemoryPercentage < 0 {
+		return nil, fmt.Errorf("utilization targets must not be negative")
+	}
+	if config.Tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative")
+	}
+	if config.Tolerance == 0 {
+		config.Tolerance = DefaultScalingTolerance
+	}
+
+	var targets []MetricTarget
+	if config.TargetCPUPercentage > 0 {
+		targets = append(targets, MetricTarget{Metric: MetricCPUPercentage, Type: TargetUtilization, Value: config.TargetCPUPercentage})
+	}
+	if config.TargetMemoryPercentage > 0 {
+		targets = append(targets, MetricTarget{Metric: MetricMemoryPercentage, Type: TargetUtilization, Value: config.TargetMemoryPercentage})
+	}
+	for _, target := range config.Metrics {
+		if err := target.validate(); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("at least one metric target is required")
+	}
+
+	return &ReplicaRecommender{config: config, targets: targets}, nil
+}
+
+// AddIntentSink registers a receiver of emitted intents
+func (r *ReplicaRecommender) AddIntentSink(sink ScalingIntentSink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sinks = append(r.sinks, sink)
+}
+
+// SetPolicyEngine routes every recommendation through the scaling policies before
+// an intent is emitted
+func (r *ReplicaRecommender) SetPolicyEngine(engine *ScalingPolicyEngine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policies = engine
+}
+
+// SetScheduler bounds recommendations by the active scaling schedules, after the
+// scaling policies
+func (r *ReplicaRecommender) SetScheduler(scheduler *ScalingScheduler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scheduler = scheduler
+}
+
+// SetAuditRecorder records every recommendation that produces an intent
+func (r *ReplicaRecommender) SetAuditRecorder(recorder AuditRecorder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audit = recorder
+}
+
+// SetForecaster enables predictive scaling on the forecasted metric, which needs a
+// Utilization target. Until a workload has enough history for a model, its reactive
+// recommendation is used in either mode.
+func (r *ReplicaRecommender) SetForecaster(forecaster *Forecaster, mode PredictiveMode) error {
+	if mode != PredictiveOnly && mode != PredictiveBlended {
+		return fmt.Errorf("unknown predictive mode %q", mode)
+	}
+	if _, ok := r.utilizationTarget(forecaster.config.Metric); !ok {
+		return fmt.Errorf("no utilization target for forecasted metric %s", forecaster.config.Metric)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.forecaster = forecaster
+	r.mode = mode
+	return nil
+}
+
+// SetRollups sizes workloads on the average of each metric over a rollup window
+// instead of the last reported value, smoothing out single-report spikes. Metrics
+// without a rollup fall back to the reported value. The RollupEngine must be added
+// as a metrics sink before the recommender, so that the averages include the
+// report being ingested.
+func (r *ReplicaRecommender) SetRollups(rollups *RollupEngine, window time.Duration) error {
+	if !rollups.HasWindow(window) {
+		return fmt.Errorf("rollups have no %s window", window)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rollups = rollups
+	r.window = window
+	return nil
+}
+
+func (r *ReplicaRecommender) utilizationTarget(metric string) (MetricTarget, bool) {
+	for _, target := range r.targets {
+		if target.Metric == metric && target.Type == TargetUtilization {
+			return target, true
+		}
+	}
+	return MetricTarget{}, false
+}
+
+// Ingest emits an intent for every workload of the report whose recommendation
+// differs from its current replica count. With a policy engine, every recommendation
+// is decided on, including unchanged ones, so that stabilization sees the full history.
+func (r *ReplicaRecommender) Ingest(tenantID string, report *agent.MetricsReport) {
+	r.mu.RLock()
+	sinks := append([]ScalingIntentSink(nil), r.sinks...)
+	policies, scheduler, audit := r.policies, r.scheduler, r.audit
+	forecaster, mode := r.forecaster, r.mode
+	r.mu.RUnlock()
+
+	ts := reportTime(report)
+	for _, recommendation := range r.recommendAll(tenantID, report) {
+		if forecaster != nil {
+			recommendation = r.predict(forecaster, mode, tenantID, recommendation, ts)
+		}
+		key := WorkloadKey{
+			TenantID:  tenantID,
+			ClusterID: report.ClusterId,
+			Namespace: recommendation.Namespace,
+			Workload:  recommendation.Workload,
+		}
+		var bound ReplicaBound
+		if scheduler != nil {
+			bound = func(replicas int32) (int32, PolicyLimit, bool) {
+				return scheduler.Bound(key, replicas, ts)
+			}
+		}
+		switch {
+		case policies != nil:
+			// The schedule bounds the decision itself, so that the scale event the
+			// policies record for later rate limits is the target actually sent
+			recommendation = recommendation.withDecision(policies.DecideWithin(key, recommendation.CurrentReplicas, recommendation.DesiredReplicas, ts, bound))
+		case bound != nil:
+			if target, limit, ok := bound(recommendation.DesiredReplicas); ok {
+				recommendation.DesiredReplicas = target
+				recommendation.Limits = append(recommendation.Limits, limit)
+				recommendation.Reason += "; " + limit.String()
+			}
+		}
+		if recommendation.DesiredReplicas == recommendation.CurrentReplicas {
+			continue
+		}
+
+		intent := recommendation.Intent(r.config.Strategy)
+		if audit != nil {
+			audit.Record(recommendation.auditEntry(tenantID, report, intent))
+		}
+		for _, sink := range sinks {
+			sink.SendScalingIntent(report.ClusterId, intent)
+		}
+	}
+}
+
+// RecommendReport returns the recommendations of the report that would change a workload
+func (r *ReplicaRecommender) RecommendReport(tenantID string, report *agent.MetricsReport) []Recommendation {
+	var result []Recommendation
+	for _, recommendation := range r.recommendAll(tenantID, report) {
+		if recommendation.DesiredReplicas != recommendation.CurrentReplicas {
+			result = append(result, recommendation)
+		}
+	}
+	return result
+}
+
+func (r *ReplicaRecommender) recommendAll(tenantID string, report *agent.MetricsReport) []Recommendation {
+	r.mu.RLock()
+	rollups, window := r.rollups, r.window
+	r.mu.RUnlock()
+
+	var result []Recommendation
+	for _, workload := range report.WorkloadMetrics {
+		values := workloadValues(workload)
+		averaged := time.Duration(0)
+		if rollups != nil {
+			averaged = r.averageUsage(rollups, window, SeriesKey{
+				TenantID:  tenantID,
+				ClusterID: report.ClusterId,
+				Namespace: workload.Namespace,
+				Workload:  workload.WorkloadName,
+			}, values)
+		}
+		recommendation, ok := r.recommend(workload, values, averaged)
+		if !ok {
+			continue
+		}
+		recommendation.ClusterID = report.ClusterId
+		result = append(result, recommendation)
+	}
+	return result
+}
+
+// averageUsage replaces the reported values of the target metrics by their rollup
+// averages, and returns the window if any value was replaced
+func (r *ReplicaRecommender) averageUsage(rollups *RollupEngine, window time.Duration, key SeriesKey, values map[string]float64) time.Duration {
+	averaged := false
+	for _, target := range r.targets {
+		if _, ok := values[target.Metric]; !ok {
+			continue
+		}
+		key.Metric = target.Metric
+		if rollup, ok := rollups.Get(key, window); ok && rollup.Count > 0 {
+			values[target.Metric] = rollup.Avg
+			averaged = true
+		}
+	}
+	if !averaged {
+		return 0
+	}
+	return window
+}
+
+// predict replaces or blends a reactive recommendation with one based on the
+// forecasted usage Lead after now
+func (r *ReplicaRecommender) predict(forecaster *Forecaster, mode PredictiveMode, tenantID string, reactive Recommendation, now time.Time) Recommendation {
+	key := SeriesKey{
+		TenantID:  tenantID,
+		ClusterID: reactive.ClusterID,
+		Namespace: reactive.Namespace,
+		Workload:  reactive.Workload,
+	}
+	forecast, err := forecaster.Predict(key, now)
+	if err != nil {
+		return reactive
+	}
+
+	target, _ := r.utilizationTarget(forecaster.config.Metric)
+	usage := forecast.Value
+	if forecaster.config.UseUpperBound {
+		usage = forecast.Upper
+	}
+	// The forecast is for the workload as a whole, so unavailable replicas do not apply
+	desired := replicasForUtilization(reactive.CurrentReplicas, reactive.CurrentReplicas, usage, target.Value, r.config.Tolerance)
+
+	predicted := reactive
+	predicted.Metric = target.Metric
+	predicted.TargetType = TargetUtilization
+	predicted.Usage = usage
+	predicted.Target = target.Value
+	predicted.DesiredReplicas = desired
+	predicted.Forecast = &forecast
+	predicted.Reason = predictionReason(predicted, forecast, forecaster.config.Lead)
+
+	if mode == PredictiveBlended && reactive.DesiredReplicas >= predicted.DesiredReplicas {
+		return reactive
+	}
+	return predicted
+}
+
+func predictionReason(rec Recommendation, forecast Forecast, lead time.Duration) string {
+	direction := rec.direction()
+	return fmt.Sprintf("%s forecast at %.1f%% in %s (%.1f%%-%.1f%%) is %s the %.1f%% target: %d -> %d replicas",
+		rec.Metric, rec.Usage, lead, forecast.Lower, forecast.Upper, direction, rec.Target, rec.CurrentReplicas, rec.DesiredReplicas)
+}
+
+// withDecision applies a scaling policy decision and explains the clamp in the reason
+func (rec Recommendation) withDecision(decision ScalingDecision) Recommendation {
+	rec.DesiredReplicas = decision.TargetReplicas
+	rec.Limits = decision.Limits
+	if limit, ok := decision.ClampedBy(); ok {
+		rec.Reason += "; " + limit.String()
+	}
+	return rec
+}
+
+// Recommend computes the desired replicas of one workload. Like the HPA, every
+// target whose metric the workload reports is evaluated and the largest
+// recommendation wins. It returns false when the workload cannot be evaluated,
+// e.g. it is a DaemonSet, is scaled to zero or reports none of the metrics.
+func (r *ReplicaRecommender) Recommend(workload *agent.WorkloadMetric) (Recommendation, bool) {
+	return r.recommend(workload, workloadValues(workload), 0)
+}
+
+// recommend evaluates the targets on values, which are averaged over window if it is not zero
+func (r *ReplicaRecommender) recommend(workload *agent.WorkloadMetric, values map[string]float64, window time.Duration) (Recommendation, bool) {
+	if !scalableWorkloadTypes[strings.ToLower(workload.WorkloadType)] {
+		return Recommendation{}, false
+	}
+	if workload.Replicas <= 0 || workload.AvailableReplicas <= 0 {
+		return Recommendation{}, false
+	}
+
+	best := Recommendation{}
+	found := false
+	for _, target := range r.targets {
+		value, ok := values[target.Metric]
+		if !ok {
+			continue
+		}
+		desired := replicasForTarget(workload.Replicas, workload.AvailableReplicas, value, target, r.config.Tolerance)
+		if !found || desired > best.DesiredReplicas {
+			best = Recommendation{
+				Metric:          target.Metric,
+				TargetType:      target.Type,
+				Usage:           value,
+				Target:          target.Value,
+				Window:          window,
+				DesiredReplicas: desired,
+			}
+			found = true
+		}
+	}
+	if !found {
+		return Recommendation{}, false
+	}
+
+	best.Namespace = workload.Namespace
+	best.Workload = workload.WorkloadName
+	best.WorkloadType = workload.WorkloadType
+	best.CurrentReplicas = workload.Replicas
+	best.AvailableReplicas = workload.AvailableReplicas
+	best.Reason = recommendationReason(best, workload.AvailableReplicas)
+	return best, true
+}
+
+// replicasForTarget applies the HPA formula for the target type. Utilization is an
+// average over the available replicas, AverageValue divides the workload total by
+// the target per replica, and Value scales the current replicas by the ratio.
+func replicasForTarget(replicas, available int32, value float64, target MetricTarget, tolerance float64) int32 {
+	switch target.Type {
+	case TargetAverageValue:
+		ratio := value / (target.Value * float64(available))
+		if math.Abs(ratio-1) <= tolerance {
+			return replicas
+		}
+		return maxInt32(1, int32(math.Ceil(value/target.Value)))
+	case TargetValue:
+		ratio := value / target.Value
+		if math.Abs(ratio-1) <= tolerance {
+			return replicas
+		}
+		return maxInt32(1, int32(math.Ceil(ratio*float64(replicas))))
+	}
+	return replicasForUtilization(replicas, available, value, target.Value, tolerance)
+}
+
+// replicasForUtilization follows the HPA controller: the usage ratio is applied to the
+// available replicas, and replicas that are not yet available are assumed to use
+// nothing when scaling up and exactly the target when scaling down, so that they
+// dampen rather than amplify the change
+func replicasForUtilization(replicas, available int32, usage, target, tolerance float64) int32 {
+	ratio := usage / target
+	if math.Abs(ratio-1) <= tolerance {
+		return replicas
+	}
+
+	if missing := replicas - available; missing > 0 {
+		total := usage * float64(available)
+		if ratio < 1 {
+			total += target * float64(missing)
+		}
+		adjusted := total / float64(replicas) / target
+		// Do not scale if the unavailable replicas flip or cancel the direction
+		if math.Abs(adjusted-1) <= tolerance || (ratio > 1) != (adjusted > 1) {
+			return replicas
+		}
+		return maxInt32(1, int32(math.Ceil(adjusted*float64(replicas))))
+	}
+
+	return maxInt32(1, int32(math.Ceil(ratio*float64(available))))
+}
+
+// direction describes the observed value relative to the target
+func (rec Recommendation) direction() string {
+	switch {
+	case rec.DesiredReplicas > rec.CurrentReplicas:
+		return "above"
+	case rec.DesiredReplicas < rec.CurrentReplicas:
+		return "below"
+	}
+	return "within tolerance of"
+}
+
+func recommendationReason(rec Recommendation, available int32) string {
+	direction := rec.direction()
+	metric := rec.Metric
+	if rec.Window > 0 {
+		metric = fmt.Sprintf("%s averaged over %s", rec.Metric, rec.Window)
+	}
+
+	var observed, target string
+	switch rec.TargetType {
+	case TargetAverageValue:
+		observed = fmt.Sprintf("%s at %s (%s per each of %d available replicas)",
+			metric, formatAlertValue(rec.Usage), formatAlertValue(rec.Usage/float64(available)), available)
+		target = fmt.Sprintf("average target of %s", formatAlertValue(rec.Target))
+	case TargetValue:
+		observed = fmt.Sprintf("%s at %s", metric, formatAlertValue(rec.Usage))
+		target = fmt.Sprintf("target of %s", formatAlertValue(rec.Target))
+	default:
+		observed = fmt.Sprintf("%s at %.1f%% across %d available replicas", metric, rec.Usage, available)
+		target = fmt.Sprintf("%.1f%% target", rec.Target)
+	}
+	return fmt.Sprintf("%s is %s the %s: %d -> %d replicas", observed, direction, target, rec.CurrentReplicas, rec.DesiredReplicas)
+}
+
+// Intent converts the recommendation to a ScalingIntent with a fresh ID
+func (rec Recommendation) Intent(strategy *agent.ScalingStrategy) *agent.ScalingIntent {
+	intent := &agent.ScalingIntent{
+		IntentId:          newIntentID(),
+		WorkloadNamespace: rec.Namespace,
+		WorkloadName:      rec.Workload,
+		WorkloadType:      rec.WorkloadType,
+		TargetReplicas:    rec.DesiredReplicas,
+		Reason:            rec.Reason,
+	}
+	if strategy != nil {
+		intent.Strategy = &agent.ScalingStrategy{
+			Type:           strategy.Type,
+			MaxSurge:       strategy.MaxSurge,
+			MaxUnavailable: strategy.MaxUnavailable,
+		}
+	}
+	return intent
+}
+
+func newIntentID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return "intent-" + hex.EncodeToString(id[:])
+}
+
+func maxInt32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,226 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+func recommenderWorkload(replicas, available int32, cpu, memory float64) *agentv1.WorkloadMetric {
+	return &agentv1.WorkloadMetric{
+		Namespace:         "production",
+		WorkloadName:      "webapp-frontend",
+		WorkloadType:      "deployment",
+		Replicas:          replicas,
+		AvailableReplicas: available,
+		Usage:             &agentv1.ResourceUsage{CpuPercentage: cpu, MemoryPercentage: memory},
+	}
+}
+
+// TestReplicaRecommenderFormula tests the HPA formula, tolerance and unavailable replicas
+func TestReplicaRecommenderFormula(t *testing.T) {
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 50, TargetMemoryPercentage: 80})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		workload  *agentv1.WorkloadMetric
+		desired   int32
+		metric    string
+		evaluated bool
+	}{
+		{"scale up on cpu", recommenderWorkload(3, 3, 90, 40), 6, MetricCPUPercentage, true},
+		{"memory wins when higher", recommenderWorkload(2, 2, 50, 170), 5, MetricMemoryPercentage, true},
+		{"within tolerance", recommenderWorkload(4, 4, 54, 84), 4, MetricCPUPercentage, true},
+		{"scale down to the larger recommendation", recommenderWorkload(6, 6, 10, 40), 3, MetricMemoryPercentage, true},
+		{"never below one replica", recommenderWorkload(2, 2, 1, 1), 1, MetricCPUPercentage, true},
+		{"unavailable replicas dampen scale up", recommenderWorkload(3, 2, 150, 0), 6, MetricCPUPercentage, true},
+		{"unavailable replicas cancel scale up", recommenderWorkload(4, 2, 60, 0), 4, MetricCPUPercentage, true},
+		{"unavailable replicas dampen scale down", recommenderWorkload(4, 2, 10, 80), 4, MetricMemoryPercentage, true},
+		{"scaled to zero", recommenderWorkload(0, 0, 0, 0), 0, "", false},
+		{"nothing available", recommenderWorkload(2, 0, 90, 90), 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recommendation, ok := recommender.Recommend(tt.workload)
+			require.Equal(t, tt.evaluated, ok)
+			if !ok {
+				return
+			}
+			require.Equal(t, tt.desired, recommendation.DesiredReplicas)
+			require.Equal(t, tt.metric, recommendation.Metric)
+		})
+	}
+
+	daemonset := recommenderWorkload(3, 3, 99, 99)
+	daemonset.WorkloadType = "daemonset"
+	_, ok := recommender.Recommend(daemonset)
+	require.False(t, ok)
+
+	_, err = NewReplicaRecommender(RecommenderConfig{})
+	require.Error(t, err)
+	_, err = NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 50, Tolerance: -1})
+	require.Error(t, err)
+}
+
+// TestReplicaRecommenderEmitsIntents tests intents generated from the generator's fixtures
+func TestReplicaRecommenderEmitsIntents(t *testing.T) {
+	recommender, err := NewReplicaRecommender(RecommenderConfig{
+		TargetCPUPercentage:    60,
+		TargetMemoryPercentage: 80,
+		Strategy:               &agentv1.ScalingStrategy{Type: "rolling", MaxSurge: 1},
+	})
+	require.NoError(t, err)
+
+	handler := NewMockScalingIntentHandler()
+	var clusters []string
+	recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		clusters = append(clusters, clusterID)
+		handler.HandleScalingIntent(intent)
+	}))
+
+	metricsService := NewMockMetricsService()
+	metricsService.AddMetricsSink(recommender)
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)))
+
+	intents := handler.GetReceivedIntents()
+	require.Len(t, intents, 3)
+	require.Equal(t, []string{"cluster-1", "cluster-1", "cluster-1"}, clusters)
+
+	targets := make(map[string]int32)
+	for _, intent := range intents {
+		targets[intent.WorkloadNamespace+"/"+intent.WorkloadName] = intent.TargetReplicas
+		require.NotEmpty(t, intent.IntentId)
+		require.Equal(t, "rolling", intent.Strategy.Type)
+	}
+	require.Equal(t, map[string]int32{
+		"kube-system/coredns":          1,
+		"monitoring/prometheus-server": 2,
+		"logging/elasticsearch":        5,
+	}, targets)
+	require.NotEqual(t, intents[0].IntentId, intents[1].IntentId)
+
+	for _, intent := range intents {
+		if intent.WorkloadName == "elasticsearch" {
+			require.Equal(t, "cpu_percentage at 89.2% across 3 available replicas is above the 60.0% target: 3 -> 5 replicas", intent.Reason)
+		}
+	}
+}
+
+// TestReplicaRecommenderCustomMetrics tests Value and AverageValue targets on custom metrics
+func TestReplicaRecommenderCustomMetrics(t *testing.T) {
+	recommender, err := NewReplicaRecommender(RecommenderConfig{
+		TargetCPUPercentage: 60,
+		Metrics: []MetricTarget{
+			{Metric: "requests_per_second", Type: TargetAverageValue, Value: 100},
+			{Metric: "queue_depth", Type: TargetValue, Value: 50},
+		},
+	})
+	require.NoError(t, err)
+
+	workload := func(rps, queue float64) *agentv1.WorkloadMetric {
+		w := recommenderWorkload(3, 3, 50, 0)
+		w.CustomMetrics = map[string]float64{"requests_per_second": rps, "queue_depth": queue}
+		return w
+	}
+
+	recommendation, ok := recommender.Recommend(workload(1000, 75))
+	require.True(t, ok)
+	require.Equal(t, int32(10), recommendation.DesiredReplicas)
+	require.Equal(t, "requests_per_second", recommendation.Metric)
+	require.Equal(t, TargetAverageValue, recommendation.TargetType)
+	require.Equal(t, "requests_per_second at 1000 (333.333 per each of 3 available replicas) is above the average target of 100: 3 -> 10 replicas", recommendation.Reason)
+
+	recommendation, ok = recommender.Recommend(workload(1000, 300))
+	require.True(t, ok)
+	require.Equal(t, int32(18), recommendation.DesiredReplicas)
+	require.Equal(t, "queue_depth at 300 is above the target of 50: 3 -> 18 replicas", recommendation.Reason)
+
+	// Both custom metrics within tolerance and cpu below target
+	recommendation, ok = recommender.Recommend(workload(310, 52))
+	require.True(t, ok)
+	require.Equal(t, int32(3), recommendation.DesiredReplicas)
+
+	// A workload without the custom metrics falls back to cpu
+	recommendation, ok = recommender.Recommend(recommenderWorkload(3, 3, 90, 0))
+	require.True(t, ok)
+	require.Equal(t, MetricCPUPercentage, recommendation.Metric)
+	require.Equal(t, int32(5), recommendation.DesiredReplicas)
+
+	customOnly, err := NewReplicaRecommender(RecommenderConfig{Metrics: []MetricTarget{{Metric: "queue_depth", Type: TargetValue, Value: 50}}})
+	require.NoError(t, err)
+	_, ok = customOnly.Recommend(recommenderWorkload(3, 3, 90, 90))
+	require.False(t, ok, "no configured metric is reported")
+
+	for _, target := range []MetricTarget{
+		{Type: TargetValue, Value: 1},
+		{Metric: "queue_depth", Type: "Total", Value: 1},
+		{Metric: "queue_depth", Type: TargetValue},
+	} {
+		_, err := NewReplicaRecommender(RecommenderConfig{Metrics: []MetricTarget{target}})
+		require.Error(t, err)
+	}
+}
+
+// TestReplicaRecommenderCustomMetricIntent tests an intent driven by a custom metric from the metrics pipeline
+func TestReplicaRecommenderCustomMetricIntent(t *testing.T) {
+	recommender, err := NewReplicaRecommender(RecommenderConfig{
+		Metrics: []MetricTarget{{Metric: "requests_per_second", Type: TargetAverageValue, Value: 100}},
+	})
+	require.NoError(t, err)
+
+	handler := NewMockScalingIntentHandler()
+	recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		handler.HandleScalingIntent(intent)
+	}))
+	metricsService := NewMockMetricsService()
+	metricsService.AddMetricsSink(recommender)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	report.WorkloadMetrics[5].CustomMetrics["requests_per_second"] = 900
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+
+	intents := handler.GetReceivedIntents()
+	require.Len(t, intents, 1)
+	require.Equal(t, "webapp-frontend", intents[0].WorkloadName)
+	require.Equal(t, int32(9), intents[0].TargetReplicas)
+	require.Contains(t, intents[0].Reason, "requests_per_second at 900")
+}
+
+// TestReplicaRecommenderReadsRollups tests that usage averaged over a rollup window drives the recommendation
+func TestReplicaRecommenderReadsRollups(t *testing.T) {
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 50})
+	require.NoError(t, err)
+	rollups := NewRollupEngine()
+	require.Error(t, recommender.SetRollups(rollups, 2*time.Minute))
+	require.NoError(t, recommender.SetRollups(rollups, 5*time.Minute))
+
+	handler := NewMockScalingIntentHandler()
+	recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		handler.HandleScalingIntent(intent)
+	}))
+	metricsService := NewMockMetricsService()
+	metricsService.AddMetricsSink(rollups)
+	metricsService.AddMetricsSink(recommender)
+
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i, cpu := range []float64{50, 50, 50, 50, 150} {
+		report := &agentv1.MetricsReport{
+			ClusterId:       "cluster-1",
+			Timestamp:       timestamppb.New(base.Add(time.Duration(i) * time.Minute)),
+			WorkloadMetrics: []*agentv1.WorkloadMetric{recommenderWorkload(3, 3, cpu, 40)},
+		}
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+	}
+
+	intents := handler.GetReceivedIntents()
+	require.Len(t, intents, 1, "the steady reports are within tolerance")
+	require.Equal(t, int32(5), intents[0].TargetReplicas, "a 70% average rather than the 150% spike")
+	require.Contains(t, intents[0].Reason, "cpu_percentage averaged over 5m0s at 70.0% across 3 available replicas")
+}
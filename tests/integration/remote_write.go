@@ -0,0 +1,295 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// RemoteWriteConfig configures forwarding of metrics reports as Prometheus remote-write
+type RemoteWriteConfig struct {
+	URL           string
+	BatchSize     int           // Max series per request
+	QueueSize     int           // Max series waiting to be sent; further series are dropped
+	FlushInterval time.Duration // Max time a partial batch waits before being sent
+	MaxRetries    int           // Retries of a batch after the first attempt
+	MinBackoff    time.Duration
+	MaxBackoff    time.Duration
+	Timeout       time.Duration // Per-request timeout
+	Headers       map[string]string
+	Client        *http.Client
+}
+
+// RemoteWriteStats counts what happened to forwarded series
+type RemoteWriteStats struct {
+	SeriesSent    int64
+	SeriesDropped int64 // Dropped because the queue was full
+	SeriesFailed  int64 // Dropped after a non-retryable error or exhausted retries
+	Requests      int64
+	Retries       int64
+}
+
+// remoteSeries is one remote-write time series with a single sample
+type remoteSeries struct {
+	labels      [][2]string // Sorted by name, including __name__
+	value       float64
+	timestampMs int64
+}
+
+// RemoteWriter forwards every ingested metrics report to a remote-write endpoint.
+// Series are queued without blocking ingestion and sent in batches by a background worker.
+type RemoteWriter struct {
+	config RemoteWriteConfig
+	queue  chan remoteSeries
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	sent     atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Int64
+	requests atomic.Int64
+	retries  atomic.Int64
+}
+
+// retryableError marks a failed request that may succeed if sent again
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// NewRemoteWriter validates the config, fills in defaults and starts the send loop
+func NewRemoteWriter(config RemoteWriteConfig) (*RemoteWriter, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote-write URL %q", config.URL)
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 30 * time.Millisecond
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 5 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{}
+	}
+
+	w := &RemoteWriter{
+		config: config,
+		queue:  make(chan remoteSeries, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w, nil
+}
+
+// Ingest queues every series of the report, dropping series the queue has no room for
+func (w *RemoteWriter) Ingest(tenantID string, report *agent.MetricsReport) {
+	timestampMs := reportTime(report).UnixMilli()
+
+	for _, metric := range reportSeries(tenantID, report) {
+		labels := append([][2]string{{"__name__", metric.name}}, metric.labels...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+
+		select {
+		case w.queue <- remoteSeries{labels: labels, value: metric.value, timestampMs: timestampMs}:
+		default:
+			w.dropped.Add(1)
+		}
+	}
+}
+
+// Close flushes queued series and stops the send loop
+func (w *RemoteWriter) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+	})
+	w.wg.Wait()
+	return nil
+}
+
+// Stats returns a snapshot of the forwarding counters
+func (w *RemoteWriter) Stats() RemoteWriteStats {
+	return RemoteWriteStats{
+		SeriesSent:    w.sent.Load(),
+		SeriesDropped: w.dropped.Load(),
+		SeriesFailed:  w.failed.Load(),
+		Requests:      w.requests.Load(),
+		Retries:       w.retries.Load(),
+	}
+}
+
+func (w *RemoteWriter) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]remoteSeries, 0, w.config.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			w.sendBatch(batch)
+			batch = make([]remoteSeries, 0, w.config.BatchSize)
+		}
+	}
+
+	for {
+		select {
+		case series := <-w.queue:
+			batch = append(batch, series)
+			if len(batch) >= w.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.done:
+			// Drain whatever was queued before Close
+			for {
+				select {
+				case series := <-w.queue:
+					batch = append(batch, series)
+					if len(batch) >= w.config.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *RemoteWriter) sendBatch(batch []remoteSeries) {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+	backoff := w.config.MinBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := w.post(body)
+		if err == nil {
+			w.sent.Add(int64(len(batch)))
+			return
+		}
+
+		if _, retryable := err.(*retryableError); !retryable || attempt >= w.config.MaxRetries {
+			w.failed.Add(int64(len(batch)))
+			return
+		}
+
+		w.retries.Add(1)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > w.config.MaxBackoff {
+			backoff = w.config.MaxBackoff
+		}
+	}
+}
+
+func (w *RemoteWriter) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "hpa-integration-tests")
+	for name, value := range w.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	w.requests.Add(1)
+	resp, err := w.config.Client.Do(req)
+	if err != nil {
+		return &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	err = fmt.Errorf("remote write returned HTTP %d", resp.StatusCode)
+	// Like Prometheus, retry server errors and rate limiting but not other client errors
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return &retryableError{err: err}
+	}
+	return err
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(batch []remoteSeries) []byte {
+	var out []byte
+
+	for _, series := range batch {
+		var ts []byte
+		for _, label := range series.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label[0])
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label[1])
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(series.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(series.timestampMs))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, ts)
+	}
+
+	return out
+}
@@ -0,0 +1,264 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receivedSeries is a decoded remote-write series with its single sample
+type receivedSeries struct {
+	labels      map[string]string
+	value       float64
+	timestampMs int64
+}
+
+// remoteWriteReceiver is an httptest handler that decodes remote-write requests
+type remoteWriteReceiver struct {
+	series   []receivedSeries
+	requests int
+	// failures is the number of requests to reject with failStatus before accepting
+	failures   int32
+	failStatus int
+	mu         sync.Mutex
+}
+
+func (r *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.AddInt32(&r.failures, -1) >= 0 {
+		w.WriteHeader(r.failStatus)
+		return
+	}
+
+	if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	compressed, _ := io.ReadAll(req.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	series, err := decodeWriteRequest(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	r.series = append(r.series, series...)
+	r.requests++
+	r.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *remoteWriteReceiver) received() ([]receivedSeries, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedSeries(nil), r.series...), r.requests
+}
+
+// decodeWriteRequest decodes the subset of prometheus.WriteRequest produced by RemoteWriter
+func decodeWriteRequest(b []byte) ([]receivedSeries, error) {
+	var result []receivedSeries
+
+	err := consumeFields(b, func(num protowire.Number, v []byte) error {
+		series := receivedSeries{labels: make(map[string]string)}
+		err := consumeFields(v, func(num protowire.Number, v []byte) error {
+			switch num {
+			case 1:
+				var name, value string
+				err := consumeFields(v, func(num protowire.Number, v []byte) error {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+					return nil
+				})
+				series.labels[name] = value
+				return err
+			case 2:
+				return decodeSample(v, &series)
+			}
+			return nil
+		})
+		result = append(result, series)
+		return err
+	})
+
+	return result, err
+}
+
+func decodeSample(b []byte, series *receivedSeries) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			bits, m := protowire.ConsumeFixed64(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			series.value = math.Float64frombits(bits)
+			b = b[m:]
+		case num == 2 && typ == protowire.VarintType:
+			ts, m := protowire.ConsumeVarint(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			series.timestampMs = int64(ts)
+			b = b[m:]
+		default:
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			b = b[m:]
+		}
+	}
+	return nil
+}
+
+// consumeFields walks the length-delimited fields of a message
+func consumeFields(b []byte, fn func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			return protowire.ParseError(-1)
+		}
+		v, m := protowire.ConsumeBytes(b)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		b = b[m:]
+
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestRemoteWriteForwardsReports tests that stored reports reach a remote-write receiver
+func TestRemoteWriteForwardsReports(t *testing.T) {
+	receiver := &remoteWriteReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriteConfig{URL: server.URL + "/api/v1/write", BatchSize: 50})
+	require.NoError(t, err)
+
+	metricsService := NewMockMetricsService()
+	metricsService.AddMetricsSink(writer)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 3)
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+	require.NoError(t, writer.Close())
+
+	series, requests := receiver.received()
+	expected := len(reportSeries("", report))
+	require.Len(t, series, expected)
+	require.Equal(t, (expected+49)/50, requests)
+
+	found := false
+	for _, s := range series {
+		if s.labels["__name__"] == "hpa_workload_cpu_percentage" && s.labels["workload"] == "nginx-ingress-controller" {
+			found = true
+			require.Equal(t, 45.2, s.value)
+			require.Equal(t, "default", s.labels["namespace"])
+			require.Equal(t, "deployment", s.labels["workload_type"])
+			require.Equal(t, report.Timestamp.AsTime().UnixMilli(), s.timestampMs)
+		}
+	}
+	require.True(t, found, "expected CPU series for nginx-ingress-controller")
+
+	stats := writer.Stats()
+	require.Equal(t, int64(expected), stats.SeriesSent)
+	require.Zero(t, stats.SeriesDropped)
+	require.Zero(t, stats.SeriesFailed)
+}
+
+// TestRemoteWriteRetriesAndFailures tests retry on server errors and giving up on client errors
+func TestRemoteWriteRetriesAndFailures(t *testing.T) {
+	receiver := &remoteWriteReceiver{failures: 2, failStatus: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriteConfig{URL: server.URL, MaxRetries: 3, MinBackoff: time.Millisecond})
+	require.NoError(t, err)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 1)
+	writer.Ingest("tenant-1", report)
+	require.NoError(t, writer.Close())
+
+	stats := writer.Stats()
+	require.Equal(t, int64(2), stats.Retries)
+	require.Equal(t, int64(3), stats.Requests)
+	require.Equal(t, int64(len(reportSeries("", report))), stats.SeriesSent)
+
+	rejecting := &remoteWriteReceiver{failures: 100, failStatus: http.StatusBadRequest}
+	badServer := httptest.NewServer(rejecting)
+	defer badServer.Close()
+
+	writer, err = NewRemoteWriter(RemoteWriteConfig{URL: badServer.URL, MaxRetries: 3, MinBackoff: time.Millisecond})
+	require.NoError(t, err)
+	writer.Ingest("tenant-1", report)
+	require.NoError(t, writer.Close())
+
+	stats = writer.Stats()
+	require.Zero(t, stats.Retries)
+	require.Equal(t, int64(len(reportSeries("", report))), stats.SeriesFailed)
+}
+
+// TestRemoteWriteBoundedQueue tests that series beyond the queue size are dropped instead of blocking
+func TestRemoteWriteBoundedQueue(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriteConfig{URL: server.URL, BatchSize: 1, QueueSize: 5})
+	require.NoError(t, err)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	writer.Ingest("tenant-1", report)
+
+	require.Positive(t, writer.Stats().SeriesDropped)
+
+	close(block)
+	require.NoError(t, writer.Close())
+
+	stats := writer.Stats()
+	require.Equal(t, int64(len(reportSeries("", report))), stats.SeriesSent+stats.SeriesDropped)
+}
+
+// TestRemoteWriteRejectsInvalidURL tests config validation
+func TestRemoteWriteRejectsInvalidURL(t *testing.T) {
+	_, err := NewRemoteWriter(RemoteWriteConfig{URL: "not a url"})
+	require.Error(t, err)
+}
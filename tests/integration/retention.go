@@ -0,0 +1,314 @@
+package integration
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// RetentionPolicy bounds the reports kept by MockMetricsService. Zero fields are unlimited.
+type RetentionPolicy struct {
+	MaxAge time.Duration
+	// MaxBytes bounds the total encoded size of stored metrics and event reports together
+	MaxBytes int64
+	// MaxReportsPerCluster bounds metrics reports and event reports of each cluster separately
+	MaxReportsPerCluster int
+}
+
+// RetentionStats counts reports evicted by the retention policy.
+// Each eviction is attributed to the first limit that triggered it.
+type RetentionStats struct {
+	EvictedMetricsReports int64
+	EvictedEventReports   int64
+	EvictedBytes          int64
+	EvictedByAge          int64
+	EvictedBySize         int64
+	EvictedByClusterCap   int64
+	RetainedBytes         int64
+}
+
+// storedReport is the bookkeeping kept for every stored report
+type storedReport struct {
+	clusterID  string
+	tenantID   string
+	receivedAt time.Time
+	size       int64
+	// evicted marks a report dropped by the cluster cap that still holds its
+	// place in the arrival order
+	evicted bool
+}
+
+type storedMetricsReport struct {
+	storedReport
+	report *agent.MetricsReport
+}
+
+type storedEventReport struct {
+	storedReport
+	// seq orders event reports by arrival for stable pagination
+	seq    uint64
+	report *agent.EventReport
+}
+
+// SetRetentionPolicy replaces the retention policy and applies it immediately
+func (m *MockMetricsService) SetRetentionPolicy(policy RetentionPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.retention = policy
+	m.enforceRetention()
+}
+
+// SetClock overrides the clock used to stamp and age out reports
+func (m *MockMetricsService) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+// EnforceRetention applies the retention policy without storing anything,
+// so reports age out even when agents go quiet
+func (m *MockMetricsService) EnforceRetention() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enforceRetention()
+}
+
+// GetRetentionStats returns the eviction counters and the currently retained size
+func (m *MockMetricsService) GetRetentionStats() RetentionStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.retentionStats
+}
+
+// ClearCluster removes all stored reports of one cluster without counting them as evictions
+func (m *MockMetricsService) ClearCluster(clusterID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := m.receivedMetrics[:0]
+	for _, stored := range m.receivedMetrics {
+		switch {
+		case stored.clusterID != clusterID:
+			metrics = append(metrics, stored)
+		case stored.evicted:
+			m.evictedMetrics--
+		default:
+			m.retentionStats.RetainedBytes -= stored.size
+		}
+	}
+	clear(m.receivedMetrics[len(metrics):])
+	m.receivedMetrics = metrics
+	delete(m.clusterMetrics, clusterID)
+
+	events := m.receivedEvents[:0]
+	for _, stored := range m.receivedEvents {
+		switch {
+		case stored.clusterID != clusterID:
+			events = append(events, stored)
+		case stored.evicted:
+			m.evictedEvents--
+		default:
+			m.retentionStats.RetainedBytes -= stored.size
+		}
+	}
+	clear(m.receivedEvents[len(events):])
+	m.receivedEvents = events
+	delete(m.clusterEvents, clusterID)
+
+	m.events.RemoveCluster(clusterID)
+	if m.rateConverter != nil {
+		m.rateConverter.RemoveCluster(clusterID)
+	}
+}
+
+// retainMetrics must be called with m.mu held
+func (m *MockMetricsService) retainMetrics(stored *storedMetricsReport) {
+	m.receivedMetrics = append(m.receivedMetrics, stored)
+	m.clusterMetrics[stored.clusterID] = append(m.clusterMetrics[stored.clusterID], stored)
+}
+
+// retainEvents must be called with m.mu held
+func (m *MockMetricsService) retainEvents(stored *storedEventReport) {
+	m.receivedEvents = append(m.receivedEvents, stored)
+	m.clusterEvents[stored.clusterID] = append(m.clusterEvents[stored.clusterID], stored)
+}
+
+// newStoredReport must be called with m.mu held
+func (m *MockMetricsService) newStoredReport(clusterID string, report proto.Message) storedReport {
+	stored := storedReport{
+		clusterID:  clusterID,
+		tenantID:   m.tenantFor(clusterID),
+		receivedAt: m.now(),
+		size:       int64(proto.Size(report)),
+	}
+	m.retentionStats.RetainedBytes += stored.size
+	return stored
+}
+
+// enforceRetention must be called with m.mu held. Reports are stored in arrival
+// order, so the front of each slice is always the oldest.
+func (m *MockMetricsService) enforceRetention() {
+	policy := m.retention
+
+	if policy.MaxAge > 0 {
+		cutoff := m.now().Add(-policy.MaxAge)
+		for oldest := m.oldestMetrics(); oldest != nil && oldest.receivedAt.Before(cutoff); oldest = m.oldestMetrics() {
+			m.evictOldestMetrics()
+			m.retentionStats.EvictedByAge++
+		}
+		for oldest := m.oldestEvents(); oldest != nil && oldest.receivedAt.Before(cutoff); oldest = m.oldestEvents() {
+			m.evictOldestEvents()
+			m.retentionStats.EvictedByAge++
+		}
+		// Aggregated series live as long as they keep recurring
+		m.events.Prune(cutoff)
+	}
+
+	if policy.MaxReportsPerCluster > 0 {
+		m.enforceClusterCap(policy.MaxReportsPerCluster)
+	}
+
+	if policy.MaxBytes > 0 {
+		for m.retentionStats.RetainedBytes > policy.MaxBytes {
+			metrics, events := m.oldestMetrics(), m.oldestEvents()
+			switch {
+			case metrics != nil && (events == nil || !events.receivedAt.Before(metrics.receivedAt)):
+				m.evictOldestMetrics()
+			case events != nil:
+				m.evictOldestEvents()
+			default:
+				return
+			}
+			m.retentionStats.EvictedBySize++
+		}
+	}
+}
+
+// oldestMetrics must be called with m.mu held. It drops the reports already
+// evicted by the cluster cap from the front and returns the oldest one left.
+func (m *MockMetricsService) oldestMetrics() *storedMetricsReport {
+	for len(m.receivedMetrics) > 0 && m.receivedMetrics[0].evicted {
+		m.receivedMetrics[0] = nil
+		m.receivedMetrics = m.receivedMetrics[1:]
+		m.evictedMetrics--
+	}
+	if len(m.receivedMetrics) == 0 {
+		return nil
+	}
+	return m.receivedMetrics[0]
+}
+
+// oldestEvents is oldestMetrics for event reports
+func (m *MockMetricsService) oldestEvents() *storedEventReport {
+	for len(m.receivedEvents) > 0 && m.receivedEvents[0].evicted {
+		m.receivedEvents[0] = nil
+		m.receivedEvents = m.receivedEvents[1:]
+		m.evictedEvents--
+	}
+	if len(m.receivedEvents) == 0 {
+		return nil
+	}
+	return m.receivedEvents[0]
+}
+
+// evictOldestMetrics evicts the report returned by oldestMetrics, which is also
+// the oldest of its cluster
+func (m *MockMetricsService) evictOldestMetrics() {
+	stored := m.receivedMetrics[0]
+	m.recordEviction(stored.storedReport)
+	m.retentionStats.EvictedMetricsReports++
+	// Clear the slot so the evicted report can be collected before the slice is reallocated
+	m.receivedMetrics[0] = nil
+	m.receivedMetrics = m.receivedMetrics[1:]
+
+	queue := m.clusterMetrics[stored.clusterID]
+	queue[0] = nil
+	if queue = queue[1:]; len(queue) == 0 {
+		delete(m.clusterMetrics, stored.clusterID)
+	} else {
+		m.clusterMetrics[stored.clusterID] = queue
+	}
+}
+
+// evictOldestEvents evicts the report returned by oldestEvents
+func (m *MockMetricsService) evictOldestEvents() {
+	stored := m.receivedEvents[0]
+	m.recordEviction(stored.storedReport)
+	m.retentionStats.EvictedEventReports++
+	m.receivedEvents[0] = nil
+	m.receivedEvents = m.receivedEvents[1:]
+
+	queue := m.clusterEvents[stored.clusterID]
+	queue[0] = nil
+	if queue = queue[1:]; len(queue) == 0 {
+		delete(m.clusterEvents, stored.clusterID)
+	} else {
+		m.clusterEvents[stored.clusterID] = queue
+	}
+}
+
+func (m *MockMetricsService) recordEviction(stored storedReport) {
+	m.retentionStats.EvictedBytes += stored.size
+	m.retentionStats.RetainedBytes -= stored.size
+}
+
+// enforceClusterCap keeps only the newest max reports of each cluster. Only the
+// per-cluster queues are walked: a report evicted here is marked in place and
+// skipped by readers until it reaches the front of the arrival order, or until
+// evicted reports make up half of it and it is compacted.
+func (m *MockMetricsService) enforceClusterCap(max int) {
+	for clusterID, queue := range m.clusterMetrics {
+		for len(queue) > max {
+			stored := queue[0]
+			queue[0] = nil
+			queue = queue[1:]
+			m.recordEviction(stored.storedReport)
+			m.retentionStats.EvictedMetricsReports++
+			m.retentionStats.EvictedByClusterCap++
+			stored.evicted = true
+			stored.report = nil
+			m.evictedMetrics++
+		}
+		m.clusterMetrics[clusterID] = queue
+	}
+	if m.evictedMetrics > 0 && 2*m.evictedMetrics >= len(m.receivedMetrics) {
+		metrics := m.receivedMetrics[:0]
+		for _, stored := range m.receivedMetrics {
+			if !stored.evicted {
+				metrics = append(metrics, stored)
+			}
+		}
+		clear(m.receivedMetrics[len(metrics):])
+		m.receivedMetrics = metrics
+		m.evictedMetrics = 0
+	}
+
+	for clusterID, queue := range m.clusterEvents {
+		for len(queue) > max {
+			stored := queue[0]
+			queue[0] = nil
+			queue = queue[1:]
+			m.recordEviction(stored.storedReport)
+			m.retentionStats.EvictedEventReports++
+			m.retentionStats.EvictedByClusterCap++
+			stored.evicted = true
+			stored.report = nil
+			m.evictedEvents++
+		}
+		m.clusterEvents[clusterID] = queue
+	}
+	if m.evictedEvents > 0 && 2*m.evictedEvents >= len(m.receivedEvents) {
+		events := m.receivedEvents[:0]
+		for _, stored := range m.receivedEvents {
+			if !stored.evicted {
+				events = append(events, stored)
+			}
+		}
+		clear(m.receivedEvents[len(events):])
+		m.receivedEvents = events
+		m.evictedEvents = 0
+	}
+}
@@ -0,0 +1,167 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// fakeClock is a manually advanced clock for retention tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// TestMetricsServiceRetentionByAge tests that old reports age out on store and on demand
+func TestMetricsServiceRetentionByAge(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	metricsService := NewMockMetricsService()
+	metricsService.SetClock(clock.Now)
+	metricsService.SetRetentionPolicy(RetentionPolicy{MaxAge: 10 * time.Minute})
+
+	ctx := context.Background()
+	generator := NewTestDataGenerator()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, metricsService.StoreMetrics(ctx, generator.GenerateMetricsReport("cluster-1", 2)))
+		require.NoError(t, metricsService.StoreEvents(ctx, generator.GenerateEventReport("cluster-1", 2)))
+		clock.Advance(time.Minute)
+	}
+
+	// Stored at minutes 0..19 and checked at minute 19, so minutes 9..19 remain
+	require.Equal(t, 11, len(metricsService.GetReceivedMetrics()))
+	require.Equal(t, 11, len(metricsService.GetReceivedEvents()))
+
+	clock.Advance(time.Hour)
+	metricsService.EnforceRetention()
+	require.Empty(t, metricsService.GetReceivedMetrics())
+	require.Empty(t, metricsService.GetReceivedEvents())
+
+	stats := metricsService.GetRetentionStats()
+	require.Equal(t, int64(20), stats.EvictedMetricsReports)
+	require.Equal(t, int64(20), stats.EvictedEventReports)
+	require.Equal(t, int64(40), stats.EvictedByAge)
+	require.Zero(t, stats.RetainedBytes)
+}
+
+// TestMetricsServiceRetentionBySize tests oldest-first eviction across metrics and events
+func TestMetricsServiceRetentionBySize(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	metricsService := NewMockMetricsService()
+	metricsService.SetClock(clock.Now)
+
+	generator := NewTestDataGenerator()
+	metricsReport := generator.GenerateMetricsReport("cluster-1", 15)
+	eventReport := generator.GenerateEventReport("cluster-1", 15)
+	metricsSize := int64(proto.Size(metricsReport))
+	eventsSize := int64(proto.Size(eventReport))
+
+	// Room for three metrics reports, but not three plus the event report
+	require.Less(t, eventsSize, metricsSize)
+	metricsService.SetRetentionPolicy(RetentionPolicy{MaxBytes: 3 * metricsSize})
+
+	ctx := context.Background()
+	require.NoError(t, metricsService.StoreEvents(ctx, eventReport))
+	clock.Advance(time.Second)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, metricsService.StoreMetrics(ctx, metricsReport))
+		clock.Advance(time.Second)
+	}
+
+	// The event report was oldest, so it went first even though it is a different kind
+	require.Equal(t, 3, len(metricsService.GetReceivedMetrics()))
+	require.Empty(t, metricsService.GetReceivedEvents())
+
+	stats := metricsService.GetRetentionStats()
+	require.Equal(t, int64(1), stats.EvictedEventReports)
+	require.Equal(t, eventsSize, stats.EvictedBytes)
+	require.Equal(t, 3*metricsSize, stats.RetainedBytes)
+
+	require.NoError(t, metricsService.StoreMetrics(ctx, metricsReport))
+	require.Equal(t, 3, len(metricsService.GetReceivedMetrics()))
+	require.Equal(t, int64(1), metricsService.GetRetentionStats().EvictedMetricsReports)
+	require.Equal(t, int64(2), metricsService.GetRetentionStats().EvictedBySize)
+}
+
+// TestMetricsServiceRetentionPerCluster tests per-cluster caps and partial clearing
+func TestMetricsServiceRetentionPerCluster(t *testing.T) {
+	metricsService := NewMockMetricsService()
+	metricsService.SetRetentionPolicy(RetentionPolicy{MaxReportsPerCluster: 5})
+
+	ctx := context.Background()
+	generator := NewTestDataGenerator()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, metricsService.StoreMetrics(ctx, generator.GenerateMetricsReport("busy-cluster", 1)))
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, metricsService.StoreMetrics(ctx, generator.GenerateMetricsReport("quiet-cluster", 1)))
+	}
+
+	counts := make(map[string]int)
+	for _, report := range metricsService.GetReceivedMetrics() {
+		counts[report.ClusterId]++
+	}
+	require.Equal(t, map[string]int{"busy-cluster": 5, "quiet-cluster": 3}, counts)
+	require.Equal(t, int64(5), metricsService.GetRetentionStats().EvictedByClusterCap)
+
+	metricsService.ClearCluster("busy-cluster")
+	reports := metricsService.GetReceivedMetrics()
+	require.Len(t, reports, 3)
+	require.Equal(t, "quiet-cluster", reports[0].ClusterId)
+	require.Equal(t, int64(5), metricsService.GetRetentionStats().EvictedMetricsReports)
+}
+
+// TestMetricsServiceClusterCapOverLongRuns tests that the cluster cap only evicts from the
+// cluster over it and keeps the stored reports bounded behind an old report of a quiet cluster
+func TestMetricsServiceClusterCapOverLongRuns(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	metricsService := NewMockMetricsService()
+	metricsService.SetClock(clock.Now)
+	metricsService.SetRetentionPolicy(RetentionPolicy{MaxAge: time.Hour, MaxReportsPerCluster: 5})
+
+	ctx := context.Background()
+	generator := NewTestDataGenerator()
+	require.NoError(t, metricsService.StoreMetrics(ctx, generator.GenerateMetricsReport("quiet-cluster", 1)))
+	require.NoError(t, metricsService.StoreEvents(ctx, generator.GenerateEventReport("quiet-cluster", 1)))
+	var busy []*agentv1.MetricsReport
+	for i := 0; i < 1000; i++ {
+		report := generator.GenerateMetricsReport("busy-cluster", 1)
+		busy = append(busy, report)
+		require.NoError(t, metricsService.StoreMetrics(ctx, report))
+		require.NoError(t, metricsService.StoreEvents(ctx, generator.GenerateEventReport("busy-cluster", 1)))
+		require.LessOrEqual(t, len(metricsService.receivedMetrics), 12, "evicted reports are compacted away")
+		require.LessOrEqual(t, len(metricsService.receivedEvents), 12)
+	}
+
+	reports := metricsService.GetReceivedMetrics()
+	require.Len(t, reports, 6)
+	require.Equal(t, "quiet-cluster", reports[0].ClusterId)
+	for i, report := range reports[1:] {
+		require.Same(t, busy[995+i], report)
+	}
+	require.Len(t, metricsService.GetReceivedEvents(), 6)
+	stats := metricsService.GetRetentionStats()
+	require.Equal(t, int64(2*995), stats.EvictedByClusterCap)
+
+	// Age eviction skips the reports the cap already evicted
+	clock.Advance(2 * time.Hour)
+	metricsService.EnforceRetention()
+	require.Empty(t, metricsService.GetReceivedMetrics())
+	require.Empty(t, metricsService.GetReceivedEvents())
+	stats = metricsService.GetRetentionStats()
+	require.Equal(t, int64(12), stats.EvictedByAge)
+	require.Zero(t, stats.RetainedBytes)
+	require.Empty(t, metricsService.clusterMetrics)
+	require.Empty(t, metricsService.clusterEvents)
+}
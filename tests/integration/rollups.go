@@ -0,0 +1,332 @@
+package integration
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// DefaultRollupWindows are the sliding windows maintained when none are configured
+var DefaultRollupWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// Rollup is the aggregate of one series over a sliding window ending at the ingest clock
+type Rollup struct {
+	Window time.Duration
+	Count  int
+	Avg    float64
+	Max    float64
+	P95    float64
+}
+
+// RollupEngine maintains sliding-window aggregates per workload and per cluster.
+// Aggregates are updated incrementally as reports arrive. Cluster-level series use
+// a SeriesKey with empty Namespace and Workload.
+//
+// Every window ends at the ingest clock, the newest sample time seen across all
+// series, so the samples of a workload that stops reporting leave its windows as
+// the other workloads keep reporting. Series with no samples left in their
+// longest window are dropped.
+type RollupEngine struct {
+	windows   []time.Duration
+	longest   time.Duration
+	series    map[SeriesKey]*rollupSeries
+	now       time.Time
+	nextSweep time.Time
+	mu        sync.RWMutex
+}
+
+type rollupSeries struct {
+	windows []*slidingWindow
+}
+
+// windowEntry is a sample tagged with its arrival sequence so evictions can
+// recognise it in the max deque
+type windowEntry struct {
+	seq   uint64
+	ts    time.Time
+	value float64
+}
+
+type slidingWindow struct {
+	size    time.Duration
+	entries []windowEntry
+	sum     float64
+	// maxDeque holds entries with strictly decreasing values; the front is the window max
+	maxDeque []windowEntry
+	// sorted holds the window values in ascending order for percentile reads
+	sorted []float64
+	seq    uint64
+}
+
+// NewRollupEngine creates an engine for the given windows, or DefaultRollupWindows if none are given
+func NewRollupEngine(windows ...time.Duration) *RollupEngine {
+	if len(windows) == 0 {
+		windows = DefaultRollupWindows
+	}
+
+	engine := &RollupEngine{
+		windows: append([]time.Duration(nil), windows...),
+		series:  make(map[SeriesKey]*rollupSeries),
+	}
+	for _, window := range windows {
+		if window > engine.longest {
+			engine.longest = window
+		}
+	}
+	return engine
+}
+
+// HasWindow reports whether the engine maintains a window of the given size
+func (e *RollupEngine) HasWindow(window time.Duration) bool {
+	for _, size := range e.windows {
+		if size == window {
+			return true
+		}
+	}
+	return false
+}
+
+// Ingest folds the usage and custom metrics of a report into the workload and cluster rollups
+func (e *RollupEngine) Ingest(tenantID string, report *agent.MetricsReport) {
+	ts := reportTime(report)
+
+	for _, workload := range report.WorkloadMetrics {
+		key := SeriesKey{
+			TenantID:  tenantID,
+			ClusterID: report.ClusterId,
+			Namespace: workload.Namespace,
+			Workload:  workload.WorkloadName,
+		}
+		e.ingestUsage(key, ts, workload.Usage, workload.CustomMetrics)
+	}
+
+	if cluster := report.ClusterMetrics; cluster != nil {
+		key := SeriesKey{TenantID: tenantID, ClusterID: report.ClusterId}
+		e.ingestUsage(key, ts, cluster.OverallUsage, cluster.CustomMetrics)
+	}
+}
+
+func (e *RollupEngine) ingestUsage(key SeriesKey, ts time.Time, usage *agent.ResourceUsage, custom map[string]float64) {
+	if usage != nil {
+		e.observeMetric(key, MetricCPUPercentage, ts, usage.CpuPercentage)
+		e.observeMetric(key, MetricMemoryPercentage, ts, usage.MemoryPercentage)
+		e.observeMetric(key, MetricStoragePercentage, ts, usage.StoragePercentage)
+	}
+
+	for name, value := range custom {
+		e.observeMetric(key, name, ts, value)
+	}
+}
+
+func (e *RollupEngine) observeMetric(key SeriesKey, metric string, ts time.Time, value float64) {
+	key.Metric = metric
+	e.Observe(key, Sample{Timestamp: ts, Value: value})
+}
+
+// Observe adds a sample to every window of a series. Samples older than the ingest
+// clock are counted as arriving at it.
+func (e *RollupEngine) Observe(key SeriesKey, sample Sample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	series, exists := e.series[key]
+	if !exists {
+		series = &rollupSeries{windows: make([]*slidingWindow, len(e.windows))}
+		for i, size := range e.windows {
+			series.windows[i] = &slidingWindow{size: size}
+		}
+		e.series[key] = series
+	}
+
+	if sample.Timestamp.After(e.now) {
+		e.now = sample.Timestamp
+	}
+
+	for _, window := range series.windows {
+		window.add(e.now, sample.Value)
+	}
+
+	if !e.now.Before(e.nextSweep) {
+		e.sweep()
+		e.nextSweep = e.now.Add(e.longest)
+	}
+}
+
+// sweep must be called with e.mu held. It drops the series that have not been
+// observed within the longest window.
+func (e *RollupEngine) sweep() {
+	for key, series := range e.series {
+		empty := true
+		for _, window := range series.windows {
+			window.evict(e.now.Add(-window.size))
+			if len(window.entries) > 0 {
+				empty = false
+			}
+		}
+		if empty {
+			delete(e.series, key)
+		}
+	}
+}
+
+// Get returns the rollup of a series over the given window
+func (e *RollupEngine) Get(key SeriesKey, window time.Duration) (Rollup, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := e.window(key, window)
+	if w == nil {
+		return Rollup{}, false
+	}
+	return w.rollup(), true
+}
+
+// Percentile returns the nearest-rank percentile p of a series over the given
+// window, with the number of samples it was taken from
+func (e *RollupEngine) Percentile(key SeriesKey, window time.Duration, p float64) (float64, int, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := e.window(key, window)
+	if w == nil {
+		return 0, 0, false
+	}
+	if len(w.sorted) == 0 {
+		return 0, 0, true
+	}
+	return w.percentile(p), len(w.sorted), true
+}
+
+// window must be called with e.mu held. It returns the window of a series with
+// the samples that fell out of it by the ingest clock evicted.
+func (e *RollupEngine) window(key SeriesKey, size time.Duration) *slidingWindow {
+	series, exists := e.series[key]
+	if !exists {
+		return nil
+	}
+
+	for _, w := range series.windows {
+		if w.size == size {
+			w.evict(e.now.Add(-w.size))
+			return w
+		}
+	}
+	return nil
+}
+
+// Rollups returns the rollups of a series for every configured window
+func (e *RollupEngine) Rollups(key SeriesKey) []Rollup {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	series, exists := e.series[key]
+	if !exists {
+		return nil
+	}
+
+	result := make([]Rollup, len(series.windows))
+	for i, w := range series.windows {
+		w.evict(e.now.Add(-w.size))
+		result[i] = w.rollup()
+	}
+	return result
+}
+
+// Truncate drops the samples of a series observed before the given time, e.g.
+// usage measured against requests that have since changed
+func (e *RollupEngine) Truncate(key SeriesKey, before time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	series, exists := e.series[key]
+	if !exists {
+		return
+	}
+	for _, w := range series.windows {
+		w.evict(before.Add(-time.Nanosecond))
+	}
+}
+
+// Series lists the keys of all rollup series accepted by match, or all series if match is nil
+func (e *RollupEngine) Series(match func(SeriesKey) bool) []SeriesKey {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	keys := make([]SeriesKey, 0, len(e.series))
+	for key := range e.series {
+		if match == nil || match(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+func (w *slidingWindow) add(ts time.Time, value float64) {
+	w.seq++
+	entry := windowEntry{seq: w.seq, ts: ts, value: value}
+
+	w.entries = append(w.entries, entry)
+	w.sum += value
+
+	for len(w.maxDeque) > 0 && w.maxDeque[len(w.maxDeque)-1].value <= value {
+		w.maxDeque = w.maxDeque[:len(w.maxDeque)-1]
+	}
+	w.maxDeque = append(w.maxDeque, entry)
+
+	i := sort.SearchFloat64s(w.sorted, value)
+	w.sorted = append(w.sorted, 0)
+	copy(w.sorted[i+1:], w.sorted[i:])
+	w.sorted[i] = value
+
+	w.evict(ts.Add(-w.size))
+}
+
+// evict drops entries at or before cutoff, so a window of size d covers (newest-d, newest]
+func (w *slidingWindow) evict(cutoff time.Time) {
+	n := 0
+	for n < len(w.entries) && !w.entries[n].ts.After(cutoff) {
+		old := w.entries[n]
+		w.sum -= old.value
+
+		if w.maxDeque[0].seq == old.seq {
+			w.maxDeque = w.maxDeque[1:]
+		}
+
+		i := sort.SearchFloat64s(w.sorted, old.value)
+		w.sorted = append(w.sorted[:i], w.sorted[i+1:]...)
+		n++
+	}
+
+	if n > 0 {
+		w.entries = w.entries[n:]
+	}
+}
+
+func (w *slidingWindow) rollup() Rollup {
+	count := len(w.entries)
+	if count == 0 {
+		return Rollup{Window: w.size}
+	}
+
+	return Rollup{
+		Window: w.size,
+		Count:  count,
+		Avg:    w.sum / float64(count),
+		Max:    w.maxDeque[0].value,
+		P95:    w.percentile(95),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of a window that is not empty
+func (w *slidingWindow) percentile(p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(w.sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return w.sorted[rank-1]
+}
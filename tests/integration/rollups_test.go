@@ -0,0 +1,115 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestRollupEngineSlidingWindows tests avg, max and p95 as samples enter and leave the windows
+func TestRollupEngineSlidingWindows(t *testing.T) {
+	engine := NewRollupEngine(time.Minute, 5*time.Minute)
+	key := SeriesKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "default", Workload: "app", Metric: MetricCPUPercentage}
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	// One sample every 15s; values 1..20 over five minutes
+	for i := 0; i < 20; i++ {
+		engine.Observe(key, Sample{Timestamp: base.Add(time.Duration(i) * 15 * time.Second), Value: float64(i + 1)})
+	}
+
+	oneMinute, ok := engine.Get(key, time.Minute)
+	require.True(t, ok)
+	require.Equal(t, 4, oneMinute.Count)
+	require.Equal(t, 18.5, oneMinute.Avg)
+	require.Equal(t, 20.0, oneMinute.Max)
+	require.Equal(t, 20.0, oneMinute.P95)
+
+	fiveMinutes, ok := engine.Get(key, 5*time.Minute)
+	require.True(t, ok)
+	require.Equal(t, 20, fiveMinutes.Count)
+	require.InDelta(t, 10.5, fiveMinutes.Avg, 1e-9)
+	require.Equal(t, 19.0, fiveMinutes.P95)
+
+	// A drop in load must pull the max down once the peak leaves the window
+	for i := 20; i < 24; i++ {
+		engine.Observe(key, Sample{Timestamp: base.Add(time.Duration(i) * 15 * time.Second), Value: 2})
+	}
+	oneMinute, _ = engine.Get(key, time.Minute)
+	require.Equal(t, 4, oneMinute.Count)
+	require.Equal(t, 2.0, oneMinute.Max)
+	require.Equal(t, 2.0, oneMinute.Avg)
+
+	_, ok = engine.Get(key, time.Hour)
+	require.False(t, ok)
+}
+
+// TestRollupEngineWorkloadAndClusterSeries tests rollups fed through MockMetricsService
+func TestRollupEngineWorkloadAndClusterSeries(t *testing.T) {
+	metricsService := NewMockMetricsService()
+	engine := NewRollupEngine()
+	metricsService.AddMetricsSink(engine)
+
+	generator := NewTestDataGenerator()
+	base := time.Now().Add(-10 * time.Minute)
+	for i := 0; i < 10; i++ {
+		report := generator.GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(base.Add(time.Duration(i) * time.Minute))
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+	}
+
+	workload := SeriesKey{ClusterID: "cluster-1", Namespace: "logging", Workload: "elasticsearch", Metric: MetricMemoryPercentage}
+	rollups := engine.Rollups(workload)
+	require.Len(t, rollups, len(DefaultRollupWindows))
+	require.Equal(t, 1, rollups[0].Count)
+	require.Equal(t, 5, rollups[1].Count)
+	require.Equal(t, 10, rollups[2].Count)
+	require.Equal(t, 91.7, rollups[2].Max)
+
+	cluster := SeriesKey{ClusterID: "cluster-1", Metric: "cluster_pods_pending"}
+	hour, ok := engine.Get(cluster, time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 2.0, hour.Avg)
+
+	require.Len(t, engine.Series(func(k SeriesKey) bool { return k.Workload == "" && k.Metric == MetricCPUPercentage }), 1)
+}
+
+// TestRollupEngineAnchoredToIngestClock tests that a series that stops reporting
+// leaves its windows while others keep reporting, and is dropped once empty
+func TestRollupEngineAnchoredToIngestClock(t *testing.T) {
+	engine := NewRollupEngine(time.Minute, 5*time.Minute)
+	quiet := SeriesKey{ClusterID: "cluster-1", Namespace: "default", Workload: "quiet", Metric: MetricCPUPercentage}
+	busy := SeriesKey{ClusterID: "cluster-1", Namespace: "default", Workload: "busy", Metric: MetricCPUPercentage}
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	engine.Observe(quiet, Sample{Timestamp: base, Value: 80})
+	for i := 0; i <= 2; i++ {
+		engine.Observe(busy, Sample{Timestamp: base.Add(time.Duration(i) * time.Minute), Value: 10})
+	}
+
+	oneMinute, ok := engine.Get(quiet, time.Minute)
+	require.True(t, ok)
+	require.Zero(t, oneMinute.Count)
+	fiveMinutes, _ := engine.Get(quiet, 5*time.Minute)
+	require.Equal(t, 80.0, fiveMinutes.Max)
+
+	for i := 3; i <= 10; i++ {
+		engine.Observe(busy, Sample{Timestamp: base.Add(time.Duration(i) * time.Minute), Value: 10})
+	}
+	_, ok = engine.Get(quiet, 5*time.Minute)
+	require.False(t, ok, "the quiet series is dropped once its longest window is empty")
+	require.Equal(t, []SeriesKey{busy}, engine.Series(nil))
+
+	// Samples before a truncation no longer count
+	engine.Observe(busy, Sample{Timestamp: base.Add(11 * time.Minute), Value: 30})
+	value, samples, ok := engine.Percentile(busy, 5*time.Minute, 90)
+	require.True(t, ok)
+	require.Equal(t, 5, samples)
+	require.Equal(t, 30.0, value)
+	engine.Truncate(busy, base.Add(11*time.Minute))
+	fiveMinutes, _ = engine.Get(busy, 5*time.Minute)
+	require.Equal(t, 1, fiveMinutes.Count)
+	require.Equal(t, 30.0, fiveMinutes.Avg)
+}
@@ -0,0 +1,568 @@
+package integration
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPolicyName is reported as the source of constraints no policy overrides
+const DefaultPolicyName = "default"
+
+// MaxPolicyPeriod and MaxStabilizationWindow match the autoscaling/v2 validation limits
+const (
+	MaxPolicyPeriod        = 30 * time.Minute
+	MaxStabilizationWindow = time.Hour
+	DecisionLogSize        = 1000
+)
+
+// PolicySelect picks between the limits of several rate policies
+type PolicySelect string
+
+const (
+	// SelectMax allows the largest change of all policies
+	SelectMax PolicySelect = "Max"
+	// SelectMin allows the smallest change of all policies
+	SelectMin PolicySelect = "Min"
+	// SelectDisabled blocks scaling in that direction
+	SelectDisabled PolicySelect = "Disabled"
+)
+
+// RatePolicyType is the unit of a rate policy
+type RatePolicyType string
+
+const (
+	RatePolicyPods    RatePolicyType = "Pods"
+	RatePolicyPercent RatePolicyType = "Percent"
+)
+
+// RatePolicy limits the change of replicas within a sliding period
+type RatePolicy struct {
+	Type   RatePolicyType
+	Value  int32
+	Period time.Duration
+}
+
+func (p RatePolicy) String() string {
+	if p.Type == RatePolicyPercent {
+		return fmt.Sprintf("%d%% per %s", p.Value, p.Period)
+	}
+	return fmt.Sprintf("%d pods per %s", p.Value, p.Period)
+}
+
+// ScalingRules configures one scaling direction like autoscaling/v2 HPAScalingRules.
+// Unset fields are inherited from broader policies.
+type ScalingRules struct {
+	StabilizationWindow *time.Duration
+	SelectPolicy        PolicySelect
+	Policies            []RatePolicy
+}
+
+// PolicyScope selects the workloads a policy applies to. Empty fields match anything,
+// and the most specific set field decides the precedence: workload over namespace
+// over cluster over tenant.
+type PolicyScope struct {
+	TenantID  string
+	ClusterID string
+	Namespace string
+	Workload  string
+}
+
+func (s PolicyScope) matches(key WorkloadKey) bool {
+	return (s.TenantID == "" || s.TenantID == key.TenantID) &&
+		(s.ClusterID == "" || s.ClusterID == key.ClusterID) &&
+		(s.Namespace == "" || s.Namespace == key.Namespace) &&
+		(s.Workload == "" || s.Workload == key.Workload)
+}
+
+func (s PolicyScope) level() int {
+	switch {
+	case s.Workload != "":
+		return 4
+	case s.Namespace != "":
+		return 3
+	case s.ClusterID != "":
+		return 2
+	case s.TenantID != "":
+		return 1
+	}
+	return 0
+}
+
+// WorkloadKey identifies a scalable workload across tenants and clusters
+type WorkloadKey struct {
+	TenantID  string
+	ClusterID string
+	Namespace string
+	Workload  string
+}
+
+func (k WorkloadKey) String() string {
+	return k.TenantID + "/" + k.ClusterID + "/" + k.Namespace + "/" + k.Workload
+}
+
+// ScalingPolicy mirrors the autoscaling/v2 min/max replicas and behavior fields.
+// Nil fields are inherited from broader policies and finally from the HPA defaults.
+type ScalingPolicy struct {
+	Name        string
+	Scope       PolicyScope
+	MinReplicas *int32
+	MaxReplicas *int32
+	ScaleUp     *ScalingRules
+	ScaleDown   *ScalingRules
+}
+
+func (p *ScalingPolicy) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("scaling policy name is required")
+	}
+	if p.MinReplicas != nil && *p.MinReplicas < 1 {
+		return fmt.Errorf("policy %s: minReplicas must be at least 1", p.Name)
+	}
+	if p.MaxReplicas != nil && *p.MaxReplicas < 1 {
+		return fmt.Errorf("policy %s: maxReplicas must be at least 1", p.Name)
+	}
+	if p.MinReplicas != nil && p.MaxReplicas != nil && *p.MinReplicas > *p.MaxReplicas {
+		return fmt.Errorf("policy %s: minReplicas %d exceeds maxReplicas %d", p.Name, *p.MinReplicas, *p.MaxReplicas)
+	}
+	for direction, rules := range map[string]*ScalingRules{"scaleUp": p.ScaleUp, "scaleDown": p.ScaleDown} {
+		if rules == nil {
+			continue
+		}
+		if window := rules.StabilizationWindow; window != nil && (*window < 0 || *window > MaxStabilizationWindow) {
+			return fmt.Errorf("policy %s: %s stabilization window must be between 0 and %s", p.Name, direction, MaxStabilizationWindow)
+		}
+		switch rules.SelectPolicy {
+		case "", SelectMax, SelectMin, SelectDisabled:
+		default:
+			return fmt.Errorf("policy %s: unknown %s select policy %q", p.Name, direction, rules.SelectPolicy)
+		}
+		for _, rate := range rules.Policies {
+			if rate.Type != RatePolicyPods && rate.Type != RatePolicyPercent {
+				return fmt.Errorf("policy %s: unknown %s rate policy type %q", p.Name, direction, rate.Type)
+			}
+			if rate.Value <= 0 {
+				return fmt.Errorf("policy %s: %s rate policy value must be positive", p.Name, direction)
+			}
+			if rate.Period <= 0 || rate.Period > MaxPolicyPeriod {
+				return fmt.Errorf("policy %s: %s rate policy period must be between 0 and %s", p.Name, direction, MaxPolicyPeriod)
+			}
+		}
+	}
+	return nil
+}
+
+// PolicyConstraint names a field of a scaling policy that can limit a target
+type PolicyConstraint string
+
+const (
+	ConstraintMinReplicas            PolicyConstraint = "minReplicas"
+	ConstraintMaxReplicas            PolicyConstraint = "maxReplicas"
+	ConstraintScaleUpStabilization   PolicyConstraint = "scaleUp.stabilizationWindow"
+	ConstraintScaleDownStabilization PolicyConstraint = "scaleDown.stabilizationWindow"
+	ConstraintScaleUpRate            PolicyConstraint = "scaleUp.policies"
+	ConstraintScaleDownRate          PolicyConstraint = "scaleDown.policies"
+)
+
+// EffectiveRules are the resolved rules of one scaling direction
+type EffectiveRules struct {
+	StabilizationWindow time.Duration
+	SelectPolicy        PolicySelect
+	Policies            []RatePolicy
+}
+
+// EffectivePolicy is the result of merging every policy that applies to a workload.
+// Sources records the name of the policy each constraint was taken from.
+type EffectivePolicy struct {
+	MinReplicas int32
+	MaxReplicas int32 // 0 means unbounded
+	ScaleUp     EffectiveRules
+	ScaleDown   EffectiveRules
+	Sources     map[PolicyConstraint]string
+}
+
+// defaultEffectivePolicy returns the autoscaling/v2 defaults
+func defaultEffectivePolicy() EffectivePolicy {
+	sources := make(map[PolicyConstraint]string)
+	for _, constraint := range []PolicyConstraint{
+		ConstraintMinReplicas, ConstraintMaxReplicas,
+		ConstraintScaleUpStabilization, ConstraintScaleDownStabilization,
+		ConstraintScaleUpRate, ConstraintScaleDownRate,
+	} {
+		sources[constraint] = DefaultPolicyName
+	}
+
+	return EffectivePolicy{
+		MinReplicas: 1,
+		ScaleUp: EffectiveRules{
+			SelectPolicy: SelectMax,
+			Policies: []RatePolicy{
+				{Type: RatePolicyPercent, Value: 100, Period: 15 * time.Second},
+				{Type: RatePolicyPods, Value: 4, Period: 15 * time.Second},
+			},
+		},
+		ScaleDown: EffectiveRules{
+			StabilizationWindow: 5 * time.Minute,
+			SelectPolicy:        SelectMax,
+			Policies:            []RatePolicy{{Type: RatePolicyPercent, Value: 100, Period: 15 * time.Second}},
+		},
+		Sources: sources,
+	}
+}
+
+// PolicyLimit records a policy constraint that changed the target of a decision
+type PolicyLimit struct {
+	Policy     string           `json:"policy"`
+	Constraint PolicyConstraint `json:"constraint"`
+	From       int32            `json:"from"`
+	To         int32            `json:"to"`
+	Detail     string           `json:"detail,omitempty"`
+}
+
+func (l PolicyLimit) String() string {
+	s := fmt.Sprintf("%s of policy %q limited %d -> %d", l.Constraint, l.Policy, l.From, l.To)
+	if l.Detail != "" {
+		s += " (" + l.Detail + ")"
+	}
+	return s
+}
+
+// ScalingDecision is the outcome of applying the policies of a workload to a recommendation
+type ScalingDecision struct {
+	Key                 WorkloadKey
+	Timestamp           time.Time
+	CurrentReplicas     int32
+	RecommendedReplicas int32
+	TargetReplicas      int32
+	// Limits are the constraints that changed the target, in the order they were applied
+	Limits []PolicyLimit
+}
+
+// ClampedBy returns the limit that set the final target
+func (d ScalingDecision) ClampedBy() (PolicyLimit, bool) {
+	if len(d.Limits) == 0 {
+		return PolicyLimit{}, false
+	}
+	return d.Limits[len(d.Limits)-1], true
+}
+
+type timestampedReplicas struct {
+	timestamp time.Time
+	replicas  int32
+}
+
+// workloadScalingHistory keeps the recommendations and scale events of one workload
+type workloadScalingHistory struct {
+	recommendations []timestampedReplicas
+	events          []timestampedReplicas // replicas holds the change, negative for scale down
+}
+
+// ScalingPolicyEngine applies min/max replicas, stabilization windows and rate
+// policies to recommendations, like the behavior field of an autoscaling/v2 HPA.
+// Policies are attached per tenant, cluster, namespace or workload, and each
+// decision records which policy clamped its target.
+type ScalingPolicyEngine struct {
+	policies  []ScalingPolicy
+	history   map[WorkloadKey]*workloadScalingHistory
+	decisions []ScalingDecision
+	mu        sync.RWMutex
+}
+
+func NewScalingPolicyEngine(policies ...ScalingPolicy) (*ScalingPolicyEngine, error) {
+	engine := &ScalingPolicyEngine{
+		history: make(map[WorkloadKey]*workloadScalingHistory),
+	}
+	for _, policy := range policies {
+		if err := engine.SetPolicy(policy); err != nil {
+			return nil, err
+		}
+	}
+	return engine, nil
+}
+
+// SetPolicy adds a policy or replaces the policy of the same name
+func (e *ScalingPolicyEngine) SetPolicy(policy ScalingPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.policies {
+		if e.policies[i].Name == policy.Name {
+			e.policies[i] = policy
+			return nil
+		}
+	}
+	e.policies = append(e.policies, policy)
+	return nil
+}
+
+// RemovePolicy deletes a policy by name
+func (e *ScalingPolicyEngine) RemovePolicy(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.policies {
+		if e.policies[i].Name == name {
+			e.policies = append(e.policies[:i], e.policies[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve merges the policies that apply to a workload, broadest first
+func (e *ScalingPolicyEngine) Resolve(key WorkloadKey) EffectivePolicy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.resolve(key)
+}
+
+// resolve must be called with e.mu held
+func (e *ScalingPolicyEngine) resolve(key WorkloadKey) EffectivePolicy {
+	var matching []*ScalingPolicy
+	for i := range e.policies {
+		if e.policies[i].Scope.matches(key) {
+			matching = append(matching, &e.policies[i])
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].Scope.level() < matching[j].Scope.level()
+	})
+
+	effective := defaultEffectivePolicy()
+	for _, policy := range matching {
+		if policy.MinReplicas != nil {
+			effective.MinReplicas = *policy.MinReplicas
+			effective.Sources[ConstraintMinReplicas] = policy.Name
+		}
+		if policy.MaxReplicas != nil {
+			effective.MaxReplicas = *policy.MaxReplicas
+			effective.Sources[ConstraintMaxReplicas] = policy.Name
+		}
+		mergeScalingRules(&effective.ScaleUp, policy.ScaleUp, policy.Name, effective.Sources, ConstraintScaleUpStabilization, ConstraintScaleUpRate)
+		mergeScalingRules(&effective.ScaleDown, policy.ScaleDown, policy.Name, effective.Sources, ConstraintScaleDownStabilization, ConstraintScaleDownRate)
+	}
+	return effective
+}
+
+func mergeScalingRules(effective *EffectiveRules, rules *ScalingRules, name string, sources map[PolicyConstraint]string, window, rate PolicyConstraint) {
+	if rules == nil {
+		return
+	}
+	if rules.StabilizationWindow != nil {
+		effective.StabilizationWindow = *rules.StabilizationWindow
+		sources[window] = name
+	}
+	if rules.SelectPolicy != "" {
+		effective.SelectPolicy = rules.SelectPolicy
+		sources[rate] = name
+	}
+	if rules.Policies != nil {
+		effective.Policies = append([]RatePolicy(nil), rules.Policies...)
+		sources[rate] = name
+	}
+}
+
+// ReplicaBound is a final bound on the target of a decision, such as the active
+// scaling schedule of the workload. ok is false when it leaves replicas unchanged.
+type ReplicaBound func(replicas int32) (bounded int32, limit PolicyLimit, ok bool)
+
+// Decide applies the effective policy of a workload to a recommendation made at now.
+// The recommendation is stabilized first, then limited by the rate policies and
+// finally clamped to the min/max replicas, which always win. A decision that
+// changes the replica count is recorded as a scale event for later rate limits.
+func (e *ScalingPolicyEngine) Decide(key WorkloadKey, current, recommended int32, now time.Time) ScalingDecision {
+	return e.DecideWithin(key, current, recommended, now, nil)
+}
+
+// DecideWithin is Decide with a bound applied after the min/max replicas, which
+// wins over every policy. The bounded target is the one recorded as a scale event.
+func (e *ScalingPolicyEngine) DecideWithin(key WorkloadKey, current, recommended int32, now time.Time, bound ReplicaBound) ScalingDecision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	policy := e.resolve(key)
+	history, exists := e.history[key]
+	if !exists {
+		history = &workloadScalingHistory{}
+		e.history[key] = history
+	}
+	history.recommendations = append(history.recommendations, timestampedReplicas{timestamp: now, replicas: recommended})
+	history.prune(now, policy)
+
+	decision := ScalingDecision{
+		Key:                 key,
+		Timestamp:           now,
+		CurrentReplicas:     current,
+		RecommendedReplicas: recommended,
+	}
+	target := recommended
+	limit := func(constraint PolicyConstraint, to int32, detail string) {
+		if to == target {
+			return
+		}
+		decision.Limits = append(decision.Limits, PolicyLimit{
+			Policy:     policy.Sources[constraint],
+			Constraint: constraint,
+			From:       target,
+			To:         to,
+			Detail:     detail,
+		})
+		target = to
+	}
+
+	// Stabilization: scale up to the lowest and down to the highest recommendation
+	// of the respective window
+	up, down := recommended, recommended
+	for _, rec := range history.recommendations {
+		if now.Sub(rec.timestamp) < policy.ScaleUp.StabilizationWindow {
+			up = min(up, rec.replicas)
+		}
+		if now.Sub(rec.timestamp) < policy.ScaleDown.StabilizationWindow {
+			down = max(down, rec.replicas)
+		}
+	}
+	stabilized := min(max(current, up), down)
+	if recommended > current {
+		limit(ConstraintScaleUpStabilization, stabilized, fmt.Sprintf("lowest recommendation in %s", policy.ScaleUp.StabilizationWindow))
+	} else if recommended < current {
+		limit(ConstraintScaleDownStabilization, stabilized, fmt.Sprintf("highest recommendation in %s", policy.ScaleDown.StabilizationWindow))
+	}
+
+	if target > current {
+		if allowed, detail := history.scaleUpLimit(now, current, policy.ScaleUp); target > allowed {
+			limit(ConstraintScaleUpRate, allowed, detail)
+		}
+	} else if target < current {
+		if allowed, detail := history.scaleDownLimit(now, current, policy.ScaleDown); target < allowed {
+			limit(ConstraintScaleDownRate, allowed, detail)
+		}
+	}
+
+	if target < policy.MinReplicas {
+		limit(ConstraintMinReplicas, policy.MinReplicas, "")
+	}
+	if policy.MaxReplicas > 0 && target > policy.MaxReplicas {
+		limit(ConstraintMaxReplicas, policy.MaxReplicas, "")
+	}
+	if bound != nil {
+		if bounded, boundLimit, ok := bound(target); ok && bounded != target {
+			decision.Limits = append(decision.Limits, boundLimit)
+			target = bounded
+		}
+	}
+
+	decision.TargetReplicas = target
+	if target != current {
+		history.events = append(history.events, timestampedReplicas{timestamp: now, replicas: target - current})
+	}
+
+	e.decisions = append(e.decisions, decision)
+	if len(e.decisions) > DecisionLogSize {
+		e.decisions = e.decisions[len(e.decisions)-DecisionLogSize:]
+	}
+	return copyDecision(decision)
+}
+
+// prune drops recommendations and events no window or period can still see
+func (h *workloadScalingHistory) prune(now time.Time, policy EffectivePolicy) {
+	keep := max(policy.ScaleUp.StabilizationWindow, policy.ScaleDown.StabilizationWindow)
+	for len(h.recommendations) > 1 && now.Sub(h.recommendations[0].timestamp) >= keep {
+		h.recommendations = h.recommendations[1:]
+	}
+
+	var period time.Duration
+	for _, rate := range append(append([]RatePolicy(nil), policy.ScaleUp.Policies...), policy.ScaleDown.Policies...) {
+		period = max(period, rate.Period)
+	}
+	for len(h.events) > 0 && now.Sub(h.events[0].timestamp) >= period {
+		h.events = h.events[1:]
+	}
+}
+
+// changedWithin sums the added and removed replicas of the events within the period
+func (h *workloadScalingHistory) changedWithin(now time.Time, period time.Duration) (added, removed int32) {
+	for _, event := range h.events {
+		if now.Sub(event.timestamp) >= period {
+			continue
+		}
+		if event.replicas > 0 {
+			added += event.replicas
+		} else {
+			removed -= event.replicas
+		}
+	}
+	return added, removed
+}
+
+// scaleUpLimit returns the most replicas the rate policies allow
+func (h *workloadScalingHistory) scaleUpLimit(now time.Time, current int32, rules EffectiveRules) (int32, string) {
+	if rules.SelectPolicy == SelectDisabled {
+		return current, "scale up disabled"
+	}
+	if len(rules.Policies) == 0 {
+		return math.MaxInt32, ""
+	}
+
+	var result int32
+	var detail string
+	for i, rate := range rules.Policies {
+		added, _ := h.changedWithin(now, rate.Period)
+		periodStart := current - added
+		allowed := periodStart + rate.Value
+		if rate.Type == RatePolicyPercent {
+			allowed = int32(math.Ceil(float64(periodStart) * (1 + float64(rate.Value)/100)))
+		}
+		if i == 0 || (rules.SelectPolicy == SelectMin && allowed < result) || (rules.SelectPolicy != SelectMin && allowed > result) {
+			result, detail = allowed, rate.String()
+		}
+	}
+	return result, detail
+}
+
+// scaleDownLimit returns the fewest replicas the rate policies allow
+func (h *workloadScalingHistory) scaleDownLimit(now time.Time, current int32, rules EffectiveRules) (int32, string) {
+	if rules.SelectPolicy == SelectDisabled {
+		return current, "scale down disabled"
+	}
+	if len(rules.Policies) == 0 {
+		return 0, ""
+	}
+
+	var result int32
+	var detail string
+	for i, rate := range rules.Policies {
+		_, removed := h.changedWithin(now, rate.Period)
+		periodStart := current + removed
+		allowed := periodStart - rate.Value
+		if rate.Type == RatePolicyPercent {
+			allowed = int32(float64(periodStart) * (1 - float64(rate.Value)/100))
+		}
+		if i == 0 || (rules.SelectPolicy == SelectMin && allowed > result) || (rules.SelectPolicy != SelectMin && allowed < result) {
+			result, detail = allowed, rate.String()
+		}
+	}
+	return result, detail
+}
+
+// Decisions returns the most recent decisions, oldest first
+func (e *ScalingPolicyEngine) Decisions() []ScalingDecision {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	result := make([]ScalingDecision, len(e.decisions))
+	for i, decision := range e.decisions {
+		result[i] = copyDecision(decision)
+	}
+	return result
+}
+
+func copyDecision(decision ScalingDecision) ScalingDecision {
+	decision.Limits = append([]PolicyLimit(nil), decision.Limits...)
+	return decision
+}
@@ -0,0 +1,204 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+func int32Ptr(v int32) *int32 { return &v }
+
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+// TestScalingPolicyInheritance tests that narrower scopes override broader ones field by field
+func TestScalingPolicyInheritance(t *testing.T) {
+	engine, err := NewScalingPolicyEngine(
+		ScalingPolicy{
+			Name:        "tenant-a",
+			Scope:       PolicyScope{TenantID: "tenant-a"},
+			MinReplicas: int32Ptr(2),
+			MaxReplicas: int32Ptr(10),
+			ScaleUp:     &ScalingRules{Policies: []RatePolicy{{Type: RatePolicyPods, Value: 2, Period: time.Minute}}},
+		},
+		ScalingPolicy{
+			Name:        "production",
+			Scope:       PolicyScope{TenantID: "tenant-a", Namespace: "production"},
+			MaxReplicas: int32Ptr(6),
+		},
+		ScalingPolicy{
+			Name:      "frontend",
+			Scope:     PolicyScope{Workload: "webapp-frontend"},
+			ScaleDown: &ScalingRules{StabilizationWindow: durationPtr(0)},
+		},
+		ScalingPolicy{
+			Name:        "other-tenant",
+			Scope:       PolicyScope{TenantID: "tenant-b"},
+			MinReplicas: int32Ptr(5),
+		},
+	)
+	require.NoError(t, err)
+
+	effective := engine.Resolve(WorkloadKey{TenantID: "tenant-a", ClusterID: "cluster-1", Namespace: "production", Workload: "webapp-frontend"})
+	require.Equal(t, int32(2), effective.MinReplicas)
+	require.Equal(t, int32(6), effective.MaxReplicas)
+	require.Equal(t, []RatePolicy{{Type: RatePolicyPods, Value: 2, Period: time.Minute}}, effective.ScaleUp.Policies)
+	require.Equal(t, SelectMax, effective.ScaleUp.SelectPolicy)
+	require.Zero(t, effective.ScaleDown.StabilizationWindow)
+	require.Equal(t, map[PolicyConstraint]string{
+		ConstraintMinReplicas:            "tenant-a",
+		ConstraintMaxReplicas:            "production",
+		ConstraintScaleUpStabilization:   DefaultPolicyName,
+		ConstraintScaleDownStabilization: "frontend",
+		ConstraintScaleUpRate:            "tenant-a",
+		ConstraintScaleDownRate:          DefaultPolicyName,
+	}, effective.Sources)
+
+	defaults := engine.Resolve(WorkloadKey{TenantID: "tenant-c", Namespace: "production", Workload: "api"})
+	require.Equal(t, int32(1), defaults.MinReplicas)
+	require.Zero(t, defaults.MaxReplicas)
+	require.Equal(t, 5*time.Minute, defaults.ScaleDown.StabilizationWindow)
+
+	require.True(t, engine.RemovePolicy("production"))
+	require.Equal(t, int32(10), engine.Resolve(WorkloadKey{TenantID: "tenant-a", Namespace: "production"}).MaxReplicas)
+	require.False(t, engine.RemovePolicy("production"))
+
+	invalid := []ScalingPolicy{
+		{},
+		{Name: "min", MinReplicas: int32Ptr(0)},
+		{Name: "bounds", MinReplicas: int32Ptr(5), MaxReplicas: int32Ptr(2)},
+		{Name: "window", ScaleDown: &ScalingRules{StabilizationWindow: durationPtr(2 * time.Hour)}},
+		{Name: "select", ScaleUp: &ScalingRules{SelectPolicy: "Sometimes"}},
+		{Name: "rate", ScaleUp: &ScalingRules{Policies: []RatePolicy{{Type: RatePolicyPods, Value: 0, Period: time.Minute}}}},
+		{Name: "period", ScaleUp: &ScalingRules{Policies: []RatePolicy{{Type: RatePolicyPercent, Value: 50, Period: time.Hour}}}},
+	}
+	for _, policy := range invalid {
+		require.Error(t, engine.SetPolicy(policy), policy.Name)
+	}
+}
+
+// TestScalingPolicyRateAndBounds tests rate policies over a sliding period and the max replicas clamp
+func TestScalingPolicyRateAndBounds(t *testing.T) {
+	engine, err := NewScalingPolicyEngine(
+		ScalingPolicy{
+			Name:    "tenant-a",
+			Scope:   PolicyScope{TenantID: "tenant-a"},
+			ScaleUp: &ScalingRules{Policies: []RatePolicy{{Type: RatePolicyPods, Value: 2, Period: time.Minute}}},
+		},
+		ScalingPolicy{
+			Name:        "production",
+			Scope:       PolicyScope{TenantID: "tenant-a", Namespace: "production"},
+			MaxReplicas: int32Ptr(6),
+		},
+	)
+	require.NoError(t, err)
+
+	key := WorkloadKey{TenantID: "tenant-a", ClusterID: "cluster-1", Namespace: "production", Workload: "webapp-frontend"}
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	decision := engine.Decide(key, 3, 10, base)
+	require.Equal(t, int32(5), decision.TargetReplicas)
+	limit, ok := decision.ClampedBy()
+	require.True(t, ok)
+	require.Equal(t, PolicyLimit{Policy: "tenant-a", Constraint: ConstraintScaleUpRate, From: 10, To: 5, Detail: "2 pods per 1m0s"}, limit)
+
+	// The two pods added 30s ago still count against the period
+	decision = engine.Decide(key, 5, 10, base.Add(30*time.Second))
+	require.Equal(t, int32(5), decision.TargetReplicas)
+	require.Equal(t, ConstraintScaleUpRate, decision.Limits[0].Constraint)
+
+	decision = engine.Decide(key, 5, 10, base.Add(61*time.Second))
+	require.Equal(t, int32(6), decision.TargetReplicas)
+	require.Len(t, decision.Limits, 2)
+	limit, _ = decision.ClampedBy()
+	require.Equal(t, PolicyLimit{Policy: "production", Constraint: ConstraintMaxReplicas, From: 7, To: 6}, limit)
+
+	// Disabled scale down keeps the replicas
+	require.NoError(t, engine.SetPolicy(ScalingPolicy{
+		Name:      "no-scale-down",
+		Scope:     PolicyScope{Workload: "webapp-frontend"},
+		ScaleDown: &ScalingRules{StabilizationWindow: durationPtr(0), SelectPolicy: SelectDisabled},
+	}))
+	decision = engine.Decide(key, 6, 2, base.Add(2*time.Minute))
+	require.Equal(t, int32(6), decision.TargetReplicas)
+	require.Equal(t, "no-scale-down", decision.Limits[0].Policy)
+
+	require.Len(t, engine.Decisions(), 4)
+}
+
+// TestScalingPolicyStabilization tests the default scale-down stabilization window
+func TestScalingPolicyStabilization(t *testing.T) {
+	engine, err := NewScalingPolicyEngine(ScalingPolicy{
+		Name:  "percent",
+		Scope: PolicyScope{ClusterID: "cluster-1"},
+		ScaleDown: &ScalingRules{Policies: []RatePolicy{
+			{Type: RatePolicyPercent, Value: 50, Period: time.Minute},
+			{Type: RatePolicyPods, Value: 1, Period: time.Minute},
+		}},
+	})
+	require.NoError(t, err)
+
+	key := WorkloadKey{ClusterID: "cluster-1", Namespace: "logging", Workload: "logstash"}
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	require.Equal(t, int32(8), engine.Decide(key, 8, 8, base).TargetReplicas)
+
+	decision := engine.Decide(key, 8, 2, base.Add(time.Minute))
+	require.Equal(t, int32(8), decision.TargetReplicas)
+	limit, _ := decision.ClampedBy()
+	require.Equal(t, DefaultPolicyName, limit.Policy)
+	require.Equal(t, ConstraintScaleDownStabilization, limit.Constraint)
+
+	// Once the window has passed, the Max select policy allows the 50% drop
+	decision = engine.Decide(key, 8, 2, base.Add(6*time.Minute))
+	require.Equal(t, int32(4), decision.TargetReplicas)
+	require.Equal(t, PolicyLimit{Policy: "percent", Constraint: ConstraintScaleDownRate, From: 2, To: 4, Detail: "50% per 1m0s"}, decision.Limits[0])
+
+	// Within the same period the removed replicas count against the limit
+	decision = engine.Decide(key, 4, 2, base.Add(6*time.Minute+30*time.Second))
+	require.Equal(t, int32(4), decision.TargetReplicas)
+	limit, _ = decision.ClampedBy()
+	require.Equal(t, ConstraintScaleDownRate, limit.Constraint)
+}
+
+// TestRecommenderAppliesScalingPolicies tests that emitted intents carry the clamped target and its reason
+func TestRecommenderAppliesScalingPolicies(t *testing.T) {
+	policies, err := NewScalingPolicyEngine(ScalingPolicy{
+		Name:        "elasticsearch-cap",
+		Scope:       PolicyScope{Namespace: "logging", Workload: "elasticsearch"},
+		MaxReplicas: int32Ptr(4),
+	})
+	require.NoError(t, err)
+
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 60, TargetMemoryPercentage: 80})
+	require.NoError(t, err)
+	recommender.SetPolicyEngine(policies)
+
+	handler := NewMockScalingIntentHandler()
+	recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		handler.HandleScalingIntent(intent)
+	}))
+
+	metricsService := NewMockMetricsService()
+	metricsService.AddMetricsSink(recommender)
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	report.Timestamp = timestamppb.New(time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+
+	targets := make(map[string]*agentv1.ScalingIntent)
+	for _, intent := range handler.GetReceivedIntents() {
+		targets[intent.WorkloadName] = intent
+	}
+	require.Len(t, targets, 3)
+	require.Equal(t, int32(1), targets["coredns"].TargetReplicas)
+	require.Equal(t, int32(2), targets["prometheus-server"].TargetReplicas)
+	require.Equal(t, int32(4), targets["elasticsearch"].TargetReplicas)
+	require.Contains(t, targets["elasticsearch"].Reason, `maxReplicas of policy "elasticsearch-cap" limited 5 -> 4`)
+
+	// Every evaluated workload is decided on, including unchanged ones
+	require.Len(t, policies.Decisions(), 13)
+}
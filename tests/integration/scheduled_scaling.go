@@ -0,0 +1,477 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// Constraints recorded in PolicyLimit when an active schedule bounds a recommendation
+const (
+	ConstraintScheduleMinReplicas    PolicyConstraint = "schedule.minReplicas"
+	ConstraintScheduleMaxReplicas    PolicyConstraint = "schedule.maxReplicas"
+	ConstraintScheduleTargetReplicas PolicyConstraint = "schedule.targetReplicas"
+)
+
+// DefaultPreviewWindow is how far ahead the preview API looks without a within parameter
+const DefaultPreviewWindow = 24 * time.Hour
+
+// cronParser accepts standard five-field expressions and descriptors like @daily
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ScalingSchedule holds a workload at fixed bounds or a fixed target for Duration
+// from every time Cron fires in Timezone. A schedule sets either TargetReplicas or
+// at least one of MinReplicas and MaxReplicas.
+type ScalingSchedule struct {
+	Name         string        `json:"name"`
+	Key          WorkloadKey   `json:"key"`
+	WorkloadType string        `json:"workloadType,omitempty"`
+	Cron         string        `json:"cron"`
+	Timezone     string        `json:"timezone,omitempty"`
+	Duration     time.Duration `json:"duration"`
+	// Priority decides between overlapping schedules of a workload: the highest
+	// wins, and among equals the one that started last
+	Priority       int    `json:"priority,omitempty"`
+	MinReplicas    *int32 `json:"minReplicas,omitempty"`
+	MaxReplicas    *int32 `json:"maxReplicas,omitempty"`
+	TargetReplicas *int32 `json:"targetReplicas,omitempty"`
+}
+
+// ScheduledAction is one window of a schedule
+type ScheduledAction struct {
+	Schedule       string      `json:"schedule"`
+	Key            WorkloadKey `json:"key"`
+	Start          time.Time   `json:"start"`
+	End            time.Time   `json:"end"`
+	MinReplicas    *int32      `json:"minReplicas,omitempty"`
+	MaxReplicas    *int32      `json:"maxReplicas,omitempty"`
+	TargetReplicas *int32      `json:"targetReplicas,omitempty"`
+}
+
+// SchedulePreviewQuery selects upcoming scheduled actions. Empty fields match everything.
+type SchedulePreviewQuery struct {
+	TenantID  string
+	ClusterID string
+	Namespace string
+	Workload  string
+	From      time.Time
+	Until     time.Time
+	Limit     int
+}
+
+type compiledSchedule struct {
+	ScalingSchedule
+	cron     cron.Schedule
+	location *time.Location
+}
+
+type observedWorkload struct {
+	replicas     int32
+	workloadType string
+}
+
+// ScalingScheduler evaluates tenant-defined scaling schedules. Tick emits a
+// ScalingIntent when a window starts and the workload is outside its bounds; while
+// a window is active, Bound applies it to reactive recommendations. Schedules are
+// deliberate operator decisions and take precedence over the scaling policies.
+type ScalingScheduler struct {
+	schedules map[string]*compiledSchedule
+	sinks     []ScalingIntentSink
+	observed  map[WorkloadKey]observedWorkload
+	lastTick  time.Time
+	audit     AuditRecorder
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+func NewScalingScheduler(schedules ...ScalingSchedule) (*ScalingScheduler, error) {
+	s := &ScalingScheduler{
+		schedules: make(map[string]*compiledSchedule),
+		observed:  make(map[WorkloadKey]observedWorkload),
+		now:       time.Now,
+	}
+	for _, schedule := range schedules {
+		if err := s.SetSchedule(schedule); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SetClock replaces the time source, for tests
+func (s *ScalingScheduler) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// AddIntentSink makes the scheduler send its intents to sink
+func (s *ScalingScheduler) AddIntentSink(sink ScalingIntentSink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sinks = append(s.sinks, sink)
+}
+
+// SetAuditRecorder records every intent a schedule emits, attributed to the schedule
+func (s *ScalingScheduler) SetAuditRecorder(recorder AuditRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = recorder
+}
+
+// SetSchedule adds a schedule or replaces the one with the same name
+func (s *ScalingScheduler) SetSchedule(schedule ScalingSchedule) error {
+	compiled, err := compileSchedule(schedule)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[schedule.Name] = compiled
+	return nil
+}
+
+// RemoveSchedule deletes a schedule and reports whether it existed
+func (s *ScalingScheduler) RemoveSchedule(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.schedules[name]
+	delete(s.schedules, name)
+	return exists
+}
+
+// Schedules returns all schedules ordered by name
+func (s *ScalingScheduler) Schedules() []ScalingSchedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedules := make([]ScalingSchedule, 0, len(s.schedules))
+	for _, compiled := range s.schedules {
+		schedules = append(schedules, compiled.ScalingSchedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules
+}
+
+func compileSchedule(schedule ScalingSchedule) (*compiledSchedule, error) {
+	if schedule.Name == "" {
+		return nil, fmt.Errorf("schedule name is required")
+	}
+	if schedule.Key.ClusterID == "" || schedule.Key.Namespace == "" || schedule.Key.Workload == "" {
+		return nil, fmt.Errorf("schedule %q: cluster, namespace and workload are required", schedule.Name)
+	}
+	if schedule.WorkloadType != "" && !scalableWorkloadTypes[strings.ToLower(schedule.WorkloadType)] {
+		return nil, fmt.Errorf("schedule %q: %w: %q", schedule.Name, ErrUnscalableWorkload, schedule.WorkloadType)
+	}
+	if schedule.Duration <= 0 {
+		return nil, fmt.Errorf("schedule %q: duration must be positive", schedule.Name)
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %q: %w", schedule.Name, err)
+	}
+	parsed, err := cronParser.Parse(schedule.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %q: invalid cron expression: %w", schedule.Name, err)
+	}
+
+	switch {
+	case schedule.TargetReplicas != nil:
+		if schedule.MinReplicas != nil || schedule.MaxReplicas != nil {
+			return nil, fmt.Errorf("schedule %q: target replicas exclude min and max replicas", schedule.Name)
+		}
+		if *schedule.TargetReplicas < 0 {
+			return nil, fmt.Errorf("schedule %q: target replicas must not be negative", schedule.Name)
+		}
+	case schedule.MinReplicas == nil && schedule.MaxReplicas == nil:
+		return nil, fmt.Errorf("schedule %q: one of target, min or max replicas is required", schedule.Name)
+	case schedule.MinReplicas != nil && *schedule.MinReplicas < 0:
+		return nil, fmt.Errorf("schedule %q: min replicas must not be negative", schedule.Name)
+	case schedule.MaxReplicas != nil && *schedule.MaxReplicas < 1:
+		return nil, fmt.Errorf("schedule %q: max replicas must be at least 1", schedule.Name)
+	case schedule.MinReplicas != nil && schedule.MaxReplicas != nil && *schedule.MinReplicas > *schedule.MaxReplicas:
+		return nil, fmt.Errorf("schedule %q: min replicas exceed max replicas", schedule.Name)
+	}
+	return &compiledSchedule{ScalingSchedule: schedule, cron: parsed, location: location}, nil
+}
+
+// lastStart returns the start of the window active at now, if any
+func (c *compiledSchedule) lastStart(now time.Time) (time.Time, bool) {
+	start := c.cron.Next(now.Add(-c.Duration).In(c.location))
+	if start.IsZero() || start.After(now) {
+		return time.Time{}, false
+	}
+	for next := c.cron.Next(start); !next.IsZero() && !next.After(now); next = c.cron.Next(next) {
+		start = next
+	}
+	return start, true
+}
+
+func (c *compiledSchedule) action(start time.Time) ScheduledAction {
+	return ScheduledAction{
+		Schedule:       c.Name,
+		Key:            c.Key,
+		Start:          start.UTC(),
+		End:            start.Add(c.Duration).UTC(),
+		MinReplicas:    c.MinReplicas,
+		MaxReplicas:    c.MaxReplicas,
+		TargetReplicas: c.TargetReplicas,
+	}
+}
+
+// String describes the replicas the action holds, e.g. "min 6, max 10 replicas"
+func (a ScheduledAction) String() string {
+	if a.TargetReplicas != nil {
+		return fmt.Sprintf("%d replicas", *a.TargetReplicas)
+	}
+	var bounds []string
+	if a.MinReplicas != nil {
+		bounds = append(bounds, fmt.Sprintf("min %d", *a.MinReplicas))
+	}
+	if a.MaxReplicas != nil {
+		bounds = append(bounds, fmt.Sprintf("max %d", *a.MaxReplicas))
+	}
+	return strings.Join(bounds, ", ") + " replicas"
+}
+
+// bound applies the action to replicas
+func (a ScheduledAction) bound(replicas int32) (int32, PolicyConstraint) {
+	switch {
+	case a.TargetReplicas != nil && replicas != *a.TargetReplicas:
+		return *a.TargetReplicas, ConstraintScheduleTargetReplicas
+	case a.MinReplicas != nil && replicas < *a.MinReplicas:
+		return *a.MinReplicas, ConstraintScheduleMinReplicas
+	case a.MaxReplicas != nil && replicas > *a.MaxReplicas:
+		return *a.MaxReplicas, ConstraintScheduleMaxReplicas
+	}
+	return replicas, ""
+}
+
+// active must be called with s.mu held
+func (s *ScalingScheduler) active(key WorkloadKey, now time.Time) (ScheduledAction, bool) {
+	var winner ScheduledAction
+	var priority int
+	found := false
+	for _, compiled := range s.schedules {
+		if compiled.Key != key {
+			continue
+		}
+		start, ok := compiled.lastStart(now)
+		if !ok {
+			continue
+		}
+		if found && (compiled.Priority < priority ||
+			compiled.Priority == priority && (start.Before(winner.Start) || start.Equal(winner.Start) && compiled.Name > winner.Schedule)) {
+			continue
+		}
+		winner, priority, found = compiled.action(start), compiled.Priority, true
+	}
+	return winner, found
+}
+
+// Active returns the scheduled action in effect for a workload at now
+func (s *ScalingScheduler) Active(key WorkloadKey, now time.Time) (ScheduledAction, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active(key, now)
+}
+
+// Bound applies the active schedule of a workload to the replicas recommended at
+// now. The returned limit is only meaningful when ok is true.
+func (s *ScalingScheduler) Bound(key WorkloadKey, replicas int32, now time.Time) (int32, PolicyLimit, bool) {
+	action, found := s.Active(key, now)
+	if !found {
+		return replicas, PolicyLimit{}, false
+	}
+	bounded, constraint := action.bound(replicas)
+	if constraint == "" {
+		return replicas, PolicyLimit{}, false
+	}
+	return bounded, PolicyLimit{
+		Policy:     action.Schedule,
+		Constraint: constraint,
+		From:       replicas,
+		To:         bounded,
+		Detail:     fmt.Sprintf("until %s", action.End.Format(time.RFC3339)),
+	}, true
+}
+
+// Ingest records the current replicas of every workload in the report
+func (s *ScalingScheduler) Ingest(tenantID string, report *agent.MetricsReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, workload := range report.WorkloadMetrics {
+		key := WorkloadKey{TenantID: tenantID, ClusterID: report.ClusterId, Namespace: workload.Namespace, Workload: workload.WorkloadName}
+		s.observed[key] = observedWorkload{replicas: workload.Replicas, workloadType: workload.WorkloadType}
+	}
+}
+
+// Tick emits an intent for every workload whose winning window started since the
+// previous tick and whose replicas are outside it. Workloads with bounds but no
+// observed replicas are left to the reactive path. It returns the number of intents.
+func (s *ScalingScheduler) Tick() int {
+	s.mu.Lock()
+	now := s.now()
+	since := s.lastTick
+	s.lastTick = now
+
+	type emission struct {
+		clusterID string
+		intent    *agent.ScalingIntent
+		entry     AuditEntry
+	}
+	var emissions []emission
+	seen := make(map[WorkloadKey]bool)
+	for _, name := range s.sortedNames() {
+		key := s.schedules[name].Key
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		action, found := s.active(key, now)
+		if !found || !action.Start.After(since) {
+			continue
+		}
+		observed, known := s.observed[key]
+		if !known && action.TargetReplicas == nil {
+			continue
+		}
+		target, constraint := action.bound(observed.replicas)
+		if known && constraint == "" {
+			continue
+		}
+
+		workloadType := s.schedules[action.Schedule].WorkloadType
+		if workloadType == "" {
+			workloadType = observed.workloadType
+		}
+		if workloadType == "" {
+			workloadType = "deployment"
+		}
+		reason := fmt.Sprintf("schedule %q (%s) until %s", action.Schedule, action, action.End.Format(time.RFC3339))
+		if known {
+			reason += fmt.Sprintf(": %d -> %d replicas", observed.replicas, target)
+		}
+		intent := &agent.ScalingIntent{
+			IntentId:          newIntentID(),
+			WorkloadNamespace: key.Namespace,
+			WorkloadName:      key.Workload,
+			WorkloadType:      workloadType,
+			TargetReplicas:    target,
+			Reason:            reason,
+		}
+		entry := action.auditEntry(intent, now)
+		if known {
+			entry.Metrics = &MetricsSnapshot{Replicas: observed.replicas}
+		}
+		emissions = append(emissions, emission{clusterID: key.ClusterID, intent: intent, entry: entry})
+	}
+	sinks := append([]ScalingIntentSink(nil), s.sinks...)
+	audit := s.audit
+	s.mu.Unlock()
+
+	for _, e := range emissions {
+		if audit != nil {
+			audit.Record(e.entry)
+		}
+		for _, sink := range sinks {
+			sink.SendScalingIntent(e.clusterID, e.intent)
+		}
+	}
+	return len(emissions)
+}
+
+// sortedNames must be called with s.mu held
+func (s *ScalingScheduler) sortedNames() []string {
+	names := make([]string, 0, len(s.schedules))
+	for name := range s.schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Preview lists the windows of matching schedules starting in [From, Until],
+// ordered by start time. Windows active at From are included.
+func (s *ScalingScheduler) Preview(query SchedulePreviewQuery) []ScheduledAction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	actions := []ScheduledAction{}
+	for _, name := range s.sortedNames() {
+		compiled := s.schedules[name]
+		key := compiled.Key
+		if (query.TenantID != "" && key.TenantID != query.TenantID) ||
+			(query.ClusterID != "" && key.ClusterID != query.ClusterID) ||
+			(query.Namespace != "" && key.Namespace != query.Namespace) ||
+			(query.Workload != "" && key.Workload != query.Workload) {
+			continue
+		}
+
+		start, ok := compiled.lastStart(query.From)
+		if !ok {
+			start = compiled.cron.Next(query.From.In(compiled.location))
+		}
+		// No single schedule contributes more than Limit windows to the result
+		for n := 0; !start.IsZero() && !start.After(query.Until); n, start = n+1, compiled.cron.Next(start) {
+			if query.Limit > 0 && n == query.Limit {
+				break
+			}
+			actions = append(actions, compiled.action(start))
+		}
+	}
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Start.Before(actions[j].Start) })
+	if query.Limit > 0 && len(actions) > query.Limit {
+		actions = actions[:query.Limit]
+	}
+	return actions
+}
+
+// ServeHTTP serves the upcoming scheduled actions, e.g.
+// GET /api/v1/schedules/preview?namespace=production&within=72h&limit=10
+func (s *ScalingScheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	params := r.URL.Query()
+	s.mu.RLock()
+	now := s.now()
+	s.mu.RUnlock()
+	query := SchedulePreviewQuery{
+		TenantID:  params.Get("tenant"),
+		ClusterID: params.Get("cluster"),
+		Namespace: params.Get("namespace"),
+		Workload:  params.Get("workload"),
+		From:      now,
+		Until:     now.Add(DefaultPreviewWindow),
+		Limit:     100,
+	}
+	if within := params.Get("within"); within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid within %q", within)})
+			return
+		}
+		query.Until = now.Add(d)
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid limit %q", limit)})
+			return
+		}
+		query.Limit = n
+	}
+
+	writeJSON(w, http.StatusOK, s.Preview(query))
+}
@@ -0,0 +1,249 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+var frontendKey = WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "webapp-frontend"}
+
+// frontendSchedules are business hours in Berlin, a nightly cap and a Black Friday override
+func frontendSchedules() []ScalingSchedule {
+	return []ScalingSchedule{
+		{Name: "business-hours", Key: frontendKey, Cron: "0 8 * * 1-5", Timezone: "Europe/Berlin", Duration: 10 * time.Hour, MinReplicas: int32Ptr(6)},
+		{Name: "nightly", Key: frontendKey, Cron: "0 22 * * *", Timezone: "Europe/Berlin", Duration: 8 * time.Hour, MaxReplicas: int32Ptr(2)},
+		{Name: "black-friday", Key: frontendKey, Cron: "0 0 28 11 *", Timezone: "Europe/Berlin", Duration: 24 * time.Hour, Priority: 10, TargetReplicas: int32Ptr(10)},
+	}
+}
+
+// TestScheduledScalingWindows tests scheduled intents, overlap resolution and bounds on reactive recommendations
+func TestScheduledScalingWindows(t *testing.T) {
+	// 07:59 in Berlin on a Monday
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 5, 59, 0, 0, time.UTC)}
+	scheduler, err := NewScalingScheduler(frontendSchedules()...)
+	require.NoError(t, err)
+	scheduler.SetClock(clock.Now)
+
+	handler := NewMockScalingIntentHandler()
+	sink := ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		handler.HandleScalingIntent(intent)
+	})
+	scheduler.AddIntentSink(sink)
+
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 60})
+	require.NoError(t, err)
+	recommender.SetScheduler(scheduler)
+	recommender.AddIntentSink(sink)
+
+	metricsService := NewMockMetricsService()
+	metricsService.SetTenantResolver(func(clusterID string) string { return "tenant-1" })
+	metricsService.AddMetricsSink(scheduler)
+	metricsService.AddMetricsSink(recommender)
+	store := func() {
+		report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(clock.Now())
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+	}
+
+	store()
+	require.Zero(t, scheduler.Tick())
+	handler.Clear()
+
+	// The business-hours window opens and raises the 3 replicas to its minimum
+	clock.Advance(time.Minute)
+	require.Equal(t, 1, scheduler.Tick())
+	require.Zero(t, scheduler.Tick(), "a window emits once when it starts")
+	intents := handler.GetReceivedIntents()
+	require.Len(t, intents, 1)
+	require.Equal(t, "webapp-frontend", intents[0].WorkloadName)
+	require.Equal(t, "deployment", intents[0].WorkloadType)
+	require.Equal(t, int32(6), intents[0].TargetReplicas)
+	require.Equal(t, `schedule "business-hours" (min 6 replicas) until 2025-09-01T16:00:00Z: 3 -> 6 replicas`, intents[0].Reason)
+
+	// Reactive recommendations stay within the window: 65.2% on 3 replicas is within tolerance
+	handler.Clear()
+	store()
+	var frontend *agentv1.ScalingIntent
+	for _, intent := range handler.GetReceivedIntents() {
+		if intent.WorkloadName == "webapp-frontend" {
+			frontend = intent
+		}
+	}
+	require.NotNil(t, frontend)
+	require.Equal(t, int32(6), frontend.TargetReplicas)
+	require.Contains(t, frontend.Reason, `; schedule.minReplicas of policy "business-hours" limited 3 -> 6 (until 2025-09-01T16:00:00Z)`)
+
+	// Outside any window the recommendation is unbounded
+	target, _, ok := scheduler.Bound(frontendKey, 3, time.Date(2025, 9, 1, 19, 0, 0, 0, time.UTC))
+	require.False(t, ok)
+	require.Equal(t, int32(3), target)
+	target, limit, ok := scheduler.Bound(frontendKey, 5, time.Date(2025, 9, 1, 23, 0, 0, 0, time.UTC))
+	require.True(t, ok)
+	require.Equal(t, int32(2), target)
+	require.Equal(t, ConstraintScheduleMaxReplicas, limit.Constraint)
+	require.Equal(t, "nightly", limit.Policy)
+
+	// Black Friday falls on a Friday and overlaps business hours; the higher priority wins
+	blackFriday := time.Date(2025, 11, 28, 9, 0, 0, 0, time.UTC)
+	action, ok := scheduler.Active(frontendKey, blackFriday)
+	require.True(t, ok)
+	require.Equal(t, "black-friday", action.Schedule)
+	require.Equal(t, time.Date(2025, 11, 27, 23, 0, 0, 0, time.UTC), action.Start)
+	target, limit, ok = scheduler.Bound(frontendKey, 12, blackFriday)
+	require.True(t, ok)
+	require.Equal(t, int32(10), target)
+	require.Equal(t, ConstraintScheduleTargetReplicas, limit.Constraint)
+
+	// Among equal priorities the window that started last wins
+	require.NoError(t, scheduler.SetSchedule(ScalingSchedule{Name: "launch", Key: frontendKey, Cron: "30 9 1 9 *", Timezone: "Europe/Berlin", Duration: time.Hour, MinReplicas: int32Ptr(8)}))
+	action, _ = scheduler.Active(frontendKey, time.Date(2025, 9, 1, 7, 45, 0, 0, time.UTC))
+	require.Equal(t, "launch", action.Schedule)
+	require.True(t, scheduler.RemoveSchedule("launch"))
+	require.False(t, scheduler.RemoveSchedule("launch"))
+
+	// Fixed targets are sent even before the workload has reported
+	unobserved := WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-2", Namespace: "batch", Workload: "importer"}
+	require.NoError(t, scheduler.SetSchedule(ScalingSchedule{Name: "import", Key: unobserved, WorkloadType: "statefulset", Cron: "0 * * * *", Duration: 15 * time.Minute, TargetReplicas: int32Ptr(4)}))
+	handler.Clear()
+	clock.Advance(time.Hour)
+	require.Equal(t, 1, scheduler.Tick())
+	intents = handler.GetReceivedIntents()
+	require.Equal(t, "statefulset", intents[0].WorkloadType)
+	require.Equal(t, int32(4), intents[0].TargetReplicas)
+	require.Equal(t, `schedule "import" (4 replicas) until 2025-09-01T07:15:00Z`, intents[0].Reason)
+
+	for _, invalid := range []ScalingSchedule{
+		{Name: "no-bounds", Key: frontendKey, Cron: "@daily", Duration: time.Hour},
+		{Name: "bad-cron", Key: frontendKey, Cron: "0 25 * * *", Duration: time.Hour, MinReplicas: int32Ptr(1)},
+		{Name: "bad-zone", Key: frontendKey, Cron: "@daily", Timezone: "Mars/Olympus", Duration: time.Hour, MinReplicas: int32Ptr(1)},
+		{Name: "no-duration", Key: frontendKey, Cron: "@daily", MinReplicas: int32Ptr(1)},
+		{Name: "mixed", Key: frontendKey, Cron: "@daily", Duration: time.Hour, MinReplicas: int32Ptr(1), TargetReplicas: int32Ptr(2)},
+		{Name: "inverted", Key: frontendKey, Cron: "@daily", Duration: time.Hour, MinReplicas: int32Ptr(5), MaxReplicas: int32Ptr(2)},
+		{Name: "daemonset", Key: frontendKey, WorkloadType: "daemonset", Cron: "@daily", Duration: time.Hour, MinReplicas: int32Ptr(1)},
+		{Name: "no-workload", Key: WorkloadKey{ClusterID: "cluster-1"}, Cron: "@daily", Duration: time.Hour, MinReplicas: int32Ptr(1)},
+	} {
+		require.Error(t, scheduler.SetSchedule(invalid), invalid.Name)
+	}
+	require.Len(t, scheduler.Schedules(), 4)
+}
+
+// TestSchedulePreviewAPI tests the listing of upcoming scheduled actions
+func TestSchedulePreviewAPI(t *testing.T) {
+	scheduler, err := NewScalingScheduler(frontendSchedules()...)
+	require.NoError(t, err)
+
+	// Windows follow the local time across the end of daylight saving time
+	actions := scheduler.Preview(SchedulePreviewQuery{
+		From:  time.Date(2025, 10, 24, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2025, 10, 28, 0, 0, 0, 0, time.UTC),
+	})
+	var starts []time.Time
+	for _, action := range actions {
+		if action.Schedule == "business-hours" {
+			starts = append(starts, action.Start)
+		}
+	}
+	require.Equal(t, []time.Time{time.Date(2025, 10, 24, 6, 0, 0, 0, time.UTC), time.Date(2025, 10, 27, 7, 0, 0, 0, time.UTC)}, starts)
+
+	// Midnight on a Monday
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)}
+	scheduler.SetClock(clock.Now)
+	httpServer := NewMockHTTPServer(NewMockMetricsService())
+	httpServer.Handle("/api/v1/schedules/preview", scheduler)
+	server := httptest.NewServer(httpServer.Handler())
+	defer server.Close()
+
+	get := func(query string) (int, []ScheduledAction) {
+		resp, err := http.Get(server.URL + "/api/v1/schedules/preview" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var actions []ScheduledAction
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&actions))
+		}
+		return resp.StatusCode, actions
+	}
+
+	status, actions := get("?workload=webapp-frontend&within=48h")
+	require.Equal(t, http.StatusOK, status)
+	var names []string
+	for _, action := range actions {
+		names = append(names, action.Schedule)
+	}
+	require.Equal(t, []string{"nightly", "business-hours", "nightly", "business-hours", "nightly"}, names,
+		"the nightly window of Sunday is still active at midnight")
+	require.Equal(t, time.Date(2025, 8, 31, 20, 0, 0, 0, time.UTC), actions[0].Start)
+	require.Equal(t, time.Date(2025, 9, 1, 16, 0, 0, 0, time.UTC), actions[1].End)
+	require.Equal(t, int32(6), *actions[1].MinReplicas)
+	require.Nil(t, actions[1].MaxReplicas)
+
+	status, actions = get("?limit=2")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, actions, 2)
+
+	status, actions = get("?namespace=logging")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, actions)
+
+	status, _ = get("?within=soon")
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = get("?limit=0")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+// TestScheduleBoundsRecordedByPolicies tests that a target raised by a schedule counts
+// against the scale-up rate of the policies
+func TestScheduleBoundsRecordedByPolicies(t *testing.T) {
+	// 08:01 in Berlin on a Monday, inside business hours
+	now := time.Date(2025, 9, 1, 6, 1, 0, 0, time.UTC)
+	scheduler, err := NewScalingScheduler(frontendSchedules()...)
+	require.NoError(t, err)
+	engine, err := NewScalingPolicyEngine(ScalingPolicy{Name: "slow-up", ScaleUp: &ScalingRules{
+		StabilizationWindow: durationPtr(0),
+		Policies:            []RatePolicy{{Type: RatePolicyPods, Value: 4, Period: 10 * time.Minute}},
+	}})
+	require.NoError(t, err)
+
+	recommender, err := NewReplicaRecommender(RecommenderConfig{TargetCPUPercentage: 60})
+	require.NoError(t, err)
+	recommender.SetPolicyEngine(engine)
+	recommender.SetScheduler(scheduler)
+	var intents []*agentv1.ScalingIntent
+	recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agentv1.ScalingIntent) {
+		if intent.WorkloadName == "webapp-frontend" {
+			intents = append(intents, intent)
+		}
+	}))
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 15)
+	report.Timestamp = timestamppb.New(now)
+	recommender.Ingest("tenant-1", report)
+	require.Len(t, intents, 1)
+	require.Equal(t, int32(6), intents[0].TargetReplicas)
+
+	var decision ScalingDecision
+	for _, d := range engine.Decisions() {
+		if d.Key == frontendKey {
+			decision = d
+		}
+	}
+	require.Equal(t, int32(6), decision.TargetReplicas)
+	limit, ok := decision.ClampedBy()
+	require.True(t, ok)
+	require.Equal(t, ConstraintScheduleMinReplicas, limit.Constraint)
+
+	// The 3 replicas added by the schedule leave 1 of the 4 allowed in the period
+	decision = engine.Decide(frontendKey, 6, 12, now.Add(time.Minute))
+	require.Equal(t, int32(7), decision.TargetReplicas)
+	limit, _ = decision.ClampedBy()
+	require.Equal(t, ConstraintScaleUpRate, limit.Constraint)
+}
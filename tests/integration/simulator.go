@@ -0,0 +1,290 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// SimulationConfig is the autoscaler setup to replay recorded metrics against
+type SimulationConfig struct {
+	TenantID    string
+	Recommender RecommenderConfig
+	Policies    []ScalingPolicy
+	// ApplyDelay is how long an issued intent takes to change the replicas. With
+	// zero delay the new replicas are in place from the next report on.
+	ApplyDelay time.Duration
+}
+
+// SimulatedIntent is an intent the autoscaler would have issued
+type SimulatedIntent struct {
+	Timestamp      time.Time `json:"timestamp"`
+	ClusterID      string    `json:"clusterId"`
+	Namespace      string    `json:"namespace"`
+	Workload       string    `json:"workload"`
+	FromReplicas   int32     `json:"fromReplicas"`
+	TargetReplicas int32     `json:"targetReplicas"`
+	Reason         string    `json:"reason"`
+}
+
+// ReplicaPoint is one step of the replica curve of a workload
+type ReplicaPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Recorded  int32     `json:"recorded"`
+	Simulated int32     `json:"simulated"`
+	// Required is what an unconstrained autoscaler would run for the simulated load
+	Required int32 `json:"required"`
+}
+
+// WorkloadSimulation is the replayed history of one workload. Replica-hours are
+// integrated over the time between consecutive reports.
+type WorkloadSimulation struct {
+	ClusterID                    string         `json:"clusterId"`
+	Namespace                    string         `json:"namespace"`
+	Workload                     string         `json:"workload"`
+	Intents                      int            `json:"intents"`
+	PeakReplicas                 int32          `json:"peakReplicas"`
+	RecordedReplicaHours         float64        `json:"recordedReplicaHours"`
+	SimulatedReplicaHours        float64        `json:"simulatedReplicaHours"`
+	OverProvisionedReplicaHours  float64        `json:"overProvisionedReplicaHours"`
+	UnderProvisionedReplicaHours float64        `json:"underProvisionedReplicaHours"`
+	Points                       []ReplicaPoint `json:"points"`
+}
+
+// SimulationResult is the outcome of a dry run
+type SimulationResult struct {
+	Start     time.Time            `json:"start"`
+	End       time.Time            `json:"end"`
+	Reports   int                  `json:"reports"`
+	Intents   []SimulatedIntent    `json:"intents"`
+	Workloads []WorkloadSimulation `json:"workloads"`
+}
+
+// Simulator replays MetricsReports through the recommender and scaling policies in
+// virtual time taken from the report timestamps. Utilization is rescaled to the
+// simulated replicas assuming the load stays the same, so each decision sees what
+// the workload would have looked like had earlier intents been applied.
+type Simulator struct {
+	config SimulationConfig
+}
+
+func NewSimulator(config SimulationConfig) (*Simulator, error) {
+	if _, err := NewReplicaRecommender(config.Recommender); err != nil {
+		return nil, err
+	}
+	if _, err := NewScalingPolicyEngine(config.Policies...); err != nil {
+		return nil, err
+	}
+	if config.ApplyDelay < 0 {
+		return nil, fmt.Errorf("apply delay must not be negative")
+	}
+	return &Simulator{config: config}, nil
+}
+
+type pendingScale struct {
+	at       time.Time
+	replicas int32
+}
+
+type simulatedWorkload struct {
+	result   *WorkloadSimulation
+	replicas int32
+	pending  []pendingScale
+}
+
+// Run replays the reports in timestamp order with a fresh recommender and policy engine
+func (s *Simulator) Run(reports []*agent.MetricsReport) (*SimulationResult, error) {
+	recommender, err := NewReplicaRecommender(s.config.Recommender)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := NewScalingPolicyEngine(s.config.Policies...)
+	if err != nil {
+		return nil, err
+	}
+	recommender.SetPolicyEngine(policies)
+
+	ordered := append([]*agent.MetricsReport(nil), reports...)
+	sort.SliceStable(ordered, func(i, j int) bool { return reportTime(ordered[i]).Before(reportTime(ordered[j])) })
+
+	result := &SimulationResult{Reports: len(ordered), Intents: []SimulatedIntent{}}
+	workloads := make(map[WorkloadKey]*simulatedWorkload)
+	var order []WorkloadKey
+
+	var now time.Time
+	var replayed map[WorkloadKey]int32
+	recommender.AddIntentSink(ScalingIntentSinkFunc(func(clusterID string, intent *agent.ScalingIntent) {
+		key := WorkloadKey{TenantID: s.config.TenantID, ClusterID: clusterID, Namespace: intent.WorkloadNamespace, Workload: intent.WorkloadName}
+		state := workloads[key]
+		state.pending = append(state.pending, pendingScale{at: now.Add(s.config.ApplyDelay), replicas: intent.TargetReplicas})
+		state.result.Intents++
+		result.Intents = append(result.Intents, SimulatedIntent{
+			Timestamp:      now,
+			ClusterID:      clusterID,
+			Namespace:      intent.WorkloadNamespace,
+			Workload:       intent.WorkloadName,
+			FromReplicas:   replayed[key],
+			TargetReplicas: intent.TargetReplicas,
+			Reason:         intent.Reason,
+		})
+	}))
+
+	for i, report := range ordered {
+		now = reportTime(report)
+		if i == 0 {
+			result.Start = now
+		}
+		result.End = now
+
+		sim := proto.Clone(report).(*agent.MetricsReport)
+		replayed = make(map[WorkloadKey]int32)
+		for _, workload := range sim.WorkloadMetrics {
+			key := WorkloadKey{TenantID: s.config.TenantID, ClusterID: sim.ClusterId, Namespace: workload.Namespace, Workload: workload.WorkloadName}
+			state, exists := workloads[key]
+			if !exists {
+				state = &simulatedWorkload{
+					result:   &WorkloadSimulation{ClusterID: sim.ClusterId, Namespace: workload.Namespace, Workload: workload.WorkloadName},
+					replicas: workload.Replicas,
+				}
+				workloads[key] = state
+				order = append(order, key)
+			}
+			for len(state.pending) > 0 && !state.pending[0].at.After(now) {
+				state.replicas = state.pending[0].replicas
+				state.pending = state.pending[1:]
+			}
+
+			recorded := workload.Replicas
+			replayWorkload(workload, state.replicas)
+			replayed[key] = state.replicas
+
+			required := state.replicas
+			if recommendation, ok := recommender.Recommend(workload); ok {
+				required = recommendation.DesiredReplicas
+			}
+			state.result.Points = append(state.result.Points, ReplicaPoint{
+				Timestamp: now,
+				Recorded:  recorded,
+				Simulated: state.replicas,
+				Required:  required,
+			})
+		}
+		recommender.Ingest(s.config.TenantID, sim)
+	}
+
+	for _, key := range order {
+		workload := workloads[key].result
+		workload.summarize()
+		result.Workloads = append(result.Workloads, *workload)
+	}
+	return result, nil
+}
+
+// replayWorkload rescales the recorded workload to the simulated replicas. Usage
+// percentages are per replica, so the same load spreads inversely to the replicas;
+// custom metrics are workload totals and stay as recorded.
+func replayWorkload(workload *agent.WorkloadMetric, replicas int32) {
+	recorded := workload.Replicas
+	unavailable := workload.Replicas - workload.AvailableReplicas
+	workload.Replicas = replicas
+	workload.AvailableReplicas = max(replicas-unavailable, 0)
+	if recorded <= 0 || replicas <= 0 || workload.Usage == nil {
+		return
+	}
+	scale := float64(recorded) / float64(replicas)
+	workload.Usage.CpuPercentage *= scale
+	workload.Usage.MemoryPercentage *= scale
+}
+
+func (w *WorkloadSimulation) summarize() {
+	for i, point := range w.Points {
+		w.PeakReplicas = max(w.PeakReplicas, point.Simulated)
+		if i == len(w.Points)-1 {
+			break
+		}
+		hours := w.Points[i+1].Timestamp.Sub(point.Timestamp).Hours()
+		w.RecordedReplicaHours += float64(point.Recorded) * hours
+		w.SimulatedReplicaHours += float64(point.Simulated) * hours
+		if diff := point.Simulated - point.Required; diff > 0 {
+			w.OverProvisionedReplicaHours += float64(diff) * hours
+		} else {
+			w.UnderProvisionedReplicaHours += float64(-diff) * hours
+		}
+	}
+}
+
+// WriteJSON writes the full result, including the replica curves, as indented JSON
+func (r *SimulationResult) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Summary returns a compact table of the workloads the autoscaler would have scaled
+func (r *SimulationResult) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Simulated %s to %s: %d reports, %d intents\n",
+		r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Reports, len(r.Intents))
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKLOAD\tINTENTS\tPEAK\tRECORDED\tSIMULATED\tOVER\tUNDER")
+	var recorded, simulated, over, under float64
+	for _, w := range r.Workloads {
+		recorded += w.RecordedReplicaHours
+		simulated += w.SimulatedReplicaHours
+		over += w.OverProvisionedReplicaHours
+		under += w.UnderProvisionedReplicaHours
+		if w.Intents == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "%s/%s/%s\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\n", w.ClusterID, w.Namespace, w.Workload,
+			w.Intents, w.PeakReplicas, w.RecordedReplicaHours, w.SimulatedReplicaHours, w.OverProvisionedReplicaHours, w.UnderProvisionedReplicaHours)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t\t%.1f\t%.1f\t%.1f\t%.1f\n", len(r.Intents), recorded, simulated, over, under)
+	tw.Flush()
+	b.WriteString("(replica-hours)\n")
+	return b.String()
+}
+
+// LoadRecordedReports reads MetricsReports recorded as one protojson object per line
+func LoadRecordedReports(r io.Reader) ([]*agent.MetricsReport, error) {
+	var reports []*agent.MetricsReport
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		report := &agent.MetricsReport{}
+		if err := protojson.Unmarshal([]byte(text), report); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, scanner.Err()
+}
+
+// RecordReports writes MetricsReports in the format read by LoadRecordedReports
+func RecordReports(w io.Writer, reports []*agent.MetricsReport) error {
+	for _, report := range reports {
+		data, err := protojson.Marshal(report)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
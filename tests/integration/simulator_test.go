@@ -0,0 +1,148 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+func simulatedReport(ts time.Time, replicas int32, cpu float64) *agentv1.MetricsReport {
+	return &agentv1.MetricsReport{
+		ClusterId: "cluster-1",
+		Timestamp: timestamppb.New(ts),
+		WorkloadMetrics: []*agentv1.WorkloadMetric{{
+			Namespace:         "production",
+			WorkloadName:      "api",
+			WorkloadType:      "deployment",
+			Replicas:          replicas,
+			AvailableReplicas: replicas,
+			Usage:             &agentv1.ResourceUsage{CpuPercentage: cpu},
+		}},
+	}
+}
+
+// TestSimulatorReplicaCurve tests the replayed decisions and provisioning estimate on a hand-computed series
+func TestSimulatorReplicaCurve(t *testing.T) {
+	simulator, err := NewSimulator(SimulationConfig{
+		TenantID:    "tenant-1",
+		Recommender: RecommenderConfig{TargetCPUPercentage: 50},
+		Policies:    []ScalingPolicy{{Name: "fast-down", ScaleDown: &ScalingRules{StabilizationWindow: durationPtr(0)}}},
+	})
+	require.NoError(t, err)
+
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	var reports []*agentv1.MetricsReport
+	for i, cpu := range []float64{50, 100, 100, 25} {
+		reports = append(reports, simulatedReport(base.Add(time.Duration(i)*time.Minute), 2, cpu))
+	}
+	// Replay order follows the report timestamps, not the slice order
+	reports[1], reports[2] = reports[2], reports[1]
+
+	result, err := simulator.Run(reports)
+	require.NoError(t, err)
+	require.Equal(t, base, result.Start)
+	require.Equal(t, base.Add(3*time.Minute), result.End)
+
+	require.Len(t, result.Intents, 2)
+	require.Equal(t, SimulatedIntent{
+		Timestamp:      base.Add(time.Minute),
+		ClusterID:      "cluster-1",
+		Namespace:      "production",
+		Workload:       "api",
+		FromReplicas:   2,
+		TargetReplicas: 4,
+		Reason:         "cpu_percentage at 100.0% across 2 available replicas is above the 50.0% target: 2 -> 4 replicas",
+	}, result.Intents[0])
+	// The recorded 25% on 2 replicas is 12.5% on the simulated 4
+	require.Equal(t, int32(1), result.Intents[1].TargetReplicas)
+	require.Contains(t, result.Intents[1].Reason, "cpu_percentage at 12.5%")
+
+	require.Len(t, result.Workloads, 1)
+	workload := result.Workloads[0]
+	require.Equal(t, []ReplicaPoint{
+		{Timestamp: base, Recorded: 2, Simulated: 2, Required: 2},
+		{Timestamp: base.Add(time.Minute), Recorded: 2, Simulated: 2, Required: 4},
+		{Timestamp: base.Add(2 * time.Minute), Recorded: 2, Simulated: 4, Required: 4},
+		{Timestamp: base.Add(3 * time.Minute), Recorded: 2, Simulated: 4, Required: 1},
+	}, workload.Points)
+	require.Equal(t, int32(4), workload.PeakReplicas)
+	require.InDelta(t, 6.0/60, workload.RecordedReplicaHours, 1e-9)
+	require.InDelta(t, 8.0/60, workload.SimulatedReplicaHours, 1e-9)
+	require.InDelta(t, 2.0/60, workload.UnderProvisionedReplicaHours, 1e-9)
+	require.Zero(t, workload.OverProvisionedReplicaHours)
+
+	// A slow rollout keeps the old replicas for longer
+	delayed, err := NewSimulator(SimulationConfig{Recommender: RecommenderConfig{TargetCPUPercentage: 50}, ApplyDelay: 90 * time.Second})
+	require.NoError(t, err)
+	result, err = delayed.Run(reports)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), result.Workloads[0].Points[2].Simulated)
+	require.Equal(t, int32(4), result.Workloads[0].Points[3].Simulated)
+
+	_, err = NewSimulator(SimulationConfig{})
+	require.Error(t, err)
+}
+
+// TestSimulatorRecordedWeek tests a replay of stored and recorded reports with JSON and text output
+func TestSimulatorRecordedWeek(t *testing.T) {
+	metricsService := NewMockMetricsService()
+	generator := NewTestDataGenerator()
+	day := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+	for ts := day; ts.Before(day.Add(48 * time.Hour)); ts = ts.Add(15 * time.Minute) {
+		report := generator.GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(ts)
+		frontend := report.WorkloadMetrics[5]
+		frontend.Replicas, frontend.AvailableReplicas = 4, 4
+		frontend.Usage.CpuPercentage = diurnalCPU(ts)
+		require.NoError(t, metricsService.StoreMetrics(context.Background(), report))
+	}
+
+	var recording bytes.Buffer
+	require.NoError(t, RecordReports(&recording, metricsService.GetReceivedMetrics()))
+	reports, err := LoadRecordedReports(&recording)
+	require.NoError(t, err)
+	require.Len(t, reports, 192)
+
+	simulator, err := NewSimulator(SimulationConfig{Recommender: RecommenderConfig{TargetCPUPercentage: 50}})
+	require.NoError(t, err)
+	result, err := simulator.Run(reports)
+	require.NoError(t, err)
+	require.Equal(t, 192, result.Reports)
+
+	var frontend WorkloadSimulation
+	for _, workload := range result.Workloads {
+		if workload.Workload == "webapp-frontend" {
+			frontend = workload
+		}
+	}
+	require.Len(t, frontend.Points, 192)
+	require.Equal(t, int32(5), frontend.PeakReplicas, "65% at noon on 4 replicas is 52% on 5, within tolerance of 50%")
+	require.Equal(t, int32(4), frontend.Points[0].Simulated)
+	require.Equal(t, int32(3), frontend.Points[1].Simulated, "35% at night on 4 replicas needs 3")
+	require.Positive(t, frontend.Intents)
+	require.Less(t, frontend.SimulatedReplicaHours, frontend.RecordedReplicaHours)
+
+	var encoded bytes.Buffer
+	require.NoError(t, result.WriteJSON(&encoded))
+	var decoded SimulationResult
+	require.NoError(t, json.Unmarshal(encoded.Bytes(), &decoded))
+	require.Equal(t, len(result.Intents), len(decoded.Intents))
+	require.Contains(t, encoded.String(), `"underProvisionedReplicaHours"`)
+
+	summary := result.Summary()
+	require.True(t, strings.HasPrefix(summary, "Simulated 2025-09-01T00:00:00Z to 2025-09-02T23:45:00Z: 192 reports"))
+	require.Contains(t, summary, "cluster-1/production/webapp-frontend")
+	require.Contains(t, summary, "TOTAL")
+	require.NotContains(t, summary, "redis", "workloads without intents are left out of the table")
+
+	_, err = LoadRecordedReports(strings.NewReader("{\"clusterId\": \"cluster-1\"}\nnot json\n"))
+	require.ErrorContains(t, err, "line 2")
+}
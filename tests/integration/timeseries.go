@@ -0,0 +1,270 @@
+package integration
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	agent "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// Metric names used for the ResourceUsage fields of a WorkloadMetric
+const (
+	MetricCPUPercentage     = "cpu_percentage"
+	MetricMemoryPercentage  = "memory_percentage"
+	MetricStoragePercentage = "storage_percentage"
+	MetricPodsRunning       = "pods_running"
+)
+
+// SeriesKey identifies a single metric series of a workload
+type SeriesKey struct {
+	TenantID  string
+	ClusterID string
+	Namespace string
+	Workload  string
+	Metric    string
+}
+
+func (k SeriesKey) String() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", k.TenantID, k.ClusterID, k.Namespace, k.Workload, k.Metric)
+}
+
+// Sample is a single timestamped value of a series
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// Aggregation selects how samples falling into one step are combined when downsampling
+type Aggregation string
+
+const (
+	AggregationAvg   Aggregation = "avg"
+	AggregationMin   Aggregation = "min"
+	AggregationMax   Aggregation = "max"
+	AggregationSum   Aggregation = "sum"
+	AggregationLast  Aggregation = "last"
+	AggregationCount Aggregation = "count"
+)
+
+// TimeSeriesStore keeps ingested workload metrics as time-ordered samples per series
+type TimeSeriesStore struct {
+	series    map[SeriesKey][]Sample
+	retention time.Duration
+	mu        sync.RWMutex
+}
+
+// NewTimeSeriesStore creates a store that drops samples older than retention.
+// A zero retention keeps samples forever.
+func NewTimeSeriesStore(retention time.Duration) *TimeSeriesStore {
+	return &TimeSeriesStore{
+		series:    make(map[SeriesKey][]Sample),
+		retention: retention,
+	}
+}
+
+// Ingest appends the usage and custom metrics of every workload in the report
+func (s *TimeSeriesStore) Ingest(tenantID string, report *agent.MetricsReport) {
+	ts := reportTime(report)
+
+	for _, workload := range report.WorkloadMetrics {
+		key := SeriesKey{
+			TenantID:  tenantID,
+			ClusterID: report.ClusterId,
+			Namespace: workload.Namespace,
+			Workload:  workload.WorkloadName,
+		}
+
+		if usage := workload.Usage; usage != nil {
+			s.appendMetric(key, MetricCPUPercentage, ts, usage.CpuPercentage)
+			s.appendMetric(key, MetricMemoryPercentage, ts, usage.MemoryPercentage)
+			s.appendMetric(key, MetricStoragePercentage, ts, usage.StoragePercentage)
+			s.appendMetric(key, MetricPodsRunning, ts, float64(usage.PodsRunning))
+		}
+
+		for name, value := range workload.CustomMetrics {
+			s.appendMetric(key, name, ts, value)
+		}
+	}
+}
+
+func (s *TimeSeriesStore) appendMetric(key SeriesKey, metric string, ts time.Time, value float64) {
+	key.Metric = metric
+	s.Append(key, Sample{Timestamp: ts, Value: value})
+}
+
+// Append adds a sample to a series, keeping the series ordered by timestamp
+func (s *TimeSeriesStore) Append(key SeriesKey, sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := s.series[key]
+
+	// Reports normally arrive in order, so only search when they do not
+	if n := len(samples); n == 0 || !sample.Timestamp.Before(samples[n-1].Timestamp) {
+		samples = append(samples, sample)
+	} else {
+		i := sort.Search(n, func(i int) bool { return samples[i].Timestamp.After(sample.Timestamp) })
+		samples = append(samples, Sample{})
+		copy(samples[i+1:], samples[i:])
+		samples[i] = sample
+	}
+
+	if s.retention > 0 {
+		cutoff := samples[len(samples)-1].Timestamp.Add(-s.retention)
+		samples = samples[firstAtOrAfter(samples, cutoff):]
+	}
+
+	s.series[key] = samples
+}
+
+// Query returns the raw samples of a series within [start, end]
+func (s *TimeSeriesStore) Query(key SeriesKey, start, end time.Time) []Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	samples := s.series[key]
+	from := firstAtOrAfter(samples, start)
+	to := firstAtOrAfter(samples, end.Add(time.Nanosecond))
+	if from >= to {
+		return nil
+	}
+
+	result := make([]Sample, to-from)
+	copy(result, samples[from:to])
+	return result
+}
+
+// QueryRange downsamples a series within [start, end] into step-aligned buckets.
+// Each returned sample is stamped with the start of its bucket; empty buckets are omitted.
+func (s *TimeSeriesStore) QueryRange(key SeriesKey, start, end time.Time, step time.Duration, agg Aggregation) ([]Sample, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive, got %v", step)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("end %v is before start %v", end, start)
+	}
+
+	samples := s.Query(key, start, end)
+	result := make([]Sample, 0)
+
+	for i := 0; i < len(samples); {
+		bucket := samples[i].Timestamp.Truncate(step)
+		j := i
+		for j < len(samples) && samples[j].Timestamp.Truncate(step).Equal(bucket) {
+			j++
+		}
+
+		value, err := aggregate(samples[i:j], agg)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Sample{Timestamp: bucket, Value: value})
+		i = j
+	}
+
+	return result, nil
+}
+
+// Latest returns the most recent sample of a series
+func (s *TimeSeriesStore) Latest(key SeriesKey) (Sample, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	samples := s.series[key]
+	if len(samples) == 0 {
+		return Sample{}, false
+	}
+	return samples[len(samples)-1], true
+}
+
+// Series lists the keys of all series accepted by match, or all series if match is nil
+func (s *TimeSeriesStore) Series(match func(SeriesKey) bool) []SeriesKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]SeriesKey, 0, len(s.series))
+	for key := range s.series {
+		if match == nil || match(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+// EnforceRetention drops samples older than the retention period relative to now
+// and returns the number of samples removed
+func (s *TimeSeriesStore) EnforceRetention(now time.Time) int {
+	if s.retention <= 0 {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := now.Add(-s.retention)
+	removed := 0
+	for key, samples := range s.series {
+		i := firstAtOrAfter(samples, cutoff)
+		removed += i
+		if i == len(samples) {
+			delete(s.series, key)
+			continue
+		}
+		s.series[key] = samples[i:]
+	}
+
+	return removed
+}
+
+// firstAtOrAfter returns the index of the first sample not before t
+func firstAtOrAfter(samples []Sample, t time.Time) int {
+	return sort.Search(len(samples), func(i int) bool { return !samples[i].Timestamp.Before(t) })
+}
+
+func aggregate(samples []Sample, agg Aggregation) (float64, error) {
+	switch agg {
+	case AggregationAvg, "":
+		sum := 0.0
+		for _, sample := range samples {
+			sum += sample.Value
+		}
+		return sum / float64(len(samples)), nil
+	case AggregationMin:
+		min := math.Inf(1)
+		for _, sample := range samples {
+			min = math.Min(min, sample.Value)
+		}
+		return min, nil
+	case AggregationMax:
+		max := math.Inf(-1)
+		for _, sample := range samples {
+			max = math.Max(max, sample.Value)
+		}
+		return max, nil
+	case AggregationSum:
+		sum := 0.0
+		for _, sample := range samples {
+			sum += sample.Value
+		}
+		return sum, nil
+	case AggregationLast:
+		return samples[len(samples)-1].Value, nil
+	case AggregationCount:
+		return float64(len(samples)), nil
+	default:
+		return 0, fmt.Errorf("unsupported aggregation %q", agg)
+	}
+}
+
+// reportTime returns the report timestamp, falling back to now for reports without one
+func reportTime(report *agent.MetricsReport) time.Time {
+	if report.Timestamp != nil {
+		return report.Timestamp.AsTime()
+	}
+	return time.Now()
+}
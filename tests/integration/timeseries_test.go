@@ -0,0 +1,124 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+// TestTimeSeriesStoreIngestAndQuery tests that reports are split into per-workload series
+func TestTimeSeriesStoreIngestAndQuery(t *testing.T) {
+	store := NewTimeSeriesStore(0)
+	generator := NewTestDataGenerator()
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 10; i++ {
+		report := generator.GenerateMetricsReport("cluster-1", 15)
+		report.Timestamp = timestamppb.New(base.Add(time.Duration(i) * time.Minute))
+		store.Ingest("tenant-1", report)
+	}
+
+	key := SeriesKey{
+		TenantID:  "tenant-1",
+		ClusterID: "cluster-1",
+		Namespace: "production",
+		Workload:  "webapp-backend",
+		Metric:    MetricCPUPercentage,
+	}
+
+	samples := store.Query(key, base.Add(5*time.Minute), base.Add(9*time.Minute))
+	require.Len(t, samples, 5)
+	require.Equal(t, base.Add(5*time.Minute), samples[0].Timestamp)
+	require.Equal(t, 58.9, samples[0].Value)
+
+	custom := key
+	custom.Metric = "restarts_total"
+	require.Len(t, store.Query(custom, base, base.Add(time.Hour)), 10)
+
+	latest, ok := store.Latest(key)
+	require.True(t, ok)
+	require.Equal(t, base.Add(9*time.Minute), latest.Timestamp)
+
+	keys := store.Series(func(k SeriesKey) bool {
+		return k.Workload == "webapp-backend" && k.Metric == MetricCPUPercentage
+	})
+	require.Equal(t, []SeriesKey{key}, keys)
+}
+
+// TestTimeSeriesStoreDownsampling tests step-aligned aggregation and out-of-order appends
+func TestTimeSeriesStoreDownsampling(t *testing.T) {
+	store := NewTimeSeriesStore(0)
+	key := SeriesKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "default", Workload: "app", Metric: MetricCPUPercentage}
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	// Samples every 30s, appended in reverse to exercise ordered insertion
+	for i := 11; i >= 0; i-- {
+		store.Append(key, Sample{Timestamp: base.Add(time.Duration(i) * 30 * time.Second), Value: float64(i)})
+	}
+
+	avg, err := store.QueryRange(key, base, base.Add(time.Hour), 2*time.Minute, AggregationAvg)
+	require.NoError(t, err)
+	require.Equal(t, []Sample{
+		{Timestamp: base, Value: 1.5},
+		{Timestamp: base.Add(2 * time.Minute), Value: 5.5},
+		{Timestamp: base.Add(4 * time.Minute), Value: 9.5},
+	}, avg)
+
+	max, err := store.QueryRange(key, base, base.Add(time.Hour), 2*time.Minute, AggregationMax)
+	require.NoError(t, err)
+	require.Equal(t, 11.0, max[2].Value)
+
+	_, err = store.QueryRange(key, base, base.Add(time.Hour), 0, AggregationAvg)
+	require.Error(t, err)
+
+	_, err = store.QueryRange(key, base, base.Add(time.Hour), time.Minute, "median")
+	require.Error(t, err)
+}
+
+// TestTimeSeriesStoreRetention tests that old samples are dropped on append and on sweep
+func TestTimeSeriesStoreRetention(t *testing.T) {
+	store := NewTimeSeriesStore(10 * time.Minute)
+	key := SeriesKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "default", Workload: "app", Metric: MetricCPUPercentage}
+	base := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i <= 30; i++ {
+		store.Append(key, Sample{Timestamp: base.Add(time.Duration(i) * time.Minute), Value: float64(i)})
+	}
+
+	samples := store.Query(key, base, base.Add(time.Hour))
+	require.Len(t, samples, 11)
+	require.Equal(t, base.Add(20*time.Minute), samples[0].Timestamp)
+
+	removed := store.EnforceRetention(base.Add(35 * time.Minute))
+	require.Equal(t, 5, removed)
+
+	removed = store.EnforceRetention(base.Add(2 * time.Hour))
+	require.Equal(t, 6, removed)
+	require.Empty(t, store.Series(nil))
+}
+
+// TestMetricsServiceFeedsTimeSeriesStore tests that reports stored through the gRPC mock reach the store
+func TestMetricsServiceFeedsTimeSeriesStore(t *testing.T) {
+	clusterService := NewMockClusterService()
+	metricsService := NewMockMetricsService()
+	NewMockGRPCServer(NewMockAuthService(), clusterService, metricsService)
+
+	store := NewTimeSeriesStore(time.Hour)
+	metricsService.AddMetricsSink(store)
+
+	ctx := context.Background()
+	_, err := clusterService.RegisterCluster(ctx, &agentv1.RegisterClusterRequest{Name: "cluster-1", TenantId: "tenant-1"})
+	require.NoError(t, err)
+
+	report := NewTestDataGenerator().GenerateMetricsReport("cluster-1", 3)
+	require.NoError(t, metricsService.StoreMetrics(ctx, report))
+
+	keys := store.Series(func(k SeriesKey) bool { return k.Metric == MetricMemoryPercentage })
+	require.Len(t, keys, 3)
+	require.Equal(t, "tenant-1", keys[0].TenantID)
+}
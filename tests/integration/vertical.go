@@ -0,0 +1,456 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	// ErrUnknownResources is returned for workloads whose requests were never reported
+	ErrUnknownResources = errors.New("unknown workload resources")
+	// ErrInsufficientUsage is returned for workloads without enough usage history
+	ErrInsufficientUsage = errors.New("insufficient usage history for a resource recommendation")
+)
+
+// ContainerResources are the requests and limits of one container of a pod template
+type ContainerResources struct {
+	Name     string              `json:"name"`
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	Limits   corev1.ResourceList `json:"limits,omitempty"`
+}
+
+// WorkloadResources are the container resources of a workload's pod template
+type WorkloadResources struct {
+	Namespace    string               `json:"namespace"`
+	Workload     string               `json:"workload"`
+	WorkloadType string               `json:"workloadType"`
+	Replicas     int32                `json:"replicas"`
+	Containers   []ContainerResources `json:"containers"`
+}
+
+// ResourcesReport is what an agent reports about the pod templates of the workloads
+// in its metrics report. It is the Go form of a message the proto does not have yet,
+// attached to the metrics report it describes.
+type ResourcesReport struct {
+	ClusterID string              `json:"clusterId"`
+	Timestamp time.Time           `json:"timestamp"`
+	Workloads []WorkloadResources `json:"workloads"`
+}
+
+func (r ResourcesReport) attachedCluster() string {
+	return r.ClusterID
+}
+
+// VerticalScalingIntent asks an agent to set the container resources of a pod
+// template. Containers not listed are left alone, as are resources not listed.
+type VerticalScalingIntent struct {
+	IntentID     string               `json:"intentId"`
+	Namespace    string               `json:"namespace"`
+	WorkloadName string               `json:"workloadName"`
+	WorkloadType string               `json:"workloadType"`
+	Containers   []ContainerResources `json:"containers"`
+	Reason       string               `json:"reason"`
+}
+
+// DefaultVerticalHistory is how far back a VerticalRecommender considers usage when
+// no History is configured. The RollupEngine it reads must maintain this window.
+const DefaultVerticalHistory = 8 * 24 * time.Hour
+
+// VerticalIntentSink receives the vertical intents a VerticalRecommender emits
+type VerticalIntentSink interface {
+	SendVerticalIntent(clusterID string, intent VerticalScalingIntent)
+}
+
+// VerticalRecommenderConfig configures a VerticalRecommender
+type VerticalRecommenderConfig struct {
+	// History is how far back usage is considered, DefaultVerticalHistory by default
+	History time.Duration
+	// Percentile of the usage the requests are sized for, 90 by default
+	Percentile float64
+	// SafetyMargin is added on top of the percentile, 0.15 by default
+	SafetyMargin float64
+	// MinSamples is the usage samples needed per resource, 12 by default
+	MinSamples int
+	// MinCPU and MinMemory are the smallest requests recommended
+	MinCPU    resource.Quantity
+	MinMemory resource.Quantity
+	// MinChange is the relative change of a request below which no intent is
+	// emitted, 0.1 by default
+	MinChange float64
+}
+
+// ContainerRecommendation is the current and recommended resources of a container
+type ContainerRecommendation struct {
+	Container   string                      `json:"container"`
+	Current     corev1.ResourceRequirements `json:"current"`
+	Recommended corev1.ResourceRequirements `json:"recommended"`
+}
+
+// ResourceSavings are the requests freed across all replicas of a workload.
+// Negative values are requests the workload needs on top of its current ones.
+type ResourceSavings struct {
+	CPU              resource.Quantity `json:"cpu"`
+	Memory           resource.Quantity `json:"memory"`
+	CPUPercentage    float64           `json:"cpuPercentage"`
+	MemoryPercentage float64           `json:"memoryPercentage"`
+}
+
+// VerticalRecommendation sizes the container requests of a workload
+type VerticalRecommendation struct {
+	TenantID     string `json:"tenantId"`
+	ClusterID    string `json:"clusterId"`
+	Namespace    string `json:"namespace"`
+	Workload     string `json:"workload"`
+	WorkloadType string `json:"workloadType"`
+	Replicas     int32  `json:"replicas"`
+	// CPUUsage and MemoryUsage are the Percentile of the usage, in percent of
+	// the current requests
+	Percentile  float64                   `json:"percentile"`
+	CPUUsage    float64                   `json:"cpuUsage"`
+	MemoryUsage float64                   `json:"memoryUsage"`
+	Containers  []ContainerRecommendation `json:"containers"`
+	Savings     ResourceSavings           `json:"savings"`
+}
+
+// Key returns the workload the recommendation is for
+func (r VerticalRecommendation) Key() WorkloadKey {
+	return WorkloadKey{TenantID: r.TenantID, ClusterID: r.ClusterID, Namespace: r.Namespace, Workload: r.Workload}
+}
+
+// changed reports whether any recommended request changes by more than
+// minChange. Requests the recommender does not size, such as ephemeral storage,
+// are left as they are.
+func (r VerticalRecommendation) changed(minChange float64) bool {
+	for _, container := range r.Containers {
+		for name, current := range container.Current.Requests {
+			recommended, sized := container.Recommended.Requests[name]
+			if !sized || current.IsZero() {
+				continue
+			}
+			if math.Abs(recommended.AsApproximateFloat64()/current.AsApproximateFloat64()-1) > minChange {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Intent returns the vertical intent applying the recommended resources
+func (r VerticalRecommendation) Intent() VerticalScalingIntent {
+	containers := make([]ContainerResources, len(r.Containers))
+	for i, container := range r.Containers {
+		containers[i] = ContainerResources{Name: container.Container, Requests: container.Recommended.Requests, Limits: container.Recommended.Limits}
+	}
+	return VerticalScalingIntent{
+		IntentID:     newIntentID(),
+		Namespace:    r.Namespace,
+		WorkloadName: r.Workload,
+		WorkloadType: r.WorkloadType,
+		Containers:   containers,
+		Reason: fmt.Sprintf("p%.0f usage is %.1f%% of requested CPU and %.1f%% of requested memory; frees %s CPU and %s memory",
+			r.Percentile, r.CPUUsage, r.MemoryUsage, r.Savings.CPU.String(), r.Savings.Memory.String()),
+	}
+}
+
+// VerticalRecommender sizes container requests from the usage percentiles a
+// RollupEngine keeps over the History window, like the VPA recommender. Usage is
+// reported in percent of the requests of the whole pod, so every container is
+// scaled by the same factor: the usage percentile plus the safety margin. Limits
+// keep their ratio to the requests. Usage recorded before the requests last
+// changed was measured against other requests, so it is truncated from the
+// rollups, which also keeps a ReplicaRecommender reading them from averaging it.
+type VerticalRecommender struct {
+	rollups   *RollupEngine
+	config    VerticalRecommenderConfig
+	resources map[WorkloadKey]WorkloadResources
+	sinks     []VerticalIntentSink
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+func NewVerticalRecommender(rollups *RollupEngine, config VerticalRecommenderConfig) (*VerticalRecommender, error) {
+	if config.History == 0 {
+		config.History = DefaultVerticalHistory
+	}
+	if config.Percentile == 0 {
+		config.Percentile = 90
+	}
+	if config.SafetyMargin == 0 {
+		config.SafetyMargin = 0.15
+	}
+	if config.MinSamples == 0 {
+		config.MinSamples = 12
+	}
+	if config.MinCPU.IsZero() {
+		config.MinCPU = resource.MustParse("10m")
+	}
+	if config.MinMemory.IsZero() {
+		config.MinMemory = resource.MustParse("32Mi")
+	}
+	if config.MinChange == 0 {
+		config.MinChange = 0.1
+	}
+
+	if config.History < 0 {
+		return nil, fmt.Errorf("history must not be negative")
+	}
+	if config.Percentile <= 0 || config.Percentile > 100 {
+		return nil, fmt.Errorf("percentile must be in (0, 100], got %v", config.Percentile)
+	}
+	if config.SafetyMargin < 0 || config.MinChange < 0 {
+		return nil, fmt.Errorf("safety margin and min change must not be negative")
+	}
+	if config.MinSamples < 1 {
+		return nil, fmt.Errorf("at least one sample is required")
+	}
+	if !rollups.HasWindow(config.History) {
+		return nil, fmt.Errorf("rollups have no %s window for the usage history", config.History)
+	}
+	return &VerticalRecommender{
+		rollups:   rollups,
+		config:    config,
+		resources: make(map[WorkloadKey]WorkloadResources),
+		now:       time.Now,
+	}, nil
+}
+
+// SetClock replaces the time source, for tests
+func (r *VerticalRecommender) SetClock(now func() time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = now
+}
+
+// AddIntentSink makes EmitIntents send vertical intents to sink
+func (r *VerticalRecommender) AddIntentSink(sink VerticalIntentSink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sinks = append(r.sinks, sink)
+}
+
+// IngestResources records the current container resources reported by an agent.
+// When the requests of a workload differ from those reported before, the usage
+// rollups of the workload are truncated to the time of the report.
+func (r *VerticalRecommender) IngestResources(tenantID string, report ResourcesReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reportedAt := report.Timestamp
+	if reportedAt.IsZero() {
+		reportedAt = r.now()
+	}
+	for _, workload := range report.Workloads {
+		key := WorkloadKey{TenantID: tenantID, ClusterID: report.ClusterID, Namespace: workload.Namespace, Workload: workload.Workload}
+		if previous, known := r.resources[key]; known && !sameRequests(previous.Containers, workload.Containers) {
+			for _, metric := range verticalMetrics {
+				series := SeriesKey{TenantID: tenantID, ClusterID: report.ClusterID, Namespace: workload.Namespace, Workload: workload.Workload, Metric: metric}
+				r.rollups.Truncate(series, reportedAt)
+			}
+		}
+		r.resources[key] = workload
+	}
+}
+
+// verticalMetrics are the usage series the requests of each resource are sized from
+var verticalMetrics = map[corev1.ResourceName]string{
+	corev1.ResourceCPU:    MetricCPUPercentage,
+	corev1.ResourceMemory: MetricMemoryPercentage,
+}
+
+// sameRequests reports whether two pod templates request the same resources
+func sameRequests(a, b []ContainerResources) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || len(a[i].Requests) != len(b[i].Requests) {
+			return false
+		}
+		for name, request := range a[i].Requests {
+			other, ok := b[i].Requests[name]
+			if !ok || request.Cmp(other) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Recommend sizes the requests of one workload
+func (r *VerticalRecommender) Recommend(key WorkloadKey) (VerticalRecommendation, error) {
+	r.mu.RLock()
+	workload, exists := r.resources[key]
+	r.mu.RUnlock()
+	if !exists {
+		return VerticalRecommendation{}, fmt.Errorf("%w: %s", ErrUnknownResources, key)
+	}
+
+	recommendation := VerticalRecommendation{
+		TenantID:     key.TenantID,
+		ClusterID:    key.ClusterID,
+		Namespace:    key.Namespace,
+		Workload:     key.Workload,
+		WorkloadType: workload.WorkloadType,
+		Replicas:     workload.Replicas,
+		Percentile:   r.config.Percentile,
+	}
+	usage := make(map[corev1.ResourceName]float64)
+	for name, metric := range verticalMetrics {
+		series := SeriesKey{TenantID: key.TenantID, ClusterID: key.ClusterID, Namespace: key.Namespace, Workload: key.Workload, Metric: metric}
+		value, samples, _ := r.rollups.Percentile(series, r.config.History, r.config.Percentile)
+		if samples < r.config.MinSamples {
+			return VerticalRecommendation{}, fmt.Errorf("%w: %d %s samples for %s, need %d", ErrInsufficientUsage, samples, metric, key, r.config.MinSamples)
+		}
+		usage[name] = value
+	}
+	recommendation.CPUUsage = usage[corev1.ResourceCPU]
+	recommendation.MemoryUsage = usage[corev1.ResourceMemory]
+
+	var currentCPU, currentMemory, recommendedCPU, recommendedMemory float64
+	for _, container := range workload.Containers {
+		rec := ContainerRecommendation{
+			Container: container.Name,
+			Current:   corev1.ResourceRequirements{Requests: container.Requests, Limits: container.Limits},
+			Recommended: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{},
+				Limits:   corev1.ResourceList{},
+			},
+		}
+		for name, request := range container.Requests {
+			percent, sized := usage[name]
+			if !sized || request.IsZero() {
+				continue
+			}
+			recommended := r.size(name, request.AsApproximateFloat64()*percent/100*(1+r.config.SafetyMargin))
+			rec.Recommended.Requests[name] = recommended
+			if limit, ok := container.Limits[name]; ok {
+				rec.Recommended.Limits[name] = r.size(name, limit.AsApproximateFloat64()*recommended.AsApproximateFloat64()/request.AsApproximateFloat64())
+			}
+			if name == corev1.ResourceCPU {
+				currentCPU += request.AsApproximateFloat64()
+				recommendedCPU += recommended.AsApproximateFloat64()
+			} else {
+				currentMemory += request.AsApproximateFloat64()
+				recommendedMemory += recommended.AsApproximateFloat64()
+			}
+		}
+		recommendation.Containers = append(recommendation.Containers, rec)
+	}
+
+	replicas := float64(workload.Replicas)
+	recommendation.Savings = ResourceSavings{
+		CPU:    *resource.NewMilliQuantity(int64(math.Round((currentCPU-recommendedCPU)*replicas*1000)), resource.DecimalSI),
+		Memory: *resource.NewQuantity(int64(math.Round((currentMemory-recommendedMemory)*replicas)), resource.BinarySI),
+	}
+	if currentCPU > 0 {
+		recommendation.Savings.CPUPercentage = (currentCPU - recommendedCPU) / currentCPU * 100
+	}
+	if currentMemory > 0 {
+		recommendation.Savings.MemoryPercentage = (currentMemory - recommendedMemory) / currentMemory * 100
+	}
+	return recommendation, nil
+}
+
+// size rounds a recommended amount up to whole millicores or mebibytes, and up
+// to the configured minimum. Amounts within float error of a whole unit are not
+// rounded up another unit.
+func (r *VerticalRecommender) size(name corev1.ResourceName, amount float64) resource.Quantity {
+	const tolerance = 1e-6
+	if name == corev1.ResourceCPU {
+		quantity := resource.NewMilliQuantity(int64(math.Ceil(amount*1000-tolerance)), resource.DecimalSI)
+		if quantity.Cmp(r.config.MinCPU) < 0 {
+			return r.config.MinCPU.DeepCopy()
+		}
+		return *quantity
+	}
+	const mebibyte = 1 << 20
+	quantity := resource.NewQuantity(int64(math.Ceil(amount/mebibyte-tolerance))*mebibyte, resource.BinarySI)
+	if quantity.Cmp(r.config.MinMemory) < 0 {
+		return r.config.MinMemory.DeepCopy()
+	}
+	return *quantity
+}
+
+// Recommendations returns the recommendations of every workload of a tenant with
+// enough history, ordered by workload. An empty tenant matches all.
+func (r *VerticalRecommender) Recommendations(tenantID string) []VerticalRecommendation {
+	r.mu.RLock()
+	keys := make([]WorkloadKey, 0, len(r.resources))
+	for key := range r.resources {
+		if tenantID == "" || key.TenantID == tenantID {
+			keys = append(keys, key)
+		}
+	}
+	r.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	recommendations := []VerticalRecommendation{}
+	for _, key := range keys {
+		if recommendation, err := r.Recommend(key); err == nil {
+			recommendations = append(recommendations, recommendation)
+		}
+	}
+	return recommendations
+}
+
+// EmitIntents sends a vertical intent for every recommendation of the tenant that
+// changes a request by more than MinChange, and returns how many were sent
+func (r *VerticalRecommender) EmitIntents(tenantID string) int {
+	r.mu.RLock()
+	sinks := append([]VerticalIntentSink(nil), r.sinks...)
+	r.mu.RUnlock()
+
+	sent := 0
+	for _, recommendation := range r.Recommendations(tenantID) {
+		if !recommendation.changed(r.config.MinChange) {
+			continue
+		}
+		intent := recommendation.Intent()
+		for _, sink := range sinks {
+			sink.SendVerticalIntent(recommendation.ClusterID, intent)
+		}
+		sent++
+	}
+	return sent
+}
+
+// verticalReport is the response of the recommendations API
+type verticalReport struct {
+	Recommendations []VerticalRecommendation `json:"recommendations"`
+	// SavedCPU and SavedMemory are summed over the recommendations
+	SavedCPU    resource.Quantity `json:"savedCpu"`
+	SavedMemory resource.Quantity `json:"savedMemory"`
+}
+
+// ServeHTTP serves the recommendations with their projected savings, e.g.
+// GET /api/v1/recommendations/vertical?tenant=tenant-1&namespace=cert-manager
+func (r *VerticalRecommender) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	params := req.URL.Query()
+	scope := PolicyScope{ClusterID: params.Get("cluster"), Namespace: params.Get("namespace"), Workload: params.Get("workload")}
+
+	report := verticalReport{
+		Recommendations: []VerticalRecommendation{},
+		SavedCPU:        *resource.NewMilliQuantity(0, resource.DecimalSI),
+		SavedMemory:     *resource.NewQuantity(0, resource.BinarySI),
+	}
+	for _, recommendation := range r.Recommendations(params.Get("tenant")) {
+		if !scope.matches(recommendation.Key()) {
+			continue
+		}
+		report.Recommendations = append(report.Recommendations, recommendation)
+		report.SavedCPU.Add(recommendation.Savings.CPU)
+		report.SavedMemory.Add(recommendation.Savings.Memory)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
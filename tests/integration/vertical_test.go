@@ -0,0 +1,268 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	agentv1 "github.com/victoralfred/hpa-shared/proto/agent/v1"
+)
+
+func resourceList(cpu, memory string) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	if cpu != "" {
+		list[corev1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		list[corev1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return list
+}
+
+func podTemplate(containers ...corev1.Container) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: containers}}
+}
+
+// verticalClientset serves the pod templates of the workloads in verticalMetricsReport
+func verticalClientset() *fake.Clientset {
+	return fake.NewClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "cert-manager", Namespace: "cert-manager"},
+			Spec: appsv1.DeploymentSpec{Template: podTemplate(corev1.Container{
+				Name:      "controller",
+				Resources: corev1.ResourceRequirements{Requests: resourceList("500m", "256Mi"), Limits: resourceList("1", "512Mi")},
+			})},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "webapp-frontend", Namespace: "production"},
+			Spec: appsv1.DeploymentSpec{Template: podTemplate(
+				corev1.Container{Name: "app", Resources: corev1.ResourceRequirements{Requests: resourceList("200m", "512Mi")}},
+				corev1.Container{Name: "envoy", Resources: corev1.ResourceRequirements{Requests: resourceList("100m", "")}},
+			)},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "production"},
+			Spec: appsv1.StatefulSetSpec{Template: podTemplate(corev1.Container{
+				Name:      "postgres",
+				Resources: corev1.ResourceRequirements{Requests: resourceList("1", "2Gi")},
+			})},
+		},
+	)
+}
+
+// verticalMetricsReport reports usage in percent of the requests served by verticalClientset
+func verticalMetricsReport(ts time.Time, i int) *agentv1.MetricsReport {
+	return &agentv1.MetricsReport{
+		ClusterId: "cluster-1",
+		Timestamp: timestamppb.New(ts),
+		WorkloadMetrics: []*agentv1.WorkloadMetric{
+			{Namespace: "cert-manager", WorkloadName: "cert-manager", WorkloadType: "deployment", Replicas: 1,
+				Usage: &agentv1.ResourceUsage{CpuPercentage: float64(5 + i%5), MemoryPercentage: 12.8}},
+			{Namespace: "production", WorkloadName: "webapp-frontend", WorkloadType: "deployment", Replicas: 3,
+				Usage: &agentv1.ResourceUsage{CpuPercentage: 65.2, MemoryPercentage: 70}},
+			{Namespace: "production", WorkloadName: "postgres", WorkloadType: "statefulset", Replicas: 1,
+				Usage: &agentv1.ResourceUsage{CpuPercentage: 86, MemoryPercentage: 88}},
+			{Namespace: "kube-system", WorkloadName: "kube-proxy", WorkloadType: "daemonset", Replicas: 3,
+				Usage: &agentv1.ResourceUsage{CpuPercentage: 8, MemoryPercentage: 15}},
+		},
+	}
+}
+
+// newVerticalRecommender rolls up two days of half-hourly usage and the pod templates reported by the agent
+func newVerticalRecommender(t *testing.T, clock *fakeClock, executor *ScalingExecutor) *VerticalRecommender {
+	rollups := NewRollupEngine(DefaultVerticalHistory)
+	for i := 0; i < 96; i++ {
+		rollups.Ingest("tenant-1", verticalMetricsReport(clock.Now().Add(-time.Duration(96-i)*30*time.Minute), i))
+	}
+	recommender, err := NewVerticalRecommender(rollups, VerticalRecommenderConfig{})
+	require.NoError(t, err)
+	recommender.SetClock(clock.Now)
+
+	resources, err := executor.ReportResources(context.Background(), verticalMetricsReport(clock.Now(), 0))
+	require.NoError(t, err)
+	require.Len(t, resources.Workloads, 3, "kube-proxy no longer exists")
+	recommender.IngestResources("tenant-1", resources)
+	return recommender
+}
+
+// TestVerticalRecommendations tests percentile-based requests, proportional limits and projected savings
+func TestVerticalRecommendations(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	recommender := newVerticalRecommender(t, clock, NewScalingExecutor(verticalClientset(), ExecutorConfig{ClusterID: "cluster-1"}))
+
+	certManager, err := recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "cert-manager", Workload: "cert-manager"})
+	require.NoError(t, err)
+	require.Equal(t, 9.0, certManager.CPUUsage, "p90 of 5..9")
+	require.Len(t, certManager.Containers, 1)
+	recommended := certManager.Containers[0].Recommended
+	require.Equal(t, "52m", recommended.Requests.Cpu().String(), "500m * 9% * 1.15, rounded up")
+	require.Equal(t, "38Mi", recommended.Requests.Memory().String(), "256Mi * 12.8% * 1.15, rounded up")
+	require.Equal(t, "104m", recommended.Limits.Cpu().String(), "limits keep their ratio to the requests")
+	require.Equal(t, "76Mi", recommended.Limits.Memory().String())
+	require.Equal(t, "448m", certManager.Savings.CPU.String())
+	require.Equal(t, "218Mi", certManager.Savings.Memory.String())
+	require.InDelta(t, 89.6, certManager.Savings.CPUPercentage, 0.001)
+
+	// Every container is scaled by the usage of the pod, across all replicas
+	frontend, err := recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "webapp-frontend"})
+	require.NoError(t, err)
+	require.Equal(t, "150m", frontend.Containers[0].Recommended.Requests.Cpu().String())
+	require.Equal(t, "413Mi", frontend.Containers[0].Recommended.Requests.Memory().String())
+	require.Equal(t, "75m", frontend.Containers[1].Recommended.Requests.Cpu().String())
+	_, hasMemory := frontend.Containers[1].Recommended.Requests[corev1.ResourceMemory]
+	require.False(t, hasMemory, "resources without a request are not recommended")
+	require.Equal(t, "225m", frontend.Savings.CPU.String(), "(300m - 225m) * 3 replicas")
+
+	// Busy workloads get larger requests, reported as negative savings
+	postgres, err := recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "postgres"})
+	require.NoError(t, err)
+	require.Equal(t, "989m", postgres.Containers[0].Recommended.Requests.Cpu().String())
+	require.Equal(t, "11m", postgres.Savings.CPU.String())
+	require.Negative(t, postgres.Savings.Memory.Value())
+
+	_, err = recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "kube-system", Workload: "kube-proxy"})
+	require.ErrorIs(t, err, ErrUnknownResources)
+	recommender.IngestResources("tenant-1", ResourcesReport{ClusterID: "cluster-2", Workloads: []WorkloadResources{{
+		Namespace: "production", Workload: "api", WorkloadType: "deployment", Replicas: 2,
+		Containers: []ContainerResources{{Name: "api", Requests: resourceList("1", "1Gi")}},
+	}}})
+	_, err = recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-2", Namespace: "production", Workload: "api"})
+	require.ErrorIs(t, err, ErrInsufficientUsage)
+	require.Len(t, recommender.Recommendations("tenant-1"), 3)
+	require.Empty(t, recommender.Recommendations("tenant-2"))
+
+	// The API reports the recommendations with their summed savings
+	httpServer := NewMockHTTPServer(NewMockMetricsService())
+	httpServer.Handle("/api/v1/recommendations/vertical", recommender)
+	server := httptest.NewServer(httpServer.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/recommendations/vertical?tenant=tenant-1&namespace=production")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var report struct {
+		Recommendations []VerticalRecommendation `json:"recommendations"`
+		SavedCPU        resource.Quantity        `json:"savedCpu"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	require.Len(t, report.Recommendations, 2)
+	require.Equal(t, "236m", report.SavedCPU.String())
+
+	for _, config := range []VerticalRecommenderConfig{{Percentile: 101}, {SafetyMargin: -0.1}, {MinSamples: -1}, {History: time.Hour}} {
+		_, err := NewVerticalRecommender(NewRollupEngine(DefaultVerticalHistory), config)
+		require.Error(t, err)
+	}
+}
+
+// verticalIntentSinkFunc adapts a function to a VerticalIntentSink
+type verticalIntentSinkFunc func(clusterID string, intent VerticalScalingIntent)
+
+func (f verticalIntentSinkFunc) SendVerticalIntent(clusterID string, intent VerticalScalingIntent) {
+	f(clusterID, intent)
+}
+
+// resultSinkFunc adapts a function to a ScalingIntentResultSink
+type resultSinkFunc func(result ScalingIntentResult) error
+
+func (f resultSinkFunc) ReportResult(result ScalingIntentResult) error {
+	return f(result)
+}
+
+// TestVerticalIntentsIgnoreUnsizedRequests tests that requests other than CPU and memory never trigger an intent
+func TestVerticalIntentsIgnoreUnsizedRequests(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	client := verticalClientset()
+	postgres, err := client.AppsV1().StatefulSets("production").Get(context.Background(), "postgres", metav1.GetOptions{})
+	require.NoError(t, err)
+	postgres.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceEphemeralStorage] = resource.MustParse("10Gi")
+	_, err = client.AppsV1().StatefulSets("production").Update(context.Background(), postgres, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	recommender := newVerticalRecommender(t, clock, NewScalingExecutor(client, ExecutorConfig{ClusterID: "cluster-1"}))
+
+	recommendation, err := recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "postgres"})
+	require.NoError(t, err)
+	_, sized := recommendation.Containers[0].Recommended.Requests[corev1.ResourceEphemeralStorage]
+	require.False(t, sized)
+
+	var sent []string
+	recommender.AddIntentSink(verticalIntentSinkFunc(func(clusterID string, intent VerticalScalingIntent) {
+		sent = append(sent, intent.WorkloadName)
+	}))
+	require.Equal(t, 2, recommender.EmitIntents("tenant-1"))
+	require.NotContains(t, sent, "postgres", "its CPU and memory change by less than MinChange")
+}
+
+// TestVerticalIntents tests emitting vertical intents and applying them to pod templates on the agent
+func TestVerticalIntents(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)}
+	client := verticalClientset()
+	executor := NewScalingExecutor(client, ExecutorConfig{ClusterID: "cluster-1"})
+	executor.SetClock(clock.Now)
+	recommender := newVerticalRecommender(t, clock, executor)
+
+	var intents []VerticalScalingIntent
+	recommender.AddIntentSink(verticalIntentSinkFunc(func(clusterID string, intent VerticalScalingIntent) {
+		require.Equal(t, "cluster-1", clusterID)
+		intents = append(intents, intent)
+	}))
+	require.Equal(t, 2, recommender.EmitIntents("tenant-1"), "postgres changes by less than MinChange")
+	require.Equal(t, "cert-manager", intents[0].WorkloadName)
+	require.Contains(t, intents[0].Reason, "p90 usage is 9.0% of requested CPU")
+
+	var results []ScalingIntentResult
+	executor.SetResultSink(resultSinkFunc(func(result ScalingIntentResult) error {
+		results = append(results, result)
+		return nil
+	}))
+	for _, intent := range intents {
+		require.NoError(t, executor.ExecuteVertical(context.Background(), intent))
+	}
+	require.Len(t, results, 4)
+	require.Equal(t, IntentSucceeded, results[1].State)
+
+	certManager, err := client.AppsV1().Deployments("cert-manager").Get(context.Background(), "cert-manager", metav1.GetOptions{})
+	require.NoError(t, err)
+	resources := certManager.Spec.Template.Spec.Containers[0].Resources
+	require.Equal(t, "52m", resources.Requests.Cpu().String())
+	require.Equal(t, "76Mi", resources.Limits.Memory().String())
+
+	frontend, err := client.AppsV1().Deployments("production").Get(context.Background(), "webapp-frontend", metav1.GetOptions{})
+	require.NoError(t, err)
+	envoy := frontend.Spec.Template.Spec.Containers[1].Resources
+	require.Equal(t, "75m", envoy.Requests.Cpu().String())
+	require.Nil(t, envoy.Limits, "unset resources stay unset")
+
+	// Usage recorded against the old requests no longer sizes the new ones, so
+	// applied intents are not shrunk again
+	clock.Advance(time.Minute)
+	applied, err := executor.ReportResources(context.Background(), verticalMetricsReport(clock.Now(), 0))
+	require.NoError(t, err)
+	recommender.IngestResources("tenant-1", applied)
+	require.Zero(t, recommender.EmitIntents("tenant-1"))
+	require.Len(t, intents, 2)
+	_, err = recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "cert-manager", Workload: "cert-manager"})
+	require.ErrorIs(t, err, ErrInsufficientUsage)
+	_, err = recommender.Recommend(WorkloadKey{TenantID: "tenant-1", ClusterID: "cluster-1", Namespace: "production", Workload: "postgres"})
+	require.NoError(t, err, "unchanged requests keep their history")
+
+	// Vertical intents respect freezes and unknown containers fail
+	executor.SendFreezes("cluster-1", []ScalingFreeze{{ID: "freeze-1", Scope: PolicyScope{Namespace: "cert-manager"}, Reason: "INC-7", Start: clock.Now()}})
+	require.ErrorIs(t, executor.ExecuteVertical(context.Background(), intents[0]), ErrScalingFrozen)
+	intents[1].Containers[0].Name = "nginx"
+	require.ErrorContains(t, executor.ExecuteVertical(context.Background(), intents[1]), `has no container "nginx"`)
+	require.Equal(t, IntentFailed, results[len(results)-1].State)
+	intents[1].WorkloadType = "job"
+	require.ErrorIs(t, executor.ExecuteVertical(context.Background(), intents[1]), ErrUnscalableWorkload)
+}